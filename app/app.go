@@ -0,0 +1,214 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/auth"
+	"github.com/mishozz/Library/config"
+	"github.com/mishozz/Library/controller"
+	"github.com/mishozz/Library/mail"
+	"github.com/mishozz/Library/middleware"
+	"github.com/mishozz/Library/policy"
+	"github.com/mishozz/Library/repositories"
+	"github.com/mishozz/Library/router"
+	"github.com/mishozz/Library/service"
+	"github.com/mishozz/Library/utils"
+	"go.uber.org/zap"
+)
+
+const holdExpiryInterval = time.Minute
+
+// App is the library with everything it runs: the HTTP server, the background workers
+// and the resources they share. It is built by New, served by Run and shut down when
+// the context of Run is done.
+type App struct {
+	cfg              config.Config
+	db               config.Database
+	mailLog          io.Closer
+	engine           *gin.Engine
+	server           *http.Server
+	holdExpiryWorker *service.HoldExpiryWorker
+	passwordService  service.PasswordService
+}
+
+// New opens the database and wires the repositories, services and controllers of the
+// library. The resources opened before a failure are released again.
+func New(cfg config.Config) (*App, error) {
+	if cfg.Log.Level != "debug" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	db, err := config.NewDatabaseConfig(cfg.Database, cfg.Log)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the database: %w", err)
+	}
+	a := &App{cfg: cfg, db: db}
+
+	mailer, err := a.newMailer()
+	if err != nil {
+		a.release()
+		return nil, err
+	}
+
+	var (
+		bookRepository         repositories.BookRepository         = repositories.NewBookRepository(db)
+		userRepository         repositories.UserRepository         = repositories.NewUserRepository(db)
+		authRepository         repositories.AuthRepository         = repositories.NewAuthRepository(db)
+		refreshTokenRepository repositories.RefreshTokenRepository = repositories.NewRefreshTokenRepository(db)
+		loanRepository         repositories.LoanRepository         = repositories.NewLoanRepository(db)
+		holdRepository         repositories.HoldRepository         = repositories.NewHoldRepository(db)
+		itemRepository         repositories.ItemRepository         = repositories.NewItemRepository(db)
+		ledgerRepository       repositories.LedgerRepository       = repositories.NewLedgerRepository(db)
+		categoryRepository     repositories.CategoryRepository     = repositories.NewCategoryRepository(db)
+		roleRepository         repositories.RoleRepository         = repositories.NewRoleRepository(db)
+		loginAttemptRepository repositories.LoginAttemptRepository = repositories.NewLoginAttemptRepository(db)
+		auditRepository        repositories.AuditRepository        = repositories.NewAuditRepository(db)
+		unitOfWork             repositories.UnitOfWork             = repositories.NewUnitOfWork(db)
+
+		signer    *auth.Signer      = auth.NewSigner(cfg.Auth.Secret, cfg.Auth.AccessTokenTTL)
+		fineRules service.FineRules = service.FineRules{Cap: cfg.Fines.Cap, BlockingBalance: cfg.Fines.BlockingBalance}
+
+		bookService         service.BookService         = service.NewBookService(bookRepository, unitOfWork)
+		userService         service.UserService         = service.NewUserService(userRepository, bookRepository, unitOfWork, fineRules, cfg.Auth.BcryptCost)
+		tokenService        service.TokenService        = service.NewTokenService(authRepository, refreshTokenRepository, signer, cfg.Auth.RefreshTokenTTL)
+		loanService         service.LoanService         = service.NewLoanService(loanRepository, categoryRepository, unitOfWork)
+		holdService         service.HoldService         = service.NewHoldService(holdRepository, unitOfWork)
+		itemService         service.ItemService         = service.NewItemService(itemRepository, unitOfWork)
+		fineService         service.FineService         = service.NewFineService(ledgerRepository, unitOfWork)
+		categoryService     service.CategoryService     = service.NewCategoryService(categoryRepository, userRepository)
+		accountService      service.AccountService      = service.NewAccountService(userRepository, roleRepository, unitOfWork, tokenService, cfg.Auth.BcryptCost)
+		roleService         service.RoleService         = service.NewRoleService(roleRepository)
+		passwordService     service.PasswordService     = service.NewPasswordService(userRepository, unitOfWork, tokenService, mailer, cfg.Auth.BcryptCost)
+		loginService        service.LoginService        = service.NewLoginService(loginAttemptRepository, unitOfWork, service.DefaultLoginRules, cfg.Auth.BcryptCost)
+		auditService        service.AuditService        = service.NewAuditService(auditRepository)
+		verificationService service.VerificationService = service.NewVerificationService(userRepository, unitOfWork, mailer, cfg.Server.URL()+"/library/api/v1/verify")
+
+		ownershipPolicy policy.OwnershipPolicy = policy.NewOwnershipPolicy(signer, roleRepository)
+
+		bookController         controller.BookController         = controller.NewBookController(bookService)
+		userController         controller.UserController         = controller.NewUserController(userService, bookService, itemService, ownershipPolicy)
+		loginController        controller.LoginController        = controller.NewLoginController(authRepository, userService, tokenService, verificationService, loginService)
+		loanController         controller.LoanController         = controller.NewLoanController(loanService, userService, bookService, ownershipPolicy)
+		holdController         controller.HoldController         = controller.NewHoldController(holdService, userService, bookService, ownershipPolicy)
+		itemController         controller.ItemController         = controller.NewItemController(itemService, bookService)
+		fineController         controller.FineController         = controller.NewFineController(fineService, userService, ownershipPolicy)
+		categoryController     controller.CategoryController     = controller.NewCategoryController(categoryService, userService)
+		accountController      controller.AccountController      = controller.NewAccountController(accountService, userService)
+		roleController         controller.RoleController         = controller.NewRoleController(roleService)
+		passwordController     controller.PasswordController     = controller.NewPasswordController(passwordService)
+		verificationController controller.VerificationController = controller.NewVerificationController(verificationService)
+		lockoutController      controller.LockoutController      = controller.NewLockoutController(loginService, auditService)
+	)
+
+	//the first admin comes from the configuration, later admins are promoted through the API
+	err = accountService.BootstrapAdmin(context.Background(), cfg.Admin.Email, cfg.Admin.Password)
+	if errors.Is(err, service.ErrNoAdmin) {
+		zap.L().Warn("the library has no admin, set ADMIN_EMAIL and ADMIN_PASSWORD to create one")
+	} else if err != nil {
+		a.release()
+		return nil, fmt.Errorf("unable to create the admin: %w", err)
+	}
+
+	a.holdExpiryWorker = service.NewHoldExpiryWorker(holdService, holdExpiryInterval)
+	a.passwordService = passwordService
+
+	a.engine = gin.New()
+	//failed logins are counted per client address, which clients could forge in X-Forwarded-For
+	//unless a proxy in front of the library sets it
+	a.engine.ForwardedByClientIP = cfg.Server.BehindProxy
+	a.engine.Use(middleware.QueryTimeout(cfg.Database.QueryTimeout))
+	router.HandleRequests(a.engine, bookController, userController, loginController, loanController, holdController, itemController, fineController, categoryController, accountController, roleController, passwordController, verificationController, lockoutController, signer, authRepository, roleRepository)
+
+	a.server = &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Server.Port),
+		Handler: a.engine,
+	}
+	return a, nil
+}
+
+// Run listens on the configured port and serves the library until the context is done
+func (a *App) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", a.server.Addr)
+	if err != nil {
+		a.release()
+		return fmt.Errorf("unable to listen on %s: %w", a.server.Addr, err)
+	}
+	return a.Serve(ctx, listener)
+}
+
+// Serve serves the library on the listener until the context is done or the server fails.
+// It then stops taking requests, gives the requests in flight the shutdown timeout to
+// finish, stops the workers and waits for the emails being sent before it closes the
+// database they all use.
+func (a *App) Serve(ctx context.Context, listener net.Listener) error {
+	a.holdExpiryWorker.Start()
+	failed := make(chan error, 1)
+	go func() {
+		failed <- a.server.Serve(listener)
+	}()
+	zap.L().Info("the library is listening", zap.String("address", listener.Addr().String()))
+
+	var err error
+	select {
+	case err = <-failed:
+		err = fmt.Errorf("the server failed: %w", err)
+	case <-ctx.Done():
+		zap.L().Info("shutting down the library")
+		err = a.shutdown()
+	}
+	a.holdExpiryWorker.Stop()
+	a.passwordService.Wait()
+	a.release()
+	return err
+}
+
+// shutdown drains the requests in flight. Those which do not finish within the shutdown
+// timeout have their connections closed.
+func (a *App) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), a.cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := a.server.Shutdown(ctx); err != nil {
+		a.server.Close()
+		return fmt.Errorf("unable to finish the requests in flight: %w", err)
+	}
+	return nil
+}
+
+// release closes the mail log and the database
+func (a *App) release() {
+	if a.mailLog != nil {
+		if err := a.mailLog.Close(); err != nil {
+			zap.L().Error("unable to close the mail log", zap.Error(err))
+		}
+	}
+	if err := utils.CloseDB(a.db.Connection); err != nil {
+		zap.L().Error("unable to close the database", zap.Error(err))
+	}
+}
+
+// newMailer sends through the configured SMTP server when there is one. Otherwise the
+// emails are written to the mail log file, or to stdout when there is no file either.
+func (a *App) newMailer() (mail.Mailer, error) {
+	cfg := a.cfg.Mail
+	if cfg.SMTPHost != "" {
+		return mail.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.From), nil
+	}
+	var out io.Writer = os.Stdout
+	if cfg.LogFile != "" {
+		file, err := os.OpenFile(cfg.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open the mail log: %w", err)
+		}
+		a.mailLog = file
+		out = file
+	}
+	return mail.NewLogMailer(out), nil
+}
@@ -0,0 +1,132 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/config"
+	"github.com/mishozz/Library/migrations"
+	"github.com/mishozz/Library/utils"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func testConfig(t *testing.T) config.Config {
+	dir, err := ioutil.TempDir("", "app")
+	if err != nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	cfg := config.Default()
+	cfg.Database.DSN = filepath.Join(dir, "library.db")
+	cfg.Auth.Secret = "secret"
+	cfg.Auth.BcryptCost = bcrypt.MinCost
+	cfg.Mail.LogFile = filepath.Join(dir, "mail.log")
+	cfg.Server.ShutdownTimeout = time.Second
+	return cfg
+}
+
+func migrate(t *testing.T, cfg config.Config) {
+	db, err := config.Open(cfg.Database, cfg.Log)
+	if err != nil {
+		t.FailNow()
+	}
+	defer utils.CloseDB(db)
+	if _, err := migrations.NewMigrator(db, migrations.All()).Up(); err != nil {
+		t.FailNow()
+	}
+}
+
+func listen(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.FailNow()
+	}
+	return listener
+}
+
+func Test_New_PendingMigrations(t *testing.T) {
+	a, err := New(testConfig(t))
+
+	assert.Nil(t, a)
+	assert.True(t, errors.Is(err, migrations.ErrSchemaBehind))
+}
+
+func Test_App_Serve_DrainsRequests(t *testing.T) {
+	cfg := testConfig(t)
+	migrate(t, cfg)
+	a, err := New(cfg)
+	if err != nil {
+		t.FailNow()
+	}
+	started := make(chan struct{})
+	a.engine.GET("/slow", func(c *gin.Context) {
+		close(started)
+		time.Sleep(time.Millisecond * 200)
+		c.JSON(http.StatusOK, "ok")
+	})
+	listener := listen(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- a.Serve(ctx, listener) }()
+
+	responded := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String() + "/slow")
+		if err != nil {
+			responded <- 0
+			return
+		}
+		resp.Body.Close()
+		responded <- resp.StatusCode
+	}()
+	<-started
+	cancel()
+
+	assert.Equal(t, http.StatusOK, <-responded)
+	assert.Nil(t, <-served)
+	sqlDB, err := a.db.Connection.DB()
+	assert.Nil(t, err)
+	assert.NotNil(t, sqlDB.Ping())
+	_, err = http.Get("http://" + listener.Addr().String() + "/slow")
+	assert.NotNil(t, err)
+}
+
+func Test_App_Serve_ShutdownTimeout(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Server.ShutdownTimeout = time.Millisecond * 50
+	migrate(t, cfg)
+	a, err := New(cfg)
+	if err != nil {
+		t.FailNow()
+	}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	a.engine.GET("/stuck", func(c *gin.Context) {
+		close(started)
+		<-release
+	})
+	listener := listen(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- a.Serve(ctx, listener) }()
+
+	go http.Get("http://" + listener.Addr().String() + "/stuck")
+	<-started
+	cancel()
+
+	err = <-served
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.True(t, strings.HasPrefix(err.Error(), "unable to finish the requests in flight"))
+}
@@ -1,9 +1,12 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -17,19 +20,47 @@ type AuthDetails struct {
 	Role     string
 }
 
-func CreateToken(authD AuthDetails) (string, error) {
+// Signer signs the access tokens with the secret of the library and verifies them
+type Signer struct {
+	secret         []byte
+	accessTokenTTL time.Duration
+}
+
+// NewSigner creates a signer of access tokens which stay valid for accessTokenTTL
+func NewSigner(secret string, accessTokenTTL time.Duration) *Signer {
+	return &Signer{
+		secret:         []byte(secret),
+		accessTokenTTL: accessTokenTTL,
+	}
+}
+
+func (s *Signer) CreateToken(authD AuthDetails) (string, error) {
 	claims := jwt.MapClaims{}
 	claims["authorized"] = true
 	claims["auth_uuid"] = authD.AuthUuid
 	claims["user_id"] = authD.UserId
 	claims["user_role"] = authD.Role
-	claims["exp"] = time.Now().Add(time.Minute * 15).Unix()
+	claims["exp"] = time.Now().Add(s.accessTokenTTL).Unix()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(os.Getenv("API_SECRET")))
+	return token.SignedString(s.secret)
+}
+
+// CreateRefreshToken returns an opaque random string, only its hash is stored in the database
+func CreateRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
-func TokenValid(r *http.Request) error {
-	token, err := VerifyToken(r)
+func (s *Signer) TokenValid(r *http.Request) error {
+	token, err := s.VerifyToken(r)
 	if err != nil {
 		return err
 	}
@@ -39,14 +70,14 @@ func TokenValid(r *http.Request) error {
 	return nil
 }
 
-func VerifyToken(r *http.Request) (*jwt.Token, error) {
+func (s *Signer) VerifyToken(r *http.Request) (*jwt.Token, error) {
 	tokenString := ExtractToken(r)
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		//Make sure that the token method conform to "SigningMethodHMAC"
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(os.Getenv("API_SECRET")), nil
+		return s.secret, nil
 	})
 	if err != nil {
 		return nil, err
@@ -70,8 +101,8 @@ func ExtractToken(r *http.Request) string {
 	return ""
 }
 
-func ExtractTokenAuth(r *http.Request) (*AuthDetails, error) {
-	token, err := VerifyToken(r)
+func (s *Signer) ExtractTokenAuth(r *http.Request) (*AuthDetails, error) {
+	token, err := s.VerifyToken(r)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,381 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the configuration of the library. The defaults are overridden by the YAML file
+// in the -config flag or the LIBRARY_CONFIG environment variable, the environment variables
+// override the file and the command line flags override everything.
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	Database DatabaseConfig `yaml:"database"`
+	Auth     AuthConfig     `yaml:"auth"`
+	Mail     MailConfig     `yaml:"mail"`
+	Fines    FinesConfig    `yaml:"fines"`
+	Admin    AdminConfig    `yaml:"admin"`
+	Log      LogConfig      `yaml:"log"`
+}
+
+type ServerConfig struct {
+	Port int `yaml:"port"`
+	// PublicURL is where users reach the library, the links in the emails point there
+	PublicURL string `yaml:"public_url"`
+	// BehindProxy trusts the client address a proxy sets in X-Forwarded-For
+	BehindProxy bool `yaml:"behind_proxy"`
+	// ShutdownTimeout bounds the time the requests in flight get to finish on shutdown
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// URL is the public URL of the library, the local server when none is configured
+func (c ServerConfig) URL() string {
+	if c.PublicURL != "" {
+		return strings.TrimSuffix(c.PublicURL, "/")
+	}
+	return fmt.Sprintf("http://localhost:%d", c.Port)
+}
+
+// DatabaseConfig selects the database. The DSN is a file name for sqlite, a URL or key=value
+// string for postgres and user:password@tcp(host:port)/database for mysql.
+type DatabaseConfig struct {
+	Driver string `yaml:"driver"`
+	DSN    string `yaml:"dsn"`
+	// QueryTimeout bounds the time the queries of one request may take together
+	QueryTimeout time.Duration `yaml:"query_timeout"`
+}
+
+type AuthConfig struct {
+	// Secret signs the access tokens
+	Secret          string        `yaml:"secret"`
+	AccessTokenTTL  time.Duration `yaml:"access_token_ttl"`
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl"`
+	BcryptCost      int           `yaml:"bcrypt_cost"`
+}
+
+// MailConfig sends the emails through SMTPHost. Without a host they are written to LogFile,
+// or to the standard output when there is no file either.
+type MailConfig struct {
+	SMTPHost     string `yaml:"smtp_host"`
+	SMTPPort     int    `yaml:"smtp_port"`
+	SMTPUsername string `yaml:"smtp_username"`
+	SMTPPassword string `yaml:"smtp_password"`
+	From         string `yaml:"from"`
+	LogFile      string `yaml:"log_file"`
+}
+
+// FinesConfig limits the fines of every patron category, the daily rates are set per
+// category. Amounts are in cents, a zero Cap means no cap. Patrons who owe more than
+// BlockingBalance cannot take books.
+type FinesConfig struct {
+	Cap             int64 `yaml:"cap"`
+	BlockingBalance int64 `yaml:"blocking_balance"`
+}
+
+// AdminConfig is the first admin of the library, it is created when the library has none
+type AdminConfig struct {
+	Email    string `yaml:"email"`
+	Password string `yaml:"password"`
+}
+
+type LogConfig struct {
+	Level string `yaml:"level"`
+}
+
+// Default is the configuration of a local library, only the secret has to be added
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:            8080,
+			ShutdownTimeout: time.Second * 15,
+		},
+		Database: DatabaseConfig{
+			Driver:       DriverSQLite,
+			DSN:          "test.db",
+			QueryTimeout: time.Second * 10,
+		},
+		Auth: AuthConfig{
+			AccessTokenTTL:  time.Minute * 15,
+			RefreshTokenTTL: time.Hour * 24 * 30,
+			BcryptCost:      14,
+		},
+		Mail: MailConfig{
+			SMTPPort: 587,
+		},
+		Fines: FinesConfig{
+			Cap:             1000,
+			BlockingBalance: 500,
+		},
+		Log: LogConfig{
+			Level: "info",
+		},
+	}
+}
+
+// Load reads the configuration from the command line arguments, the file they or the
+// environment point to and the environment, and validates it
+func Load(args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	cfg, err := read(args, lookupEnv)
+	if err != nil {
+		return Config{}, err
+	}
+	return cfg, cfg.Validate()
+}
+
+// LoadDatabase reads the configuration like Load but validates only the database and log
+// sections, so the migrate subcommand runs without the settings of the server
+func LoadDatabase(args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	cfg, err := read(args, lookupEnv)
+	if err != nil {
+		return Config{}, err
+	}
+	return cfg, invalid(append(cfg.Database.problems(), cfg.Log.problems()...))
+}
+
+func read(args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	flags := flag.NewFlagSet("library", flag.ContinueOnError)
+	path := flags.String("config", "", "path of the YAML configuration file")
+	flags.Int("port", 0, "port the server listens on")
+	flags.String("driver", "", "database driver: sqlite, postgres or mysql")
+	flags.String("dsn", "", "data source name of the database")
+	flags.String("log-level", "", "log level: debug, info, warn or error")
+	if err := flags.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	cfg := Default()
+	if *path == "" {
+		*path, _ = lookupEnv("LIBRARY_CONFIG")
+	}
+	if *path != "" {
+		if err := cfg.readFile(*path); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := cfg.readEnv(lookupEnv); err != nil {
+		return Config{}, err
+	}
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			cfg.Server.Port, err = strconv.Atoi(f.Value.String())
+		case "driver":
+			cfg.Database.Driver = f.Value.String()
+		case "dsn":
+			cfg.Database.DSN = f.Value.String()
+		case "log-level":
+			cfg.Log.Level = f.Value.String()
+		}
+	})
+	if err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) readFile(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read the configuration file: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(content, c); err != nil {
+		return fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+	return nil
+}
+
+// readEnv overrides the configuration with the environment variables which are set
+func (c *Config) readEnv(lookupEnv func(string) (string, bool)) error {
+	variables := []struct {
+		name string
+		set  func(value string) error
+	}{
+		{"PORT", intVar(&c.Server.Port)},
+		{"PUBLIC_URL", stringVar(&c.Server.PublicURL)},
+		{"BEHIND_PROXY", boolVar(&c.Server.BehindProxy)},
+		{"SHUTDOWN_TIMEOUT", durationVar(&c.Server.ShutdownTimeout)},
+		{"DATABASE_DRIVER", stringVar(&c.Database.Driver)},
+		{"DATABASE_DSN", stringVar(&c.Database.DSN)},
+		{"DATABASE_QUERY_TIMEOUT", durationVar(&c.Database.QueryTimeout)},
+		{"API_SECRET", stringVar(&c.Auth.Secret)},
+		{"ACCESS_TOKEN_TTL", durationVar(&c.Auth.AccessTokenTTL)},
+		{"REFRESH_TOKEN_TTL", durationVar(&c.Auth.RefreshTokenTTL)},
+		{"BCRYPT_COST", intVar(&c.Auth.BcryptCost)},
+		{"SMTP_HOST", stringVar(&c.Mail.SMTPHost)},
+		{"SMTP_PORT", intVar(&c.Mail.SMTPPort)},
+		{"SMTP_USERNAME", stringVar(&c.Mail.SMTPUsername)},
+		{"SMTP_PASSWORD", stringVar(&c.Mail.SMTPPassword)},
+		{"MAIL_FROM", stringVar(&c.Mail.From)},
+		{"MAIL_LOG", stringVar(&c.Mail.LogFile)},
+		{"FINE_CAP", int64Var(&c.Fines.Cap)},
+		{"FINE_BLOCKING_BALANCE", int64Var(&c.Fines.BlockingBalance)},
+		{"ADMIN_EMAIL", stringVar(&c.Admin.Email)},
+		{"ADMIN_PASSWORD", stringVar(&c.Admin.Password)},
+		{"LOG_LEVEL", stringVar(&c.Log.Level)},
+	}
+	for _, variable := range variables {
+		value, ok := lookupEnv(variable.name)
+		if !ok {
+			continue
+		}
+		if err := variable.set(value); err != nil {
+			return fmt.Errorf("invalid %s: %w", variable.name, err)
+		}
+	}
+	return nil
+}
+
+func stringVar(target *string) func(string) error {
+	return func(value string) error {
+		*target = value
+		return nil
+	}
+}
+
+func intVar(target *int) func(string) error {
+	return func(value string) (err error) {
+		*target, err = strconv.Atoi(value)
+		return err
+	}
+}
+
+func int64Var(target *int64) func(string) error {
+	return func(value string) (err error) {
+		*target, err = strconv.ParseInt(value, 10, 64)
+		return err
+	}
+}
+
+func boolVar(target *bool) func(string) error {
+	return func(value string) (err error) {
+		*target, err = strconv.ParseBool(value)
+		return err
+	}
+}
+
+func durationVar(target *time.Duration) func(string) error {
+	return func(value string) (err error) {
+		*target, err = time.ParseDuration(value)
+		return err
+	}
+}
+
+// Validate reports every problem of the configuration at once
+func (c Config) Validate() error {
+	var problems []string
+	problems = append(problems, c.Server.problems()...)
+	problems = append(problems, c.Database.problems()...)
+	problems = append(problems, c.Auth.problems()...)
+	problems = append(problems, c.Mail.problems()...)
+	problems = append(problems, c.Fines.problems()...)
+	problems = append(problems, c.Admin.problems()...)
+	problems = append(problems, c.Log.problems()...)
+	return invalid(problems)
+}
+
+func invalid(problems []string) error {
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (c ServerConfig) problems() []string {
+	var problems []string
+	if c.Port < 1 || c.Port > 65535 {
+		problems = append(problems, "server.port must be between 1 and 65535")
+	}
+	if c.PublicURL != "" {
+		if u, err := url.Parse(c.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, "server.public_url must be an http or https URL")
+		}
+	}
+	if c.ShutdownTimeout <= 0 {
+		problems = append(problems, "server.shutdown_timeout must be positive")
+	}
+	return problems
+}
+
+func (c DatabaseConfig) problems() []string {
+	var problems []string
+	if !contains(Drivers, c.Driver) {
+		problems = append(problems, "database.driver must be one of "+strings.Join(Drivers, ", "))
+	}
+	if c.DSN == "" {
+		problems = append(problems, "database.dsn is required")
+	}
+	if c.QueryTimeout <= 0 {
+		problems = append(problems, "database.query_timeout must be positive")
+	}
+	return problems
+}
+
+func (c AuthConfig) problems() []string {
+	var problems []string
+	if c.Secret == "" {
+		problems = append(problems, "auth.secret is required, set it in the file or in API_SECRET")
+	}
+	if c.AccessTokenTTL <= 0 {
+		problems = append(problems, "auth.access_token_ttl must be positive")
+	}
+	if c.RefreshTokenTTL <= 0 {
+		problems = append(problems, "auth.refresh_token_ttl must be positive")
+	}
+	if c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost {
+		problems = append(problems, fmt.Sprintf("auth.bcrypt_cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost))
+	}
+	return problems
+}
+
+func (c MailConfig) problems() []string {
+	if c.SMTPHost != "" && (c.SMTPPort < 1 || c.SMTPPort > 65535) {
+		return []string{"mail.smtp_port must be between 1 and 65535"}
+	}
+	return nil
+}
+
+func (c FinesConfig) problems() []string {
+	var problems []string
+	if c.Cap < 0 {
+		problems = append(problems, "fines.cap must not be negative")
+	}
+	if c.BlockingBalance < 0 {
+		problems = append(problems, "fines.blocking_balance must not be negative")
+	}
+	return problems
+}
+
+func (c AdminConfig) problems() []string {
+	//an email alone promotes a registered user, the password is needed only to register one
+	if c.Email == "" && c.Password != "" {
+		return []string{"admin.password needs admin.email"}
+	}
+	return nil
+}
+
+func (c LogConfig) problems() []string {
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+		return []string{"log.level must be debug, info, warn or error"}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,255 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm/logger"
+)
+
+// env looks the variables up in the map instead of the environment of the test
+func env(variables map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := variables[name]
+		return value, ok
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "library.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.FailNow()
+	}
+	return path
+}
+
+func Test_Load_Defaults(t *testing.T) {
+	cfg, err := Load(nil, env(map[string]string{"API_SECRET": "secret"}))
+	assert.Nil(t, err)
+
+	expected := Default()
+	expected.Auth.Secret = "secret"
+	assert.Equal(t, expected, cfg)
+	assert.Equal(t, "http://localhost:8080", cfg.Server.URL())
+}
+
+func Test_Load_Precedence(t *testing.T) {
+	path := writeFile(t, `
+server:
+  port: 9000
+  public_url: https://library.example.com/
+database:
+  dsn: file.db
+auth:
+  secret: file secret
+  access_token_ttl: 5m
+  bcrypt_cost: 10
+log:
+  level: warn
+`)
+
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		expected func(cfg Config) Config
+	}{{
+		name: "file",
+		args: []string{"-config", path},
+		expected: func(cfg Config) Config {
+			cfg.Server.Port = 9000
+			cfg.Server.PublicURL = "https://library.example.com/"
+			cfg.Database.DSN = "file.db"
+			cfg.Auth.Secret = "file secret"
+			cfg.Auth.AccessTokenTTL = time.Minute * 5
+			cfg.Auth.BcryptCost = 10
+			cfg.Log.Level = "warn"
+			return cfg
+		},
+	}, {
+		name: "file from the environment",
+		env:  map[string]string{"LIBRARY_CONFIG": path, "DATABASE_DSN": "env.db", "DATABASE_QUERY_TIMEOUT": "30s", "ACCESS_TOKEN_TTL": "1h", "BEHIND_PROXY": "true", "SHUTDOWN_TIMEOUT": "1m", "FINE_CAP": "0", "FINE_BLOCKING_BALANCE": "2000"},
+		expected: func(cfg Config) Config {
+			cfg.Server.Port = 9000
+			cfg.Server.PublicURL = "https://library.example.com/"
+			cfg.Server.BehindProxy = true
+			cfg.Server.ShutdownTimeout = time.Minute
+			cfg.Database.DSN = "env.db"
+			cfg.Database.QueryTimeout = time.Second * 30
+			cfg.Fines.Cap = 0
+			cfg.Fines.BlockingBalance = 2000
+			cfg.Auth.Secret = "file secret"
+			cfg.Auth.AccessTokenTTL = time.Hour
+			cfg.Auth.BcryptCost = 10
+			cfg.Log.Level = "warn"
+			return cfg
+		},
+	}, {
+		name: "flags",
+		args: []string{"-config", path, "-port", "9090", "-driver", "postgres", "-dsn", "host=localhost", "-log-level", "debug"},
+		env:  map[string]string{"PORT": "9001", "DATABASE_DRIVER": "mysql", "DATABASE_DSN": "env.db", "API_SECRET": "env secret"},
+		expected: func(cfg Config) Config {
+			cfg.Server.Port = 9090
+			cfg.Server.PublicURL = "https://library.example.com/"
+			cfg.Database.Driver = DriverPostgres
+			cfg.Database.DSN = "host=localhost"
+			cfg.Auth.Secret = "env secret"
+			cfg.Auth.AccessTokenTTL = time.Minute * 5
+			cfg.Auth.BcryptCost = 10
+			cfg.Log.Level = "debug"
+			return cfg
+		},
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Load(tt.args, env(tt.env))
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected(Default()), cfg)
+			assert.Equal(t, "https://library.example.com", cfg.Server.URL())
+		})
+	}
+}
+
+// An admin email alone promotes a registered user
+func Test_Load_AdminEmail(t *testing.T) {
+	cfg, err := Load(nil, env(map[string]string{"API_SECRET": "secret", "ADMIN_EMAIL": "admin@example.com"}))
+	assert.Nil(t, err)
+	assert.Equal(t, AdminConfig{Email: "admin@example.com"}, cfg.Admin)
+}
+
+func Test_Load_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		file    string
+		message string
+	}{{
+		name:    "missing secret",
+		message: "invalid configuration: auth.secret is required, set it in the file or in API_SECRET",
+	}, {
+		name:    "every problem",
+		env:     map[string]string{"API_SECRET": "secret", "PORT": "0", "DATABASE_DSN": "", "BCRYPT_COST": "50", "ADMIN_PASSWORD": "secret", "LOG_LEVEL": "verbose"},
+		message: "invalid configuration: server.port must be between 1 and 65535; database.dsn is required; auth.bcrypt_cost must be between 4 and 31; admin.password needs admin.email; log.level must be debug, info, warn or error",
+	}, {
+		name:    "unknown driver",
+		env:     map[string]string{"API_SECRET": "secret", "DATABASE_DRIVER": "oracle"},
+		message: "invalid configuration: database.driver must be one of sqlite, postgres, mysql",
+	}, {
+		name:    "invalid public url",
+		env:     map[string]string{"API_SECRET": "secret", "PUBLIC_URL": "library.example.com"},
+		message: "invalid configuration: server.public_url must be an http or https URL",
+	}, {
+		name:    "negative fines",
+		env:     map[string]string{"API_SECRET": "secret", "FINE_CAP": "-1", "FINE_BLOCKING_BALANCE": "-500"},
+		message: "invalid configuration: fines.cap must not be negative; fines.blocking_balance must not be negative",
+	}, {
+		name:    "invalid shutdown timeout",
+		env:     map[string]string{"API_SECRET": "secret", "SHUTDOWN_TIMEOUT": "-1s"},
+		message: "invalid configuration: server.shutdown_timeout must be positive",
+	}, {
+		name:    "invalid query timeout",
+		env:     map[string]string{"API_SECRET": "secret", "DATABASE_QUERY_TIMEOUT": "0s"},
+		message: "invalid configuration: database.query_timeout must be positive",
+	}, {
+		name:    "invalid ttl",
+		env:     map[string]string{"API_SECRET": "secret", "REFRESH_TOKEN_TTL": "-1h"},
+		message: "invalid configuration: auth.refresh_token_ttl must be positive",
+	}, {
+		name:    "malformed variable",
+		env:     map[string]string{"API_SECRET": "secret", "ACCESS_TOKEN_TTL": "forever"},
+		message: `invalid ACCESS_TOKEN_TTL: time: invalid duration "forever"`,
+	}, {
+		name:    "unknown flag",
+		args:    []string{"-secret", "secret"},
+		message: "flag provided but not defined: -secret",
+	}, {
+		name:    "missing file",
+		args:    []string{"-config", "missing.yaml"},
+		message: "unable to read the configuration file: open missing.yaml: no such file or directory",
+	}, {
+		name: "unknown key",
+		file: "auth:\n  secrets: secret\n",
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = []string{"-config", writeFile(t, tt.file)}
+			}
+			_, err := Load(args, env(tt.env))
+			assert.NotNil(t, err)
+			if tt.message != "" {
+				assert.EqualError(t, err, tt.message)
+			}
+		})
+	}
+}
+
+// The migrate subcommand needs only the database
+func Test_LoadDatabase(t *testing.T) {
+	cfg, err := LoadDatabase([]string{"-dsn", "migrate.db"}, env(map[string]string{"PORT": "0", "BCRYPT_COST": "50"}))
+	assert.Nil(t, err)
+	assert.Equal(t, "migrate.db", cfg.Database.DSN)
+
+	_, err = LoadDatabase(nil, env(map[string]string{"DATABASE_DRIVER": "oracle", "DATABASE_DSN": "", "LOG_LEVEL": "verbose"}))
+	assert.EqualError(t, err, "invalid configuration: database.driver must be one of sqlite, postgres, mysql; database.dsn is required; log.level must be debug, info, warn or error")
+}
+
+func Test_mysqlDSN(t *testing.T) {
+	dsn, err := mysqlDSN("library:secret@tcp(localhost:3306)/library?charset=utf8mb4")
+	assert.Nil(t, err)
+	assert.Equal(t, "library:secret@tcp(localhost:3306)/library?clientFoundRows=true&parseTime=true&charset=utf8mb4", dsn)
+
+	_, err = mysqlDSN("localhost:3306")
+	assert.NotNil(t, err)
+}
+
+func Test_sqliteDSN(t *testing.T) {
+	tests := []struct {
+		dsn      string
+		expected string
+	}{
+		{"library.db", "library.db?_txlock=immediate"},
+		{"file:library.db?cache=shared", "file:library.db?cache=shared&_txlock=immediate"},
+		{"library.db?_txlock=exclusive", "library.db?_txlock=exclusive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dsn, func(t *testing.T) {
+			assert.Equal(t, tt.expected, sqliteDSN(tt.dsn))
+		})
+	}
+}
+
+func Test_queryLogLevel(t *testing.T) {
+	tests := []struct {
+		level    string
+		expected logger.LogLevel
+	}{
+		{"debug", logger.Info},
+		{"info", logger.Warn},
+		{"warn", logger.Warn},
+		{"error", logger.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			assert.Equal(t, tt.expected, queryLogLevel(LogConfig{Level: tt.level}))
+		})
+	}
+}
+
+func Test_Open_UnknownDriver(t *testing.T) {
+	_, err := Open(DatabaseConfig{Driver: "oracle", DSN: "library"}, LogConfig{Level: "info"})
+	assert.EqualError(t, err, `unknown database driver "oracle"`)
+}
@@ -1,24 +1,45 @@
 package config
 
 import (
-	"github.com/mishozz/Library/entities"
+	"fmt"
+
+	"github.com/mishozz/Library/migrations"
+	"github.com/mishozz/Library/utils"
 	"github.com/pkg/errors"
-	"gorm.io/driver/sqlite"
+	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
 type Database struct {
 	Connection *gorm.DB
+	// FullTextSearch is set when the catalogue is indexed with SQLite FTS5
+	FullTextSearch bool
 }
 
-func NewDatabaseConfig() Database {
-	db, err := gorm.Open(sqlite.Open("test.db"), &gorm.Config{})
+// NewDatabaseConfig opens the database of the configuration. It refuses and closes a database
+// with pending migrations, the library would not work on an old schema.
+func NewDatabaseConfig(cfg DatabaseConfig, logCfg LogConfig) (Database, error) {
+	db, err := Open(cfg, logCfg)
+	if err != nil {
+		return Database{}, errors.Wrap(err, "unable to open db connection")
+	}
+	err = migrations.NewMigrator(db, migrations.All()).Check()
 	if err != nil {
-		errors.Wrap(err, "unable to open db connection")
+		if closeErr := utils.CloseDB(db); closeErr != nil {
+			zap.L().Error("unable to close the database", zap.Error(closeErr))
+		}
+		return Database{}, fmt.Errorf("%w, run library migrate up", err)
 	}
-	db.AutoMigrate(&entities.Book{}, &entities.User{}, &entities.Auth{})
 
 	return Database{
-		Connection: db,
-	}
+		Connection:     db,
+		FullTextSearch: fullTextSearch(db),
+	}, nil
+}
+
+// fullTextSearch tells whether the catalogue is searched with its FTS5 index. The index is
+// created by the books_fts migration when the build which migrated the database had FTS5,
+// a database migrated by a build without FTS5 is searched with LIKE by every build.
+func fullTextSearch(db *gorm.DB) bool {
+	return migrations.FullTextSearchAvailable(db) && db.Migrator().HasTable("books_fts")
 }
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// The database drivers the library can run on
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+	DriverMySQL    = "mysql"
+)
+
+// Drivers lists the supported database drivers
+var Drivers = []string{DriverSQLite, DriverPostgres, DriverMySQL}
+
+// Open connects to the database of the configuration without migrating it. The queries are
+// logged at the level of the log configuration.
+func Open(cfg DatabaseConfig, logCfg LogConfig) (*gorm.DB, error) {
+	dialector, err := dialector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return gorm.Open(dialector, &gorm.Config{Logger: logger.Default.LogMode(queryLogLevel(logCfg))})
+}
+
+func dialector(cfg DatabaseConfig) (gorm.Dialector, error) {
+	switch cfg.Driver {
+	case DriverSQLite:
+		return sqlite.Open(sqliteDSN(cfg.DSN)), nil
+	case DriverPostgres:
+		return postgres.Open(cfg.DSN), nil
+	case DriverMySQL:
+		dsn, err := mysqlDSN(cfg.DSN)
+		if err != nil {
+			return nil, err
+		}
+		return mysql.Open(dsn), nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", cfg.Driver)
+	}
+}
+
+// mysqlDSN makes MySQL behave like the other databases: the times are scanned into
+// time.Time and the updates report the rows they matched rather than the rows they changed,
+// which the conditional updates of the repositories rely on
+func mysqlDSN(dsn string) (string, error) {
+	cfg, err := mysqldriver.ParseDSN(dsn)
+	if err != nil {
+		return "", fmt.Errorf("invalid MySQL data source name: %w", err)
+	}
+	cfg.ParseTime = true
+	cfg.ClientFoundRows = true
+	return cfg.FormatDSN(), nil
+}
+
+// sqliteDSN makes the transactions of SQLite take the write lock of the database when they
+// begin. SQLite has no row locks to take later, and a transaction which reads before it
+// writes could not wait for another writer without deadlocking it.
+func sqliteDSN(dsn string) string {
+	if strings.Contains(dsn, "_txlock=") {
+		return dsn
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&_txlock=immediate"
+	}
+	return dsn + "?_txlock=immediate"
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gorm.io/gorm/logger"
+)
+
+// NewLogger creates the logger of the library which writes the entries of the configured
+// level and above as JSON to the standard error
+func NewLogger(cfg LogConfig) (*zap.Logger, error) {
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, err
+	}
+	zapConfig := zap.NewProductionConfig()
+	zapConfig.Level = zap.NewAtomicLevelAt(level)
+	return zapConfig.Build()
+}
+
+// queryLogLevel is the level of the queries for the configured level. Every query is logged at
+// debug, the slow and failed queries at info and warn and only the failed ones at error.
+func queryLogLevel(cfg LogConfig) logger.LogLevel {
+	switch cfg.Level {
+	case "debug":
+		return logger.Info
+	case "error":
+		return logger.Error
+	default:
+		return logger.Warn
+	}
+}
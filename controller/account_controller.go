@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/service"
+	"gorm.io/gorm"
+)
+
+const (
+	lastAdmin    = "The library must keep at least one active admin"
+	userHasLoans = "This user still has books on loan"
+	userOwesFine = "This user still owes fines"
+)
+
+// AccountController is an interface with all the methods we need for the account controller
+type AccountController interface {
+	SetRole(ctx *gin.Context)
+	SetStatus(ctx *gin.Context)
+	Delete(ctx *gin.Context)
+}
+
+type accountController struct {
+	accountService service.AccountService
+	userService    service.UserService
+}
+
+type roleRequest struct {
+	Role string `json:"Role" binding:"required"`
+}
+
+type statusRequest struct {
+	Disabled *bool `json:"Disabled" binding:"required"`
+}
+
+// NewAccountController creates a new instance of the account controller
+func NewAccountController(accountService service.AccountService, userService service.UserService) *accountController {
+	return &accountController{
+		accountService: accountService,
+		userService:    userService,
+	}
+}
+
+// SetRole gives the user in the email parameter another role
+func (c *accountController) SetRole(ctx *gin.Context) {
+	var request roleRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: invalidRequest})
+		return
+	}
+	user, ok := c.findUser(ctx)
+	if !ok {
+		return
+	}
+	err := c.accountService.SetRole(ctx.Request.Context(), user, request.Role)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: roleNotFound})
+		return
+	}
+	if !c.handleError(ctx, err, "unable to set role") {
+		return
+	}
+	ctx.JSON(http.StatusNoContent, gin.H{message: "Role successfully set"})
+}
+
+// SetStatus disables or enables the account of the user in the email parameter
+func (c *accountController) SetStatus(ctx *gin.Context) {
+	var request statusRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: invalidRequest})
+		return
+	}
+	user, ok := c.findUser(ctx)
+	if !ok {
+		return
+	}
+	err := c.accountService.SetDisabled(ctx.Request.Context(), user, *request.Disabled)
+	if !c.handleError(ctx, err, "unable to set status") {
+		return
+	}
+	ctx.JSON(http.StatusNoContent, gin.H{message: "Status successfully set"})
+}
+
+// Delete removes the user in the email parameter
+func (c *accountController) Delete(ctx *gin.Context) {
+	user, ok := c.findUser(ctx)
+	if !ok {
+		return
+	}
+	err := c.accountService.Delete(ctx.Request.Context(), user)
+	if !c.handleError(ctx, err, "unable to delete user") {
+		return
+	}
+	ctx.JSON(http.StatusNoContent, gin.H{message: "User successfully deleted"})
+}
+
+func (c *accountController) findUser(ctx *gin.Context) (entities.User, bool) {
+	user, err := c.userService.FindByEmail(ctx.Request.Context(), ctx.Param("email"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: userNotFound})
+		return user, false
+	}
+	return user, true
+}
+
+// handleError writes the error response and returns false when the change failed
+func (c *accountController) handleError(ctx *gin.Context, err error, failure string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, service.ErrLastAdmin):
+		ctx.JSON(http.StatusConflict, gin.H{errorMessage: lastAdmin})
+	case errors.Is(err, service.ErrUserHasLoans):
+		ctx.JSON(http.StatusConflict, gin.H{errorMessage: userHasLoans})
+	case errors.Is(err, service.ErrUserOwesFines):
+		ctx.JSON(http.StatusConflict, gin.H{errorMessage: userOwesFine})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: failure})
+	}
+	return false
+}
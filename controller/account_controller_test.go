@@ -0,0 +1,282 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+type mockAccountService struct {
+	mock.Mock
+}
+
+func (m *mockAccountService) BootstrapAdmin(ctx context.Context, email string, password string) error {
+	args := m.Called(ctx, email, password)
+	return args.Error(0)
+}
+
+func (m *mockAccountService) SetRole(ctx context.Context, user entities.User, role string) error {
+	args := m.Called(ctx, user, role)
+	return args.Error(0)
+}
+
+func (m *mockAccountService) SetDisabled(ctx context.Context, user entities.User, disabled bool) error {
+	args := m.Called(ctx, user, disabled)
+	return args.Error(0)
+}
+
+func (m *mockAccountService) Delete(ctx context.Context, user entities.User) error {
+	args := m.Called(ctx, user)
+	return args.Error(0)
+}
+
+func Test_NewAccountController(t *testing.T) {
+	accountController := NewAccountController(&mockAccountService{}, &mockUserService{})
+	assert.NotNil(t, accountController.accountService)
+	assert.NotNil(t, accountController.userService)
+}
+
+func Test_AccountController_SetRole(t *testing.T) {
+	user := entities.User{Email: "email", Role: entities.RoleAdmin}
+
+	tests := []struct {
+		name               string
+		body               string
+		mockAccountService func(m *mockAccountService) *mockAccountService
+		mockUserService    func(m *mockUserService) *mockUserService
+		respStatus         int
+	}{{
+		name: "success",
+		body: `{"Role": "User"}`,
+		mockAccountService: func(m *mockAccountService) *mockAccountService {
+			m.On("SetRole", mock.Anything, user, entities.RoleUser).Return(nil)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		respStatus: http.StatusNoContent,
+	}, {
+		name: "missing role",
+		body: `{}`,
+		mockAccountService: func(m *mockAccountService) *mockAccountService {
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			return m
+		},
+		respStatus: http.StatusUnprocessableEntity,
+	}, {
+		name: "role not found",
+		body: `{"Role": "Janitor"}`,
+		mockAccountService: func(m *mockAccountService) *mockAccountService {
+			m.On("SetRole", mock.Anything, user, "Janitor").Return(gorm.ErrRecordNotFound)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		respStatus: http.StatusNotFound,
+	}, {
+		name: "user not found",
+		body: `{"Role": "User"}`,
+		mockAccountService: func(m *mockAccountService) *mockAccountService {
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{}, errors.New("Not found"))
+			return m
+		},
+		respStatus: http.StatusNotFound,
+	}, {
+		name: "last admin",
+		body: `{"Role": "User"}`,
+		mockAccountService: func(m *mockAccountService) *mockAccountService {
+			m.On("SetRole", mock.Anything, user, entities.RoleUser).Return(service.ErrLastAdmin)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		respStatus: http.StatusConflict,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockAccounts := tt.mockAccountService(&mockAccountService{})
+			mockUsers := tt.mockUserService(&mockUserService{})
+			accountController := NewAccountController(mockAccounts, mockUsers)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodPut, "/users/email/role", bytes.NewBufferString(tt.body))
+			c.Params = append(c.Params, gin.Param{Key: "email", Value: "email"})
+			accountController.SetRole(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			mockAccounts.AssertExpectations(t)
+			mockUsers.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_AccountController_SetStatus(t *testing.T) {
+	user := entities.User{Email: "email", Role: entities.RoleUser}
+
+	tests := []struct {
+		name               string
+		body               string
+		mockAccountService func(m *mockAccountService) *mockAccountService
+		mockUserService    func(m *mockUserService) *mockUserService
+		respStatus         int
+	}{{
+		name: "disabled",
+		body: `{"Disabled": true}`,
+		mockAccountService: func(m *mockAccountService) *mockAccountService {
+			m.On("SetDisabled", mock.Anything, user, true).Return(nil)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		respStatus: http.StatusNoContent,
+	}, {
+		name: "enabled",
+		body: `{"Disabled": false}`,
+		mockAccountService: func(m *mockAccountService) *mockAccountService {
+			m.On("SetDisabled", mock.Anything, user, false).Return(nil)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		respStatus: http.StatusNoContent,
+	}, {
+		name: "missing status",
+		body: `{}`,
+		mockAccountService: func(m *mockAccountService) *mockAccountService {
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			return m
+		},
+		respStatus: http.StatusUnprocessableEntity,
+	}, {
+		name: "internal error",
+		body: `{"Disabled": true}`,
+		mockAccountService: func(m *mockAccountService) *mockAccountService {
+			m.On("SetDisabled", mock.Anything, user, true).Return(errors.New("error"))
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		respStatus: http.StatusInternalServerError,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockAccounts := tt.mockAccountService(&mockAccountService{})
+			mockUsers := tt.mockUserService(&mockUserService{})
+			accountController := NewAccountController(mockAccounts, mockUsers)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodPut, "/users/email/status", bytes.NewBufferString(tt.body))
+			c.Params = append(c.Params, gin.Param{Key: "email", Value: "email"})
+			accountController.SetStatus(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			mockAccounts.AssertExpectations(t)
+			mockUsers.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_AccountController_Delete(t *testing.T) {
+	user := entities.User{Email: "email", Role: entities.RoleUser}
+
+	tests := []struct {
+		name               string
+		mockAccountService func(m *mockAccountService) *mockAccountService
+		mockUserService    func(m *mockUserService) *mockUserService
+		respStatus         int
+	}{{
+		name: "success",
+		mockAccountService: func(m *mockAccountService) *mockAccountService {
+			m.On("Delete", mock.Anything, user).Return(nil)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		respStatus: http.StatusNoContent,
+	}, {
+		name: "user not found",
+		mockAccountService: func(m *mockAccountService) *mockAccountService {
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{}, errors.New("Not found"))
+			return m
+		},
+		respStatus: http.StatusNotFound,
+	}, {
+		name: "books on loan",
+		mockAccountService: func(m *mockAccountService) *mockAccountService {
+			m.On("Delete", mock.Anything, user).Return(service.ErrUserHasLoans)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		respStatus: http.StatusConflict,
+	}, {
+		name: "owes fines",
+		mockAccountService: func(m *mockAccountService) *mockAccountService {
+			m.On("Delete", mock.Anything, user).Return(service.ErrUserOwesFines)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		respStatus: http.StatusConflict,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockAccounts := tt.mockAccountService(&mockAccountService{})
+			mockUsers := tt.mockUserService(&mockUserService{})
+			accountController := NewAccountController(mockAccounts, mockUsers)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodDelete, "/users/email", nil)
+			c.Params = append(c.Params, gin.Param{Key: "email", Value: "email"})
+			accountController.Delete(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			mockAccounts.AssertExpectations(t)
+			mockUsers.AssertExpectations(t)
+		})
+	}
+}
@@ -1,13 +1,16 @@
 package controller
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/isbn"
 
 	"github.com/mishozz/Library/repositories"
 	"github.com/mishozz/Library/service"
+	"gorm.io/gorm"
 )
 
 const (
@@ -16,15 +19,21 @@ const (
 	errorMessage   = "error message"
 	bookConflict   = "Every book must have a unique ISBN!"
 	bookNotFound   = "Book not found"
-	ADMIN          = "Admin"
-	USER           = "User"
+	incompleteBook = "Isbn, Title and Author are required"
+	emptyUpdate    = "Nothing to update"
+	emptySearch    = "The q parameter must contain at least one word"
+	invalidIsbn    = "Invalid ISBN"
+	bookHasLoans   = "The book was lent and is kept for the loan history"
 )
 
 // BookController is an interface with all the methods we need for the book controller
 type BookController interface {
 	GetAll(ctx *gin.Context)
 	GetByIsbn(ctx *gin.Context)
+	Search(ctx *gin.Context)
 	Save(ctx *gin.Context)
+	Update(ctx *gin.Context)
+	Patch(ctx *gin.Context)
 	Delete(ctx *gin.Context)
 }
 
@@ -41,12 +50,60 @@ func NewBookController(service service.BookService) *bookController {
 }
 
 func (c *bookController) GetAll(ctx *gin.Context) {
-	books, err := c.service.FindAll()
+	query, err := bookQuery(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: invalidListQuery})
+		return
+	}
+	books, total, err := c.service.FindAll(ctx.Request.Context(), query)
+	if errors.Is(err, repositories.ErrInvalidSort) {
+		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: invalidListQuery})
+		return
+	}
 	if err != nil {
 		ctx.JSON(400, gin.H{errorMessage: "Internal error"})
 		return
 	}
-	ctx.JSON(200, books)
+	ctx.JSON(200, newPageResponse(ctx, books, total, query.Page))
+}
+
+// bookQuery reads the author, title and available filters and the paging of the book list
+func bookQuery(ctx *gin.Context) (repositories.BookQuery, error) {
+	page, err := parsePage(ctx)
+	if err != nil {
+		return repositories.BookQuery{}, err
+	}
+	available, err := parseBool(ctx, "available")
+	if err != nil {
+		return repositories.BookQuery{}, err
+	}
+	return repositories.BookQuery{
+		Author:    ctx.Query("author"),
+		Title:     ctx.Query("title"),
+		Available: available,
+		Sort:      parseSort(ctx),
+		Page:      page,
+	}, nil
+}
+
+// Search finds the books whose title or author match every word of the q parameter,
+// best matches first
+func (c *bookController) Search(ctx *gin.Context) {
+	page, err := parsePage(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: invalidListQuery})
+		return
+	}
+	matches, total, err := c.service.Search(ctx.Request.Context(), ctx.Query("q"), page)
+	if errors.Is(err, repositories.ErrEmptySearch) {
+		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: emptySearch})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "error while searching"})
+		return
+	}
+	ctx.JSON(200, newPageResponse(ctx, matches, total, page))
 }
 
 func (c *bookController) Save(ctx *gin.Context) {
@@ -56,7 +113,12 @@ func (c *bookController) Save(ctx *gin.Context) {
 		ctx.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: invalidRequest})
 		return
 	}
-	err = c.service.Save(book)
+	book.Isbn, err = isbn.Normalize(book.Isbn)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: invalidIsbn})
+		return
+	}
+	err = c.service.Save(ctx.Request.Context(), book)
 	if err != nil {
 		ctx.JSON(http.StatusConflict, gin.H{errorMessage: bookConflict})
 		return
@@ -65,8 +127,11 @@ func (c *bookController) Save(ctx *gin.Context) {
 }
 
 func (c *bookController) GetByIsbn(ctx *gin.Context) {
-	isbn := ctx.Param("isbn")
-	book, err := c.service.FindByIsbn(isbn)
+	isbn, ok := bookIsbn(ctx)
+	if !ok {
+		return
+	}
+	book, err := c.service.FindByIsbn(ctx.Request.Context(), isbn)
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: bookNotFound})
 	} else {
@@ -74,23 +139,96 @@ func (c *bookController) GetByIsbn(ctx *gin.Context) {
 	}
 }
 
+// Update replaces all the details of the book
+func (c *bookController) Update(ctx *gin.Context) {
+	c.update(ctx, false)
+}
+
+// Patch changes only the details of the book which are present in the request
+func (c *bookController) Patch(ctx *gin.Context) {
+	c.update(ctx, true)
+}
+
+func (c *bookController) update(ctx *gin.Context, partial bool) {
+	var update service.BookUpdate
+	if err := ctx.ShouldBindJSON(&update); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: invalidRequest})
+		return
+	}
+	complete := update.Isbn != nil && update.Title != nil && update.Author != nil
+	if !partial && !complete {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: incompleteBook})
+		return
+	}
+	if update == (service.BookUpdate{}) {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: emptyUpdate})
+		return
+	}
+	if update.Isbn != nil {
+		normalized, err := isbn.Normalize(*update.Isbn)
+		if err != nil {
+			ctx.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: invalidIsbn})
+			return
+		}
+		update.Isbn = &normalized
+	}
+
+	current, ok := bookIsbn(ctx)
+	if !ok {
+		return
+	}
+	book, err := c.service.FindByIsbn(ctx.Request.Context(), current)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: bookNotFound})
+		return
+	}
+	book, err = c.service.Update(ctx.Request.Context(), book, update)
+	switch {
+	case errors.Is(err, service.ErrBookConflict):
+		ctx.JSON(http.StatusConflict, gin.H{errorMessage: bookConflict})
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "error while updating"})
+	default:
+		ctx.JSON(http.StatusOK, book)
+	}
+}
+
 func (c *bookController) Delete(ctx *gin.Context) {
-	isbn := ctx.Param("isbn")
-	_, err := c.service.FindByIsbn(isbn)
+	isbn, ok := bookIsbn(ctx)
+	if !ok {
+		return
+	}
+	_, err := c.service.FindByIsbn(ctx.Request.Context(), isbn)
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: bookNotFound})
 		return
 	}
 
-	if c.service.IsBookTaken(isbn) {
+	if c.service.IsBookTaken(ctx.Request.Context(), isbn) {
 		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: bookAlreadyTaken})
 		return
 	}
 
-	err = c.service.Delete(isbn)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "error while deleting"})
-	} else {
+	err = c.service.Delete(ctx.Request.Context(), isbn)
+	switch {
+	case err == nil:
 		ctx.JSON(204, gin.H{message: "book deleted"})
+	case errors.Is(err, repositories.ErrBookHasLoans):
+		ctx.JSON(http.StatusConflict, gin.H{errorMessage: bookHasLoans})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: bookNotFound})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "error while deleting"})
+	}
+}
+
+// bookIsbn reads the isbn path parameter, which may be any valid form of the ISBN, and
+// normalizes it. It writes the error response and returns false when the ISBN is invalid.
+func bookIsbn(ctx *gin.Context) (string, bool) {
+	normalized, err := isbn.Normalize(ctx.Param("isbn"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: invalidIsbn})
+		return "", false
 	}
+	return normalized, true
 }
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -10,6 +11,8 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+	"github.com/mishozz/Library/service"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -22,31 +25,46 @@ type mockBookService struct {
 	mock.Mock
 }
 
-func (m *mockBookService) Save(book entities.Book) error {
-	args := m.Called(book)
+func (m *mockBookService) Save(ctx context.Context, book entities.Book) error {
+	args := m.Called(ctx, book)
 	return args.Error(0)
 }
 
-func (m *mockBookService) FindAll() ([]entities.Book, error) {
-	args := m.Called()
-	return args.Get(0).([]entities.Book), args.Error(1)
+func (m *mockBookService) Search(ctx context.Context, text string, page repositories.Page) ([]repositories.BookMatch, int64, error) {
+	args := m.Called(ctx, text, page)
+	return args.Get(0).([]repositories.BookMatch), args.Get(1).(int64), args.Error(2)
 }
 
-func (m *mockBookService) FindByIsbn(isbn string) (entities.Book, error) {
-	args := m.Called(isbn)
+func (m *mockBookService) FindAll(ctx context.Context, query repositories.BookQuery) ([]entities.Book, int64, error) {
+	args := m.Called(ctx, query)
+	return args.Get(0).([]entities.Book), args.Get(1).(int64), args.Error(2)
+}
+
+func (m *mockBookService) FindByIsbn(ctx context.Context, isbn string) (entities.Book, error) {
+	args := m.Called(ctx, isbn)
+	return args.Get(0).(entities.Book), args.Error(1)
+}
+
+func (m *mockBookService) Update(ctx context.Context, book entities.Book, update service.BookUpdate) (entities.Book, error) {
+	args := m.Called(ctx, book, update)
 	return args.Get(0).(entities.Book), args.Error(1)
 }
 
-func (m *mockBookService) Delete(isbn string) error {
-	args := m.Called(isbn)
+func (m *mockBookService) Delete(ctx context.Context, isbn string) error {
+	args := m.Called(ctx, isbn)
 	return args.Error(0)
 }
 
-func (m *mockBookService) IsBookTaken(isbn string) bool {
-	args := m.Called(isbn)
+func (m *mockBookService) IsBookTaken(ctx context.Context, isbn string) bool {
+	args := m.Called(ctx, isbn)
 	return args.Get(0).(bool)
 }
 
+const (
+	testIsbn       = "9780134685991"
+	hyphenatedIsbn = "978-0-13-468599-1"
+)
+
 func Test_NewBookController(t *testing.T) {
 	service := &mockBookService{}
 	bookController := NewBookController(service)
@@ -62,44 +80,201 @@ func Test_BookController_GetAll(t *testing.T) {
 			AvailableUnits: 1,
 		},
 	}
-
-	mockService := func(m *mockBookService) *mockBookService {
-		m.On("FindAll").Return([]entities.Book{
-			{
-				Isbn:           "test",
-				Author:         "test",
-				Title:          "test",
-				AvailableUnits: 1,
-			},
-		}, nil)
-		return m
+	available := true
+
+	type bookPage struct {
+		Items    []entities.Book
+		Total    int64
+		Page     int
+		PerPage  int
+		Next     string
+		Previous string
 	}
 
-	mock := &mockBookService{}
-	controller := NewBookController(mockService(mock))
+	tests := []struct {
+		name            string
+		url             string
+		mockBookService func(m *mockBookService) *mockBookService
+		expected        bookPage
+		statusCode      int
+	}{{
+		name: "first page",
+		url:  "/books",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindAll", mock.Anything, repositories.BookQuery{Page: repositories.Page{Number: 1, Size: 20}}).Return(expectedBooks, int64(1), nil)
+			return m
+		},
+		expected:   bookPage{Items: expectedBooks, Total: 1, Page: 1, PerPage: 20},
+		statusCode: http.StatusOK,
+	}, {
+		name: "filtered and sorted page in the middle",
+		url:  "/books?author=test&available=true&page=2&per_page=1&sort=-title",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindAll", mock.Anything, repositories.BookQuery{
+				Author:    "test",
+				Available: &available,
+				Sort:      repositories.Sort{Key: "title", Desc: true},
+				Page:      repositories.Page{Number: 2, Size: 1},
+			}).Return(expectedBooks, int64(3), nil)
+			return m
+		},
+		expected: bookPage{
+			Items:    expectedBooks,
+			Total:    3,
+			Page:     2,
+			PerPage:  1,
+			Next:     "/books?author=test&available=true&page=3&per_page=1&sort=-title",
+			Previous: "/books?author=test&available=true&page=1&per_page=1&sort=-title",
+		},
+		statusCode: http.StatusOK,
+	}, {
+		name: "invalid page",
+		url:  "/books?page=0",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			return m
+		},
+		statusCode: http.StatusBadRequest,
+	}, {
+		name: "page too large",
+		url:  "/books?per_page=1000",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			return m
+		},
+		statusCode: http.StatusBadRequest,
+	}, {
+		name: "unknown sort key",
+		url:  "/books?sort=password",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindAll", mock.Anything, repositories.BookQuery{
+				Sort: repositories.Sort{Key: "password"},
+				Page: repositories.Page{Number: 1, Size: 20},
+			}).Return([]entities.Book(nil), int64(0), repositories.ErrInvalidSort)
+			return m
+		},
+		statusCode: http.StatusBadRequest,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mock := tt.mockBookService(&mockBookService{})
+			controller := NewBookController(mock)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodGet, tt.url, nil)
+			controller.GetAll(c)
+
+			assert.Equal(t, tt.statusCode, w.Code)
+			if tt.statusCode == http.StatusOK {
+				var actual bookPage
+				err := json.Unmarshal(w.Body.Bytes(), &actual)
+				if err != nil {
+					t.FailNow()
+				}
+				assert.Equal(t, tt.expected, actual)
+			}
+			mock.AssertExpectations(t)
+		})
+	}
+}
 
-	w := httptest.NewRecorder()
-	c, _ := gin.CreateTestContext(w)
-	controller.GetAll(c)
+func Test_BookController_Search(t *testing.T) {
+	matches := []repositories.BookMatch{{
+		Book:          entities.Book{Isbn: "test", Author: "Frank Herbert", Title: "Dune"},
+		TitleSnippet:  "<mark>Dune</mark>",
+		AuthorSnippet: "Frank Herbert",
+	}}
 
-	var books []entities.Book
-	err := json.Unmarshal(w.Body.Bytes(), &books)
-	if err != nil {
-		t.FailNow()
+	type matchPage struct {
+		Items    []repositories.BookMatch
+		Total    int64
+		Page     int
+		PerPage  int
+		Next     string
+		Previous string
 	}
-	assert.Equal(t, expectedBooks, books)
-	assert.Equal(t, http.StatusOK, w.Code)
 
-	mock.AssertExpectations(t)
+	tests := []struct {
+		name            string
+		url             string
+		mockBookService func(m *mockBookService) *mockBookService
+		expected        matchPage
+		statusCode      int
+	}{{
+		name: "matches found",
+		url:  "/books/search?q=dun&per_page=1",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("Search", mock.Anything, "dun", repositories.Page{Number: 1, Size: 1}).Return(matches, int64(2), nil)
+			return m
+		},
+		expected: matchPage{
+			Items:   matches,
+			Total:   2,
+			Page:    1,
+			PerPage: 1,
+			Next:    "/books/search?page=2&per_page=1&q=dun",
+		},
+		statusCode: http.StatusOK,
+	}, {
+		name: "empty search",
+		url:  "/books/search?q=+",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("Search", mock.Anything, " ", repositories.Page{Number: 1, Size: 20}).Return([]repositories.BookMatch(nil), int64(0), repositories.ErrEmptySearch)
+			return m
+		},
+		statusCode: http.StatusBadRequest,
+	}, {
+		name: "invalid page",
+		url:  "/books/search?q=dune&page=x",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			return m
+		},
+		statusCode: http.StatusBadRequest,
+	}, {
+		name: "search failed",
+		url:  "/books/search?q=dune",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("Search", mock.Anything, "dune", repositories.Page{Number: 1, Size: 20}).Return([]repositories.BookMatch(nil), int64(0), errors.New("error"))
+			return m
+		},
+		statusCode: http.StatusInternalServerError,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mock := tt.mockBookService(&mockBookService{})
+			controller := NewBookController(mock)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodGet, tt.url, nil)
+			controller.Search(c)
+
+			assert.Equal(t, tt.statusCode, w.Code)
+			if tt.statusCode == http.StatusOK {
+				var actual matchPage
+				err := json.Unmarshal(w.Body.Bytes(), &actual)
+				if err != nil {
+					t.FailNow()
+				}
+				assert.Equal(t, tt.expected, actual)
+			}
+			mock.AssertExpectations(t)
+		})
+	}
 }
 
 func Test_BookController_Save(t *testing.T) {
 	validBook := entities.Book{
-		Isbn:           "test",
+		Isbn:           hyphenatedIsbn,
 		Author:         "test",
 		Title:          "test",
 		AvailableUnits: 1,
 	}
+	savedBook := validBook
+	savedBook.Isbn = testIsbn
+	invalidIsbnBook := validBook
+	invalidIsbnBook.Isbn = "test"
 	invalidBook := entities.Book{
 		Author:         "test",
 		Title:          "test",
@@ -115,7 +290,7 @@ func Test_BookController_Save(t *testing.T) {
 	}{{
 		name: "success",
 		mockBookService: func(m *mockBookService) *mockBookService {
-			m.On("Save", validBook).Return(nil)
+			m.On("Save", mock.Anything, savedBook).Return(nil)
 			return m
 		},
 		input:      validBook,
@@ -129,10 +304,18 @@ func Test_BookController_Save(t *testing.T) {
 		input:      invalidBook,
 		statusCode: http.StatusUnprocessableEntity,
 		respBody:   gin.H{errorMessage: invalidRequest},
+	}, {
+		name: "invalid isbn",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			return m
+		},
+		input:      invalidIsbnBook,
+		statusCode: http.StatusUnprocessableEntity,
+		respBody:   gin.H{errorMessage: invalidIsbn},
 	}, {
 		name: "book already exists",
 		mockBookService: func(m *mockBookService) *mockBookService {
-			m.On("Save", validBook).Return(errors.New("book exists"))
+			m.On("Save", mock.Anything, savedBook).Return(errors.New("book exists"))
 			return m
 		},
 		input:      validBook,
@@ -175,7 +358,8 @@ func Test_BookController_Save(t *testing.T) {
 
 func Test_BookController_GetByIsbn(t *testing.T) {
 	validBook := entities.Book{
-		Isbn:           "test",
+		Isbn:           testIsbn,
+		HyphenatedIsbn: hyphenatedIsbn,
 		Author:         "test",
 		Title:          "test",
 		AvailableUnits: 1,
@@ -189,21 +373,38 @@ func Test_BookController_GetByIsbn(t *testing.T) {
 	}{{
 		name: "success",
 		mockBookService: func(m *mockBookService) *mockBookService {
-			m.On("FindByIsbn", "test").Return(validBook, nil)
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(validBook, nil)
 			return m
 		},
-		isbn:       "test",
+		isbn:       hyphenatedIsbn,
 		statusCode: http.StatusOK,
-		respBody:   gin.H{"Author": "test", "AvailableUnits": float64(1), "Isbn": "test", "Title": "test"},
+		respBody:   gin.H{"Author": "test", "AvailableUnits": float64(1), "Isbn": testIsbn, "HyphenatedIsbn": hyphenatedIsbn, "Title": "test"},
+	}, {
+		name: "isbn-10 of the book",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(validBook, nil)
+			return m
+		},
+		isbn:       "0134685997",
+		statusCode: http.StatusOK,
+		respBody:   gin.H{"Author": "test", "AvailableUnits": float64(1), "Isbn": testIsbn, "HyphenatedIsbn": hyphenatedIsbn, "Title": "test"},
 	}, {
 		name: "invalid book",
 		mockBookService: func(m *mockBookService) *mockBookService {
-			m.On("FindByIsbn", "test").Return(entities.Book{}, errors.New("Not found"))
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(entities.Book{}, errors.New("Not found"))
 			return m
 		},
-		isbn:       "test",
+		isbn:       testIsbn,
 		statusCode: http.StatusNotFound,
 		respBody:   gin.H{errorMessage: bookNotFound},
+	}, {
+		name: "invalid isbn",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			return m
+		},
+		isbn:       "test",
+		statusCode: http.StatusBadRequest,
+		respBody:   gin.H{errorMessage: invalidIsbn},
 	}}
 	for _, tt := range tests {
 		tt := tt
@@ -213,7 +414,8 @@ func Test_BookController_GetByIsbn(t *testing.T) {
 
 			w := httptest.NewRecorder()
 			c, _ := gin.CreateTestContext(w)
-			c.Params = append(c.Params, gin.Param{Key: "isbn", Value: "test"})
+			c.Request, _ = http.NewRequest(http.MethodGet, "/books/"+tt.isbn, nil)
+			c.Params = append(c.Params, gin.Param{Key: "isbn", Value: tt.isbn})
 			controller.GetByIsbn(c)
 
 			var actualBody gin.H
@@ -229,6 +431,137 @@ func Test_BookController_GetByIsbn(t *testing.T) {
 	}
 }
 
+func Test_BookController_Update(t *testing.T) {
+	book := entities.Book{
+		Isbn:           "test",
+		Author:         "test",
+		Title:          "test",
+		AvailableUnits: 1,
+	}
+	updated := entities.Book{
+		Isbn:           "9780306406157",
+		Author:         "author",
+		Title:          "title",
+		AvailableUnits: 2,
+	}
+	isbn, title, author := "9780306406157", "title", "author"
+	fullUpdate := service.BookUpdate{Isbn: &isbn, Title: &title, Author: &author}
+	titleUpdate := service.BookUpdate{Title: &title}
+
+	tests := []struct {
+		name            string
+		method          string
+		body            string
+		mockBookService func(m *mockBookService) *mockBookService
+		respBody        gin.H
+		statusCode      int
+	}{{
+		name:   "put",
+		method: http.MethodPut,
+		body:   `{"Isbn": "0-306-40615-2", "Title": "title", "Author": "author"}`,
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
+			m.On("Update", mock.Anything, book, fullUpdate).Return(updated, nil)
+			return m
+		},
+		statusCode: http.StatusOK,
+		respBody:   gin.H{"Author": "author", "AvailableUnits": float64(2), "Isbn": "9780306406157", "Title": "title"},
+	}, {
+		name:   "put with missing fields",
+		method: http.MethodPut,
+		body:   `{"Title": "title"}`,
+		mockBookService: func(m *mockBookService) *mockBookService {
+			return m
+		},
+		statusCode: http.StatusUnprocessableEntity,
+		respBody:   gin.H{errorMessage: incompleteBook},
+	}, {
+		name:   "patch",
+		method: http.MethodPatch,
+		body:   `{"Title": "title"}`,
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
+			m.On("Update", mock.Anything, book, titleUpdate).Return(updated, nil)
+			return m
+		},
+		statusCode: http.StatusOK,
+		respBody:   gin.H{"Author": "author", "AvailableUnits": float64(2), "Isbn": "9780306406157", "Title": "title"},
+	}, {
+		name:   "patch without changes",
+		method: http.MethodPatch,
+		body:   `{}`,
+		mockBookService: func(m *mockBookService) *mockBookService {
+			return m
+		},
+		statusCode: http.StatusUnprocessableEntity,
+		respBody:   gin.H{errorMessage: emptyUpdate},
+	}, {
+		name:   "empty title",
+		method: http.MethodPatch,
+		body:   `{"Title": ""}`,
+		mockBookService: func(m *mockBookService) *mockBookService {
+			return m
+		},
+		statusCode: http.StatusUnprocessableEntity,
+		respBody:   gin.H{errorMessage: invalidRequest},
+	}, {
+		name:   "invalid isbn",
+		method: http.MethodPatch,
+		body:   `{"Isbn": "978-0-13-468599-2"}`,
+		mockBookService: func(m *mockBookService) *mockBookService {
+			return m
+		},
+		statusCode: http.StatusUnprocessableEntity,
+		respBody:   gin.H{errorMessage: invalidIsbn},
+	}, {
+		name:   "book not found",
+		method: http.MethodPatch,
+		body:   `{"Title": "title"}`,
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(entities.Book{}, errors.New("Not found"))
+			return m
+		},
+		statusCode: http.StatusNotFound,
+		respBody:   gin.H{errorMessage: bookNotFound},
+	}, {
+		name:   "isbn of another book",
+		method: http.MethodPut,
+		body:   `{"Isbn": "0-306-40615-2", "Title": "title", "Author": "author"}`,
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
+			m.On("Update", mock.Anything, book, fullUpdate).Return(entities.Book{}, service.ErrBookConflict)
+			return m
+		},
+		statusCode: http.StatusConflict,
+		respBody:   gin.H{errorMessage: bookConflict},
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockBookService{}
+			controller := NewBookController(tt.mockBookService(mock))
+
+			w := httptest.NewRecorder()
+			_, r := gin.CreateTestContext(w)
+			r.PUT("/books/:isbn", controller.Update)
+			r.PATCH("/books/:isbn", controller.Patch)
+
+			req, _ := http.NewRequest(tt.method, "/books/"+hyphenatedIsbn, bytes.NewBufferString(tt.body))
+			r.ServeHTTP(w, req)
+
+			var actualBody gin.H
+			err := json.Unmarshal(w.Body.Bytes(), &actualBody)
+			if err != nil {
+				t.FailNow()
+			}
+
+			assert.Equal(t, tt.respBody, actualBody)
+			assert.Equal(t, tt.statusCode, w.Code)
+			mock.AssertExpectations(t)
+		})
+	}
+}
+
 func Test_BookController_Delete(t *testing.T) {
 	tests := []struct {
 		name            string
@@ -237,33 +570,42 @@ func Test_BookController_Delete(t *testing.T) {
 	}{{
 		name: "success",
 		mockBookService: func(m *mockBookService) *mockBookService {
-			m.On("FindByIsbn", "test").Return(entities.Book{}, nil)
-			m.On("IsBookTaken", "test").Return(false)
-			m.On("Delete", "test").Return(nil)
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(entities.Book{}, nil)
+			m.On("IsBookTaken", mock.Anything, testIsbn).Return(false)
+			m.On("Delete", mock.Anything, testIsbn).Return(nil)
 			return m
 		},
 		statusCode: 204,
 	}, {
 		name: "book not found",
 		mockBookService: func(m *mockBookService) *mockBookService {
-			m.On("FindByIsbn", "test").Return(entities.Book{}, errors.New("not found"))
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(entities.Book{}, errors.New("not found"))
 			return m
 		},
 		statusCode: 404,
 	}, {
 		name: "book is still take",
 		mockBookService: func(m *mockBookService) *mockBookService {
-			m.On("FindByIsbn", "test").Return(entities.Book{}, nil)
-			m.On("IsBookTaken", "test").Return(true)
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(entities.Book{}, nil)
+			m.On("IsBookTaken", mock.Anything, testIsbn).Return(true)
 			return m
 		},
 		statusCode: 400,
+	}, {
+		name: "book with loan history",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(entities.Book{}, nil)
+			m.On("IsBookTaken", mock.Anything, testIsbn).Return(false)
+			m.On("Delete", mock.Anything, testIsbn).Return(repositories.ErrBookHasLoans)
+			return m
+		},
+		statusCode: 409,
 	}, {
 		name: "error while deleting",
 		mockBookService: func(m *mockBookService) *mockBookService {
-			m.On("FindByIsbn", "test").Return(entities.Book{}, nil)
-			m.On("IsBookTaken", "test").Return(false)
-			m.On("Delete", "test").Return(errors.New("internal error"))
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(entities.Book{}, nil)
+			m.On("IsBookTaken", mock.Anything, testIsbn).Return(false)
+			m.On("Delete", mock.Anything, testIsbn).Return(errors.New("internal error"))
 			return m
 		},
 		statusCode: 500,
@@ -276,7 +618,8 @@ func Test_BookController_Delete(t *testing.T) {
 
 			w := httptest.NewRecorder()
 			c, _ := gin.CreateTestContext(w)
-			c.Params = append(c.Params, gin.Param{Key: "isbn", Value: "test"})
+			c.Request, _ = http.NewRequest(http.MethodDelete, "/books/"+hyphenatedIsbn, nil)
+			c.Params = append(c.Params, gin.Param{Key: "isbn", Value: hyphenatedIsbn})
 			controller.Delete(c)
 
 			assert.Equal(t, tt.statusCode, w.Code)
@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+	"github.com/mishozz/Library/service"
+	"gorm.io/gorm"
+)
+
+const (
+	categoryNotFound = "Patron category not found"
+	categoryConflict = "A patron category with this name already exists"
+	holdLimit        = "This user already has as many holds as their category allows"
+)
+
+// maxCategoryName is the length of the name column of the categories
+const maxCategoryName = 32
+
+// CategoryController is an interface with all the methods we need for the patron category controller
+type CategoryController interface {
+	GetAll(ctx *gin.Context)
+	Create(ctx *gin.Context)
+	Update(ctx *gin.Context)
+	Assign(ctx *gin.Context)
+}
+
+type categoryController struct {
+	categoryService service.CategoryService
+	userService     service.UserService
+}
+
+type assignRequest struct {
+	Category string `json:"Category" binding:"required"`
+}
+
+// NewCategoryController creates a new instance of the patron category controller
+func NewCategoryController(categoryService service.CategoryService, userService service.UserService) *categoryController {
+	return &categoryController{
+		categoryService: categoryService,
+		userService:     userService,
+	}
+}
+
+func (c *categoryController) GetAll(ctx *gin.Context) {
+	categories, err := c.categoryService.FindAll(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "Internal error"})
+		return
+	}
+	ctx.JSON(http.StatusOK, categories)
+}
+
+// Create adds the category in the request body with its circulation policy
+func (c *categoryController) Create(ctx *gin.Context) {
+	var category entities.PatronCategory
+	if err := ctx.ShouldBindJSON(&category); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: invalidRequest})
+		return
+	}
+	category.Name = strings.TrimSpace(category.Name)
+	if category.Name == "" || len(category.Name) > maxCategoryName {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: invalidRequest})
+		return
+	}
+	category, err := c.categoryService.Create(ctx.Request.Context(), category)
+	if errors.Is(err, repositories.ErrCategoryExists) {
+		ctx.JSON(http.StatusConflict, gin.H{errorMessage: categoryConflict})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "unable to create category"})
+		return
+	}
+	ctx.JSON(http.StatusCreated, category)
+}
+
+// Update replaces the circulation policy of the category in the name parameter
+func (c *categoryController) Update(ctx *gin.Context) {
+	var category entities.PatronCategory
+	if err := ctx.ShouldBindJSON(&category); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: invalidRequest})
+		return
+	}
+	category, err := c.categoryService.Update(ctx.Request.Context(), ctx.Param("name"), category)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: categoryNotFound})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "unable to update category"})
+		return
+	}
+	ctx.JSON(http.StatusOK, category)
+}
+
+// Assign moves the user in the email parameter to another category
+func (c *categoryController) Assign(ctx *gin.Context) {
+	var request assignRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: invalidRequest})
+		return
+	}
+	user, err := c.userService.FindByEmail(ctx.Request.Context(), ctx.Param("email"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: userNotFound})
+		return
+	}
+	err = c.categoryService.Assign(ctx.Request.Context(), user, request.Category)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: categoryNotFound})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "unable to assign category"})
+		return
+	}
+	ctx.JSON(http.StatusNoContent, gin.H{message: "Category successfully assigned"})
+}
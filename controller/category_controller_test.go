@@ -0,0 +1,284 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+// testCategories are the patron categories the seed migration creates
+var testCategories = []entities.PatronCategory{
+	{Name: entities.CategoryPublic, LoanDays: 14, MaxLoans: 5, MaxRenewals: 2, FineRate: 25, MaxHolds: 3},
+	{Name: entities.CategoryStudent, LoanDays: 21, MaxLoans: 10, MaxRenewals: 3, FineRate: 10, MaxHolds: 5},
+	{Name: entities.CategoryStaff, LoanDays: 28, MaxLoans: 20, MaxRenewals: 5, FineRate: 0, MaxHolds: 10},
+}
+
+type mockCategoryService struct {
+	mock.Mock
+}
+
+func (m *mockCategoryService) FindAll(ctx context.Context) ([]entities.PatronCategory, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]entities.PatronCategory), args.Error(1)
+}
+
+func (m *mockCategoryService) Find(ctx context.Context, name string) (entities.PatronCategory, error) {
+	args := m.Called(ctx, name)
+	return args.Get(0).(entities.PatronCategory), args.Error(1)
+}
+
+func (m *mockCategoryService) Create(ctx context.Context, category entities.PatronCategory) (entities.PatronCategory, error) {
+	args := m.Called(ctx, category)
+	return args.Get(0).(entities.PatronCategory), args.Error(1)
+}
+
+func (m *mockCategoryService) Update(ctx context.Context, name string, category entities.PatronCategory) (entities.PatronCategory, error) {
+	args := m.Called(ctx, name, category)
+	return args.Get(0).(entities.PatronCategory), args.Error(1)
+}
+
+func (m *mockCategoryService) Assign(ctx context.Context, user entities.User, name string) error {
+	args := m.Called(ctx, user, name)
+	return args.Error(0)
+}
+
+func Test_NewCategoryController(t *testing.T) {
+	categoryController := NewCategoryController(&mockCategoryService{}, &mockUserService{})
+	assert.NotNil(t, categoryController.categoryService)
+	assert.NotNil(t, categoryController.userService)
+}
+
+func Test_CategoryController_GetAll(t *testing.T) {
+	categories := testCategories
+	mockCategories := &mockCategoryService{}
+	mockCategories.On("FindAll", mock.Anything).Return(categories, nil)
+	categoryController := NewCategoryController(mockCategories, &mockUserService{})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/categories", nil)
+	categoryController.GetAll(c)
+
+	var actual []entities.PatronCategory
+	err := json.Unmarshal(w.Body.Bytes(), &actual)
+	if err != nil {
+		t.FailNow()
+	}
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, categories, actual)
+	mockCategories.AssertExpectations(t)
+}
+
+func Test_CategoryController_Create(t *testing.T) {
+	alumni := entities.PatronCategory{Name: "alumni", LoanDays: 14, MaxLoans: 3, MaxRenewals: 1, FineRate: 25, MaxHolds: 2}
+
+	tests := []struct {
+		name                string
+		body                string
+		mockCategoryService func(m *mockCategoryService) *mockCategoryService
+		respStatus          int
+		respBody            gin.H
+	}{{
+		name: "success",
+		body: `{"Name": " alumni ", "LoanDays": 14, "MaxLoans": 3, "MaxRenewals": 1, "FineRate": 25, "MaxHolds": 2}`,
+		mockCategoryService: func(m *mockCategoryService) *mockCategoryService {
+			m.On("Create", mock.Anything, alumni).Return(alumni, nil)
+			return m
+		},
+		respStatus: http.StatusCreated,
+	}, {
+		name: "name missing",
+		body: `{"LoanDays": 14, "MaxLoans": 3}`,
+		mockCategoryService: func(m *mockCategoryService) *mockCategoryService {
+			return m
+		},
+		respStatus: http.StatusUnprocessableEntity,
+		respBody:   gin.H{errorMessage: invalidRequest},
+	}, {
+		name: "name too long",
+		body: `{"Name": "` + strings.Repeat("a", 33) + `", "LoanDays": 14, "MaxLoans": 3}`,
+		mockCategoryService: func(m *mockCategoryService) *mockCategoryService {
+			return m
+		},
+		respStatus: http.StatusUnprocessableEntity,
+		respBody:   gin.H{errorMessage: invalidRequest},
+	}, {
+		name: "name taken",
+		body: `{"Name": "alumni", "LoanDays": 14, "MaxLoans": 3, "MaxRenewals": 1, "FineRate": 25, "MaxHolds": 2}`,
+		mockCategoryService: func(m *mockCategoryService) *mockCategoryService {
+			m.On("Create", mock.Anything, alumni).Return(entities.PatronCategory{}, repositories.ErrCategoryExists)
+			return m
+		},
+		respStatus: http.StatusConflict,
+		respBody:   gin.H{errorMessage: categoryConflict},
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockCategories := tt.mockCategoryService(&mockCategoryService{})
+			categoryController := NewCategoryController(mockCategories, &mockUserService{})
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodPost, "/categories", bytes.NewBufferString(tt.body))
+			categoryController.Create(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			if tt.respBody != nil {
+				var actual gin.H
+				if err := json.Unmarshal(w.Body.Bytes(), &actual); err != nil {
+					t.FailNow()
+				}
+				assert.Equal(t, tt.respBody, actual)
+			}
+			mockCategories.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_CategoryController_Update(t *testing.T) {
+	policy := entities.PatronCategory{LoanDays: 7, MaxLoans: 2, MaxRenewals: 1, FineRate: 50, MaxHolds: 1}
+	updated := policy
+	updated.Name = entities.CategoryPublic
+
+	tests := []struct {
+		name                string
+		body                string
+		mockCategoryService func(m *mockCategoryService) *mockCategoryService
+		respStatus          int
+	}{{
+		name: "success",
+		body: `{"LoanDays": 7, "MaxLoans": 2, "MaxRenewals": 1, "FineRate": 50, "MaxHolds": 1}`,
+		mockCategoryService: func(m *mockCategoryService) *mockCategoryService {
+			m.On("Update", mock.Anything, entities.CategoryPublic, policy).Return(updated, nil)
+			return m
+		},
+		respStatus: http.StatusOK,
+	}, {
+		name: "no loans allowed",
+		body: `{"LoanDays": 7, "MaxLoans": 0}`,
+		mockCategoryService: func(m *mockCategoryService) *mockCategoryService {
+			return m
+		},
+		respStatus: http.StatusUnprocessableEntity,
+	}, {
+		name: "category not found",
+		body: `{"LoanDays": 7, "MaxLoans": 2, "MaxRenewals": 1, "FineRate": 50, "MaxHolds": 1}`,
+		mockCategoryService: func(m *mockCategoryService) *mockCategoryService {
+			m.On("Update", mock.Anything, entities.CategoryPublic, policy).Return(entities.PatronCategory{}, gorm.ErrRecordNotFound)
+			return m
+		},
+		respStatus: http.StatusNotFound,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockCategories := tt.mockCategoryService(&mockCategoryService{})
+			categoryController := NewCategoryController(mockCategories, &mockUserService{})
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodPut, "/categories/public", bytes.NewBufferString(tt.body))
+			c.Params = append(c.Params, gin.Param{Key: "name", Value: entities.CategoryPublic})
+			categoryController.Update(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			if tt.respStatus == http.StatusOK {
+				var actual entities.PatronCategory
+				err := json.Unmarshal(w.Body.Bytes(), &actual)
+				if err != nil {
+					t.FailNow()
+				}
+				assert.Equal(t, updated, actual)
+			}
+			mockCategories.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_CategoryController_Assign(t *testing.T) {
+	user := entities.User{Email: "email", Category: entities.CategoryPublic}
+
+	tests := []struct {
+		name                string
+		body                string
+		mockCategoryService func(m *mockCategoryService) *mockCategoryService
+		mockUserService     func(m *mockUserService) *mockUserService
+		respStatus          int
+	}{{
+		name: "success",
+		body: `{"Category": "staff"}`,
+		mockCategoryService: func(m *mockCategoryService) *mockCategoryService {
+			m.On("Assign", mock.Anything, user, entities.CategoryStaff).Return(nil)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		respStatus: http.StatusNoContent,
+	}, {
+		name: "missing category",
+		body: `{}`,
+		mockCategoryService: func(m *mockCategoryService) *mockCategoryService {
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			return m
+		},
+		respStatus: http.StatusUnprocessableEntity,
+	}, {
+		name: "user not found",
+		body: `{"Category": "staff"}`,
+		mockCategoryService: func(m *mockCategoryService) *mockCategoryService {
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{}, errors.New("Not found"))
+			return m
+		},
+		respStatus: http.StatusNotFound,
+	}, {
+		name: "category not found",
+		body: `{"Category": "alumni"}`,
+		mockCategoryService: func(m *mockCategoryService) *mockCategoryService {
+			m.On("Assign", mock.Anything, user, "alumni").Return(gorm.ErrRecordNotFound)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		respStatus: http.StatusNotFound,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockCategories := tt.mockCategoryService(&mockCategoryService{})
+			mockUsers := tt.mockUserService(&mockUserService{})
+			categoryController := NewCategoryController(mockCategories, mockUsers)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodPut, "/users/email/category", bytes.NewBufferString(tt.body))
+			c.Params = append(c.Params, gin.Param{Key: "email", Value: "email"})
+			categoryController.Assign(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			mockCategories.AssertExpectations(t)
+			mockUsers.AssertExpectations(t)
+		})
+	}
+}
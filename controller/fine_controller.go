@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/policy"
+	"github.com/mishozz/Library/service"
+)
+
+const (
+	finesOutstanding     = "Outstanding fines must be paid before taking more books"
+	amountExceedsBalance = "The amount is larger than the balance of the user"
+)
+
+// FineController is an interface with all the methods we need for the fine controller
+type FineController interface {
+	GetByUser(ctx *gin.Context)
+	Pay(ctx *gin.Context)
+	Waive(ctx *gin.Context)
+}
+
+type fineController struct {
+	fineService service.FineService
+	userService service.UserService
+	policy      policy.OwnershipPolicy
+}
+
+// NewFineController creates a new instance of the fine controller
+func NewFineController(fineService service.FineService, userService service.UserService, policy policy.OwnershipPolicy) *fineController {
+	return &fineController{
+		fineService: fineService,
+		userService: userService,
+		policy:      policy,
+	}
+}
+
+// GetByUser returns the balance and the ledger of the user
+func (c *fineController) GetByUser(ctx *gin.Context) {
+	user, err := c.userService.FindByEmail(ctx.Request.Context(), ctx.Param("email"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: userNotFound})
+		return
+	}
+	if !authorizeLoan(ctx, c.policy, user) {
+		return
+	}
+	account, err := c.fineService.FindAccount(ctx.Request.Context(), user)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "Internal error"})
+		return
+	}
+	ctx.JSON(http.StatusOK, account)
+}
+
+// Pay records a payment of the user
+func (c *fineController) Pay(ctx *gin.Context) {
+	c.settle(ctx, c.fineService.Pay)
+}
+
+// Waive forgives a part of the balance of the user
+func (c *fineController) Waive(ctx *gin.Context) {
+	c.settle(ctx, c.fineService.Waive)
+}
+
+func (c *fineController) settle(ctx *gin.Context, record func(ctx context.Context, user entities.User, settlement service.Settlement) (entities.LedgerEntry, error)) {
+	var settlement service.Settlement
+	if err := ctx.ShouldBindJSON(&settlement); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: invalidRequest})
+		return
+	}
+	user, err := c.userService.FindByEmail(ctx.Request.Context(), ctx.Param("email"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: userNotFound})
+		return
+	}
+	entry, err := record(ctx.Request.Context(), user, settlement)
+	if errors.Is(err, service.ErrAmountExceedsBalance) {
+		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: amountExceedsBalance})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "unable to record the settlement"})
+		return
+	}
+	ctx.JSON(http.StatusCreated, entry)
+}
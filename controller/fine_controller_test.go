@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockFineService struct {
+	mock.Mock
+}
+
+func (m *mockFineService) FindAccount(ctx context.Context, user entities.User) (service.Account, error) {
+	args := m.Called(ctx, user)
+	return args.Get(0).(service.Account), args.Error(1)
+}
+
+func (m *mockFineService) Pay(ctx context.Context, user entities.User, payment service.Settlement) (entities.LedgerEntry, error) {
+	args := m.Called(ctx, user, payment)
+	return args.Get(0).(entities.LedgerEntry), args.Error(1)
+}
+
+func (m *mockFineService) Waive(ctx context.Context, user entities.User, waiver service.Settlement) (entities.LedgerEntry, error) {
+	args := m.Called(ctx, user, waiver)
+	return args.Get(0).(entities.LedgerEntry), args.Error(1)
+}
+
+func Test_NewFineController(t *testing.T) {
+	fineController := NewFineController(&mockFineService{}, &mockUserService{}, &mockOwnershipPolicy{})
+	assert.NotNil(t, fineController.fineService)
+	assert.NotNil(t, fineController.userService)
+	assert.NotNil(t, fineController.policy)
+}
+
+func Test_FineController_GetByUser(t *testing.T) {
+	user := entities.User{Email: "email"}
+	account := service.Account{
+		Balance: 200,
+		Entries: []entities.LedgerEntry{{ID: 1, Kind: entities.LedgerCharge, Amount: 200}},
+	}
+	mockFines := &mockFineService{}
+	mockFines.On("FindAccount", mock.Anything, user).Return(account, nil)
+	mockUsers := &mockUserService{}
+	mockUsers.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+	mockPolicy := allowLoans()
+	fineController := NewFineController(mockFines, mockUsers, mockPolicy)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/users/email/fines", nil)
+	c.Params = append(c.Params, gin.Param{Key: "email", Value: "email"})
+	fineController.GetByUser(c)
+
+	var actual service.Account
+	err := json.Unmarshal(w.Body.Bytes(), &actual)
+	if err != nil {
+		t.FailNow()
+	}
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, account, actual)
+	mockFines.AssertExpectations(t)
+	mockPolicy.AssertExpectations(t)
+}
+
+func Test_FineController_Pay(t *testing.T) {
+	user := entities.User{Email: "email"}
+	payment := service.Settlement{Amount: 150, Note: "cash"}
+	entry := entities.LedgerEntry{ID: 2, Kind: entities.LedgerPayment, Amount: 150, Note: "cash"}
+
+	tests := []struct {
+		name            string
+		body            string
+		mockFineService func(m *mockFineService) *mockFineService
+		mockUserService func(m *mockUserService) *mockUserService
+		respStatus      int
+	}{{
+		name: "success",
+		body: `{"Amount": 150, "Note": "cash"}`,
+		mockFineService: func(m *mockFineService) *mockFineService {
+			m.On("Pay", mock.Anything, user, payment).Return(entry, nil)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		respStatus: http.StatusCreated,
+	}, {
+		name: "non positive amount",
+		body: `{"Amount": -5}`,
+		mockFineService: func(m *mockFineService) *mockFineService {
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			return m
+		},
+		respStatus: http.StatusUnprocessableEntity,
+	}, {
+		name: "user not found",
+		body: `{"Amount": 150, "Note": "cash"}`,
+		mockFineService: func(m *mockFineService) *mockFineService {
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{}, errors.New("Not found"))
+			return m
+		},
+		respStatus: http.StatusNotFound,
+	}, {
+		name: "more than the balance",
+		body: `{"Amount": 150, "Note": "cash"}`,
+		mockFineService: func(m *mockFineService) *mockFineService {
+			m.On("Pay", mock.Anything, user, payment).Return(entities.LedgerEntry{}, service.ErrAmountExceedsBalance)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		respStatus: http.StatusBadRequest,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockFines := tt.mockFineService(&mockFineService{})
+			mockUsers := tt.mockUserService(&mockUserService{})
+			fineController := NewFineController(mockFines, mockUsers, &mockOwnershipPolicy{})
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodPost, "/fines/email/payments", bytes.NewBufferString(tt.body))
+			c.Params = append(c.Params, gin.Param{Key: "email", Value: "email"})
+			fineController.Pay(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			if tt.respStatus == http.StatusCreated {
+				var actual entities.LedgerEntry
+				err := json.Unmarshal(w.Body.Bytes(), &actual)
+				if err != nil {
+					t.FailNow()
+				}
+				assert.Equal(t, entry, actual)
+			}
+			mockFines.AssertExpectations(t)
+			mockUsers.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_FineController_Waive(t *testing.T) {
+	user := entities.User{Email: "email"}
+	waiver := service.Settlement{Amount: 100, Note: "first time"}
+	mockFines := &mockFineService{}
+	mockFines.On("Waive", mock.Anything, user, waiver).Return(entities.LedgerEntry{ID: 3, Kind: entities.LedgerWaiver, Amount: 100}, nil)
+	mockUsers := &mockUserService{}
+	mockUsers.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+	fineController := NewFineController(mockFines, mockUsers, &mockOwnershipPolicy{})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodPost, "/fines/email/waivers", bytes.NewBufferString(`{"Amount": 100, "Note": "first time"}`))
+	c.Params = append(c.Params, gin.Param{Key: "email", Value: "email"})
+	fineController.Waive(c)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	mockFines.AssertExpectations(t)
+}
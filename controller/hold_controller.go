@@ -0,0 +1,180 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/policy"
+	"github.com/mishozz/Library/repositories"
+	"github.com/mishozz/Library/service"
+)
+
+const (
+	holdNotFound    = "There is no active hold on this book"
+	copiesAvailable = "This book has available copies, take it instead"
+	alreadyOnHold   = "This book is already on hold for this user"
+	invalidPosition = "The position is outside of the queue"
+)
+
+// HoldController is an interface with all the methods we need for the hold controller
+type HoldController interface {
+	Place(ctx *gin.Context)
+	Cancel(ctx *gin.Context)
+	GetByUser(ctx *gin.Context)
+	GetQueue(ctx *gin.Context)
+	Reorder(ctx *gin.Context)
+}
+
+type holdController struct {
+	holdService service.HoldService
+	userService service.UserService
+	bookService service.BookService
+	policy      policy.OwnershipPolicy
+}
+
+type reorderRequest struct {
+	Position int `json:"Position" binding:"required"`
+}
+
+// NewHoldController creates a new instance of the hold controller
+func NewHoldController(holdService service.HoldService, userService service.UserService, bookService service.BookService, policy policy.OwnershipPolicy) *holdController {
+	return &holdController{
+		holdService: holdService,
+		userService: userService,
+		bookService: bookService,
+		policy:      policy,
+	}
+}
+
+func (c *holdController) Place(ctx *gin.Context) {
+	user, book, ok := c.findUserAndBook(ctx)
+	if !ok {
+		return
+	}
+	hold, err := c.holdService.Place(ctx.Request.Context(), user, book)
+	switch {
+	case errors.Is(err, service.ErrCopiesAvailable):
+		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: copiesAvailable})
+	case errors.Is(err, service.ErrAlreadyOnHold):
+		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: alreadyOnHold})
+	case errors.Is(err, repositories.ErrBookAlreadyTaken):
+		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: bookAlreadyTaken})
+	case errors.Is(err, service.ErrHoldLimit):
+		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: holdLimit})
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "unable to place hold"})
+	default:
+		ctx.JSON(http.StatusCreated, hold)
+	}
+}
+
+func (c *holdController) Cancel(ctx *gin.Context) {
+	user, book, ok := c.findUserAndBook(ctx)
+	if !ok {
+		return
+	}
+	err := c.holdService.Cancel(ctx.Request.Context(), user, book)
+	if errors.Is(err, repositories.ErrHoldNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: holdNotFound})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "unable to cancel hold"})
+		return
+	}
+	ctx.JSON(http.StatusNoContent, gin.H{message: "Hold successfully cancelled"})
+}
+
+func (c *holdController) GetByUser(ctx *gin.Context) {
+	user, err := c.userService.FindByEmail(ctx.Request.Context(), ctx.Param("email"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: userNotFound})
+		return
+	}
+	if !authorizeLoan(ctx, c.policy, user) {
+		return
+	}
+	holds, err := c.holdService.FindByUser(ctx.Request.Context(), user)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "Internal error"})
+		return
+	}
+	ctx.JSON(http.StatusOK, holds)
+}
+
+func (c *holdController) GetQueue(ctx *gin.Context) {
+	isbn, ok := bookIsbn(ctx)
+	if !ok {
+		return
+	}
+	book, err := c.bookService.FindByIsbn(ctx.Request.Context(), isbn)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: bookNotFound})
+		return
+	}
+	holds, err := c.holdService.FindQueue(ctx.Request.Context(), book)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "Internal error"})
+		return
+	}
+	ctx.JSON(http.StatusOK, holds)
+}
+
+func (c *holdController) Reorder(ctx *gin.Context) {
+	var req reorderRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: invalidRequest})
+		return
+	}
+	holdId, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: holdNotFound})
+		return
+	}
+	isbn, ok := bookIsbn(ctx)
+	if !ok {
+		return
+	}
+	book, err := c.bookService.FindByIsbn(ctx.Request.Context(), isbn)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: bookNotFound})
+		return
+	}
+	err = c.holdService.Reorder(ctx.Request.Context(), book, uint(holdId), req.Position)
+	switch {
+	case errors.Is(err, repositories.ErrHoldNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: holdNotFound})
+	case errors.Is(err, service.ErrInvalidPosition):
+		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: invalidPosition})
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "unable to reorder holds"})
+	default:
+		ctx.JSON(http.StatusOK, gin.H{message: "Hold successfully moved"})
+	}
+}
+
+// findUserAndBook resolves the patron and the book of a hold request and checks that
+// the caller may act on behalf of the patron
+func (c *holdController) findUserAndBook(ctx *gin.Context) (entities.User, entities.Book, bool) {
+	user, err := c.userService.FindByEmail(ctx.Request.Context(), ctx.Param("email"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: userNotFound})
+		return user, entities.Book{}, false
+	}
+	if !authorizeLoan(ctx, c.policy, user) {
+		return user, entities.Book{}, false
+	}
+	isbn, ok := bookIsbn(ctx)
+	if !ok {
+		return user, entities.Book{}, false
+	}
+	book, err := c.bookService.FindByIsbn(ctx.Request.Context(), isbn)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: bookNotFound})
+		return user, book, false
+	}
+	return user, book, true
+}
@@ -0,0 +1,368 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/policy"
+	"github.com/mishozz/Library/repositories"
+	"github.com/mishozz/Library/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockHoldService struct {
+	mock.Mock
+}
+
+func (m *mockHoldService) Place(ctx context.Context, user entities.User, book entities.Book) (entities.Hold, error) {
+	args := m.Called(ctx, user, book)
+	return args.Get(0).(entities.Hold), args.Error(1)
+}
+
+func (m *mockHoldService) Cancel(ctx context.Context, user entities.User, book entities.Book) error {
+	args := m.Called(ctx, user, book)
+	return args.Error(0)
+}
+
+func (m *mockHoldService) FindByUser(ctx context.Context, user entities.User) ([]entities.Hold, error) {
+	args := m.Called(ctx, user)
+	return args.Get(0).([]entities.Hold), args.Error(1)
+}
+
+func (m *mockHoldService) FindQueue(ctx context.Context, book entities.Book) ([]entities.Hold, error) {
+	args := m.Called(ctx, book)
+	return args.Get(0).([]entities.Hold), args.Error(1)
+}
+
+func (m *mockHoldService) Reorder(ctx context.Context, book entities.Book, holdId uint, position int) error {
+	args := m.Called(ctx, book, holdId, position)
+	return args.Error(0)
+}
+
+func (m *mockHoldService) ExpireReady(ctx context.Context, now time.Time) (int, error) {
+	args := m.Called(ctx, now)
+	return args.Int(0), args.Error(1)
+}
+
+func Test_NewHoldController(t *testing.T) {
+	holdController := NewHoldController(&mockHoldService{}, &mockUserService{}, &mockBookService{}, &mockOwnershipPolicy{})
+	assert.NotNil(t, holdController.holdService)
+	assert.NotNil(t, holdController.userService)
+	assert.NotNil(t, holdController.bookService)
+	assert.NotNil(t, holdController.policy)
+}
+
+func Test_HoldController_Place(t *testing.T) {
+	user := entities.User{Email: "email"}
+	book := entities.Book{Isbn: "test", Author: "test", Title: "test"}
+	hold := entities.Hold{ID: 1, Book: book, Position: 1, Status: entities.HoldWaiting}
+
+	tests := []struct {
+		name            string
+		mockHoldService func(m *mockHoldService) *mockHoldService
+		mockUserService func(m *mockUserService) *mockUserService
+		mockBookService func(m *mockBookService) *mockBookService
+		policy          *mockOwnershipPolicy
+		respStatus      int
+		respBody        gin.H
+	}{{
+		name: "success",
+		mockHoldService: func(m *mockHoldService) *mockHoldService {
+			m.On("Place", mock.Anything, user, book).Return(hold, nil)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
+			return m
+		},
+		respStatus: 201,
+	}, {
+		name: "copies on the shelf",
+		mockHoldService: func(m *mockHoldService) *mockHoldService {
+			m.On("Place", mock.Anything, user, book).Return(entities.Hold{}, service.ErrCopiesAvailable)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
+			return m
+		},
+		respStatus: 400,
+		respBody:   gin.H{errorMessage: copiesAvailable},
+	}, {
+		name: "already on hold",
+		mockHoldService: func(m *mockHoldService) *mockHoldService {
+			m.On("Place", mock.Anything, user, book).Return(entities.Hold{}, service.ErrAlreadyOnHold)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
+			return m
+		},
+		respStatus: 400,
+		respBody:   gin.H{errorMessage: alreadyOnHold},
+	}, {
+		name: "hold limit of the category",
+		mockHoldService: func(m *mockHoldService) *mockHoldService {
+			m.On("Place", mock.Anything, user, book).Return(entities.Hold{}, service.ErrHoldLimit)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
+			return m
+		},
+		respStatus: 400,
+		respBody:   gin.H{errorMessage: holdLimit},
+	}, {
+		name: "book not found",
+		mockHoldService: func(m *mockHoldService) *mockHoldService {
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(entities.Book{}, errors.New("Not found"))
+			return m
+		},
+		respStatus: 404,
+		respBody:   gin.H{errorMessage: bookNotFound},
+	}, {
+		name: "hold for another user",
+		mockHoldService: func(m *mockHoldService) *mockHoldService {
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		mockBookService: func(m *mockBookService) *mockBookService {
+			return m
+		},
+		policy: func() *mockOwnershipPolicy {
+			m := &mockOwnershipPolicy{}
+			m.On("AuthorizeLoan", mock.Anything, user).Return(policy.ErrForbidden)
+			return m
+		}(),
+		respStatus: 403,
+		respBody:   gin.H{errorMessage: forbiddenLoan},
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockHolds := tt.mockHoldService(&mockHoldService{})
+			mockUsers := tt.mockUserService(&mockUserService{})
+			mockBooks := tt.mockBookService(&mockBookService{})
+			mockPolicy := tt.policy
+			if mockPolicy == nil {
+				mockPolicy = allowLoans()
+			}
+			holdController := NewHoldController(mockHolds, mockUsers, mockBooks, mockPolicy)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodPost, "/holds/email/test", nil)
+			c.Params = append(c.Params, gin.Param{Key: "email", Value: "email"}, gin.Param{Key: "isbn", Value: hyphenatedIsbn})
+			holdController.Place(c)
+
+			if tt.respBody != nil {
+				var actualBody gin.H
+				err := json.Unmarshal(w.Body.Bytes(), &actualBody)
+				if err != nil {
+					t.FailNow()
+				}
+				assert.Equal(t, tt.respBody, actualBody)
+			} else {
+				var actualHold entities.Hold
+				err := json.Unmarshal(w.Body.Bytes(), &actualHold)
+				if err != nil {
+					t.FailNow()
+				}
+				assert.Equal(t, hold, actualHold)
+			}
+			assert.Equal(t, tt.respStatus, w.Code)
+			mockHolds.AssertExpectations(t)
+			mockUsers.AssertExpectations(t)
+			mockBooks.AssertExpectations(t)
+			mockPolicy.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_HoldController_Cancel(t *testing.T) {
+	user := entities.User{Email: "email"}
+	book := entities.Book{Isbn: "test", Author: "test", Title: "test"}
+
+	tests := []struct {
+		name            string
+		mockHoldService func(m *mockHoldService) *mockHoldService
+		respStatus      int
+	}{{
+		name: "success",
+		mockHoldService: func(m *mockHoldService) *mockHoldService {
+			m.On("Cancel", mock.Anything, user, book).Return(nil)
+			return m
+		},
+		respStatus: 204,
+	}, {
+		name: "no active hold",
+		mockHoldService: func(m *mockHoldService) *mockHoldService {
+			m.On("Cancel", mock.Anything, user, book).Return(repositories.ErrHoldNotFound)
+			return m
+		},
+		respStatus: 404,
+	}, {
+		name: "internal error",
+		mockHoldService: func(m *mockHoldService) *mockHoldService {
+			m.On("Cancel", mock.Anything, user, book).Return(errors.New("database is locked"))
+			return m
+		},
+		respStatus: 500,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockHolds := tt.mockHoldService(&mockHoldService{})
+			mockUsers := &mockUserService{}
+			mockUsers.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			mockBooks := &mockBookService{}
+			mockBooks.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
+			holdController := NewHoldController(mockHolds, mockUsers, mockBooks, allowLoans())
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodDelete, "/holds/email/test", nil)
+			c.Params = append(c.Params, gin.Param{Key: "email", Value: "email"}, gin.Param{Key: "isbn", Value: hyphenatedIsbn})
+			holdController.Cancel(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			mockHolds.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_HoldController_GetQueue(t *testing.T) {
+	book := entities.Book{Isbn: "test", Author: "test", Title: "test"}
+	queue := []entities.Hold{
+		{ID: 1, Patron: "email1", Book: book, Position: 1, Status: entities.HoldWaiting},
+		{ID: 2, Patron: "email2", Book: book, Position: 2, Status: entities.HoldWaiting},
+	}
+	mockHolds := &mockHoldService{}
+	mockHolds.On("FindQueue", mock.Anything, book).Return(queue, nil)
+	mockBooks := &mockBookService{}
+	mockBooks.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
+	holdController := NewHoldController(mockHolds, &mockUserService{}, mockBooks, &mockOwnershipPolicy{})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/books/test/holds", nil)
+	c.Params = append(c.Params, gin.Param{Key: "isbn", Value: hyphenatedIsbn})
+	holdController.GetQueue(c)
+
+	var actualQueue []entities.Hold
+	err := json.Unmarshal(w.Body.Bytes(), &actualQueue)
+	if err != nil {
+		t.FailNow()
+	}
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, queue, actualQueue)
+	mockHolds.AssertExpectations(t)
+}
+
+func Test_HoldController_Reorder(t *testing.T) {
+	book := entities.Book{Isbn: "test", Author: "test", Title: "test"}
+
+	tests := []struct {
+		name            string
+		holdId          string
+		body            string
+		mockHoldService func(m *mockHoldService) *mockHoldService
+		respStatus      int
+	}{{
+		name:   "success",
+		holdId: "3",
+		body:   `{"Position": 1}`,
+		mockHoldService: func(m *mockHoldService) *mockHoldService {
+			m.On("Reorder", mock.Anything, book, uint(3), 1).Return(nil)
+			return m
+		},
+		respStatus: 200,
+	}, {
+		name:   "position outside of the queue",
+		holdId: "3",
+		body:   `{"Position": 5}`,
+		mockHoldService: func(m *mockHoldService) *mockHoldService {
+			m.On("Reorder", mock.Anything, book, uint(3), 5).Return(service.ErrInvalidPosition)
+			return m
+		},
+		respStatus: 400,
+	}, {
+		name:   "hold not waiting",
+		holdId: "3",
+		body:   `{"Position": 1}`,
+		mockHoldService: func(m *mockHoldService) *mockHoldService {
+			m.On("Reorder", mock.Anything, book, uint(3), 1).Return(repositories.ErrHoldNotFound)
+			return m
+		},
+		respStatus: 404,
+	}, {
+		name:   "invalid hold id",
+		holdId: "abc",
+		body:   `{"Position": 1}`,
+		mockHoldService: func(m *mockHoldService) *mockHoldService {
+			return m
+		},
+		respStatus: 404,
+	}, {
+		name:   "missing position",
+		holdId: "3",
+		body:   `{}`,
+		mockHoldService: func(m *mockHoldService) *mockHoldService {
+			return m
+		},
+		respStatus: 422,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockHolds := tt.mockHoldService(&mockHoldService{})
+			mockBooks := &mockBookService{}
+			mockBooks.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
+			holdController := NewHoldController(mockHolds, &mockUserService{}, mockBooks, &mockOwnershipPolicy{})
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodPut, "/books/test/holds/"+tt.holdId, bytes.NewBufferString(tt.body))
+			c.Params = append(c.Params, gin.Param{Key: "isbn", Value: hyphenatedIsbn}, gin.Param{Key: "id", Value: tt.holdId})
+			holdController.Reorder(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			mockHolds.AssertExpectations(t)
+		})
+	}
+}
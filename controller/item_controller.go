@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+	"github.com/mishozz/Library/service"
+)
+
+const (
+	itemNotFound     = "Item not found"
+	barcodeConflict  = "Every item must have a unique barcode!"
+	itemNotAvailable = "This item is not on the shelf"
+)
+
+// ItemController is an interface with all the methods we need for the item controller
+type ItemController interface {
+	GetByBook(ctx *gin.Context)
+	GetByBarcode(ctx *gin.Context)
+	Add(ctx *gin.Context)
+	Update(ctx *gin.Context)
+	Withdraw(ctx *gin.Context)
+}
+
+type itemController struct {
+	itemService service.ItemService
+	bookService service.BookService
+}
+
+// NewItemController creates a new instance of the item controller
+func NewItemController(itemService service.ItemService, bookService service.BookService) *itemController {
+	return &itemController{
+		itemService: itemService,
+		bookService: bookService,
+	}
+}
+
+func (c *itemController) GetByBook(ctx *gin.Context) {
+	book, ok := c.findBook(ctx)
+	if !ok {
+		return
+	}
+	items, err := c.itemService.FindByBook(ctx.Request.Context(), book)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "Internal error"})
+		return
+	}
+	ctx.JSON(http.StatusOK, items)
+}
+
+func (c *itemController) GetByBarcode(ctx *gin.Context) {
+	item, err := c.itemService.Find(ctx.Request.Context(), ctx.Param("barcode"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: itemNotFound})
+		return
+	}
+	ctx.JSON(http.StatusOK, item)
+}
+
+func (c *itemController) Add(ctx *gin.Context) {
+	var item entities.Item
+	if err := ctx.ShouldBindJSON(&item); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: invalidRequest})
+		return
+	}
+	book, ok := c.findBook(ctx)
+	if !ok {
+		return
+	}
+	item, err := c.itemService.Add(ctx.Request.Context(), book, item)
+	if errors.Is(err, service.ErrBarcodeConflict) {
+		ctx.JSON(http.StatusConflict, gin.H{errorMessage: barcodeConflict})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "unable to add item"})
+		return
+	}
+	ctx.JSON(http.StatusCreated, item)
+}
+
+// Update changes the condition or the location of the item
+func (c *itemController) Update(ctx *gin.Context) {
+	var update service.ItemUpdate
+	if err := ctx.ShouldBindJSON(&update); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: invalidRequest})
+		return
+	}
+	if update == (service.ItemUpdate{}) {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: emptyUpdate})
+		return
+	}
+	item, err := c.itemService.Find(ctx.Request.Context(), ctx.Param("barcode"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: itemNotFound})
+		return
+	}
+	item, err = c.itemService.Update(ctx.Request.Context(), item, update)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "error while updating"})
+		return
+	}
+	ctx.JSON(http.StatusOK, item)
+}
+
+// Withdraw takes a copy on the shelf out of the collection
+func (c *itemController) Withdraw(ctx *gin.Context) {
+	item, err := c.itemService.Find(ctx.Request.Context(), ctx.Param("barcode"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: itemNotFound})
+		return
+	}
+	err = c.itemService.Withdraw(ctx.Request.Context(), item)
+	if errors.Is(err, repositories.ErrItemNotAvailable) {
+		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: itemNotAvailable})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "error while withdrawing"})
+		return
+	}
+	ctx.JSON(http.StatusNoContent, gin.H{message: "Item withdrawn"})
+}
+
+func (c *itemController) findBook(ctx *gin.Context) (entities.Book, bool) {
+	isbn, ok := bookIsbn(ctx)
+	if !ok {
+		return entities.Book{}, false
+	}
+	book, err := c.bookService.FindByIsbn(ctx.Request.Context(), isbn)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: bookNotFound})
+		return book, false
+	}
+	return book, true
+}
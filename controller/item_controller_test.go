@@ -0,0 +1,285 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+	"github.com/mishozz/Library/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+type mockItemService struct {
+	mock.Mock
+}
+
+func (m *mockItemService) Add(ctx context.Context, book entities.Book, item entities.Item) (entities.Item, error) {
+	args := m.Called(ctx, book, item)
+	return args.Get(0).(entities.Item), args.Error(1)
+}
+
+func (m *mockItemService) Find(ctx context.Context, barcode string) (entities.Item, error) {
+	args := m.Called(ctx, barcode)
+	return args.Get(0).(entities.Item), args.Error(1)
+}
+
+func (m *mockItemService) FindByBook(ctx context.Context, book entities.Book) ([]entities.Item, error) {
+	args := m.Called(ctx, book)
+	return args.Get(0).([]entities.Item), args.Error(1)
+}
+
+func (m *mockItemService) Update(ctx context.Context, item entities.Item, update service.ItemUpdate) (entities.Item, error) {
+	args := m.Called(ctx, item, update)
+	return args.Get(0).(entities.Item), args.Error(1)
+}
+
+func (m *mockItemService) Withdraw(ctx context.Context, item entities.Item) error {
+	args := m.Called(ctx, item)
+	return args.Error(0)
+}
+
+// noItems is an item service which knows no barcodes
+func noItems() *mockItemService {
+	m := &mockItemService{}
+	m.On("Find", mock.Anything, mock.Anything).Return(entities.Item{}, gorm.ErrRecordNotFound).Maybe()
+	return m
+}
+
+func Test_NewItemController(t *testing.T) {
+	itemController := NewItemController(&mockItemService{}, &mockBookService{})
+	assert.NotNil(t, itemController.itemService)
+	assert.NotNil(t, itemController.bookService)
+}
+
+func Test_ItemController_GetByBook(t *testing.T) {
+	book := entities.Book{Isbn: testIsbn, Author: "test", Title: "test"}
+	items := []entities.Item{
+		{Barcode: "0001", Condition: entities.ConditionGood, Status: entities.ItemAvailable, Location: "A1"},
+		{Barcode: "0002", Condition: entities.ConditionDamaged, Status: entities.ItemOnLoan, Location: "A1"},
+	}
+	mockItems := &mockItemService{}
+	mockItems.On("FindByBook", mock.Anything, book).Return(items, nil)
+	mockBooks := &mockBookService{}
+	mockBooks.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
+	itemController := NewItemController(mockItems, mockBooks)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/books/test/items", nil)
+	c.Params = append(c.Params, gin.Param{Key: "isbn", Value: hyphenatedIsbn})
+	itemController.GetByBook(c)
+
+	var actualItems []entities.Item
+	err := json.Unmarshal(w.Body.Bytes(), &actualItems)
+	if err != nil {
+		t.FailNow()
+	}
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, items, actualItems)
+	mockItems.AssertExpectations(t)
+}
+
+func Test_ItemController_Add(t *testing.T) {
+	book := entities.Book{Isbn: testIsbn, Author: "test", Title: "test"}
+	item := entities.Item{Barcode: "0001", Location: "A1"}
+	added := entities.Item{Barcode: "0001", Condition: entities.ConditionGood, Status: entities.ItemAvailable, Location: "A1"}
+
+	tests := []struct {
+		name            string
+		body            string
+		mockItemService func(m *mockItemService) *mockItemService
+		mockBookService func(m *mockBookService) *mockBookService
+		respStatus      int
+	}{{
+		name: "success",
+		body: `{"Barcode": "0001", "Location": "A1"}`,
+		mockItemService: func(m *mockItemService) *mockItemService {
+			m.On("Add", mock.Anything, book, item).Return(added, nil)
+			return m
+		},
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
+			return m
+		},
+		respStatus: http.StatusCreated,
+	}, {
+		name: "missing barcode",
+		body: `{"Location": "A1"}`,
+		mockItemService: func(m *mockItemService) *mockItemService {
+			return m
+		},
+		mockBookService: func(m *mockBookService) *mockBookService {
+			return m
+		},
+		respStatus: http.StatusUnprocessableEntity,
+	}, {
+		name: "unknown condition",
+		body: `{"Barcode": "0001", "Condition": "lost"}`,
+		mockItemService: func(m *mockItemService) *mockItemService {
+			return m
+		},
+		mockBookService: func(m *mockBookService) *mockBookService {
+			return m
+		},
+		respStatus: http.StatusUnprocessableEntity,
+	}, {
+		name: "book not found",
+		body: `{"Barcode": "0001", "Location": "A1"}`,
+		mockItemService: func(m *mockItemService) *mockItemService {
+			return m
+		},
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(entities.Book{}, errors.New("Not found"))
+			return m
+		},
+		respStatus: http.StatusNotFound,
+	}, {
+		name: "barcode of another item",
+		body: `{"Barcode": "0001", "Location": "A1"}`,
+		mockItemService: func(m *mockItemService) *mockItemService {
+			m.On("Add", mock.Anything, book, item).Return(entities.Item{}, service.ErrBarcodeConflict)
+			return m
+		},
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
+			return m
+		},
+		respStatus: http.StatusConflict,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockItems := tt.mockItemService(&mockItemService{})
+			mockBooks := tt.mockBookService(&mockBookService{})
+			itemController := NewItemController(mockItems, mockBooks)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodPost, "/books/test/items", bytes.NewBufferString(tt.body))
+			c.Params = append(c.Params, gin.Param{Key: "isbn", Value: hyphenatedIsbn})
+			itemController.Add(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			if tt.respStatus == http.StatusCreated {
+				var actual entities.Item
+				err := json.Unmarshal(w.Body.Bytes(), &actual)
+				if err != nil {
+					t.FailNow()
+				}
+				assert.Equal(t, added, actual)
+			}
+			mockItems.AssertExpectations(t)
+			mockBooks.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_ItemController_Update(t *testing.T) {
+	item := entities.Item{Barcode: "0001", Condition: entities.ConditionGood, Status: entities.ItemAvailable}
+	damaged := entities.ConditionDamaged
+
+	tests := []struct {
+		name            string
+		body            string
+		mockItemService func(m *mockItemService) *mockItemService
+		respStatus      int
+	}{{
+		name: "success",
+		body: `{"Condition": "damaged"}`,
+		mockItemService: func(m *mockItemService) *mockItemService {
+			m.On("Find", mock.Anything, "0001").Return(item, nil)
+			m.On("Update", mock.Anything, item, service.ItemUpdate{Condition: &damaged}).Return(item, nil)
+			return m
+		},
+		respStatus: http.StatusOK,
+	}, {
+		name: "nothing to update",
+		body: `{}`,
+		mockItemService: func(m *mockItemService) *mockItemService {
+			return m
+		},
+		respStatus: http.StatusUnprocessableEntity,
+	}, {
+		name: "item not found",
+		body: `{"Location": "B2"}`,
+		mockItemService: func(m *mockItemService) *mockItemService {
+			m.On("Find", mock.Anything, "0001").Return(entities.Item{}, gorm.ErrRecordNotFound)
+			return m
+		},
+		respStatus: http.StatusNotFound,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockItems := tt.mockItemService(&mockItemService{})
+			itemController := NewItemController(mockItems, &mockBookService{})
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodPatch, "/items/0001", bytes.NewBufferString(tt.body))
+			c.Params = append(c.Params, gin.Param{Key: "barcode", Value: "0001"})
+			itemController.Update(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			mockItems.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_ItemController_Withdraw(t *testing.T) {
+	item := entities.Item{Barcode: "0001", Condition: entities.ConditionGood, Status: entities.ItemOnLoan}
+
+	tests := []struct {
+		name            string
+		mockItemService func(m *mockItemService) *mockItemService
+		respStatus      int
+	}{{
+		name: "success",
+		mockItemService: func(m *mockItemService) *mockItemService {
+			m.On("Find", mock.Anything, "0001").Return(item, nil)
+			m.On("Withdraw", mock.Anything, item).Return(nil)
+			return m
+		},
+		respStatus: http.StatusNoContent,
+	}, {
+		name: "item on loan",
+		mockItemService: func(m *mockItemService) *mockItemService {
+			m.On("Find", mock.Anything, "0001").Return(item, nil)
+			m.On("Withdraw", mock.Anything, item).Return(repositories.ErrItemNotAvailable)
+			return m
+		},
+		respStatus: http.StatusBadRequest,
+	}, {
+		name: "item not found",
+		mockItemService: func(m *mockItemService) *mockItemService {
+			m.On("Find", mock.Anything, "0001").Return(entities.Item{}, gorm.ErrRecordNotFound)
+			return m
+		},
+		respStatus: http.StatusNotFound,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockItems := tt.mockItemService(&mockItemService{})
+			itemController := NewItemController(mockItems, &mockBookService{})
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodDelete, "/items/0001", nil)
+			c.Params = append(c.Params, gin.Param{Key: "barcode", Value: "0001"})
+			itemController.Withdraw(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			mockItems.AssertExpectations(t)
+		})
+	}
+}
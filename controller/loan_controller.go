@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/policy"
+	"github.com/mishozz/Library/repositories"
+	"github.com/mishozz/Library/service"
+)
+
+const (
+	loanOverdue  = "Overdue loans cannot be renewed"
+	renewalLimit = "This loan was renewed as many times as allowed"
+)
+
+// LoanController is an interface with all the methods we need for the loan controller
+type LoanController interface {
+	GetByUser(ctx *gin.Context)
+	Renew(ctx *gin.Context)
+}
+
+type loanController struct {
+	loanService service.LoanService
+	userService service.UserService
+	bookService service.BookService
+	policy      policy.OwnershipPolicy
+}
+
+// NewLoanController creates a new instance of the loan controller
+func NewLoanController(loanService service.LoanService, userService service.UserService, bookService service.BookService, policy policy.OwnershipPolicy) *loanController {
+	return &loanController{
+		loanService: loanService,
+		userService: userService,
+		bookService: bookService,
+		policy:      policy,
+	}
+}
+
+func (c *loanController) GetByUser(ctx *gin.Context) {
+	email := ctx.Param("email")
+	user, err := c.userService.FindByEmail(ctx.Request.Context(), email)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: userNotFound})
+		return
+	}
+	if !authorizeLoan(ctx, c.policy, user) {
+		return
+	}
+	loans, err := c.loanService.FindByUser(ctx.Request.Context(), user)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "Internal error"})
+		return
+	}
+	ctx.JSON(http.StatusOK, loans)
+}
+
+// Renew pushes the due date of the loan of the book by another loan period
+func (c *loanController) Renew(ctx *gin.Context) {
+	user, err := c.userService.FindByEmail(ctx.Request.Context(), ctx.Param("email"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: userNotFound})
+		return
+	}
+	if !authorizeLoan(ctx, c.policy, user) {
+		return
+	}
+	isbn, ok := bookIsbn(ctx)
+	if !ok {
+		return
+	}
+	book, err := c.bookService.FindByIsbn(ctx.Request.Context(), isbn)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: bookNotFound})
+		return
+	}
+	loan, err := c.loanService.Renew(ctx.Request.Context(), user, book)
+	switch {
+	case errors.Is(err, repositories.ErrBookNotTaken):
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: bookIsNotTaken})
+	case errors.Is(err, repositories.ErrLoanOverdue):
+		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: loanOverdue})
+	case errors.Is(err, repositories.ErrRenewalLimit):
+		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: renewalLimit})
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "unable to renew loan"})
+	default:
+		ctx.JSON(http.StatusOK, loan)
+	}
+}
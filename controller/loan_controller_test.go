@@ -0,0 +1,242 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/policy"
+	"github.com/mishozz/Library/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockLoanService struct {
+	mock.Mock
+}
+
+func (m *mockLoanService) FindByUser(ctx context.Context, user entities.User) ([]entities.Loan, error) {
+	args := m.Called(ctx, user)
+	return args.Get(0).([]entities.Loan), args.Error(1)
+}
+
+func (m *mockLoanService) Renew(ctx context.Context, user entities.User, book entities.Book) (entities.Loan, error) {
+	args := m.Called(ctx, user, book)
+	return args.Get(0).(entities.Loan), args.Error(1)
+}
+
+func Test_NewLoanController(t *testing.T) {
+	loanController := NewLoanController(&mockLoanService{}, &mockUserService{}, &mockBookService{}, &mockOwnershipPolicy{})
+	assert.NotNil(t, loanController.loanService)
+	assert.NotNil(t, loanController.userService)
+	assert.NotNil(t, loanController.bookService)
+	assert.NotNil(t, loanController.policy)
+}
+
+func Test_LoanController_GetByUser(t *testing.T) {
+	borrowedAt := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	loans := []entities.Loan{{
+		Book: entities.Book{
+			Isbn:           "test",
+			Author:         "test",
+			Title:          "test",
+			AvailableUnits: 3,
+		},
+		BorrowedAt: borrowedAt,
+		DueAt:      borrowedAt.Add(entities.DefaultLoanPeriod),
+	}}
+
+	tests := []struct {
+		name            string
+		mockLoanService func(m *mockLoanService) *mockLoanService
+		mockUserService func(m *mockUserService) *mockUserService
+		policy          *mockOwnershipPolicy
+		respStatus      int
+	}{{
+		name: "success",
+		mockLoanService: func(m *mockLoanService) *mockLoanService {
+			m.On("FindByUser", mock.Anything, entities.User{Email: "email"}).Return(loans, nil)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{Email: "email"}, nil)
+			return m
+		},
+		policy:     allowLoans(),
+		respStatus: 200,
+	}, {
+		name: "user not found",
+		mockLoanService: func(m *mockLoanService) *mockLoanService {
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{}, errors.New("Not found"))
+			return m
+		},
+		policy:     &mockOwnershipPolicy{},
+		respStatus: 404,
+	}, {
+		name: "loans of another user",
+		mockLoanService: func(m *mockLoanService) *mockLoanService {
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{Email: "email"}, nil)
+			return m
+		},
+		policy: func() *mockOwnershipPolicy {
+			m := &mockOwnershipPolicy{}
+			m.On("AuthorizeLoan", mock.Anything, entities.User{Email: "email"}).Return(policy.ErrForbidden)
+			return m
+		}(),
+		respStatus: 403,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockLoans := tt.mockLoanService(&mockLoanService{})
+			mockUsers := tt.mockUserService(&mockUserService{})
+			loanController := NewLoanController(mockLoans, mockUsers, &mockBookService{}, tt.policy)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodGet, "/users/email/loans", nil)
+			c.Params = append(c.Params, gin.Param{Key: "email", Value: "email"})
+			loanController.GetByUser(c)
+
+			if w.Code == 200 {
+				var actualLoans []entities.Loan
+				err := json.Unmarshal(w.Body.Bytes(), &actualLoans)
+				if err != nil {
+					t.FailNow()
+				}
+				assert.Equal(t, loans, actualLoans)
+			}
+			assert.Equal(t, tt.respStatus, w.Code)
+			mockLoans.AssertExpectations(t)
+			mockUsers.AssertExpectations(t)
+			tt.policy.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_LoanController_Renew(t *testing.T) {
+	user := entities.User{Email: "email"}
+	book := entities.Book{Isbn: testIsbn, Author: "test", Title: "test"}
+	dueAt := time.Date(2021, 1, 15, 0, 0, 0, 0, time.UTC)
+	renewed := entities.Loan{
+		Book:         book,
+		DueAt:        dueAt.Add(entities.DefaultLoanPeriod),
+		RenewalCount: 1,
+		Renewals:     []entities.Renewal{{RenewedAt: dueAt, PreviousDueAt: dueAt, DueAt: dueAt.Add(entities.DefaultLoanPeriod)}},
+	}
+
+	tests := []struct {
+		name            string
+		mockLoanService func(m *mockLoanService) *mockLoanService
+		mockBookService func(m *mockBookService) *mockBookService
+		respBody        gin.H
+		respStatus      int
+	}{{
+		name: "success",
+		mockLoanService: func(m *mockLoanService) *mockLoanService {
+			m.On("Renew", mock.Anything, user, book).Return(renewed, nil)
+			return m
+		},
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
+			return m
+		},
+		respStatus: http.StatusOK,
+	}, {
+		name: "book not found",
+		mockLoanService: func(m *mockLoanService) *mockLoanService {
+			return m
+		},
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(entities.Book{}, errors.New("Not found"))
+			return m
+		},
+		respBody:   gin.H{errorMessage: bookNotFound},
+		respStatus: http.StatusNotFound,
+	}, {
+		name: "book is not taken",
+		mockLoanService: func(m *mockLoanService) *mockLoanService {
+			m.On("Renew", mock.Anything, user, book).Return(entities.Loan{}, repositories.ErrBookNotTaken)
+			return m
+		},
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
+			return m
+		},
+		respBody:   gin.H{errorMessage: bookIsNotTaken},
+		respStatus: http.StatusNotFound,
+	}, {
+		name: "overdue",
+		mockLoanService: func(m *mockLoanService) *mockLoanService {
+			m.On("Renew", mock.Anything, user, book).Return(entities.Loan{}, repositories.ErrLoanOverdue)
+			return m
+		},
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
+			return m
+		},
+		respBody:   gin.H{errorMessage: loanOverdue},
+		respStatus: http.StatusBadRequest,
+	}, {
+		name: "renewal limit",
+		mockLoanService: func(m *mockLoanService) *mockLoanService {
+			m.On("Renew", mock.Anything, user, book).Return(entities.Loan{}, repositories.ErrRenewalLimit)
+			return m
+		},
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
+			return m
+		},
+		respBody:   gin.H{errorMessage: renewalLimit},
+		respStatus: http.StatusBadRequest,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockLoans := tt.mockLoanService(&mockLoanService{})
+			mockBooks := tt.mockBookService(&mockBookService{})
+			mockUsers := &mockUserService{}
+			mockUsers.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			mockPolicy := allowLoans()
+			loanController := NewLoanController(mockLoans, mockUsers, mockBooks, mockPolicy)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodPost, "/users/email/loans/isbn/renew", nil)
+			c.Params = append(c.Params, gin.Param{Key: "email", Value: "email"}, gin.Param{Key: "isbn", Value: hyphenatedIsbn})
+			loanController.Renew(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			if tt.respStatus == http.StatusOK {
+				var actual entities.Loan
+				err := json.Unmarshal(w.Body.Bytes(), &actual)
+				if err != nil {
+					t.FailNow()
+				}
+				assert.Equal(t, renewed, actual)
+			} else {
+				var actual gin.H
+				err := json.Unmarshal(w.Body.Bytes(), &actual)
+				if err != nil {
+					t.FailNow()
+				}
+				assert.Equal(t, tt.respBody, actual)
+			}
+			mockLoans.AssertExpectations(t)
+			mockBooks.AssertExpectations(t)
+			mockPolicy.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/middleware"
+	"github.com/mishozz/Library/service"
+	"gorm.io/gorm"
+)
+
+const (
+	notLocked    = "No failed logins for this account or address"
+	unknownScope = "Lockouts are of an account or an address"
+)
+
+// LockoutController is an interface with all the methods we need for the login lockout controller
+type LockoutController interface {
+	GetAll(ctx *gin.Context)
+	Unlock(ctx *gin.Context)
+	GetAudit(ctx *gin.Context)
+}
+
+type lockoutController struct {
+	loginService service.LoginService
+	auditService service.AuditService
+}
+
+// NewLockoutController creates a new instance of the login lockout controller
+func NewLockoutController(loginService service.LoginService, auditService service.AuditService) *lockoutController {
+	return &lockoutController{
+		loginService: loginService,
+		auditService: auditService,
+	}
+}
+
+// GetAll returns the accounts and addresses which are locked out now
+func (c *lockoutController) GetAll(ctx *gin.Context) {
+	lockouts, err := c.loginService.Lockouts(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "Internal error"})
+		return
+	}
+	ctx.JSON(http.StatusOK, lockouts)
+}
+
+// Unlock lets the account or the address in the subject parameter log in again
+func (c *lockoutController) Unlock(ctx *gin.Context) {
+	caller, ok := middleware.GetAuthDetails(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{errorMessage: unauthorized})
+		return
+	}
+	err := c.loginService.Unlock(ctx.Request.Context(), ctx.Param("scope"), ctx.Param("subject"), uint(caller.UserId))
+	switch {
+	case errors.Is(err, service.ErrUnknownLoginScope):
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: unknownScope})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: notLocked})
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "unable to unlock"})
+	default:
+		ctx.JSON(http.StatusNoContent, gin.H{message: "Successfully unlocked"})
+	}
+}
+
+// GetAudit returns a page of the audit log, the latest events first
+func (c *lockoutController) GetAudit(ctx *gin.Context) {
+	page, err := parsePage(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: invalidListQuery})
+		return
+	}
+	events, total, err := c.auditService.FindAll(ctx.Request.Context(), page)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "Internal error"})
+		return
+	}
+	ctx.JSON(http.StatusOK, newPageResponse(ctx, events, total, page))
+}
@@ -0,0 +1,207 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/auth"
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/middleware"
+	"github.com/mishozz/Library/repositories"
+	"github.com/mishozz/Library/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+type mockAuditService struct {
+	mock.Mock
+}
+
+func (m *mockAuditService) FindAll(ctx context.Context, page repositories.Page) ([]entities.AuditEvent, int64, error) {
+	args := m.Called(ctx, page)
+	return args.Get(0).([]entities.AuditEvent), args.Get(1).(int64), args.Error(2)
+}
+
+func Test_NewLockoutController(t *testing.T) {
+	lockoutController := NewLockoutController(&mockLoginService{}, &mockAuditService{})
+	assert.NotNil(t, lockoutController.loginService)
+	assert.NotNil(t, lockoutController.auditService)
+}
+
+func Test_LockoutController_GetAll(t *testing.T) {
+	until := time.Now().Add(time.Hour)
+	lockouts := []entities.LoginAttempt{{Scope: entities.LoginScopeAccount, Subject: "email", Failures: 10, BlockedUntil: &until, Locked: true}}
+
+	tests := []struct {
+		name             string
+		mockLoginService func(m *mockLoginService) *mockLoginService
+		respStatus       int
+	}{{
+		name: "success",
+		mockLoginService: func(m *mockLoginService) *mockLoginService {
+			m.On("Lockouts", mock.Anything).Return(lockouts, nil)
+			return m
+		},
+		respStatus: http.StatusOK,
+	}, {
+		name: "internal error",
+		mockLoginService: func(m *mockLoginService) *mockLoginService {
+			m.On("Lockouts", mock.Anything).Return([]entities.LoginAttempt{}, errors.New("error"))
+			return m
+		},
+		respStatus: http.StatusInternalServerError,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockLogins := tt.mockLoginService(&mockLoginService{})
+			lockoutController := NewLockoutController(mockLogins, &mockAuditService{})
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodGet, "/lockouts", nil)
+			lockoutController.GetAll(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			if w.Code == http.StatusOK {
+				var actualBody []entities.LoginAttempt
+				if err := json.Unmarshal(w.Body.Bytes(), &actualBody); err != nil {
+					t.FailNow()
+				}
+				assert.Equal(t, "email", actualBody[0].Subject)
+				assert.True(t, actualBody[0].Locked)
+			}
+			mockLogins.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_LockoutController_Unlock(t *testing.T) {
+	admin := &auth.AuthDetails{AuthUuid: "uuid", UserId: 7, Role: entities.RoleAdmin}
+
+	tests := []struct {
+		name             string
+		scope            string
+		caller           *auth.AuthDetails
+		mockLoginService func(m *mockLoginService) *mockLoginService
+		respStatus       int
+	}{{
+		name:   "success",
+		scope:  entities.LoginScopeAccount,
+		caller: admin,
+		mockLoginService: func(m *mockLoginService) *mockLoginService {
+			m.On("Unlock", mock.Anything, entities.LoginScopeAccount, "email", uint(7)).Return(nil)
+			return m
+		},
+		respStatus: http.StatusNoContent,
+	}, {
+		name:   "not locked",
+		scope:  entities.LoginScopeAccount,
+		caller: admin,
+		mockLoginService: func(m *mockLoginService) *mockLoginService {
+			m.On("Unlock", mock.Anything, entities.LoginScopeAccount, "email", uint(7)).Return(gorm.ErrRecordNotFound)
+			return m
+		},
+		respStatus: http.StatusNotFound,
+	}, {
+		name:   "unknown scope",
+		scope:  "device",
+		caller: admin,
+		mockLoginService: func(m *mockLoginService) *mockLoginService {
+			m.On("Unlock", mock.Anything, "device", "email", uint(7)).Return(service.ErrUnknownLoginScope)
+			return m
+		},
+		respStatus: http.StatusNotFound,
+	}, {
+		name:  "anonymous",
+		scope: entities.LoginScopeAccount,
+		mockLoginService: func(m *mockLoginService) *mockLoginService {
+			return m
+		},
+		respStatus: http.StatusUnauthorized,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockLogins := tt.mockLoginService(&mockLoginService{})
+			lockoutController := NewLockoutController(mockLogins, &mockAuditService{})
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodDelete, "/lockouts/"+tt.scope+"/email", nil)
+			if tt.caller != nil {
+				c.Set(middleware.AuthDetailsKey, tt.caller)
+			}
+			c.Params = append(c.Params, gin.Param{Key: "scope", Value: tt.scope}, gin.Param{Key: "subject", Value: "email"})
+			lockoutController.Unlock(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			mockLogins.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_LockoutController_GetAudit(t *testing.T) {
+	events := []entities.AuditEvent{{ID: 2, Action: entities.AuditAccountLocked, Subject: "email"}}
+
+	tests := []struct {
+		name             string
+		query            string
+		mockAuditService func(m *mockAuditService) *mockAuditService
+		respStatus       int
+	}{{
+		name:  "success",
+		query: "?page=2&per_page=1",
+		mockAuditService: func(m *mockAuditService) *mockAuditService {
+			m.On("FindAll", mock.Anything, repositories.Page{Number: 2, Size: 1}).Return(events, int64(3), nil)
+			return m
+		},
+		respStatus: http.StatusOK,
+	}, {
+		name:  "invalid page",
+		query: "?page=0",
+		mockAuditService: func(m *mockAuditService) *mockAuditService {
+			return m
+		},
+		respStatus: http.StatusBadRequest,
+	}, {
+		name:  "internal error",
+		query: "",
+		mockAuditService: func(m *mockAuditService) *mockAuditService {
+			m.On("FindAll", mock.Anything, repositories.Page{Number: 1, Size: defaultPageSize}).Return([]entities.AuditEvent{}, int64(0), errors.New("error"))
+			return m
+		},
+		respStatus: http.StatusInternalServerError,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockAudit := tt.mockAuditService(&mockAuditService{})
+			lockoutController := NewLockoutController(&mockLoginService{}, mockAudit)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodGet, "/audit"+tt.query, nil)
+			lockoutController.GetAudit(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			if w.Code == http.StatusOK {
+				var actualBody pageResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &actualBody); err != nil {
+					t.FailNow()
+				}
+				assert.Equal(t, int64(3), actualBody.Total)
+				assert.Equal(t, "/audit?page=3&per_page=1", actualBody.Next)
+				assert.Equal(t, "/audit?page=1&per_page=1", actualBody.Previous)
+			}
+			mockAudit.AssertExpectations(t)
+		})
+	}
+}
@@ -1,14 +1,17 @@
 package controller
 
 import (
+	"errors"
 	"log"
+	"math"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/mishozz/Library/auth"
 	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/middleware"
 	"github.com/mishozz/Library/repositories"
 	"github.com/mishozz/Library/service"
-	"golang.org/x/crypto/bcrypt"
 
 	"net/http"
 )
@@ -18,47 +21,77 @@ type LoginController interface {
 	Login(c *gin.Context)
 	LogOut(c *gin.Context)
 	Register(c *gin.Context)
+	Refresh(c *gin.Context)
 }
 
 type loginController struct {
-	authRepository repositories.AuthRepository
-	userService    service.UserService
+	authRepository      repositories.AuthRepository
+	userService         service.UserService
+	tokenService        service.TokenService
+	verificationService service.VerificationService
+	loginService        service.LoginService
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type registerRequest struct {
+	Email    string `json:"Email" binding:"required,email"`
+	Password string `json:"Password" binding:"required"`
 }
 
 var (
-	successullyRegister string = "registered successully"
+	successullyRegister string = "registered successully, check your email to verify it"
 	userConflict        string = "this user already exists"
-	wrongPassword       string = "wrong password"
+	invalidCredentials  string = "Invalid credentials"
+	tooManyLogins       string = "Too many failed logins, try again later"
+	invalidRefreshToken string = "invalid refresh token"
+	accountDisabled     string = "This account is disabled"
 )
 
 // NewLoginController creates a new instance of the login controller
-func NewLoginController(authRepo repositories.AuthRepository, userService service.UserService) *loginController {
+func NewLoginController(authRepo repositories.AuthRepository, userService service.UserService, tokenService service.TokenService, verificationService service.VerificationService, loginService service.LoginService) *loginController {
 	return &loginController{
-		authRepository: authRepo,
-		userService:    userService,
+		authRepository:      authRepo,
+		userService:         userService,
+		tokenService:        tokenService,
+		verificationService: verificationService,
+		loginService:        loginService,
 	}
 }
 
+// Login answers a wrong password and an unknown email alike. After too many failures the
+// logins to the account or from the address of the client are refused for a while, the
+// Retry-After header tells for how long.
 func (lc *loginController) Login(c *gin.Context) {
 	var u entities.User
 	if err := c.ShouldBindJSON(&u); err != nil {
 		c.JSON(http.StatusUnprocessableEntity, err.Error())
 		return
 	}
-	//check if the user exist:
-	user, err := lc.userService.FindByEmail(u.Email)
-	if err != nil {
-		c.JSON(http.StatusNotFound, userNotFound)
+	user, err := lc.loginService.Authenticate(c.Request.Context(), u.Email, u.Password, c.ClientIP())
+	var blocked *service.LoginBlockedError
+	if errors.As(err, &blocked) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(blocked.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{errorMessage: tooManyLogins})
+		return
+	}
+	if errors.Is(err, service.ErrInvalidCredentials) {
+		c.JSON(http.StatusUnauthorized, gin.H{errorMessage: invalidCredentials})
 		return
 	}
-
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(u.Password))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, wrongPassword)
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{errorMessage: "Please try to login later"})
+		return
+	}
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{errorMessage: accountDisabled})
 		return
 	}
 	//since after the user logged out, we destroyed that record in the database so that same jwt token can't be used twice. We need to create the token again
-	authData, err := lc.authRepository.CreateAuth(uint64(user.ID), user.Role)
+	authData, err := lc.authRepository.CreateAuth(c.Request.Context(), uint64(user.ID), user.Role)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
@@ -68,21 +101,40 @@ func (lc *loginController) Login(c *gin.Context) {
 	authD.AuthUuid = authData.AuthUUID
 	authD.Role = authData.Role
 
-	token, loginErr := service.Authorize.SignIn(authD)
+	tokens, loginErr := lc.tokenService.CreateTokenPair(c.Request.Context(), authD)
 	if loginErr != nil {
 		c.JSON(http.StatusForbidden, gin.H{errorMessage: "Please try to login later"})
 		return
 	}
-	c.JSON(http.StatusOK, token)
+	c.JSON(http.StatusOK, tokens)
 }
 
-func (lc *loginController) LogOut(c *gin.Context) {
-	au, err := auth.ExtractTokenAuth(c.Request)
+func (lc *loginController) Refresh(c *gin.Context) {
+	var req refreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: invalidRequest})
+		return
+	}
+	tokens, err := lc.tokenService.Refresh(c.Request.Context(), req.RefreshToken)
+	if errors.Is(err, service.ErrInvalidRefreshToken) || errors.Is(err, service.ErrRefreshTokenReused) {
+		c.JSON(http.StatusUnauthorized, gin.H{errorMessage: invalidRefreshToken})
+		return
+	}
 	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{errorMessage: "Please try to login later"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (lc *loginController) LogOut(c *gin.Context) {
+	au, ok := middleware.GetAuthDetails(c)
+	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{errorMessage: "unauthorized"})
 		return
 	}
-	delErr := lc.authRepository.DeleteAuth(au)
+	delErr := lc.tokenService.Revoke(c.Request.Context(), au)
 	if delErr != nil {
 		log.Println(delErr)
 		c.JSON(http.StatusUnauthorized, gin.H{errorMessage: "unauthorized"})
@@ -91,17 +143,21 @@ func (lc *loginController) LogOut(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{message: "Successfully logged out"})
 }
 
+// Register creates an unverified user and mails them the link which verifies their email.
+// A link which could not be sent can be asked for again, so the user is registered anyway.
 func (lc *loginController) Register(c *gin.Context) {
-	var user entities.User
-	err := c.ShouldBindJSON(&user)
-	if err != nil {
-		c.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: invalidRequest})
+	var request registerRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, validationErrors(err))
 		return
 	}
-	err = lc.userService.Register(user)
+	err := lc.userService.Register(c.Request.Context(), entities.User{Email: request.Email, Password: request.Password})
 	if err != nil {
 		c.JSON(http.StatusConflict, gin.H{errorMessage: userConflict})
 		return
 	}
+	if err := lc.verificationService.Send(c.Request.Context(), request.Email); err != nil {
+		log.Printf("unable to send the verification link to %s: %v", request.Email, err)
+	}
 	c.JSON(http.StatusCreated, gin.H{message: successullyRegister})
 }
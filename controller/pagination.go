@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/repositories"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+
+	invalidListQuery = "Invalid pagination, filter or sort parameters"
+)
+
+var errInvalidListQuery = errors.New("invalid list query")
+
+// pageResponse is the envelope of a paginated list. Next and Previous link to the
+// neighbouring pages with the same filters and sorting.
+type pageResponse struct {
+	Items    interface{} `json:"Items"`
+	Total    int64       `json:"Total"`
+	Page     int         `json:"Page"`
+	PerPage  int         `json:"PerPage"`
+	Next     string      `json:"Next,omitempty"`
+	Previous string      `json:"Previous,omitempty"`
+}
+
+// parsePage reads the page and per_page query parameters
+func parsePage(ctx *gin.Context) (repositories.Page, error) {
+	page := repositories.Page{Number: 1, Size: defaultPageSize}
+	var err error
+	if value := ctx.Query("page"); value != "" {
+		page.Number, err = strconv.Atoi(value)
+		if err != nil || page.Number < 1 {
+			return page, errInvalidListQuery
+		}
+	}
+	if value := ctx.Query("per_page"); value != "" {
+		page.Size, err = strconv.Atoi(value)
+		if err != nil || page.Size < 1 || page.Size > maxPageSize {
+			return page, errInvalidListQuery
+		}
+	}
+	return page, nil
+}
+
+// parseSort reads the sort query parameter, a leading minus sorts in descending order
+func parseSort(ctx *gin.Context) repositories.Sort {
+	key := ctx.Query("sort")
+	return repositories.Sort{
+		Key:  strings.TrimPrefix(key, "-"),
+		Desc: strings.HasPrefix(key, "-"),
+	}
+}
+
+// parseBool reads an optional boolean query parameter
+func parseBool(ctx *gin.Context, name string) (*bool, error) {
+	value := ctx.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, errInvalidListQuery
+	}
+	return &b, nil
+}
+
+func newPageResponse(ctx *gin.Context, items interface{}, total int64, page repositories.Page) pageResponse {
+	resp := pageResponse{
+		Items:   items,
+		Total:   total,
+		Page:    page.Number,
+		PerPage: page.Size,
+	}
+	if int64(page.Number*page.Size) < total {
+		resp.Next = pageLink(ctx, page.Number+1)
+	}
+	if page.Number > 1 {
+		resp.Previous = pageLink(ctx, page.Number-1)
+	}
+	return resp
+}
+
+func pageLink(ctx *gin.Context, number int) string {
+	query := ctx.Request.URL.Query()
+	query.Set("page", strconv.Itoa(number))
+	return ctx.Request.URL.Path + "?" + query.Encode()
+}
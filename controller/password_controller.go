@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/repositories"
+	"github.com/mishozz/Library/service"
+)
+
+const (
+	resetTokenSent    = "If the account exists a reset token was sent to its email"
+	passwordReset     = "Password successfully reset"
+	invalidResetToken = "Invalid or expired reset token"
+)
+
+// PasswordController is an interface with all the methods we need for the password reset controller
+type PasswordController interface {
+	Forgot(ctx *gin.Context)
+	Reset(ctx *gin.Context)
+}
+
+type passwordController struct {
+	passwordService service.PasswordService
+}
+
+type forgotRequest struct {
+	Email string `json:"Email" binding:"required"`
+}
+
+type resetRequest struct {
+	Token    string `json:"Token" binding:"required"`
+	Password string `json:"Password" binding:"required"`
+}
+
+// NewPasswordController creates a new instance of the password reset controller
+func NewPasswordController(passwordService service.PasswordService) *passwordController {
+	return &passwordController{
+		passwordService: passwordService,
+	}
+}
+
+// Forgot mails a reset token to the user in the request. The response is the same whether
+// the user exists or not.
+func (c *passwordController) Forgot(ctx *gin.Context) {
+	var request forgotRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: invalidRequest})
+		return
+	}
+	if err := c.passwordService.Forgot(ctx.Request.Context(), request.Email); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "unable to send reset token"})
+		return
+	}
+	ctx.JSON(http.StatusAccepted, gin.H{message: resetTokenSent})
+}
+
+// Reset sets a new password with a mailed reset token
+func (c *passwordController) Reset(ctx *gin.Context) {
+	var request resetRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: invalidRequest})
+		return
+	}
+	err := c.passwordService.Reset(ctx.Request.Context(), request.Token, request.Password)
+	if errors.Is(err, repositories.ErrInvalidToken) {
+		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: invalidResetToken})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "unable to reset password"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{message: passwordReset})
+}
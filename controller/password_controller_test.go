@@ -0,0 +1,132 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockPasswordService struct {
+	mock.Mock
+}
+
+func (m *mockPasswordService) Forgot(ctx context.Context, email string) error {
+	args := m.Called(ctx, email)
+	return args.Error(0)
+}
+
+func (m *mockPasswordService) Reset(ctx context.Context, token string, password string) error {
+	args := m.Called(ctx, token, password)
+	return args.Error(0)
+}
+
+func (m *mockPasswordService) Wait() {
+	m.Called()
+}
+
+func Test_NewPasswordController(t *testing.T) {
+	passwordController := NewPasswordController(&mockPasswordService{})
+	assert.NotNil(t, passwordController.passwordService)
+}
+
+func Test_PasswordController_Forgot(t *testing.T) {
+	tests := []struct {
+		name                string
+		body                string
+		mockPasswordService func(m *mockPasswordService) *mockPasswordService
+		respStatus          int
+	}{{
+		name: "success",
+		body: `{"Email": "email"}`,
+		mockPasswordService: func(m *mockPasswordService) *mockPasswordService {
+			m.On("Forgot", mock.Anything, "email").Return(nil)
+			return m
+		},
+		respStatus: http.StatusAccepted,
+	}, {
+		name: "missing email",
+		body: `{}`,
+		mockPasswordService: func(m *mockPasswordService) *mockPasswordService {
+			return m
+		},
+		respStatus: http.StatusUnprocessableEntity,
+	}, {
+		name: "mail not sent",
+		body: `{"Email": "email"}`,
+		mockPasswordService: func(m *mockPasswordService) *mockPasswordService {
+			m.On("Forgot", mock.Anything, "email").Return(errors.New("error"))
+			return m
+		},
+		respStatus: http.StatusInternalServerError,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockPasswords := tt.mockPasswordService(&mockPasswordService{})
+			passwordController := NewPasswordController(mockPasswords)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodPost, "/password/forgot", bytes.NewBufferString(tt.body))
+			passwordController.Forgot(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			mockPasswords.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_PasswordController_Reset(t *testing.T) {
+	tests := []struct {
+		name                string
+		body                string
+		mockPasswordService func(m *mockPasswordService) *mockPasswordService
+		respStatus          int
+	}{{
+		name: "success",
+		body: `{"Token": "token", "Password": "secret"}`,
+		mockPasswordService: func(m *mockPasswordService) *mockPasswordService {
+			m.On("Reset", mock.Anything, "token", "secret").Return(nil)
+			return m
+		},
+		respStatus: http.StatusOK,
+	}, {
+		name: "missing password",
+		body: `{"Token": "token"}`,
+		mockPasswordService: func(m *mockPasswordService) *mockPasswordService {
+			return m
+		},
+		respStatus: http.StatusUnprocessableEntity,
+	}, {
+		name: "invalid token",
+		body: `{"Token": "token", "Password": "secret"}`,
+		mockPasswordService: func(m *mockPasswordService) *mockPasswordService {
+			m.On("Reset", mock.Anything, "token", "secret").Return(repositories.ErrInvalidToken)
+			return m
+		},
+		respStatus: http.StatusBadRequest,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockPasswords := tt.mockPasswordService(&mockPasswordService{})
+			passwordController := NewPasswordController(mockPasswords)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodPost, "/password/reset", bytes.NewBufferString(tt.body))
+			passwordController.Reset(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			mockPasswords.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+	"github.com/mishozz/Library/service"
+	"gorm.io/gorm"
+)
+
+const (
+	roleNotFound      = "Role not found"
+	roleInUse         = "Some users still have this role"
+	builtInRole       = "Built-in roles cannot be changed"
+	unknownPermission = "Unknown permission"
+)
+
+// RoleController is an interface with all the methods we need for the role controller
+type RoleController interface {
+	GetAll(ctx *gin.Context)
+	Save(ctx *gin.Context)
+	Delete(ctx *gin.Context)
+}
+
+type roleController struct {
+	roleService service.RoleService
+}
+
+type roleBody struct {
+	Permissions entities.Permissions `json:"Permissions" binding:"required"`
+}
+
+// NewRoleController creates a new instance of the role controller
+func NewRoleController(roleService service.RoleService) *roleController {
+	return &roleController{
+		roleService: roleService,
+	}
+}
+
+func (c *roleController) GetAll(ctx *gin.Context) {
+	roles, err := c.roleService.FindAll(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "Internal error"})
+		return
+	}
+	ctx.JSON(http.StatusOK, roles)
+}
+
+// Save creates the role in the name parameter or replaces its permissions
+func (c *roleController) Save(ctx *gin.Context) {
+	var body roleBody
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: invalidRequest})
+		return
+	}
+	role, err := c.roleService.Save(ctx.Request.Context(), ctx.Param("name"), body.Permissions)
+	switch {
+	case errors.Is(err, service.ErrUnknownPermission):
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: unknownPermission})
+	case errors.Is(err, service.ErrBuiltInRole):
+		ctx.JSON(http.StatusConflict, gin.H{errorMessage: builtInRole})
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "unable to save role"})
+	default:
+		ctx.JSON(http.StatusOK, role)
+	}
+}
+
+// Delete removes the role in the name parameter
+func (c *roleController) Delete(ctx *gin.Context) {
+	err := c.roleService.Delete(ctx.Request.Context(), ctx.Param("name"))
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: roleNotFound})
+	case errors.Is(err, service.ErrBuiltInRole):
+		ctx.JSON(http.StatusConflict, gin.H{errorMessage: builtInRole})
+	case errors.Is(err, repositories.ErrRoleInUse):
+		ctx.JSON(http.StatusConflict, gin.H{errorMessage: roleInUse})
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "unable to delete role"})
+	default:
+		ctx.JSON(http.StatusNoContent, gin.H{message: "Role successfully deleted"})
+	}
+}
@@ -0,0 +1,194 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+	"github.com/mishozz/Library/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+// testRoles are the roles the seed migration creates
+var testRoles = []entities.Role{
+	{Name: entities.RoleAdmin, Permissions: entities.AllPermissions},
+	{Name: entities.RoleLibrarian, Permissions: entities.Permissions{
+		entities.PermissionBooksRead,
+		entities.PermissionBooksWrite,
+		entities.PermissionItemsManage,
+		entities.PermissionHoldsManage,
+		entities.PermissionLoansManage,
+		entities.PermissionFinesManage,
+		entities.PermissionUsersRead,
+	}},
+	{Name: entities.RoleUser, Permissions: entities.Permissions{entities.PermissionBooksRead}},
+}
+
+type mockRoleService struct {
+	mock.Mock
+}
+
+func (m *mockRoleService) FindAll(ctx context.Context) ([]entities.Role, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]entities.Role), args.Error(1)
+}
+
+func (m *mockRoleService) Save(ctx context.Context, name string, permissions entities.Permissions) (entities.Role, error) {
+	args := m.Called(ctx, name, permissions)
+	return args.Get(0).(entities.Role), args.Error(1)
+}
+
+func (m *mockRoleService) Delete(ctx context.Context, name string) error {
+	args := m.Called(ctx, name)
+	return args.Error(0)
+}
+
+func Test_NewRoleController(t *testing.T) {
+	roleController := NewRoleController(&mockRoleService{})
+	assert.NotNil(t, roleController.roleService)
+}
+
+func Test_RoleController_GetAll(t *testing.T) {
+	mockRoles := &mockRoleService{}
+	mockRoles.On("FindAll", mock.Anything).Return(testRoles, nil)
+	roleController := NewRoleController(mockRoles)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/roles", nil)
+	roleController.GetAll(c)
+
+	var actual []entities.Role
+	err := json.Unmarshal(w.Body.Bytes(), &actual)
+	if err != nil {
+		t.FailNow()
+	}
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, testRoles, actual)
+	mockRoles.AssertExpectations(t)
+}
+
+func Test_RoleController_Save(t *testing.T) {
+	permissions := entities.Permissions{entities.PermissionBooksRead, entities.PermissionItemsManage}
+	saved := entities.Role{Name: "Archivist", Permissions: permissions}
+
+	tests := []struct {
+		name            string
+		role            string
+		body            string
+		mockRoleService func(m *mockRoleService) *mockRoleService
+		respStatus      int
+	}{{
+		name: "success",
+		role: "Archivist",
+		body: `{"Permissions": ["books:read", "items:manage"]}`,
+		mockRoleService: func(m *mockRoleService) *mockRoleService {
+			m.On("Save", mock.Anything, "Archivist", permissions).Return(saved, nil)
+			return m
+		},
+		respStatus: http.StatusOK,
+	}, {
+		name: "missing permissions",
+		role: "Archivist",
+		body: `{}`,
+		mockRoleService: func(m *mockRoleService) *mockRoleService {
+			return m
+		},
+		respStatus: http.StatusUnprocessableEntity,
+	}, {
+		name: "unknown permission",
+		role: "Archivist",
+		body: `{"Permissions": ["books:burn"]}`,
+		mockRoleService: func(m *mockRoleService) *mockRoleService {
+			m.On("Save", mock.Anything, "Archivist", entities.Permissions{"books:burn"}).Return(entities.Role{}, service.ErrUnknownPermission)
+			return m
+		},
+		respStatus: http.StatusUnprocessableEntity,
+	}, {
+		name: "admin",
+		role: entities.RoleAdmin,
+		body: `{"Permissions": ["books:read"]}`,
+		mockRoleService: func(m *mockRoleService) *mockRoleService {
+			m.On("Save", mock.Anything, entities.RoleAdmin, entities.Permissions{entities.PermissionBooksRead}).Return(entities.Role{}, service.ErrBuiltInRole)
+			return m
+		},
+		respStatus: http.StatusConflict,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockRoles := tt.mockRoleService(&mockRoleService{})
+			roleController := NewRoleController(mockRoles)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodPut, "/roles/"+tt.role, bytes.NewBufferString(tt.body))
+			c.Params = append(c.Params, gin.Param{Key: "name", Value: tt.role})
+			roleController.Save(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			if tt.respStatus == http.StatusOK {
+				var actual entities.Role
+				err := json.Unmarshal(w.Body.Bytes(), &actual)
+				if err != nil {
+					t.FailNow()
+				}
+				assert.Equal(t, saved, actual)
+			}
+			mockRoles.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_RoleController_Delete(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		respStatus int
+	}{{
+		name:       "success",
+		respStatus: http.StatusNoContent,
+	}, {
+		name:       "role not found",
+		err:        gorm.ErrRecordNotFound,
+		respStatus: http.StatusNotFound,
+	}, {
+		name:       "built-in role",
+		err:        service.ErrBuiltInRole,
+		respStatus: http.StatusConflict,
+	}, {
+		name:       "role in use",
+		err:        repositories.ErrRoleInUse,
+		respStatus: http.StatusConflict,
+	}, {
+		name:       "internal error",
+		err:        errors.New("error"),
+		respStatus: http.StatusInternalServerError,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockRoles := &mockRoleService{}
+			mockRoles.On("Delete", mock.Anything, entities.RoleLibrarian).Return(tt.err)
+			roleController := NewRoleController(mockRoles)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodDelete, "/roles/Librarian", nil)
+			c.Params = append(c.Params, gin.Param{Key: "name", Value: entities.RoleLibrarian})
+			roleController.Delete(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			mockRoles.AssertExpectations(t)
+		})
+	}
+}
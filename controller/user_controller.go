@@ -1,11 +1,15 @@
 package controller
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/isbn"
+	"github.com/mishozz/Library/policy"
+	"github.com/mishozz/Library/repositories"
 	"github.com/mishozz/Library/service"
-	"github.com/mishozz/Library/utils"
 )
 
 const (
@@ -14,6 +18,10 @@ const (
 	bookAlreadyTaken = "This book is already taken"
 	bookIsNotTaken   = "This book is not taken"
 	message          = "message"
+	unauthorized     = "unauthorized"
+	forbiddenLoan    = "You are not allowed to manage the loans of this user"
+	forbiddenProfile = "You are not allowed to see this user"
+	loanLimit        = "This user already has as many books as they are allowed"
 )
 
 // UserController is interface with all the methods we need for the user controller
@@ -22,70 +30,158 @@ type UserController interface {
 	ReturnBook(ctx *gin.Context)
 	GetAll(ctx *gin.Context)
 	GetByEmail(ctx *gin.Context)
+	SetLoanLimit(ctx *gin.Context)
+}
+
+// loanLimitRequest overrides the loan limit of a user, a null limit restores the limit of their category
+type loanLimitRequest struct {
+	LoanLimit *uint `json:"LoanLimit"`
 }
 
 type userController struct {
 	userService service.UserService
 	bookService service.BookService
+	itemService service.ItemService
+	policy      policy.OwnershipPolicy
 }
 
 // NewUserController creates new instance of the user controller
-func NewUserController(userService service.UserService, bookService service.BookService) *userController {
+func NewUserController(userService service.UserService, bookService service.BookService, itemService service.ItemService, policy policy.OwnershipPolicy) *userController {
 	return &userController{
 		userService: userService,
 		bookService: bookService,
+		itemService: itemService,
+		policy:      policy,
 	}
 }
 
 func (c *userController) GetAll(ctx *gin.Context) {
-	users, err := c.userService.FindAll()
+	query, err := userQuery(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: invalidListQuery})
+		return
+	}
+	users, total, err := c.userService.FindAll(ctx.Request.Context(), query)
+	if errors.Is(err, repositories.ErrInvalidSort) {
+		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: invalidListQuery})
+		return
+	}
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, "Internal error")
 		return
 	}
-	for _, user := range users {
-		user.Password = ""
+	for i := range users {
+		users[i].Password = ""
 	}
-	ctx.JSON(http.StatusOK, users)
+	ctx.JSON(http.StatusOK, newPageResponse(ctx, users, total, query.Page))
+}
+
+// userQuery reads the email and has_overdue filters and the paging of the user list
+func userQuery(ctx *gin.Context) (repositories.UserQuery, error) {
+	page, err := parsePage(ctx)
+	if err != nil {
+		return repositories.UserQuery{}, err
+	}
+	hasOverdue, err := parseBool(ctx, "has_overdue")
+	if err != nil {
+		return repositories.UserQuery{}, err
+	}
+	return repositories.UserQuery{
+		Email:      ctx.Query("email"),
+		HasOverdue: hasOverdue,
+		Sort:       parseSort(ctx),
+		Page:       page,
+	}, nil
 }
 
 func (c *userController) GetByEmail(ctx *gin.Context) {
 	email := ctx.Param("email")
-	user, err := c.userService.FindByEmail(email)
+	user, err := c.userService.FindByEmail(ctx.Request.Context(), email)
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: userNotFound})
 		return
 	}
+	if !authorizeProfile(ctx, c.policy, user) {
+		return
+	}
+	allowance, err := c.userService.LoanAllowance(ctx.Request.Context(), user)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, "Internal error")
+		return
+	}
 	user.Password = ""
+	user.Allowance = &allowance
 	ctx.JSON(http.StatusOK, user)
 }
 
+// SetLoanLimit overrides the loan limit of the category of the user in the email parameter
+func (c *userController) SetLoanLimit(ctx *gin.Context) {
+	var request loanLimitRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{errorMessage: invalidRequest})
+		return
+	}
+	user, err := c.userService.FindByEmail(ctx.Request.Context(), ctx.Param("email"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: userNotFound})
+		return
+	}
+	if err := c.userService.SetLoanLimit(ctx.Request.Context(), user, request.LoanLimit); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "unable to set loan limit"})
+		return
+	}
+	ctx.JSON(http.StatusNoContent, gin.H{message: "Loan limit successfully set"})
+}
+
+// TakeBook lends the item with the barcode in the isbn parameter, or any copy
+// when the parameter is the ISBN of the book
 func (c *userController) TakeBook(ctx *gin.Context) {
-	isbn := ctx.Param("isbn")
 	email := ctx.Param("email")
 
-	user, err := c.userService.FindByEmail(email)
+	user, err := c.userService.FindByEmail(ctx.Request.Context(), email)
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: userNotFound})
 		return
 	}
+	if !authorizeLoan(ctx, c.policy, user) {
+		return
+	}
 
-	book, err := c.bookService.FindByIsbn(isbn)
-	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: bookNotFound})
+	book, item, ok := c.findCopy(ctx)
+	if !ok {
 		return
 	}
 
-	if book.AvailableUnits <= 0 {
+	if item != nil {
+		err = c.userService.TakeItem(ctx.Request.Context(), user, *item)
+	} else {
+		err = c.userService.TakeBook(ctx.Request.Context(), user, book)
+	}
+	if errors.Is(err, repositories.ErrNoAvailableUnits) {
 		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: noAvailableUnits})
 		return
 	}
-	if utils.Contains(user.TakenBooks, book) {
+	if errors.Is(err, repositories.ErrItemNotAvailable) {
+		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: itemNotAvailable})
+		return
+	}
+	if errors.Is(err, repositories.ErrBookAlreadyTaken) {
 		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: bookAlreadyTaken})
 		return
 	}
-
-	err = c.userService.TakeBook(user, book)
+	if errors.Is(err, service.ErrFinesOutstanding) {
+		ctx.JSON(http.StatusForbidden, gin.H{errorMessage: finesOutstanding})
+		return
+	}
+	if errors.Is(err, service.ErrEmailNotVerified) {
+		ctx.JSON(http.StatusForbidden, gin.H{errorMessage: emailNotVerified})
+		return
+	}
+	var limitErr *service.LoanLimitError
+	if errors.As(err, &limitErr) {
+		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: loanLimit, "Loans": limitErr.Loans, "Limit": limitErr.Limit})
+		return
+	}
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{message: "unable to take book"})
 		return
@@ -95,26 +191,39 @@ func (c *userController) TakeBook(ctx *gin.Context) {
 
 }
 
+// ReturnBook takes back the item with the barcode in the isbn parameter, or the copy
+// of the book on loan when the parameter is the ISBN of the book
 func (c *userController) ReturnBook(ctx *gin.Context) {
-	isbn := ctx.Param("isbn")
 	email := ctx.Param("email")
 
-	if !c.userService.IsBookTakenByUser(email, isbn) {
-		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: bookIsNotTaken})
+	user, err := c.userService.FindByEmail(ctx.Request.Context(), email)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: userNotFound})
+		return
+	}
+	if !authorizeLoan(ctx, c.policy, user) {
 		return
 	}
 
-	book, err := c.bookService.FindByIsbn(isbn)
-	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: bookNotFound})
+	book, item, ok := c.findCopy(ctx)
+	if !ok {
 		return
 	}
-	user, err := c.userService.FindByEmail(email)
-	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: userNotFound})
+
+	if !c.userService.IsBookTakenByUser(ctx.Request.Context(), email, book.Isbn) {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: bookIsNotTaken})
+		return
+	}
+
+	if item != nil {
+		err = c.userService.ReturnItem(ctx.Request.Context(), user, *item)
+	} else {
+		err = c.userService.ReturnBook(ctx.Request.Context(), user, book)
+	}
+	if errors.Is(err, repositories.ErrBookNotTaken) {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: bookIsNotTaken})
 		return
 	}
-	err = c.userService.ReturnBook(user, book)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "unable to return book"})
 		return
@@ -122,3 +231,51 @@ func (c *userController) ReturnBook(ctx *gin.Context) {
 	ctx.JSON(http.StatusNoContent, gin.H{message: "Book successfuly returned"})
 
 }
+
+// findCopy resolves the isbn parameter, which is either the barcode of an item or any
+// valid form of the ISBN of a book. The item is nil when the parameter is an ISBN.
+// Barcodes are looked up first, so a barcode which happens to be a valid ISBN still works.
+func (c *userController) findCopy(ctx *gin.Context) (entities.Book, *entities.Item, bool) {
+	if item, err := c.itemService.Find(ctx.Request.Context(), ctx.Param("isbn")); err == nil && item.Book != nil {
+		return *item.Book, &item, true
+	}
+	normalized, err := isbn.Normalize(ctx.Param("isbn"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: itemNotFound})
+		return entities.Book{}, nil, false
+	}
+	book, err := c.bookService.FindByIsbn(ctx.Request.Context(), normalized)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{errorMessage: bookNotFound})
+		return book, nil, false
+	}
+	return book, nil, true
+}
+
+// authorizeLoan writes the error response and returns false when the caller
+// is not allowed to manage the loans of the user
+func authorizeLoan(ctx *gin.Context, loanPolicy policy.OwnershipPolicy, user entities.User) bool {
+	switch loanPolicy.AuthorizeLoan(ctx.Request, user) {
+	case nil:
+		return true
+	case policy.ErrForbidden:
+		ctx.JSON(http.StatusForbidden, gin.H{errorMessage: forbiddenLoan})
+	default:
+		ctx.JSON(http.StatusUnauthorized, gin.H{errorMessage: unauthorized})
+	}
+	return false
+}
+
+// authorizeProfile writes the error response and returns false when the caller
+// is not allowed to see the user
+func authorizeProfile(ctx *gin.Context, profilePolicy policy.OwnershipPolicy, user entities.User) bool {
+	switch profilePolicy.AuthorizeProfile(ctx.Request, user) {
+	case nil:
+		return true
+	case policy.ErrForbidden:
+		ctx.JSON(http.StatusForbidden, gin.H{errorMessage: forbiddenProfile})
+	default:
+		ctx.JSON(http.StatusUnauthorized, gin.H{errorMessage: unauthorized})
+	}
+	return false
+}
@@ -1,6 +1,8 @@
 package controller
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -9,6 +11,9 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/policy"
+	"github.com/mishozz/Library/repositories"
+	"github.com/mishozz/Library/service"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -17,42 +22,84 @@ type mockUserService struct {
 	mock.Mock
 }
 
-func (m *mockUserService) FindByEmail(email string) (entities.User, error) {
-	args := m.Called(email)
+func (m *mockUserService) FindByEmail(ctx context.Context, email string) (entities.User, error) {
+	args := m.Called(ctx, email)
 	return args.Get(0).(entities.User), args.Error(1)
 }
 
-func (m *mockUserService) FindAll() ([]entities.User, error) {
-	args := m.Called()
-	return args.Get(0).([]entities.User), args.Error(1)
+func (m *mockUserService) FindAll(ctx context.Context, query repositories.UserQuery) ([]entities.User, int64, error) {
+	args := m.Called(ctx, query)
+	return args.Get(0).([]entities.User), args.Get(1).(int64), args.Error(2)
 }
 
-func (m *mockUserService) TakeBook(user entities.User, book entities.Book) error {
-	args := m.Called(user, book)
+func (m *mockUserService) TakeBook(ctx context.Context, user entities.User, book entities.Book) error {
+	args := m.Called(ctx, user, book)
 	return args.Error(0)
 }
 
-func (m *mockUserService) ReturnBook(user entities.User, book entities.Book) error {
-	args := m.Called(user, book)
+func (m *mockUserService) TakeItem(ctx context.Context, user entities.User, item entities.Item) error {
+	args := m.Called(ctx, user, item)
 	return args.Error(0)
 }
 
-func (m *mockUserService) IsBookTakenByUser(email string, isbn string) bool {
-	args := m.Called(email, isbn)
+func (m *mockUserService) ReturnBook(ctx context.Context, user entities.User, book entities.Book) error {
+	args := m.Called(ctx, user, book)
+	return args.Error(0)
+}
+
+func (m *mockUserService) ReturnItem(ctx context.Context, user entities.User, item entities.Item) error {
+	args := m.Called(ctx, user, item)
+	return args.Error(0)
+}
+
+func (m *mockUserService) IsBookTakenByUser(ctx context.Context, email string, isbn string) bool {
+	args := m.Called(ctx, email, isbn)
 	return args.Get(0).(bool)
 }
 
-func (m *mockUserService) Register(user entities.User) error {
-	args := m.Called(user)
+func (m *mockUserService) LoanAllowance(ctx context.Context, user entities.User) (entities.LoanAllowance, error) {
+	args := m.Called(ctx, user)
+	return args.Get(0).(entities.LoanAllowance), args.Error(1)
+}
+
+func (m *mockUserService) SetLoanLimit(ctx context.Context, user entities.User, limit *uint) error {
+	args := m.Called(ctx, user, limit)
 	return args.Error(0)
 }
 
+func (m *mockUserService) Register(ctx context.Context, user entities.User) error {
+	args := m.Called(ctx, user)
+	return args.Error(0)
+}
+
+type mockOwnershipPolicy struct {
+	mock.Mock
+}
+
+func (m *mockOwnershipPolicy) AuthorizeLoan(r *http.Request, owner entities.User) error {
+	args := m.Called(r, owner)
+	return args.Error(0)
+}
+
+func (m *mockOwnershipPolicy) AuthorizeProfile(r *http.Request, owner entities.User) error {
+	args := m.Called(r, owner)
+	return args.Error(0)
+}
+
+func allowLoans() *mockOwnershipPolicy {
+	m := &mockOwnershipPolicy{}
+	m.On("AuthorizeLoan", mock.Anything, mock.Anything).Return(nil)
+	return m
+}
+
 func Test_NewUserController(t *testing.T) {
 	userService := &mockUserService{}
 	bookService := &mockBookService{}
-	userController := NewUserController(userService, bookService)
+	userController := NewUserController(userService, bookService, &mockItemService{}, &mockOwnershipPolicy{})
 	assert.NotNil(t, userController.userService)
 	assert.NotNil(t, userController.bookService)
+	assert.NotNil(t, userController.itemService)
+	assert.NotNil(t, userController.policy)
 }
 
 func Test_UserController_GetAll(t *testing.T) {
@@ -67,52 +114,97 @@ func Test_UserController_GetAll(t *testing.T) {
 		TakenBooks:    []entities.Book{book},
 		ReturnedBooks: []entities.Book{book},
 	}}
+	hasOverdue := true
 
 	mockService := func(m *mockUserService) *mockUserService {
-		m.On("FindAll").Return(expectedUsers, nil)
+		m.On("FindAll", mock.Anything, repositories.UserQuery{
+			HasOverdue: &hasOverdue,
+			Page:       repositories.Page{Number: 1, Size: 20},
+		}).Return(expectedUsers, int64(1), nil)
 		return m
 	}
 	mockBookService := &mockBookService{}
 	mockUserService := &mockUserService{}
 
-	userController := NewUserController(mockService(mockUserService), mockBookService)
+	userController := NewUserController(mockService(mockUserService), mockBookService, &mockItemService{}, &mockOwnershipPolicy{})
 
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/users?has_overdue=true", nil)
 
 	userController.GetAll(c)
 
-	var users []entities.User
-	err := json.Unmarshal(w.Body.Bytes(), &users)
+	var page struct {
+		Items []entities.User
+		Total int64
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &page)
 	if err != nil {
 		t.FailNow()
 	}
-	assert.Equal(t, expectedUsers, users)
+	assert.Equal(t, expectedUsers, page.Items)
+	assert.Equal(t, int64(1), page.Total)
 	assert.Equal(t, http.StatusOK, w.Code)
 
 	mockUserService.AssertExpectations(t)
 }
 
 func Test_UserController_GetByEmail(t *testing.T) {
+	user := entities.User{
+		Email:    "email",
+		Role:     entities.RoleUser,
+		Category: entities.CategoryPublic,
+		Verified: true,
+	}
+
 	tests := []struct {
 		name        string
 		mockService func(m *mockUserService) *mockUserService
+		mockPolicy  func(m *mockOwnershipPolicy) *mockOwnershipPolicy
 		respBody    gin.H
 		respStatus  int
 	}{{
-		name: "success",
+		name: "patron reads their own record",
 		mockService: func(m *mockUserService) *mockUserService {
-			m.On("FindByEmail", "email").Return(entities.User{
-				Email: "email",
-			}, nil)
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			m.On("LoanAllowance", mock.Anything, user).Return(entities.NewLoanAllowance(2, 5), nil)
+			return m
+		},
+		mockPolicy: func(m *mockOwnershipPolicy) *mockOwnershipPolicy {
+			m.On("AuthorizeProfile", mock.Anything, user).Return(nil)
 			return m
 		},
-		respBody:   gin.H{"Email": "email", "Returned_books": interface{}(nil), "Taken_books": interface{}(nil)},
+		respBody: gin.H{
+			"Email":          "email",
+			"Role":           "User",
+			"Disabled":       false,
+			"Verified":       true,
+			"Category":       "public",
+			"LoanLimit":      nil,
+			"Allowance":      map[string]interface{}{"Loans": float64(2), "Limit": float64(5), "Remaining": float64(3)},
+			"Returned_books": interface{}(nil),
+			"Taken_books":    interface{}(nil),
+		},
 		respStatus: 200,
+	}, {
+		name: "record of another patron",
+		mockService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		mockPolicy: func(m *mockOwnershipPolicy) *mockOwnershipPolicy {
+			m.On("AuthorizeProfile", mock.Anything, user).Return(policy.ErrForbidden)
+			return m
+		},
+		respBody:   gin.H{errorMessage: forbiddenProfile},
+		respStatus: 403,
 	}, {
 		name: "user does not exist",
 		mockService: func(m *mockUserService) *mockUserService {
-			m.On("FindByEmail", "email").Return(entities.User{}, errors.New("Not found"))
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{}, errors.New("Not found"))
+			return m
+		},
+		mockPolicy: func(m *mockOwnershipPolicy) *mockOwnershipPolicy {
 			return m
 		},
 		respBody:   gin.H{errorMessage: userNotFound},
@@ -124,10 +216,13 @@ func Test_UserController_GetByEmail(t *testing.T) {
 			mockBookService := &mockBookService{}
 			mockUserService := &mockUserService{}
 
-			userController := NewUserController(tt.mockService(mockUserService), mockBookService)
+			mockPolicy := tt.mockPolicy(&mockOwnershipPolicy{})
+
+			userController := NewUserController(tt.mockService(mockUserService), mockBookService, &mockItemService{}, mockPolicy)
 
 			w := httptest.NewRecorder()
 			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodGet, "/users/email", nil)
 			c.Params = append(c.Params, gin.Param{Key: "email", Value: "email"})
 			userController.GetByEmail(c)
 
@@ -140,19 +235,82 @@ func Test_UserController_GetByEmail(t *testing.T) {
 			assert.Equal(t, tt.respBody, actualBody)
 			assert.Equal(t, tt.respStatus, w.Code)
 			mockUserService.AssertExpectations(t)
+			mockPolicy.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_UserController_SetLoanLimit(t *testing.T) {
+	user := entities.User{Email: "email"}
+	limit := uint(8)
+
+	tests := []struct {
+		name            string
+		body            string
+		mockUserService func(m *mockUserService) *mockUserService
+		respStatus      int
+	}{{
+		name: "limit set",
+		body: `{"LoanLimit": 8}`,
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			m.On("SetLoanLimit", mock.Anything, user, &limit).Return(nil)
+			return m
+		},
+		respStatus: http.StatusNoContent,
+	}, {
+		name: "limit of the category restored",
+		body: `{"LoanLimit": null}`,
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			m.On("SetLoanLimit", mock.Anything, user, (*uint)(nil)).Return(nil)
+			return m
+		},
+		respStatus: http.StatusNoContent,
+	}, {
+		name: "negative limit",
+		body: `{"LoanLimit": -1}`,
+		mockUserService: func(m *mockUserService) *mockUserService {
+			return m
+		},
+		respStatus: http.StatusUnprocessableEntity,
+	}, {
+		name: "user not found",
+		body: `{"LoanLimit": 8}`,
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{}, errors.New("Not found"))
+			return m
+		},
+		respStatus: http.StatusNotFound,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockUserService := tt.mockUserService(&mockUserService{})
+			userController := NewUserController(mockUserService, &mockBookService{}, &mockItemService{}, &mockOwnershipPolicy{})
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodPut, "/users/email/loan-limit", bytes.NewBufferString(tt.body))
+			c.Params = append(c.Params, gin.Param{Key: "email", Value: "email"})
+			userController.SetLoanLimit(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			mockUserService.AssertExpectations(t)
 		})
 	}
 }
 
 func Test_UserController_TakeBook(t *testing.T) {
 	book := entities.Book{
-		Isbn:           "test",
+		Isbn:           testIsbn,
 		Author:         "test",
 		Title:          "test",
 		AvailableUnits: 3,
 	}
+	item := entities.Item{Barcode: "0001", BookID: 1, Book: &book, Status: entities.ItemAvailable}
 	invalidBook := entities.Book{
-		Isbn:           "test",
+		Isbn:           testIsbn,
 		Author:         "test",
 		Title:          "test",
 		AvailableUnits: 0,
@@ -162,35 +320,139 @@ func Test_UserController_TakeBook(t *testing.T) {
 		name            string
 		mockBookService func(m *mockBookService) *mockBookService
 		mockUserService func(m *mockUserService) *mockUserService
+		mockItemService func(m *mockItemService) *mockItemService
+		param           string
+		policy          *mockOwnershipPolicy
 		respBody        gin.H
 		respStatus      int
 	}{{
 		name: "success",
 		mockBookService: func(m *mockBookService) *mockBookService {
-			m.On("FindByIsbn", "test").Return(book, nil)
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
 			return m
 		},
 		mockUserService: func(m *mockUserService) *mockUserService {
-			m.On("FindByEmail", "email").Return(entities.User{
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{
 				Email: "email",
 			}, nil)
-			m.On("TakeBook", entities.User{
+			m.On("TakeBook", mock.Anything, entities.User{
 				Email: "email",
 			}, book).Return(nil)
 			return m
 		},
 		respBody:   gin.H{message: "Book successfully taken"},
 		respStatus: 201,
+	}, {
+		name:  "success by barcode",
+		param: "0001",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			return m
+		},
+		mockItemService: func(m *mockItemService) *mockItemService {
+			m.On("Find", mock.Anything, "0001").Return(item, nil)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{
+				Email: "email",
+			}, nil)
+			m.On("TakeItem", mock.Anything, entities.User{
+				Email: "email",
+			}, item).Return(nil)
+			return m
+		},
+		respBody:   gin.H{message: "Book successfully taken"},
+		respStatus: 201,
+	}, {
+		name:  "item is not on the shelf",
+		param: "0001",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			return m
+		},
+		mockItemService: func(m *mockItemService) *mockItemService {
+			m.On("Find", mock.Anything, "0001").Return(item, nil)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{
+				Email: "email",
+			}, nil)
+			m.On("TakeItem", mock.Anything, entities.User{
+				Email: "email",
+			}, item).Return(repositories.ErrItemNotAvailable)
+			return m
+		},
+		respBody:   gin.H{errorMessage: itemNotAvailable},
+		respStatus: 400,
+	}, {
+		name:  "fines outstanding",
+		param: "0001",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			return m
+		},
+		mockItemService: func(m *mockItemService) *mockItemService {
+			m.On("Find", mock.Anything, "0001").Return(item, nil)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{
+				Email: "email",
+			}, nil)
+			m.On("TakeItem", mock.Anything, entities.User{
+				Email: "email",
+			}, item).Return(service.ErrFinesOutstanding)
+			return m
+		},
+		respBody:   gin.H{errorMessage: finesOutstanding},
+		respStatus: 403,
+	}, {
+		name:  "email not verified",
+		param: "0001",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			return m
+		},
+		mockItemService: func(m *mockItemService) *mockItemService {
+			m.On("Find", mock.Anything, "0001").Return(item, nil)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{
+				Email: "email",
+			}, nil)
+			m.On("TakeItem", mock.Anything, entities.User{
+				Email: "email",
+			}, item).Return(service.ErrEmailNotVerified)
+			return m
+		},
+		respBody:   gin.H{errorMessage: emailNotVerified},
+		respStatus: 403,
+	}, {
+		name:  "neither a barcode nor an isbn",
+		param: "0002",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{
+				Email: "email",
+			}, nil)
+			return m
+		},
+		respBody:   gin.H{errorMessage: itemNotFound},
+		respStatus: 404,
 	}, {
 		name: "no available units",
 		mockBookService: func(m *mockBookService) *mockBookService {
-			m.On("FindByIsbn", "test").Return(invalidBook, nil)
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(invalidBook, nil)
 			return m
 		},
 		mockUserService: func(m *mockUserService) *mockUserService {
-			m.On("FindByEmail", "email").Return(entities.User{
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{
 				Email: "email",
 			}, nil)
+			m.On("TakeBook", mock.Anything, entities.User{
+				Email: "email",
+			}, invalidBook).Return(repositories.ErrNoAvailableUnits)
 			return m
 		},
 		respBody:   gin.H{errorMessage: noAvailableUnits},
@@ -198,53 +460,141 @@ func Test_UserController_TakeBook(t *testing.T) {
 	}, {
 		name: "book is already taken by this user",
 		mockBookService: func(m *mockBookService) *mockBookService {
-			m.On("FindByIsbn", "test").Return(book, nil)
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
 			return m
 		},
 		mockUserService: func(m *mockUserService) *mockUserService {
-			m.On("FindByEmail", "email").Return(entities.User{
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{
 				Email:      "email",
 				TakenBooks: []entities.Book{book},
 			}, nil)
+			m.On("TakeBook", mock.Anything, entities.User{
+				Email:      "email",
+				TakenBooks: []entities.Book{book},
+			}, book).Return(repositories.ErrBookAlreadyTaken)
 			return m
 		},
 		respBody:   gin.H{errorMessage: bookAlreadyTaken},
 		respStatus: 400,
+	}, {
+		name: "loan limit of the category",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{
+				Email: "email",
+			}, nil)
+			m.On("TakeBook", mock.Anything, entities.User{
+				Email: "email",
+			}, book).Return(&service.LoanLimitError{Loans: 5, Limit: 5})
+			return m
+		},
+		respBody:   gin.H{errorMessage: loanLimit, "Loans": float64(5), "Limit": float64(5)},
+		respStatus: 400,
+	}, {
+		name: "last copy taken by a concurrent checkout",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{
+				Email: "email",
+			}, nil)
+			m.On("TakeBook", mock.Anything, entities.User{
+				Email: "email",
+			}, book).Return(repositories.ErrNoAvailableUnits)
+			return m
+		},
+		respBody:   gin.H{errorMessage: noAvailableUnits},
+		respStatus: 400,
 	}, {
 		name: "user doesnt exists",
 		mockUserService: func(m *mockUserService) *mockUserService {
-			m.On("FindByEmail", "email").Return(entities.User{}, errors.New("Not found"))
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{}, errors.New("Not found"))
 			return m
 		},
 		mockBookService: func(m *mockBookService) *mockBookService {
 			return m
 		},
+		policy:     &mockOwnershipPolicy{},
 		respBody:   gin.H{errorMessage: userNotFound},
 		respStatus: 404,
 	}, {
 		name: "book doesnt exists",
 		mockUserService: func(m *mockUserService) *mockUserService {
-			m.On("FindByEmail", "email").Return(entities.User{}, nil)
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{}, nil)
 			return m
 		},
 		mockBookService: func(m *mockBookService) *mockBookService {
-			m.On("FindByIsbn", "test").Return(book, errors.New("Not found"))
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(book, errors.New("Not found"))
 			return m
 		},
 		respBody:   gin.H{errorMessage: bookNotFound},
 		respStatus: 404,
+	}, {
+		name: "taking a book for another user",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{
+				Email: "email",
+			}, nil)
+			return m
+		},
+		policy: func() *mockOwnershipPolicy {
+			m := &mockOwnershipPolicy{}
+			m.On("AuthorizeLoan", mock.Anything, entities.User{Email: "email"}).Return(policy.ErrForbidden)
+			return m
+		}(),
+		respBody:   gin.H{errorMessage: forbiddenLoan},
+		respStatus: 403,
+	}, {
+		name: "unauthenticated caller",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{
+				Email: "email",
+			}, nil)
+			return m
+		},
+		policy: func() *mockOwnershipPolicy {
+			m := &mockOwnershipPolicy{}
+			m.On("AuthorizeLoan", mock.Anything, entities.User{Email: "email"}).Return(policy.ErrUnauthenticated)
+			return m
+		}(),
+		respBody:   gin.H{errorMessage: unauthorized},
+		respStatus: 401,
 	}}
 	for _, tt := range tests {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
 			mockBook := &mockBookService{}
 			mockUser := &mockUserService{}
+			mockItem := noItems()
+			if tt.mockItemService != nil {
+				mockItem = tt.mockItemService(&mockItemService{})
+			}
+			param := tt.param
+			if param == "" {
+				param = hyphenatedIsbn
+			}
+			mockPolicy := tt.policy
+			if mockPolicy == nil {
+				mockPolicy = allowLoans()
+			}
 
-			userController := NewUserController(tt.mockUserService(mockUser), tt.mockBookService(mockBook))
+			userController := NewUserController(tt.mockUserService(mockUser), tt.mockBookService(mockBook), mockItem, mockPolicy)
 
 			w := httptest.NewRecorder()
 			c, _ := gin.CreateTestContext(w)
-			c.Params = append(c.Params, gin.Param{Key: "email", Value: "email"}, gin.Param{Key: "isbn", Value: "test"})
+			c.Request, _ = http.NewRequest(http.MethodPost, "/users/email/test", nil)
+			c.Params = append(c.Params, gin.Param{Key: "email", Value: "email"}, gin.Param{Key: "isbn", Value: param})
 			userController.TakeBook(c)
 
 			var actualBody gin.H
@@ -257,91 +607,172 @@ func Test_UserController_TakeBook(t *testing.T) {
 			assert.Equal(t, tt.respStatus, w.Code)
 			mockBook.AssertExpectations(t)
 			mockUser.AssertExpectations(t)
+			mockItem.AssertExpectations(t)
+			mockPolicy.AssertExpectations(t)
 		})
 	}
 }
 
 func Test_UserController_ReturnBook(t *testing.T) {
 	book := entities.Book{
-		Isbn:           "test",
+		Isbn:           testIsbn,
 		Author:         "test",
 		Title:          "test",
 		AvailableUnits: 3,
 	}
+	item := entities.Item{Barcode: "0001", BookID: 1, Book: &book, Status: entities.ItemOnLoan}
 	tests := []struct {
 		name            string
 		mockBookService func(m *mockBookService) *mockBookService
 		mockUserService func(m *mockUserService) *mockUserService
+		mockItemService func(m *mockItemService) *mockItemService
+		param           string
+		policy          *mockOwnershipPolicy
 		respStatus      int
 	}{{
 		name: "success",
 		mockBookService: func(m *mockBookService) *mockBookService {
-			m.On("FindByIsbn", "test").Return(book, nil)
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
 			return m
 		},
 		mockUserService: func(m *mockUserService) *mockUserService {
-			m.On("IsBookTakenByUser", "email", "test").Return(true)
-			m.On("FindByEmail", "email").Return(entities.User{
+			m.On("IsBookTakenByUser", mock.Anything, "email", testIsbn).Return(true)
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{
 				Email:      "email",
 				TakenBooks: []entities.Book{book},
 			}, nil)
-			m.On("ReturnBook", entities.User{
+			m.On("ReturnBook", mock.Anything, entities.User{
 				Email:      "email",
 				TakenBooks: []entities.Book{book},
 			}, book).Return(nil)
 			return m
 		},
 		respStatus: 204,
+	}, {
+		name:  "success by barcode",
+		param: "0001",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			return m
+		},
+		mockItemService: func(m *mockItemService) *mockItemService {
+			m.On("Find", mock.Anything, "0001").Return(item, nil)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("IsBookTakenByUser", mock.Anything, "email", testIsbn).Return(true)
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{
+				Email: "email",
+			}, nil)
+			m.On("ReturnItem", mock.Anything, entities.User{
+				Email: "email",
+			}, item).Return(nil)
+			return m
+		},
+		respStatus: 204,
+	}, {
+		name: "book returned by a concurrent request",
+		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
+			return m
+		},
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("IsBookTakenByUser", mock.Anything, "email", testIsbn).Return(true)
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{
+				Email: "email",
+			}, nil)
+			m.On("ReturnBook", mock.Anything, entities.User{
+				Email: "email",
+			}, book).Return(repositories.ErrBookNotTaken)
+			return m
+		},
+		respStatus: 404,
 	}, {
 		name: "book is not taken",
 		mockBookService: func(m *mockBookService) *mockBookService {
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(book, nil)
 			return m
 		},
 		mockUserService: func(m *mockUserService) *mockUserService {
-			m.On("IsBookTakenByUser", "email", "test").Return(false)
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{
+				Email: "email",
+			}, nil)
+			m.On("IsBookTakenByUser", mock.Anything, "email", testIsbn).Return(false)
 			return m
 		},
 		respStatus: 404,
 	}, {
 		name: "user not found",
 		mockUserService: func(m *mockUserService) *mockUserService {
-			m.On("IsBookTakenByUser", "email", "test").Return(true)
-			m.On("FindByEmail", "email").Return(entities.User{}, errors.New("Not found"))
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{}, errors.New("Not found"))
 			return m
 		},
 		mockBookService: func(m *mockBookService) *mockBookService {
-			m.On("FindByIsbn", "test").Return(book, nil)
 			return m
 		},
+		policy:     &mockOwnershipPolicy{},
 		respStatus: 404,
 	}, {
 		name: "book not found",
 		mockUserService: func(m *mockUserService) *mockUserService {
-			m.On("IsBookTakenByUser", "email", "test").Return(true)
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{
+				Email: "email",
+			}, nil)
 			return m
 		},
 		mockBookService: func(m *mockBookService) *mockBookService {
-			m.On("FindByIsbn", "test").Return(entities.Book{}, errors.New("Not found"))
+			m.On("FindByIsbn", mock.Anything, testIsbn).Return(entities.Book{}, errors.New("Not found"))
 			return m
 		},
 		respStatus: 404,
+	}, {
+		name: "returning a book of another user",
+		mockUserService: func(m *mockUserService) *mockUserService {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{
+				Email: "email",
+			}, nil)
+			return m
+		},
+		mockBookService: func(m *mockBookService) *mockBookService {
+			return m
+		},
+		policy: func() *mockOwnershipPolicy {
+			m := &mockOwnershipPolicy{}
+			m.On("AuthorizeLoan", mock.Anything, entities.User{Email: "email"}).Return(policy.ErrForbidden)
+			return m
+		}(),
+		respStatus: 403,
 	}}
 	for _, tt := range tests {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
 			mockBook := &mockBookService{}
 			mockUser := &mockUserService{}
+			mockItem := noItems()
+			if tt.mockItemService != nil {
+				mockItem = tt.mockItemService(&mockItemService{})
+			}
+			param := tt.param
+			if param == "" {
+				param = hyphenatedIsbn
+			}
+			mockPolicy := tt.policy
+			if mockPolicy == nil {
+				mockPolicy = allowLoans()
+			}
 
-			userController := NewUserController(tt.mockUserService(mockUser), tt.mockBookService(mockBook))
+			userController := NewUserController(tt.mockUserService(mockUser), tt.mockBookService(mockBook), mockItem, mockPolicy)
 
 			w := httptest.NewRecorder()
 			c, _ := gin.CreateTestContext(w)
-			c.Params = append(c.Params, gin.Param{Key: "email", Value: "email"}, gin.Param{Key: "isbn", Value: "test"})
+			c.Request, _ = http.NewRequest(http.MethodDelete, "/users/email/test", nil)
+			c.Params = append(c.Params, gin.Param{Key: "email", Value: "email"}, gin.Param{Key: "isbn", Value: param})
 			userController.ReturnBook(c)
 
 			assert.Equal(t, tt.respStatus, w.Code)
 			mockBook.AssertExpectations(t)
 			mockUser.AssertExpectations(t)
+			mockItem.AssertExpectations(t)
+			mockPolicy.AssertExpectations(t)
 		})
 	}
 }
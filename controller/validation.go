@@ -0,0 +1,34 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// validationErrors is the response to a request body which failed validation. Besides the
+// usual error message it tells what is wrong with every invalid field, the body is
+// reported as a whole when it cannot even be decoded.
+func validationErrors(err error) gin.H {
+	var invalid validator.ValidationErrors
+	if !errors.As(err, &invalid) {
+		return gin.H{errorMessage: invalidRequest}
+	}
+	fields := make(map[string]string, len(invalid))
+	for _, field := range invalid {
+		fields[field.Field()] = fieldProblem(field)
+	}
+	return gin.H{errorMessage: invalidRequest, "fields": fields}
+}
+
+func fieldProblem(field validator.FieldError) string {
+	switch field.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	default:
+		return "is invalid"
+	}
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/repositories"
+	"github.com/mishozz/Library/service"
+)
+
+const (
+	emailVerified           = "Email successfully verified"
+	verificationLinkSent    = "If the account exists and is not verified a new link was sent to its email"
+	invalidVerificationLink = "Invalid or expired verification link"
+	emailNotVerified        = "Verify your email before borrowing books"
+)
+
+// VerificationController is an interface with all the methods we need for the email verification controller
+type VerificationController interface {
+	Verify(ctx *gin.Context)
+	Resend(ctx *gin.Context)
+}
+
+type verificationController struct {
+	verificationService service.VerificationService
+}
+
+type resendRequest struct {
+	Email string `json:"Email" binding:"required,email"`
+}
+
+// NewVerificationController creates a new instance of the email verification controller
+func NewVerificationController(verificationService service.VerificationService) *verificationController {
+	return &verificationController{
+		verificationService: verificationService,
+	}
+}
+
+// Verify marks the email of a user verified with the token of the link mailed to them
+func (c *verificationController) Verify(ctx *gin.Context) {
+	token := ctx.Query("token")
+	if token == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: invalidVerificationLink})
+		return
+	}
+	err := c.verificationService.Verify(ctx.Request.Context(), token)
+	if errors.Is(err, repositories.ErrInvalidToken) {
+		ctx.JSON(http.StatusBadRequest, gin.H{errorMessage: invalidVerificationLink})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "unable to verify email"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{message: emailVerified})
+}
+
+// Resend mails a new verification link to the user in the request. The response is the
+// same whether the user exists or not.
+func (c *verificationController) Resend(ctx *gin.Context) {
+	var request resendRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, validationErrors(err))
+		return
+	}
+	if err := c.verificationService.Send(ctx.Request.Context(), request.Email); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{errorMessage: "unable to send verification link"})
+		return
+	}
+	ctx.JSON(http.StatusAccepted, gin.H{message: verificationLinkSent})
+}
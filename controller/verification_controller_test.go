@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_NewVerificationController(t *testing.T) {
+	verificationController := NewVerificationController(&mockVerificationService{})
+	assert.NotNil(t, verificationController.verificationService)
+}
+
+func Test_VerificationController_Verify(t *testing.T) {
+	tests := []struct {
+		name                    string
+		url                     string
+		mockVerificationService func(m *mockVerificationService) *mockVerificationService
+		respStatus              int
+	}{{
+		name: "success",
+		url:  "/verify?token=token",
+		mockVerificationService: func(m *mockVerificationService) *mockVerificationService {
+			m.On("Verify", mock.Anything, "token").Return(nil)
+			return m
+		},
+		respStatus: http.StatusOK,
+	}, {
+		name: "missing token",
+		url:  "/verify",
+		mockVerificationService: func(m *mockVerificationService) *mockVerificationService {
+			return m
+		},
+		respStatus: http.StatusBadRequest,
+	}, {
+		name: "invalid token",
+		url:  "/verify?token=token",
+		mockVerificationService: func(m *mockVerificationService) *mockVerificationService {
+			m.On("Verify", mock.Anything, "token").Return(repositories.ErrInvalidToken)
+			return m
+		},
+		respStatus: http.StatusBadRequest,
+	}, {
+		name: "internal error",
+		url:  "/verify?token=token",
+		mockVerificationService: func(m *mockVerificationService) *mockVerificationService {
+			m.On("Verify", mock.Anything, "token").Return(errors.New("error"))
+			return m
+		},
+		respStatus: http.StatusInternalServerError,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockVerifications := tt.mockVerificationService(&mockVerificationService{})
+			verificationController := NewVerificationController(mockVerifications)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodGet, tt.url, nil)
+			verificationController.Verify(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			mockVerifications.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_VerificationController_Resend(t *testing.T) {
+	tests := []struct {
+		name                    string
+		body                    string
+		mockVerificationService func(m *mockVerificationService) *mockVerificationService
+		respStatus              int
+	}{{
+		name: "success",
+		body: `{"Email": "test@example.com"}`,
+		mockVerificationService: func(m *mockVerificationService) *mockVerificationService {
+			m.On("Send", mock.Anything, "test@example.com").Return(nil)
+			return m
+		},
+		respStatus: http.StatusAccepted,
+	}, {
+		name: "invalid email",
+		body: `{"Email": "test"}`,
+		mockVerificationService: func(m *mockVerificationService) *mockVerificationService {
+			return m
+		},
+		respStatus: http.StatusUnprocessableEntity,
+	}, {
+		name: "mail not sent",
+		body: `{"Email": "test@example.com"}`,
+		mockVerificationService: func(m *mockVerificationService) *mockVerificationService {
+			m.On("Send", mock.Anything, "test@example.com").Return(errors.New("error"))
+			return m
+		},
+		respStatus: http.StatusInternalServerError,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockVerifications := tt.mockVerificationService(&mockVerificationService{})
+			verificationController := NewVerificationController(mockVerifications)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodPost, "/verify/resend", bytes.NewBufferString(tt.body))
+			verificationController.Resend(c)
+
+			assert.Equal(t, tt.respStatus, w.Code)
+			mockVerifications.AssertExpectations(t)
+		})
+	}
+}
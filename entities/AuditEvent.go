@@ -0,0 +1,23 @@
+package entities
+
+import "time"
+
+// Actions recorded in the audit log
+const (
+	AuditAccountLocked   = "account_locked"
+	AuditAddressLocked   = "address_locked"
+	AuditAccountUnlocked = "account_unlocked"
+	AuditAddressUnlocked = "address_unlocked"
+)
+
+// AuditEvent records a security relevant action. Subject is the email or the address the
+// action was about and ActorID the user who took it, it is empty for the actions the
+// library takes on its own.
+type AuditEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"ID"`
+	CreatedAt time.Time `json:"CreatedAt"`
+	Action    string    `gorm:"size:32;not null;index" json:"Action"`
+	Subject   string    `gorm:"size:255;not null" json:"Subject"`
+	ActorID   *uint     `json:"ActorID"`
+	Detail    string    `json:"Detail"`
+}
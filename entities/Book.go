@@ -1,13 +1,23 @@
 package entities
 
-import "gorm.io/gorm"
+import (
+	"github.com/mishozz/Library/isbn"
+	"gorm.io/gorm"
+)
 
+// Book is stored under its ISBN-13 without hyphens, HyphenatedIsbn is only for display.
+// AvailableUnits is not set by clients, it counts the lendable items on the shelf.
 type Book struct {
 	gorm.Model     `json:"-"`
 	Isbn           string `json:"Isbn" binding:"required" gorm:"type:varchar(32);UNIQUE"`
+	HyphenatedIsbn string `json:"HyphenatedIsbn,omitempty" gorm:"-"`
 	Title          string `json:"Title" binding:"required" gorm:"type:varchar(256)"`
 	Author         string `json:"Author" binding:"required" gorm:"type:varchar(100)"`
-	AvailableUnits uint   `json:"AvailableUnits" binding:"required"`
-	UserTaken      []User `json:"-" gorm:"many2many:user_taken;"`
-	UserReturned   []User `json:"-" gorm:"many2many:user_returned;"`
+	AvailableUnits uint   `json:"AvailableUnits"`
+}
+
+// AfterFind fills in the hyphenated form of the ISBN of the loaded book
+func (b *Book) AfterFind(tx *gorm.DB) error {
+	b.HyphenatedIsbn = isbn.Hyphenate(b.Isbn)
+	return nil
 }
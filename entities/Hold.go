@@ -0,0 +1,33 @@
+package entities
+
+import "time"
+
+// DefaultPickupWindow is how long a copy allocated to a hold waits for the patron
+const DefaultPickupWindow = time.Hour * 24 * 3
+
+// Statuses of a hold. Waiting and ready holds are active, the rest are kept for history.
+const (
+	HoldWaiting   = "waiting"
+	HoldReady     = "ready"
+	HoldFulfilled = "fulfilled"
+	HoldCancelled = "cancelled"
+	HoldExpired   = "expired"
+)
+
+// Hold is a reservation of a book. Waiting holds are served in order of Position,
+// a ready hold has the Item set aside for the patron until ExpiresAt.
+type Hold struct {
+	ID        uint       `gorm:"primaryKey" json:"ID"`
+	CreatedAt time.Time  `json:"CreatedAt"`
+	UpdatedAt time.Time  `json:"-"`
+	UserID    uint       `gorm:"not null;index" json:"-"`
+	User      User       `json:"-"`
+	Patron    string     `gorm:"-" json:"Patron,omitempty"`
+	BookID    uint       `gorm:"not null;index" json:"-"`
+	Book      Book       `json:"Book"`
+	ItemID    *uint      `json:"-"`
+	Item      *Item      `json:"Item,omitempty"`
+	Position  uint       `gorm:"not null" json:"Position"`
+	Status    string     `gorm:"size:16;not null;index" json:"Status"`
+	ExpiresAt *time.Time `json:"ExpiresAt"`
+}
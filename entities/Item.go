@@ -0,0 +1,33 @@
+package entities
+
+import "time"
+
+// Statuses of an item. Only available items are on the shelf, an item on hold is
+// set aside for a ready hold and a withdrawn item has left the collection.
+const (
+	ItemAvailable = "available"
+	ItemOnLoan    = "on_loan"
+	ItemOnHold    = "on_hold"
+	ItemWithdrawn = "withdrawn"
+)
+
+// Conditions of an item. Damaged items stay in the collection but are not lent.
+const (
+	ConditionGood    = "good"
+	ConditionWorn    = "worn"
+	ConditionDamaged = "damaged"
+)
+
+// Item is a physical copy of a book, identified by the barcode on it
+type Item struct {
+	ID         uint      `gorm:"primaryKey" json:"-"`
+	CreatedAt  time.Time `json:"-"`
+	UpdatedAt  time.Time `json:"-"`
+	Barcode    string    `gorm:"type:varchar(32);UNIQUE;not null" json:"Barcode" binding:"required,max=32"`
+	BookID     uint      `gorm:"not null;index" json:"-"`
+	Book       *Book     `json:"Book,omitempty"`
+	Condition  string    `gorm:"column:copy_condition;size:16;not null" json:"Condition" binding:"omitempty,oneof=good worn damaged"`
+	Status     string    `gorm:"size:16;not null;index" json:"Status"`
+	Location   string    `gorm:"size:100" json:"Location" binding:"max=100"`
+	AcquiredAt time.Time `json:"AcquiredAt"`
+}
@@ -0,0 +1,23 @@
+package entities
+
+import "time"
+
+// Kinds of ledger entries. Charges raise the balance of the patron, payments and waivers lower it.
+const (
+	LedgerCharge  = "charge"
+	LedgerPayment = "payment"
+	LedgerWaiver  = "waiver"
+)
+
+// LedgerEntry is a movement on the fines account of a patron. Amounts are in cents and
+// always positive, the kind decides the direction. Charges point to the overdue loan.
+type LedgerEntry struct {
+	ID        uint      `gorm:"primaryKey" json:"ID"`
+	CreatedAt time.Time `json:"CreatedAt"`
+	UserID    uint      `gorm:"not null;index" json:"-"`
+	LoanID    *uint     `gorm:"index" json:"-"`
+	Loan      *Loan     `json:"Loan,omitempty"`
+	Kind      string    `gorm:"size:16;not null" json:"Kind"`
+	Amount    int64     `gorm:"not null" json:"Amount"`
+	Note      string    `gorm:"size:256" json:"Note,omitempty"`
+}
@@ -0,0 +1,57 @@
+package entities
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultLoanPeriod is how long a patron of the public category can keep a book
+	DefaultLoanPeriod = time.Hour * 24 * 14
+)
+
+// Loan is a copy lent to a patron. It is overdue once it is kept past DueAt.
+// Every renewal pushes DueAt and is kept in Renewals.
+type Loan struct {
+	gorm.Model   `json:"-"`
+	UserID       uint       `gorm:"not null;index" json:"-"`
+	BookID       uint       `gorm:"not null;index" json:"-"`
+	Book         Book       `json:"Book"`
+	ItemID       *uint      `gorm:"index" json:"-"`
+	Item         *Item      `json:"Item,omitempty"`
+	BorrowedAt   time.Time  `gorm:"not null" json:"BorrowedAt"`
+	DueAt        time.Time  `gorm:"not null" json:"DueAt"`
+	ReturnedAt   *time.Time `json:"ReturnedAt"`
+	RenewalCount uint       `gorm:"not null;default:0" json:"RenewalCount"`
+	Renewals     []Renewal  `json:"Renewals,omitempty"`
+	Overdue      bool       `gorm:"-" json:"Overdue"`
+}
+
+// Renewal records when a loan was renewed and how its due date moved
+type Renewal struct {
+	ID            uint      `gorm:"primaryKey" json:"-"`
+	LoanID        uint      `gorm:"not null;index" json:"-"`
+	RenewedAt     time.Time `gorm:"not null" json:"RenewedAt"`
+	PreviousDueAt time.Time `gorm:"not null" json:"PreviousDueAt"`
+	DueAt         time.Time `gorm:"not null" json:"DueAt"`
+}
+
+// DaysOverdue counts the started days the loan was kept past its due date at the given time
+func (l *Loan) DaysOverdue(at time.Time) int64 {
+	if l.ReturnedAt != nil && l.ReturnedAt.Before(at) {
+		at = *l.ReturnedAt
+	}
+	late := at.Sub(l.DueAt)
+	if late <= 0 {
+		return 0
+	}
+	day := time.Hour * 24
+	return int64((late + day - 1) / day)
+}
+
+// AfterFind marks the loan as overdue when it is, or was returned, past its due date
+func (l *Loan) AfterFind(tx *gorm.DB) error {
+	l.Overdue = l.DaysOverdue(time.Now()) > 0
+	return nil
+}
@@ -0,0 +1,28 @@
+package entities
+
+import "time"
+
+// Scopes of the failed login counters, the failures are counted for the account the
+// login was for and for the address it came from
+const (
+	LoginScopeAccount = "account"
+	LoginScopeAddress = "address"
+)
+
+// LoginAttempt counts the failed logins to an account or from an address. Logins are refused
+// until BlockedUntil, which is a short backoff after a failure or a longer lockout when
+// Locked is set.
+type LoginAttempt struct {
+	ID           uint       `gorm:"primaryKey" json:"-"`
+	Scope        string     `gorm:"size:16;not null;uniqueIndex:idx_login_attempt_subject" json:"Scope"`
+	Subject      string     `gorm:"size:255;not null;uniqueIndex:idx_login_attempt_subject" json:"Subject"`
+	Failures     uint       `gorm:"not null;default:0" json:"Failures"`
+	LastFailure  time.Time  `json:"LastFailure"`
+	BlockedUntil *time.Time `json:"BlockedUntil"`
+	Locked       bool       `gorm:"not null;default:false" json:"Locked"`
+}
+
+// BlockedAt tells whether logins are refused at the given time
+func (a LoginAttempt) BlockedAt(now time.Time) bool {
+	return a.BlockedUntil != nil && a.BlockedUntil.After(now)
+}
@@ -0,0 +1,28 @@
+package entities
+
+import "time"
+
+// Purposes of the one-time tokens, a token is accepted only for the purpose it was issued for
+const (
+	TokenPasswordReset     = "password_reset"
+	TokenEmailVerification = "email_verification"
+)
+
+const (
+	// PasswordResetTTL is how long a password reset token can be used
+	PasswordResetTTL = time.Hour
+	// EmailVerificationTTL is how long the link which verifies the email of a new user works
+	EmailVerificationTTL = time.Hour * 48
+)
+
+// OneTimeToken is a secret mailed to a user to confirm an action. Only the hash of the
+// token is stored, and the token is spent by the first use.
+type OneTimeToken struct {
+	ID        uint       `gorm:"primaryKey" json:"-"`
+	CreatedAt time.Time  `json:"-"`
+	UserID    uint       `gorm:"not null;index" json:"-"`
+	Purpose   string     `gorm:"size:32;not null" json:"-"`
+	TokenHash string     `gorm:"size:64;not null;UNIQUE" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"-"`
+	UsedAt    *time.Time `json:"-"`
+}
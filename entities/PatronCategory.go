@@ -0,0 +1,28 @@
+package entities
+
+import "time"
+
+// Names of the patron categories the library starts with
+const (
+	CategoryPublic  = "public"
+	CategoryStudent = "student"
+	CategoryStaff   = "staff"
+)
+
+// PatronCategory holds the circulation policy of a group of patrons. Loans are kept for
+// LoanDays and renewed at most MaxRenewals times, a patron has at most MaxLoans books and
+// MaxHolds active holds at once, and overdue loans cost FineRate cents a day.
+type PatronCategory struct {
+	ID          uint   `gorm:"primaryKey" json:"-"`
+	Name        string `gorm:"size:32;UNIQUE;not null" json:"Name"`
+	LoanDays    uint   `gorm:"not null" json:"LoanDays" binding:"required,min=1"`
+	MaxLoans    uint   `gorm:"not null" json:"MaxLoans" binding:"required,min=1"`
+	MaxRenewals uint   `gorm:"not null" json:"MaxRenewals"`
+	FineRate    int64  `gorm:"not null" json:"FineRate" binding:"min=0"`
+	MaxHolds    uint   `gorm:"not null" json:"MaxHolds"`
+}
+
+// LoanPeriod is how long a patron of the category can keep a book
+func (c PatronCategory) LoanPeriod() time.Duration {
+	return time.Hour * 24 * time.Duration(c.LoanDays)
+}
@@ -0,0 +1,14 @@
+package entities
+
+import "time"
+
+// RefreshToken belongs to the session (Auth row) with the same AuthUUID.
+// All refresh tokens issued for one session form a family.
+type RefreshToken struct {
+	ID        uint64    `gorm:"primary_key;auto_increment" json:"id"`
+	UserID    uint64    `gorm:"not null;" json:"user_id"`
+	AuthUUID  string    `gorm:"size:255;not null;index" json:"auth_uuid"`
+	TokenHash string    `gorm:"size:64;not null;UNIQUE" json:"-"`
+	ExpiresAt time.Time `gorm:"not null;" json:"expires_at"`
+	Rotated   bool      `gorm:"not null;default:false" json:"rotated"`
+}
@@ -0,0 +1,106 @@
+package entities
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Names of the roles the library starts with. Admin and User are built in, the
+// other roles can be changed or removed.
+const (
+	RoleUser      = "User"
+	RoleAdmin     = "Admin"
+	RoleLibrarian = "Librarian"
+)
+
+// Permissions checked by the routes. A role grants a set of them.
+const (
+	PermissionBooksRead        = "books:read"
+	PermissionBooksWrite       = "books:write"
+	PermissionItemsManage      = "items:manage"
+	PermissionHoldsManage      = "holds:manage"
+	PermissionLoansManage      = "loans:manage"
+	PermissionFinesManage      = "fines:manage"
+	PermissionCategoriesManage = "categories:manage"
+	PermissionUsersRead        = "users:read"
+	PermissionUsersManage      = "users:manage"
+	PermissionRolesManage      = "roles:manage"
+)
+
+// AllPermissions lists every permission, the Admin role always has all of them
+var AllPermissions = Permissions{
+	PermissionBooksRead,
+	PermissionBooksWrite,
+	PermissionItemsManage,
+	PermissionHoldsManage,
+	PermissionLoansManage,
+	PermissionFinesManage,
+	PermissionCategoriesManage,
+	PermissionUsersRead,
+	PermissionUsersManage,
+	PermissionRolesManage,
+}
+
+// Permissions is a set of permissions, stored as a comma separated list
+type Permissions []string
+
+// Has tells whether the set contains the permission
+func (p Permissions) Has(permission string) bool {
+	for _, granted := range p {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Value stores the permissions sorted and without duplicates
+func (p Permissions) Value() (driver.Value, error) {
+	unique := make([]string, 0, len(p))
+	for _, permission := range p {
+		if !Permissions(unique).Has(permission) {
+			unique = append(unique, permission)
+		}
+	}
+	sort.Strings(unique)
+	return strings.Join(unique, ","), nil
+}
+
+// Scan reads the comma separated list stored by Value
+func (p *Permissions) Scan(value interface{}) error {
+	var list string
+	switch v := value.(type) {
+	case string:
+		list = v
+	case []byte:
+		list = string(v)
+	case nil:
+		list = ""
+	default:
+		return fmt.Errorf("unable to scan %T into permissions", value)
+	}
+	*p = Permissions{}
+	if list != "" {
+		*p = strings.Split(list, ",")
+	}
+	return nil
+}
+
+// Role is a named set of permissions. Users get the permissions of their role.
+type Role struct {
+	ID          uint        `gorm:"primaryKey" json:"-"`
+	Name        string      `gorm:"size:32;UNIQUE;not null" json:"Name"`
+	Permissions Permissions `gorm:"type:text;not null" json:"Permissions" binding:"required"`
+}
+
+// Can tells whether the role grants the permission
+func (r Role) Can(permission string) bool {
+	return r.Permissions.Has(permission)
+}
+
+// BuiltIn tells whether the role is one the library cannot work without
+func (r Role) BuiltIn() bool {
+	return r.Name == RoleAdmin || r.Name == RoleUser
+}
@@ -6,7 +6,37 @@ type User struct {
 	gorm.Model    `json:"-"`
 	Email         string `json:"Email" binding:"required" gorm:"type:varchar(100);UNIQUE"`
 	Password      string `json:"Password,omitempty"`
-	Role          string `gorm:"size:255;not null;" json:"-"`
-	TakenBooks    []Book `json:"Taken_books" gorm:"many2many:user_taken;"`
-	ReturnedBooks []Book `json:"Returned_books" gorm:"many2many:user_returned;"`
+	Role          string `gorm:"size:255;not null;" json:"Role"`
+	Disabled      bool   `gorm:"not null;default:false" json:"Disabled"`
+	Verified      bool   `gorm:"not null;default:false" json:"Verified"`
+	Category      string `gorm:"size:32;not null;default:public" json:"Category"`
+	LoanLimit     *uint  `json:"LoanLimit"`
+	TakenBooks    []Book `json:"Taken_books" gorm:"-"`
+	ReturnedBooks []Book `json:"Returned_books" gorm:"-"`
+	// Allowance is filled in only when a single user is returned
+	Allowance *LoanAllowance `json:"Allowance,omitempty" gorm:"-"`
+}
+
+// LoanAllowance tells how many more books a user can take
+type LoanAllowance struct {
+	Loans     int64 `json:"Loans"`
+	Limit     uint  `json:"Limit"`
+	Remaining int64 `json:"Remaining"`
+}
+
+// MaxLoans is the loan limit set for the user by an admin, or the limit of their category
+func (u User) MaxLoans(category PatronCategory) uint {
+	if u.LoanLimit != nil {
+		return *u.LoanLimit
+	}
+	return category.MaxLoans
+}
+
+// NewLoanAllowance is the allowance of a user who has the given number of books
+func NewLoanAllowance(loans int64, limit uint) LoanAllowance {
+	remaining := int64(limit) - loans
+	if remaining < 0 {
+		remaining = 0
+	}
+	return LoanAllowance{Loans: loans, Limit: limit, Remaining: remaining}
 }
@@ -0,0 +1,115 @@
+package isbn
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalid is returned for text which is not an ISBN-10 or ISBN-13 with a valid check digit
+var ErrInvalid = errors.New("invalid isbn")
+
+// Normalize validates an ISBN-10 or ISBN-13, with or without hyphens and spaces,
+// and returns it as the 13 digits under which books are stored
+func Normalize(text string) (string, error) {
+	digits := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(text))
+	switch len(digits) {
+	case 10:
+		if !isDigits(digits[:9]) || !validIsbn10(digits) {
+			return "", ErrInvalid
+		}
+		body := "978" + digits[:9]
+		return body + string(checkDigit13(body)), nil
+	case 13:
+		if !isDigits(digits) || !(strings.HasPrefix(digits, "978") || strings.HasPrefix(digits, "979")) {
+			return "", ErrInvalid
+		}
+		if checkDigit13(digits[:12]) != digits[12] {
+			return "", ErrInvalid
+		}
+		return digits, nil
+	}
+	return "", ErrInvalid
+}
+
+// Hyphenate splits a normalized ISBN-13 into prefix, registration group, registrant,
+// publication and check digit. Only the English language groups 978-0 and 978-1 are
+// split completely, other ISBNs only have the prefix and the check digit set apart.
+func Hyphenate(isbn string) string {
+	if len(isbn) != 13 {
+		return isbn
+	}
+	prefix, rest, check := isbn[:3], isbn[3:12], isbn[12:]
+	if prefix == "978" {
+		if ranges, ok := registrantRanges[rest[:1]]; ok {
+			group, number := rest[:1], rest[1:]
+			for _, r := range ranges {
+				registrant := number[:r.length]
+				if registrant >= r.from && registrant <= r.to {
+					return strings.Join([]string{prefix, group, registrant, number[r.length:], check}, "-")
+				}
+			}
+		}
+	}
+	return strings.Join([]string{prefix, rest, check}, "-")
+}
+
+type registrantRange struct {
+	from, to string
+	length   int
+}
+
+// registrantRanges are the registrant ranges published by the International ISBN Agency
+// for the registration groups, keyed by the group
+var registrantRanges = map[string][]registrantRange{
+	"0": {
+		{"00", "19", 2},
+		{"200", "699", 3},
+		{"7000", "8499", 4},
+		{"85000", "89999", 5},
+		{"900000", "949999", 6},
+		{"9500000", "9999999", 7},
+	},
+	"1": {
+		{"00", "09", 2},
+		{"100", "399", 3},
+		{"4000", "5499", 4},
+		{"55000", "86979", 5},
+		{"869800", "998999", 6},
+		{"9990000", "9999999", 7},
+	},
+}
+
+func validIsbn10(digits string) bool {
+	sum := 0
+	for i, r := range digits {
+		value := int(r - '0')
+		if r == 'X' && i == 9 {
+			value = 10
+		} else if r < '0' || r > '9' {
+			return false
+		}
+		sum += (10 - i) * value
+	}
+	return sum%11 == 0
+}
+
+func checkDigit13(body string) byte {
+	sum := 0
+	for i, r := range body {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(r-'0')
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+func isDigits(text string) bool {
+	for _, r := range text {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package isbn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Normalize(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected string
+		err      error
+	}{{
+		name:     "isbn-13",
+		text:     "9780134685991",
+		expected: "9780134685991",
+	}, {
+		name:     "hyphenated isbn-13",
+		text:     "978-0-13-468599-1",
+		expected: "9780134685991",
+	}, {
+		name:     "isbn-10",
+		text:     "0-306-40615-2",
+		expected: "9780306406157",
+	}, {
+		name:     "isbn-10 with X check digit",
+		text:     "0 8044 2957 x",
+		expected: "9780804429573",
+	}, {
+		name:     "979 prefix",
+		text:     "979-10-90636-07-1",
+		expected: "9791090636071",
+	}, {
+		name: "wrong isbn-13 check digit",
+		text: "9780134685992",
+		err:  ErrInvalid,
+	}, {
+		name: "wrong isbn-10 check digit",
+		text: "0306406153",
+		err:  ErrInvalid,
+	}, {
+		name: "X inside isbn-10",
+		text: "03064X6152",
+		err:  ErrInvalid,
+	}, {
+		name: "unknown prefix",
+		text: "9770134685994",
+		err:  ErrInvalid,
+	}, {
+		name: "wrong length",
+		text: "978013468599",
+		err:  ErrInvalid,
+	}, {
+		name: "not a number",
+		text: "test",
+		err:  ErrInvalid,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := Normalize(tt.text)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func Test_Hyphenate(t *testing.T) {
+	tests := []struct {
+		isbn     string
+		expected string
+	}{
+		{"9780134685991", "978-0-13-468599-1"},
+		{"9780306406157", "978-0-306-40615-7"},
+		{"9780804429573", "978-0-8044-2957-3"},
+		{"9781861972712", "978-1-86197-271-2"},
+		{"9791090636071", "979-109063607-1"},
+		{"9783161484100", "978-316148410-0"},
+		{"test", "test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.isbn, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Hyphenate(tt.isbn))
+		})
+	}
+}
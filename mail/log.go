@@ -0,0 +1,32 @@
+package mail
+
+import (
+	"io"
+	"sync"
+)
+
+const logFrom = "library@localhost"
+
+type logMailer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewLogMailer creates a mailer which writes the messages to w instead of sending them.
+// It is meant for tests and for deployments without a mail server, where the messages
+// are read from the log or the file.
+func NewLogMailer(w io.Writer) *logMailer {
+	return &logMailer{
+		w: w,
+	}
+}
+
+func (m *logMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.w.Write(format(logFrom, msg)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(m.w, "\r\n")
+	return err
+}
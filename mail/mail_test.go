@@ -0,0 +1,35 @@
+package mail
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LogMailer_Send(t *testing.T) {
+	var out bytes.Buffer
+	mailer := NewLogMailer(&out)
+
+	err := mailer.Send(Message{To: "email@gmail.com", Subject: "Reset", Body: "first\nsecond"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "From: library@localhost\r\n"+
+		"To: email@gmail.com\r\n"+
+		"Subject: Reset\r\n"+
+		"MIME-Version: 1.0\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n"+
+		"\r\n"+
+		"first\r\nsecond\r\n"+
+		"\r\n", out.String())
+}
+
+func Test_NewSMTPMailer(t *testing.T) {
+	mailer := NewSMTPMailer("smtp.example.com", 587, "user", "secret", "library@example.com")
+	assert.Equal(t, "smtp.example.com:587", mailer.addr)
+	assert.NotNil(t, mailer.auth)
+	assert.Equal(t, "library@example.com", mailer.from)
+
+	anonymous := NewSMTPMailer("localhost", 25, "", "", "library@example.com")
+	assert.Nil(t, anonymous.auth)
+}
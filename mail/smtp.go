@@ -0,0 +1,30 @@
+package mail
+
+import (
+	"fmt"
+	"net/smtp"
+)
+
+type smtpMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPMailer creates a mailer which sends through the SMTP server at host:port.
+// The server is logged in to with PLAIN auth unless the username is empty.
+func NewSMTPMailer(host string, port int, username string, password string, from string) *smtpMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &smtpMailer{
+		addr: fmt.Sprintf("%s:%d", host, port),
+		auth: auth,
+		from: from,
+	}
+}
+
+func (m *smtpMailer) Send(msg Message) error {
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, format(m.from, msg))
+}
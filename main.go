@@ -1,39 +1,65 @@
 package main
 
 import (
-	"github.com/gin-gonic/gin"
-	"github.com/mishozz/Library/config"
-	"github.com/mishozz/Library/controller"
-	"github.com/mishozz/Library/repositories"
-	"github.com/mishozz/Library/router"
-	"github.com/mishozz/Library/service"
-	"github.com/mishozz/Library/utils"
-)
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
-const (
-	PORT string = "8080"
+	"github.com/mishozz/Library/app"
+	"github.com/mishozz/Library/config"
+	"go.uber.org/zap"
 )
 
-var (
-	db                                         = config.NewDatabaseConfig()
-	bookRepository repositories.BookRepository = repositories.NewBookRepository(db)
-	userRepository repositories.UserRepository = repositories.NewUserRepository(db)
-	authRepository repositories.AuthRepository = repositories.NewAuthRepository(db)
-
-	bookService service.BookService = service.NewBookService(bookRepository)
-	userService service.UserService = service.NewUserService(userRepository, bookRepository)
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(os.Args[2:])
+		return
+	}
+	os.Exit(run(os.Args[1:]))
+}
 
-	bookController  controller.BookController  = controller.NewBookController(bookService)
-	userController  controller.UserController  = controller.NewUserController(userService, bookService)
-	loginController controller.LoginController = controller.NewLoginController(authRepository, userService)
-)
+// run serves the library until it receives SIGINT or SIGTERM and returns the exit code.
+// A second signal during the shutdown kills the library right away.
+func run(args []string) int {
+	cfg, err := config.Load(args, os.LookupEnv)
+	if err != nil {
+		log.Printf("unable to load the configuration: %v", err)
+		return 1
+	}
 
-func main() {
-	defer utils.CloseDB(db.Connection)
+	logger, err := config.NewLogger(cfg.Log)
+	if err != nil {
+		log.Printf("unable to create the logger: %v", err)
+		return 1
+	}
+	defer logger.Sync()
+	zap.ReplaceGlobals(logger)
 
-	server := gin.New()
+	library, err := app.New(cfg)
+	if err != nil {
+		logger.Error("unable to start the library", zap.Error(err))
+		return 1
+	}
 
-	router.HandleRequests(server, bookController, userController, loginController)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case received := <-signals:
+			logger.Info("received a signal", zap.String("signal", received.String()))
+			signal.Stop(signals)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 
-	server.Run(":" + PORT)
+	if err := library.Run(ctx); err != nil {
+		logger.Error("the library stopped", zap.Error(err))
+		return 1
+	}
+	return 0
 }
@@ -1,45 +1,40 @@
 package middleware
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/mishozz/Library/auth"
+	"github.com/mishozz/Library/repositories"
 )
 
 const (
-	ADMIN = "Admin"
-	USER  = "User"
+	// AuthDetailsKey is the gin context key under which the resolved auth details are stored
+	AuthDetailsKey = "authDetails"
 )
 
-func TokenAuthMiddleware() gin.HandlerFunc {
+func TokenAuthMiddleware(signer *auth.Signer, authRepository repositories.AuthRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		err := auth.TokenValid(c.Request)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, "You need to be authorized to access this route")
-			c.Abort()
+		if _, ok := authenticate(c, signer, authRepository); !ok {
 			return
 		}
 		c.Next()
 	}
 }
 
-func TokenRoleMiddleware(role string) gin.HandlerFunc {
+// TokenPermissionMiddleware lets through the callers whose role grants the permission.
+// The permissions are read on every request, so changes to a role apply immediately.
+func TokenPermissionMiddleware(signer *auth.Signer, authRepository repositories.AuthRepository, roleRepository repositories.RoleRepository, permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		err := auth.TokenValid(c.Request)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, "You need to be authorized to access this route")
-			c.Abort()
-			return
-		}
-		tokenAuth, err := auth.ExtractTokenAuth(c.Request)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, "unauthorized")
-			c.Abort()
+		tokenAuth, ok := authenticate(c, signer, authRepository)
+		if !ok {
 			return
 		}
-		if tokenAuth.Role != role {
+		role, err := roleRepository.Find(c.Request.Context(), tokenAuth.Role)
+		if err != nil || !role.Can(permission) {
 			c.JSON(http.StatusForbidden, fmt.Sprintf("This route is forbidenn for %s", tokenAuth.Role))
 			c.Abort()
 			return
@@ -47,3 +42,49 @@ func TokenRoleMiddleware(role string) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// QueryTimeout puts a deadline on the context of the request. The repositories run their
+// queries with that context, so a slow database fails the request instead of holding it.
+func QueryTimeout(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// GetAuthDetails returns the auth details resolved by the token middlewares
+func GetAuthDetails(c *gin.Context) (*auth.AuthDetails, bool) {
+	value, ok := c.Get(AuthDetailsKey)
+	if !ok {
+		return nil, false
+	}
+	authD, ok := value.(*auth.AuthDetails)
+	return authD, ok
+}
+
+// authenticate validates the token, makes sure its session was not revoked
+// and stores the auth details on the context. It aborts the request on failure.
+func authenticate(c *gin.Context, signer *auth.Signer, authRepository repositories.AuthRepository) (*auth.AuthDetails, bool) {
+	err := signer.TokenValid(c.Request)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, "You need to be authorized to access this route")
+		c.Abort()
+		return nil, false
+	}
+	tokenAuth, err := signer.ExtractTokenAuth(c.Request)
+	if err != nil || tokenAuth == nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		c.Abort()
+		return nil, false
+	}
+	//the session is deleted on logout, so a token without an auth row is no longer valid
+	if _, err := authRepository.FetchAuth(c.Request.Context(), tokenAuth); err != nil {
+		c.JSON(http.StatusUnauthorized, "unauthorized")
+		c.Abort()
+		return nil, false
+	}
+	c.Set(AuthDetailsKey, tokenAuth)
+	return tokenAuth, true
+}
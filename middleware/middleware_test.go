@@ -0,0 +1,230 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/auth"
+	"github.com/mishozz/Library/entities"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var testSigner = auth.NewSigner("secret", time.Minute)
+
+type mockAuthRepo struct {
+	mock.Mock
+}
+
+func (m *mockAuthRepo) FetchAuth(ctx context.Context, authD *auth.AuthDetails) (*entities.Auth, error) {
+	args := m.Called(ctx, authD)
+	return args.Get(0).(*entities.Auth), args.Error(1)
+}
+
+func (m *mockAuthRepo) DeleteAuth(ctx context.Context, authD *auth.AuthDetails) error {
+	args := m.Called(ctx, authD)
+	return args.Error(0)
+}
+
+func (m *mockAuthRepo) CreateAuth(ctx context.Context, id uint64, role string) (*entities.Auth, error) {
+	args := m.Called(ctx, id, role)
+	return args.Get(0).(*entities.Auth), args.Error(1)
+}
+
+func (m *mockAuthRepo) DeleteByUser(ctx context.Context, id uint64) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+type mockRoleRepo struct {
+	mock.Mock
+}
+
+func (m *mockRoleRepo) Find(ctx context.Context, name string) (entities.Role, error) {
+	args := m.Called(ctx, name)
+	return args.Get(0).(entities.Role), args.Error(1)
+}
+
+func (m *mockRoleRepo) FindAll(ctx context.Context) ([]entities.Role, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]entities.Role), args.Error(1)
+}
+
+func (m *mockRoleRepo) Save(ctx context.Context, role entities.Role) error {
+	args := m.Called(ctx, role)
+	return args.Error(0)
+}
+
+func (m *mockRoleRepo) Delete(ctx context.Context, name string) error {
+	args := m.Called(ctx, name)
+	return args.Error(0)
+}
+
+func Test_TokenMiddlewares(t *testing.T) {
+	authD := auth.AuthDetails{
+		AuthUuid: "83b09612-9dfc-4c1d-8f7d-a589acec7081",
+		UserId:   1,
+		Role:     entities.RoleLibrarian,
+	}
+	librarian := entities.Role{Name: entities.RoleLibrarian, Permissions: entities.Permissions{entities.PermissionBooksRead, entities.PermissionBooksWrite}}
+	token, err := testSigner.CreateToken(authD)
+	if err != nil {
+		t.FailNow()
+	}
+
+	tests := []struct {
+		name         string
+		middleware   func(m *mockAuthRepo, r *mockRoleRepo) gin.HandlerFunc
+		mockAuthRepo func(m *mockAuthRepo) *mockAuthRepo
+		mockRoleRepo func(m *mockRoleRepo) *mockRoleRepo
+		token        string
+		statusCode   int
+	}{{
+		name: "active session",
+		middleware: func(m *mockAuthRepo, r *mockRoleRepo) gin.HandlerFunc {
+			return TokenAuthMiddleware(testSigner, m)
+		},
+		mockAuthRepo: func(m *mockAuthRepo) *mockAuthRepo {
+			m.On("FetchAuth", mock.Anything, &authD).Return(&entities.Auth{}, nil)
+			return m
+		},
+		token:      token,
+		statusCode: http.StatusOK,
+	}, {
+		name: "revoked session",
+		middleware: func(m *mockAuthRepo, r *mockRoleRepo) gin.HandlerFunc {
+			return TokenAuthMiddleware(testSigner, m)
+		},
+		mockAuthRepo: func(m *mockAuthRepo) *mockAuthRepo {
+			m.On("FetchAuth", mock.Anything, &authD).Return((*entities.Auth)(nil), errors.New("record not found"))
+			return m
+		},
+		token:      token,
+		statusCode: http.StatusUnauthorized,
+	}, {
+		name: "invalid token",
+		middleware: func(m *mockAuthRepo, r *mockRoleRepo) gin.HandlerFunc {
+			return TokenAuthMiddleware(testSigner, m)
+		},
+		mockAuthRepo: func(m *mockAuthRepo) *mockAuthRepo {
+			return m
+		},
+		token:      token + "xx",
+		statusCode: http.StatusUnauthorized,
+	}, {
+		name: "revoked session with permission",
+		middleware: func(m *mockAuthRepo, r *mockRoleRepo) gin.HandlerFunc {
+			return TokenPermissionMiddleware(testSigner, m, r, entities.PermissionBooksWrite)
+		},
+		mockAuthRepo: func(m *mockAuthRepo) *mockAuthRepo {
+			m.On("FetchAuth", mock.Anything, &authD).Return((*entities.Auth)(nil), errors.New("record not found"))
+			return m
+		},
+		token:      token,
+		statusCode: http.StatusUnauthorized,
+	}, {
+		name: "granted permission",
+		middleware: func(m *mockAuthRepo, r *mockRoleRepo) gin.HandlerFunc {
+			return TokenPermissionMiddleware(testSigner, m, r, entities.PermissionBooksWrite)
+		},
+		mockAuthRepo: func(m *mockAuthRepo) *mockAuthRepo {
+			m.On("FetchAuth", mock.Anything, &authD).Return(&entities.Auth{}, nil)
+			return m
+		},
+		mockRoleRepo: func(m *mockRoleRepo) *mockRoleRepo {
+			m.On("Find", mock.Anything, entities.RoleLibrarian).Return(librarian, nil)
+			return m
+		},
+		token:      token,
+		statusCode: http.StatusOK,
+	}, {
+		name: "missing permission",
+		middleware: func(m *mockAuthRepo, r *mockRoleRepo) gin.HandlerFunc {
+			return TokenPermissionMiddleware(testSigner, m, r, entities.PermissionRolesManage)
+		},
+		mockAuthRepo: func(m *mockAuthRepo) *mockAuthRepo {
+			m.On("FetchAuth", mock.Anything, &authD).Return(&entities.Auth{}, nil)
+			return m
+		},
+		mockRoleRepo: func(m *mockRoleRepo) *mockRoleRepo {
+			m.On("Find", mock.Anything, entities.RoleLibrarian).Return(librarian, nil)
+			return m
+		},
+		token:      token,
+		statusCode: http.StatusForbidden,
+	}, {
+		name: "deleted role",
+		middleware: func(m *mockAuthRepo, r *mockRoleRepo) gin.HandlerFunc {
+			return TokenPermissionMiddleware(testSigner, m, r, entities.PermissionBooksRead)
+		},
+		mockAuthRepo: func(m *mockAuthRepo) *mockAuthRepo {
+			m.On("FetchAuth", mock.Anything, &authD).Return(&entities.Auth{}, nil)
+			return m
+		},
+		mockRoleRepo: func(m *mockRoleRepo) *mockRoleRepo {
+			m.On("Find", mock.Anything, entities.RoleLibrarian).Return(entities.Role{}, errors.New("record not found"))
+			return m
+		},
+		token:      token,
+		statusCode: http.StatusForbidden,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockAuth := tt.mockAuthRepo(&mockAuthRepo{})
+			mockRoles := &mockRoleRepo{}
+			if tt.mockRoleRepo != nil {
+				mockRoles = tt.mockRoleRepo(mockRoles)
+			}
+
+			w := httptest.NewRecorder()
+			c, r := gin.CreateTestContext(w)
+
+			var resolved *auth.AuthDetails
+			r.GET("/test", tt.middleware(mockAuth, mockRoles), func(c *gin.Context) {
+				resolved, _ = GetAuthDetails(c)
+				c.JSON(http.StatusOK, "ok")
+			})
+
+			c.Request, _ = http.NewRequest(http.MethodGet, "/test", nil)
+			c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %v", tt.token))
+			r.ServeHTTP(w, c.Request)
+
+			assert.Equal(t, tt.statusCode, w.Code)
+			if w.Code == http.StatusOK {
+				assert.Equal(t, &authD, resolved)
+			}
+			mockAuth.AssertExpectations(t)
+			mockRoles.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_QueryTimeout(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, r := gin.CreateTestContext(w)
+
+	var deadline time.Time
+	var hasDeadline bool
+	var ctx context.Context
+	r.GET("/test", QueryTimeout(time.Minute), func(c *gin.Context) {
+		ctx = c.Request.Context()
+		deadline, hasDeadline = ctx.Deadline()
+		c.JSON(http.StatusOK, "ok")
+	})
+
+	c.Request, _ = http.NewRequest(http.MethodGet, "/test", nil)
+	r.ServeHTTP(w, c.Request)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, hasDeadline)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second*5)
+	//the context is released once the request is handled
+	assert.Equal(t, context.Canceled, ctx.Err())
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mishozz/Library/config"
+	"github.com/mishozz/Library/migrations"
+	"github.com/mishozz/Library/utils"
+)
+
+const migrateUsage = `usage:
+  library migrate up [flags]            apply the pending migrations
+  library migrate down [steps] [flags]  revert the last migration, or the last steps migrations
+  library migrate status [flags]        list the migrations and when they were applied
+  library migrate create <name>         create an empty migration in ./migrations`
+
+// migrate runs the migrate subcommand with the arguments after it. The flags are the flags
+// of the server, the database comes from the same configuration.
+func migrate(args []string) {
+	if len(args) == 0 {
+		log.Fatal(migrateUsage)
+	}
+	command, args := args[0], args[1:]
+	if command == "create" {
+		if len(args) != 1 {
+			log.Fatal(migrateUsage)
+		}
+		path, err := migrations.Create("migrations", args[0], time.Now())
+		if err != nil {
+			log.Fatalf("unable to create the migration: %v", err)
+		}
+		fmt.Println("created", path)
+		return
+	}
+
+	steps := 1
+	if command == "down" && len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		var err error
+		steps, err = strconv.Atoi(args[0])
+		if err != nil || steps < 1 {
+			log.Fatalf("the steps to revert must be a positive number, not %s", args[0])
+		}
+		args = args[1:]
+	}
+	cfg, err := config.LoadDatabase(args, os.LookupEnv)
+	if err != nil {
+		log.Fatalf("unable to load the configuration: %v", err)
+	}
+	db, err := config.Open(cfg.Database, cfg.Log)
+	if err != nil {
+		log.Fatalf("unable to open the database: %v", err)
+	}
+	defer utils.CloseDB(db)
+	migrator := migrations.NewMigrator(db, migrations.All())
+
+	switch command {
+	case "up":
+		applied, err := migrator.Up()
+		for _, migration := range applied {
+			fmt.Println("applied", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("the schema is up to date")
+		}
+	case "down":
+		reverted, err := migrator.Down(steps)
+		for _, migration := range reverted {
+			fmt.Println("reverted", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			log.Fatal(err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, applied)
+		}
+		w.Flush()
+	default:
+		log.Fatal(migrateUsage)
+	}
+}
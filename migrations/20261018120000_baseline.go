@@ -0,0 +1,344 @@
+package migrations
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// The tables as they were when the schema was first versioned. Before that every start of
+// the library migrated the database to the entities, so the baseline brings a database of
+// any earlier release to this schema.
+
+// The values the baseline writes, as the library used them then. They are not taken from
+// the entities, so changing the entities later does not change what the baseline does.
+const (
+	baselineLoanPeriod    = time.Hour * 24 * 14
+	baselineConditionGood = "good"
+	baselineItemAvailable = "available"
+	baselineItemOnLoan    = "on_loan"
+	baselineItemOnHold    = "on_hold"
+	baselineHoldReady     = "ready"
+)
+
+type baselineBook struct {
+	gorm.Model
+	Isbn           string `gorm:"type:varchar(32);UNIQUE"`
+	Title          string `gorm:"type:varchar(256)"`
+	Author         string `gorm:"type:varchar(100)"`
+	AvailableUnits uint
+}
+
+func (baselineBook) TableName() string { return "books" }
+
+type baselineUser struct {
+	gorm.Model
+	Email     string `gorm:"type:varchar(100);UNIQUE"`
+	Password  string
+	Role      string `gorm:"size:255;not null;"`
+	Disabled  bool   `gorm:"not null;default:false"`
+	Verified  bool   `gorm:"not null;default:false"`
+	Category  string `gorm:"size:32;not null;default:public"`
+	LoanLimit *uint
+}
+
+func (baselineUser) TableName() string { return "users" }
+
+type baselineAuth struct {
+	ID       uint64 `gorm:"primary_key;auto_increment"`
+	UserID   uint64 `gorm:";not null;"`
+	AuthUUID string `gorm:"size:255;not null;"`
+	Role     string `gorm:"size:255;not null;"`
+}
+
+func (baselineAuth) TableName() string { return "auths" }
+
+type baselineRefreshToken struct {
+	ID        uint64    `gorm:"primary_key;auto_increment"`
+	UserID    uint64    `gorm:"not null;"`
+	AuthUUID  string    `gorm:"size:255;not null;index"`
+	TokenHash string    `gorm:"size:64;not null;UNIQUE"`
+	ExpiresAt time.Time `gorm:"not null;"`
+	Rotated   bool      `gorm:"not null;default:false"`
+}
+
+func (baselineRefreshToken) TableName() string { return "refresh_tokens" }
+
+type baselineItem struct {
+	ID         uint `gorm:"primaryKey"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Barcode    string `gorm:"type:varchar(32);UNIQUE;not null"`
+	BookID     uint   `gorm:"not null;index"`
+	Book       *baselineBook
+	Condition  string `gorm:"column:copy_condition;size:16;not null"`
+	Status     string `gorm:"size:16;not null;index"`
+	Location   string `gorm:"size:100"`
+	AcquiredAt time.Time
+}
+
+func (baselineItem) TableName() string { return "items" }
+
+type baselineLoan struct {
+	gorm.Model
+	UserID       uint `gorm:"not null;index"`
+	BookID       uint `gorm:"not null;index"`
+	Book         baselineBook
+	ItemID       *uint `gorm:"index"`
+	Item         *baselineItem
+	BorrowedAt   time.Time `gorm:"not null"`
+	DueAt        time.Time `gorm:"not null"`
+	ReturnedAt   *time.Time
+	RenewalCount uint              `gorm:"not null;default:0"`
+	Renewals     []baselineRenewal `gorm:"foreignKey:LoanID"`
+}
+
+func (baselineLoan) TableName() string { return "loans" }
+
+type baselineRenewal struct {
+	ID            uint      `gorm:"primaryKey"`
+	LoanID        uint      `gorm:"not null;index"`
+	RenewedAt     time.Time `gorm:"not null"`
+	PreviousDueAt time.Time `gorm:"not null"`
+	DueAt         time.Time `gorm:"not null"`
+}
+
+func (baselineRenewal) TableName() string { return "renewals" }
+
+type baselineHold struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserID    uint `gorm:"not null;index"`
+	User      baselineUser
+	BookID    uint `gorm:"not null;index"`
+	Book      baselineBook
+	ItemID    *uint
+	Item      *baselineItem
+	Position  uint   `gorm:"not null"`
+	Status    string `gorm:"size:16;not null;index"`
+	ExpiresAt *time.Time
+}
+
+func (baselineHold) TableName() string { return "holds" }
+
+type baselineLedgerEntry struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UserID    uint  `gorm:"not null;index"`
+	LoanID    *uint `gorm:"index"`
+	Loan      *baselineLoan
+	Kind      string `gorm:"size:16;not null"`
+	Amount    int64  `gorm:"not null"`
+	Note      string `gorm:"size:256"`
+}
+
+func (baselineLedgerEntry) TableName() string { return "ledger_entries" }
+
+type baselinePatronCategory struct {
+	ID          uint   `gorm:"primaryKey"`
+	Name        string `gorm:"size:32;UNIQUE;not null"`
+	LoanDays    uint   `gorm:"not null"`
+	MaxLoans    uint   `gorm:"not null"`
+	MaxRenewals uint   `gorm:"not null"`
+	FineRate    int64  `gorm:"not null"`
+	MaxHolds    uint   `gorm:"not null"`
+}
+
+func (baselinePatronCategory) TableName() string { return "patron_categories" }
+
+type baselineRole struct {
+	ID          uint   `gorm:"primaryKey"`
+	Name        string `gorm:"size:32;UNIQUE;not null"`
+	Permissions string `gorm:"type:text;not null"` //sorted and comma separated
+}
+
+func (baselineRole) TableName() string { return "roles" }
+
+type baselineOneTimeToken struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UserID    uint      `gorm:"not null;index"`
+	Purpose   string    `gorm:"size:32;not null"`
+	TokenHash string    `gorm:"size:64;not null;UNIQUE"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+}
+
+func (baselineOneTimeToken) TableName() string { return "one_time_tokens" }
+
+type baselineLoginAttempt struct {
+	ID           uint   `gorm:"primaryKey"`
+	Scope        string `gorm:"size:16;not null;uniqueIndex:idx_login_attempt_subject"`
+	Subject      string `gorm:"size:255;not null;uniqueIndex:idx_login_attempt_subject"`
+	Failures     uint   `gorm:"not null;default:0"`
+	LastFailure  time.Time
+	BlockedUntil *time.Time
+	Locked       bool `gorm:"not null;default:false"`
+}
+
+func (baselineLoginAttempt) TableName() string { return "login_attempts" }
+
+type baselineAuditEvent struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	Action    string `gorm:"size:32;not null;index"`
+	Subject   string `gorm:"size:255;not null"`
+	ActorID   *uint
+	Detail    string
+}
+
+func (baselineAuditEvent) TableName() string { return "audit_events" }
+
+// baselineTables are the tables of the baseline, each after the tables it refers to
+var baselineTables = []interface{}{
+	&baselineBook{},
+	&baselineUser{},
+	&baselineAuth{},
+	&baselineRefreshToken{},
+	&baselineItem{},
+	&baselineLoan{},
+	&baselineRenewal{},
+	&baselineHold{},
+	&baselineLedgerEntry{},
+	&baselinePatronCategory{},
+	&baselineRole{},
+	&baselineOneTimeToken{},
+	&baselineLoginAttempt{},
+	&baselineAuditEvent{},
+}
+
+func init() {
+	register(Migration{
+		Version: 20261018120000,
+		Name:    "baseline",
+		Up:      baselineUp,
+		Down: func(tx *gorm.DB) error {
+			for i := len(baselineTables) - 1; i >= 0; i-- {
+				if err := tx.Migrator().DropTable(baselineTables[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
+
+func baselineUp(tx *gorm.DB) error {
+	//the users of the releases before the email verification are verified, they could borrow
+	//books already
+	verifyUsers := tx.Migrator().HasTable(&baselineUser{}) && !tx.Migrator().HasColumn(&baselineUser{}, "Verified")
+	if err := tx.AutoMigrate(baselineTables...); err != nil {
+		return err
+	}
+	if err := migrateLegacyLoans(tx); err != nil {
+		return err
+	}
+	if err := migrateStockToItems(tx); err != nil {
+		return err
+	}
+	if verifyUsers {
+		return tx.Model(&baselineUser{}).Where("1 = 1").Update("verified", true).Error
+	}
+	return nil
+}
+
+type legacyLoan struct {
+	UserID uint
+	BookID uint
+}
+
+// migrateLegacyLoans moves the rows of the old user_taken and user_returned join tables
+// into loans and drops the tables. Their borrow dates were never recorded, so the time
+// of the migration is used instead.
+func migrateLegacyLoans(tx *gorm.DB) error {
+	now := time.Now()
+	for _, table := range []string{"user_returned", "user_taken"} {
+		if !tx.Migrator().HasTable(table) {
+			continue
+		}
+		var rows []legacyLoan
+		if err := tx.Table(table).Select("user_id, book_id").Scan(&rows).Error; err != nil {
+			return fmt.Errorf("unable to read %s: %w", table, err)
+		}
+		for _, row := range rows {
+			loan := baselineLoan{
+				UserID:     row.UserID,
+				BookID:     row.BookID,
+				BorrowedAt: now,
+				DueAt:      now.Add(baselineLoanPeriod),
+			}
+			if table == "user_returned" {
+				loan.DueAt = now
+				loan.ReturnedAt = &now
+			}
+			if err := tx.Omit("Book", "Item").Create(&loan).Error; err != nil {
+				return fmt.Errorf("unable to migrate %s: %w", table, err)
+			}
+		}
+		if err := tx.Migrator().DropTable(table); err != nil {
+			return fmt.Errorf("unable to drop %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// migrateStockToItems gives the books which still count their copies in available_units
+// an item for every copy on the shelf, on loan or set aside for a ready hold, and links the
+// loans and holds to their items. The barcodes are made up from the ISBN, so the copies
+// have to be relabelled.
+func migrateStockToItems(tx *gorm.DB) error {
+	var books []baselineBook
+	err := tx.Where("NOT EXISTS (SELECT 1 FROM items WHERE items.book_id = books.id)").Find(&books).Error
+	if err != nil {
+		return fmt.Errorf("unable to read the books without items: %w", err)
+	}
+	for _, book := range books {
+		copies := 0
+		newItem := func(status string) (baselineItem, error) {
+			copies++
+			item := baselineItem{
+				Barcode:    fmt.Sprintf("%s-%d", book.Isbn, copies),
+				BookID:     book.ID,
+				Condition:  baselineConditionGood,
+				Status:     status,
+				AcquiredAt: book.CreatedAt,
+			}
+			return item, tx.Omit("Book").Create(&item).Error
+		}
+
+		for i := uint(0); i < book.AvailableUnits; i++ {
+			if _, err := newItem(baselineItemAvailable); err != nil {
+				return fmt.Errorf("unable to migrate the copies of %s: %w", book.Isbn, err)
+			}
+		}
+		var loans []baselineLoan
+		if err := tx.Where("book_id = ? AND returned_at IS NULL", book.ID).Find(&loans).Error; err != nil {
+			return fmt.Errorf("unable to read the loans of %s: %w", book.Isbn, err)
+		}
+		for _, loan := range loans {
+			item, err := newItem(baselineItemOnLoan)
+			if err == nil {
+				err = tx.Model(&loan).Update("item_id", item.ID).Error
+			}
+			if err != nil {
+				return fmt.Errorf("unable to migrate the loans of %s: %w", book.Isbn, err)
+			}
+		}
+		var holds []baselineHold
+		if err := tx.Where("book_id = ? AND status = ?", book.ID, baselineHoldReady).Find(&holds).Error; err != nil {
+			return fmt.Errorf("unable to read the holds of %s: %w", book.Isbn, err)
+		}
+		for _, hold := range holds {
+			item, err := newItem(baselineItemOnHold)
+			if err == nil {
+				err = tx.Model(&hold).Update("item_id", item.ID).Error
+			}
+			if err != nil {
+				return fmt.Errorf("unable to migrate the holds of %s: %w", book.Isbn, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package migrations
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// The patron categories and roles a new library starts with. They are only defined here,
+// afterwards they are changed through the API.
+var (
+	seedCategories = []baselinePatronCategory{
+		{Name: "public", LoanDays: 14, MaxLoans: 5, MaxRenewals: 2, FineRate: 25, MaxHolds: 3},
+		{Name: "student", LoanDays: 21, MaxLoans: 10, MaxRenewals: 3, FineRate: 10, MaxHolds: 5},
+		{Name: "staff", LoanDays: 28, MaxLoans: 20, MaxRenewals: 5, FineRate: 0, MaxHolds: 10},
+	}
+	seedRoles = []baselineRole{
+		{Name: "Admin", Permissions: seedAdminPermissions},
+		{Name: "Librarian", Permissions: "books:read,books:write,fines:manage,holds:manage,items:manage,loans:manage,users:read"},
+		{Name: "User", Permissions: "books:read"},
+	}
+)
+
+// seedAdminPermissions are all the permissions there were
+const seedAdminPermissions = "books:read,books:write,categories:manage,fines:manage,holds:manage,items:manage,loans:manage,roles:manage,users:manage,users:read"
+
+func init() {
+	register(Migration{
+		Version: 20261018120100,
+		Name:    "seed_defaults",
+		Up:      seedDefaultsUp,
+		Down: func(tx *gorm.DB) error {
+			var categories, roles []string
+			for _, category := range seedCategories {
+				categories = append(categories, category.Name)
+			}
+			for _, role := range seedRoles {
+				roles = append(roles, role.Name)
+			}
+			if err := tx.Where("name IN ?", categories).Delete(&baselinePatronCategory{}).Error; err != nil {
+				return err
+			}
+			return tx.Where("name IN ?", roles).Delete(&baselineRole{}).Error
+		},
+	})
+}
+
+// seedDefaultsUp creates the default patron categories and roles which are missing.
+// Categories and roles changed by the library are left as they are, except Admin which gets
+// every permission. A migration which adds a permission has to grant it to Admin as well.
+func seedDefaultsUp(tx *gorm.DB) error {
+	for _, category := range seedCategories {
+		row := category
+		err := tx.Where(baselinePatronCategory{Name: row.Name}).FirstOrCreate(&row).Error
+		if err != nil {
+			return fmt.Errorf("unable to create the %s category: %w", row.Name, err)
+		}
+	}
+	for _, role := range seedRoles {
+		row := role
+		err := tx.Where(baselineRole{Name: row.Name}).FirstOrCreate(&row).Error
+		if err != nil {
+			return fmt.Errorf("unable to create the %s role: %w", row.Name, err)
+		}
+	}
+	err := tx.Model(&baselineRole{}).Where("name = ?", "Admin").Update("permissions", seedAdminPermissions).Error
+	if err != nil {
+		return fmt.Errorf("unable to update the Admin role: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// Deleted users used to be only marked deleted, which kept their emails taken. The marked
+// users are deleted for good with their holds, like the library deletes users now. The
+// rows are gone, so there is nothing to bring back on the way down.
+func init() {
+	register(Migration{
+		Version: 20261018130000,
+		Name:    "purge_deleted_users",
+		Up: func(tx *gorm.DB) error {
+			deleted := tx.Unscoped().Model(&baselineUser{}).Select("id").Where("deleted_at IS NOT NULL")
+			if err := tx.Where("user_id IN (?)", deleted).Delete(&baselineHold{}).Error; err != nil {
+				return err
+			}
+			return tx.Unscoped().Where("deleted_at IS NOT NULL").Delete(&baselineUser{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return nil
+		},
+	})
+}
@@ -0,0 +1,40 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// The FTS5 index of the catalogue. It exists only on SQLite built with FTS5, the catalogue is
+// searched with LIKE everywhere else. Earlier releases created the index when the library
+// started, such an index is kept as it is.
+func init() {
+	register(Migration{
+		Version: 20261018140000,
+		Name:    "books_fts",
+		Up: func(tx *gorm.DB) error {
+			if !FullTextSearchAvailable(tx) || tx.Migrator().HasTable("books_fts") {
+				return nil
+			}
+			err := tx.Exec("CREATE VIRTUAL TABLE books_fts USING fts5(title, author, tokenize = 'unicode61 remove_diacritics 2')").Error
+			if err != nil {
+				return err
+			}
+			return tx.Exec("INSERT INTO books_fts (rowid, title, author) SELECT id, title, author FROM books").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Dialector.Name() != "sqlite" {
+				return nil
+			}
+			return tx.Exec("DROP TABLE IF EXISTS books_fts").Error
+		},
+	})
+}
+
+// FullTextSearchAvailable tells whether the database is SQLite compiled with FTS5, which
+// the sqlite_fts5 build tag of the driver does
+func FullTextSearchAvailable(db *gorm.DB) bool {
+	if db.Dialector.Name() != "sqlite" {
+		return false
+	}
+	var enabled int
+	err := db.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled).Error
+	return err == nil && enabled == 1
+}
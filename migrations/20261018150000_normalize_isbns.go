@@ -0,0 +1,116 @@
+package migrations
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mishozz/Library/isbn"
+	"gorm.io/gorm"
+)
+
+// The values the migration writes, as the library used them then
+const (
+	normalizeHoldWaiting   = "waiting"
+	normalizeHoldReady     = "ready"
+	normalizeHoldCancelled = "cancelled"
+)
+
+// Books used to be stored under the ISBN as it was sent, the library looks them up by the
+// 13 digits now. The ISBNs are normalized and the books which turn out to be the same book
+// are merged into the first of them, with their copies, loans and holds. A book whose ISBN
+// cannot be normalized fails the migration with the list of such books, they have to be
+// corrected by hand first. The old forms are not kept, so the way down changes nothing.
+func init() {
+	register(Migration{
+		Version: 20261018150000,
+		Name:    "normalize_isbns",
+		Up:      normalizeIsbns,
+		Down: func(tx *gorm.DB) error {
+			return nil
+		},
+	})
+}
+
+func normalizeIsbns(tx *gorm.DB) error {
+	//the books which are not deleted are kept when they are merged
+	var books []baselineBook
+	if err := tx.Unscoped().Order("deleted_at IS NOT NULL").Order("id").Find(&books).Error; err != nil {
+		return err
+	}
+	var invalid []string
+	normalized := make([]string, len(books))
+	for i, book := range books {
+		var err error
+		normalized[i], err = isbn.Normalize(book.Isbn)
+		if err != nil {
+			invalid = append(invalid, fmt.Sprintf("%d %q", book.ID, book.Isbn))
+		}
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("the ISBNs of these books cannot be normalized, correct them and migrate again: %s", strings.Join(invalid, ", "))
+	}
+
+	survivors := map[string]baselineBook{}
+	for i, book := range books {
+		if survivor, ok := survivors[normalized[i]]; ok {
+			if err := mergeBook(tx, book, survivor); err != nil {
+				return fmt.Errorf("unable to merge book %d into book %d: %w", book.ID, survivor.ID, err)
+			}
+			continue
+		}
+		survivors[normalized[i]] = book
+	}
+	for normalizedIsbn, book := range survivors {
+		if book.Isbn == normalizedIsbn {
+			continue
+		}
+		if err := tx.Unscoped().Model(&baselineBook{}).Where("id = ?", book.ID).Update("isbn", normalizedIsbn).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeBook moves the copies, loans and holds of the duplicate to the survivor and deletes
+// the duplicate. Its holds queue after the holds of the survivor, a hold of a patron who
+// already holds the survivor is cancelled.
+func mergeBook(tx *gorm.DB, duplicate baselineBook, survivor baselineBook) error {
+	if err := tx.Model(&baselineItem{}).Where("book_id = ?", duplicate.ID).Update("book_id", survivor.ID).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Model(&baselineLoan{}).Where("book_id = ?", duplicate.ID).Update("book_id", survivor.ID).Error; err != nil {
+		return err
+	}
+
+	active := []string{normalizeHoldWaiting, normalizeHoldReady}
+	holding := tx.Model(&baselineHold{}).Select("user_id").Where("book_id = ? AND status IN ?", survivor.ID, active)
+	err := tx.Model(&baselineHold{}).
+		Where("book_id = ? AND status = ? AND user_id IN (?)", duplicate.ID, normalizeHoldWaiting, holding).
+		Update("status", normalizeHoldCancelled).Error
+	if err != nil {
+		return err
+	}
+	var last uint
+	err = tx.Model(&baselineHold{}).Select("COALESCE(MAX(position), 0)").Where("book_id = ?", survivor.ID).Scan(&last).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Model(&baselineHold{}).Where("book_id = ?", duplicate.ID).
+		Updates(map[string]interface{}{"book_id": survivor.ID, "position": gorm.Expr("position + ?", last)}).Error
+	if err != nil {
+		return err
+	}
+
+	err = tx.Unscoped().Model(&baselineBook{}).Where("id = ?", survivor.ID).
+		Update("available_units", gorm.Expr("available_units + ?", duplicate.AvailableUnits)).Error
+	if err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Delete(&baselineBook{}, duplicate.ID).Error; err != nil {
+		return err
+	}
+	if tx.Migrator().HasTable("books_fts") {
+		return tx.Exec("DELETE FROM books_fts WHERE rowid = ?", duplicate.ID).Error
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package migrations
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"text/template"
+	"time"
+)
+
+// ErrInvalidName is returned when the name of a new migration is not lower snake case
+var ErrInvalidName = errors.New("the name of a migration is made of lowercase letters, digits and underscores")
+
+var validName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+var migrationTemplate = template.Must(template.New("migration").Parse(`package migrations
+
+import "gorm.io/gorm"
+
+func init() {
+	register(Migration{
+		Version: {{.Version}},
+		Name:    "{{.Name}}",
+		Up: func(tx *gorm.DB) error {
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			return nil
+		},
+	})
+}
+`))
+
+// Create writes an empty migration versioned with the time into the directory of the
+// package and returns the path of its file
+func Create(dir string, name string, now time.Time) (string, error) {
+	if !validName.MatchString(name) {
+		return "", ErrInvalidName
+	}
+	version := now.UTC().Format("20060102150405")
+	path := filepath.Join(dir, fmt.Sprintf("%s_%s.go", version, name))
+	if _, err := os.Stat(path); err == nil {
+		return "", fmt.Errorf("%s exists already", path)
+	}
+	var content bytes.Buffer
+	err := migrationTemplate.Execute(&content, struct{ Version, Name string }{version, name})
+	if err != nil {
+		return "", err
+	}
+	return path, ioutil.WriteFile(path, content.Bytes(), 0644)
+}
@@ -0,0 +1,209 @@
+// Package migrations versions the schema of the database. Every change of the schema is a
+// migration with an up and a down step, applied in the order of the versions and recorded
+// in the schema_migrations table. The migrations describe the tables with their own structs
+// rather than the entities, so the entities can change without changing what an old
+// migration does. New migrations are created with `library migrate create <name>`.
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrSchemaBehind is returned when the database has pending migrations
+var ErrSchemaBehind = errors.New("the database schema is behind")
+
+// Migration changes the schema from the previous version to Version and back. Both steps
+// run in a transaction, although MySQL commits every change of a table right away.
+type Migration struct {
+	// Version orders the migrations, it is the UTC time of their creation as YYYYMMDDhhmmss
+	Version uint64
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// Status tells whether a migration is applied to the database and when
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+type schemaMigration struct {
+	Version   uint64    `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"size:255;not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+var registered []Migration
+
+// register adds a migration of the package, it is called from the init of its file
+func register(migration Migration) {
+	for _, m := range registered {
+		if m.Version == migration.Version {
+			panic(fmt.Sprintf("migrations %s and %s have the same version %d", m.Name, migration.Name, m.Version))
+		}
+	}
+	registered = append(registered, migration)
+}
+
+// All returns the migrations of the library ordered by their versions
+func All() []Migration {
+	all := append([]Migration{}, registered...)
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+	return all
+}
+
+// Migrator applies and reverts the migrations of a database
+type Migrator interface {
+	Up() ([]Migration, error)
+	Down(steps int) ([]Migration, error)
+	Status() ([]Status, error)
+	Check() error
+}
+
+type migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// NewMigrator creates a migrator of the database, the migrations have to be ordered by
+// their versions
+func NewMigrator(db *gorm.DB, migrations []Migration) *migrator {
+	return &migrator{
+		db:         db,
+		migrations: migrations,
+	}
+}
+
+// Up applies the pending migrations in order and returns them. It stops at the first one
+// which fails, the migrations before it stay applied.
+func (m *migrator) Up() ([]Migration, error) {
+	if err := m.db.AutoMigrate(&schemaMigration{}); err != nil {
+		return nil, fmt.Errorf("unable to create the schema_migrations table: %w", err)
+	}
+	pending, err := m.pending()
+	if err != nil {
+		return nil, err
+	}
+	var applied []Migration
+	for _, migration := range pending {
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return applied, fmt.Errorf("unable to apply migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+// Down reverts the last steps applied migrations, the latest first, and returns them
+func (m *migrator) Down(steps int) ([]Migration, error) {
+	records, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	var reverted []Migration
+	for i := len(records) - 1; i >= 0 && len(reverted) < steps; i-- {
+		migration, ok := m.find(records[i].Version)
+		if !ok {
+			return reverted, fmt.Errorf("migration %d %s is applied but unknown to this build", records[i].Version, records[i].Name)
+		}
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&schemaMigration{}, migration.Version).Error
+		})
+		if err != nil {
+			return reverted, fmt.Errorf("unable to revert migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+		reverted = append(reverted, migration)
+	}
+	return reverted, nil
+}
+
+// Status lists every migration with the time it was applied
+func (m *migrator) Status() ([]Status, error) {
+	records, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	appliedAt := map[uint64]time.Time{}
+	for _, record := range records {
+		appliedAt[record.Version] = record.AppliedAt
+	}
+	statuses := make([]Status, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i] = Status{Migration: migration}
+		if at, ok := appliedAt[migration.Version]; ok {
+			statuses[i].AppliedAt = &at
+		}
+	}
+	return statuses, nil
+}
+
+// Check returns ErrSchemaBehind when a migration is not applied to the database yet
+func (m *migrator) Check() error {
+	pending, err := m.pending()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %d pending migrations, the first is %d %s", ErrSchemaBehind, len(pending), pending[0].Version, pending[0].Name)
+	}
+	return nil
+}
+
+func (m *migrator) pending() ([]Migration, error) {
+	records, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	applied := map[uint64]bool{}
+	for _, record := range records {
+		applied[record.Version] = true
+	}
+	var pending []Migration
+	for _, migration := range m.migrations {
+		if !applied[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// applied returns the records of the applied migrations ordered by their versions, none when
+// the database was never migrated
+func (m *migrator) applied() ([]schemaMigration, error) {
+	if !m.db.Migrator().HasTable(&schemaMigration{}) {
+		return nil, nil
+	}
+	var records []schemaMigration
+	if err := m.db.Order("version").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("unable to read the schema_migrations table: %w", err)
+	}
+	return records, nil
+}
+
+func (m *migrator) find(version uint64) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
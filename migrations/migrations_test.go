@@ -0,0 +1,395 @@
+package migrations
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mishozz/Library/entities"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "migrations")
+	if err != nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func newTestDatabase(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(tempDir(t), "test.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+type firstTable struct {
+	ID uint
+}
+
+type secondTable struct {
+	ID uint
+}
+
+func createTable(table interface{}) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error { return tx.Migrator().CreateTable(table) }
+}
+
+func dropTable(table interface{}) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error { return tx.Migrator().DropTable(table) }
+}
+
+var testMigrations = []Migration{
+	{Version: 1, Name: "first", Up: createTable(&firstTable{}), Down: dropTable(&firstTable{})},
+	{Version: 2, Name: "second", Up: createTable(&secondTable{}), Down: dropTable(&secondTable{})},
+}
+
+func versions(migrations []Migration) []uint64 {
+	var versions []uint64
+	for _, migration := range migrations {
+		versions = append(versions, migration.Version)
+	}
+	return versions
+}
+
+func Test_Migrator(t *testing.T) {
+	db := newTestDatabase(t)
+	migrator := NewMigrator(db, testMigrations)
+
+	err := migrator.Check()
+	assert.True(t, errors.Is(err, ErrSchemaBehind))
+	assert.Equal(t, "the database schema is behind: 2 pending migrations, the first is 1 first", err.Error())
+	statuses, err := migrator.Status()
+	assert.Nil(t, err)
+	assert.Len(t, statuses, 2)
+	assert.Nil(t, statuses[0].AppliedAt)
+	assert.Nil(t, statuses[1].AppliedAt)
+
+	applied, err := migrator.Up()
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{1, 2}, versions(applied))
+	assert.True(t, db.Migrator().HasTable(&firstTable{}))
+	assert.True(t, db.Migrator().HasTable(&secondTable{}))
+	assert.Nil(t, migrator.Check())
+	statuses, err = migrator.Status()
+	assert.Nil(t, err)
+	assert.NotNil(t, statuses[0].AppliedAt)
+	assert.NotNil(t, statuses[1].AppliedAt)
+
+	applied, err = migrator.Up()
+	assert.Nil(t, err)
+	assert.Empty(t, applied)
+
+	reverted, err := migrator.Down(1)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{2}, versions(reverted))
+	assert.True(t, db.Migrator().HasTable(&firstTable{}))
+	assert.False(t, db.Migrator().HasTable(&secondTable{}))
+	assert.True(t, errors.Is(migrator.Check(), ErrSchemaBehind))
+
+	reverted, err = migrator.Down(5)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{1}, versions(reverted))
+	assert.False(t, db.Migrator().HasTable(&firstTable{}))
+}
+
+func Test_Migrator_Up_Failure(t *testing.T) {
+	db := newTestDatabase(t)
+	failing := Migration{
+		Version: 3,
+		Name:    "failing",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().CreateTable(&secondTable{}); err != nil {
+				return err
+			}
+			return errors.New("failure")
+		},
+		Down: dropTable(&secondTable{}),
+	}
+	migrator := NewMigrator(db, []Migration{testMigrations[0], failing})
+
+	applied, err := migrator.Up()
+	assert.Equal(t, "unable to apply migration 3 failing: failure", err.Error())
+	assert.Equal(t, []uint64{1}, versions(applied))
+	assert.True(t, db.Migrator().HasTable(&firstTable{}))
+	assert.False(t, db.Migrator().HasTable(&secondTable{}))
+	statuses, err := migrator.Status()
+	assert.Nil(t, err)
+	assert.NotNil(t, statuses[0].AppliedAt)
+	assert.Nil(t, statuses[1].AppliedAt)
+}
+
+func Test_Migrator_Down_Unknown(t *testing.T) {
+	db := newTestDatabase(t)
+	_, err := NewMigrator(db, testMigrations).Up()
+	assert.Nil(t, err)
+
+	reverted, err := NewMigrator(db, testMigrations[:1]).Down(1)
+	assert.Equal(t, "migration 2 second is applied but unknown to this build", err.Error())
+	assert.Empty(t, reverted)
+}
+
+func Test_All(t *testing.T) {
+	all := All()
+	for i := 1; i < len(all); i++ {
+		assert.Less(t, all[i-1].Version, all[i].Version)
+	}
+}
+
+// The migrations have to bring the database to the schema of the entities
+func Test_All_MatchEntities(t *testing.T) {
+	db := newTestDatabase(t)
+	migrator := NewMigrator(db, All())
+	_, err := migrator.Up()
+	assert.Nil(t, err)
+
+	tables := []interface{}{&entities.Book{}, &entities.User{}, &entities.Auth{}, &entities.RefreshToken{}, &entities.Item{}, &entities.Loan{}, &entities.Renewal{}, &entities.Hold{}, &entities.LedgerEntry{}, &entities.PatronCategory{}, &entities.Role{}, &entities.OneTimeToken{}, &entities.LoginAttempt{}, &entities.AuditEvent{}}
+	for _, table := range tables {
+		statement := &gorm.Statement{DB: db}
+		assert.Nil(t, statement.Parse(table))
+		assert.True(t, db.Migrator().HasTable(table), statement.Schema.Table)
+		for _, field := range statement.Schema.Fields {
+			if field.DBName != "" {
+				assert.True(t, db.Migrator().HasColumn(table, field.DBName), statement.Schema.Table+"."+field.DBName)
+			}
+		}
+	}
+	var roles []entities.Role
+	assert.Nil(t, db.Find(&roles).Error)
+	assert.Len(t, roles, len(seedRoles))
+	var categories []entities.PatronCategory
+	assert.Nil(t, db.Find(&categories).Error)
+	assert.Len(t, categories, len(seedCategories))
+
+	_, err = migrator.Down(len(All()))
+	assert.Nil(t, err)
+	for _, table := range tables {
+		assert.False(t, db.Migrator().HasTable(table))
+	}
+}
+
+// A database of a release before the migrations still has the join tables of the loans,
+// counts the copies of the books, has no verified users and keeps the deleted users
+func Test_Baseline_LegacyDatabase(t *testing.T) {
+	db := newTestDatabase(t)
+	//the tables as the AutoMigrate of the last release before the migrations created them
+	legacy := []string{
+		"CREATE TABLE `books` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`isbn` varchar(32) UNIQUE,`title` varchar(256),`author` varchar(100),`available_units` integer,PRIMARY KEY (`id`))",
+		"CREATE TABLE `users` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`email` varchar(100) UNIQUE,`password` text,`role` text NOT NULL,PRIMARY KEY (`id`))",
+		"CREATE TABLE `user_taken` (`user_id` integer,`book_id` integer,PRIMARY KEY (`user_id`,`book_id`))",
+		"CREATE TABLE `user_returned` (`user_id` integer,`book_id` integer,PRIMARY KEY (`user_id`,`book_id`))",
+		"INSERT INTO `books` (`id`, `isbn`, `title`, `author`, `available_units`) VALUES (1, '9780441013593', 'Dune', 'Frank Herbert', 2)",
+		"INSERT INTO `users` (`id`, `email`, `password`, `role`) VALUES (1, 'reader@example.com', 'hash', 'User')",
+		"INSERT INTO `users` (`id`, `deleted_at`, `email`, `password`, `role`) VALUES (2, '2020-01-01 00:00:00', 'deleted@example.com', 'hash', 'User')",
+		"INSERT INTO `user_taken` (`user_id`, `book_id`) VALUES (1, 1)",
+		"INSERT INTO `user_returned` (`user_id`, `book_id`) VALUES (1, 1)",
+	}
+	for _, statement := range legacy {
+		assert.Nil(t, db.Exec(statement).Error)
+	}
+
+	_, err := NewMigrator(db, All()).Up()
+	assert.Nil(t, err)
+
+	assert.False(t, db.Migrator().HasTable("user_taken"))
+	assert.False(t, db.Migrator().HasTable("user_returned"))
+	var user entities.User
+	assert.Nil(t, db.First(&user, 1).Error)
+	assert.True(t, user.Verified)
+	assert.True(t, errors.Is(db.Unscoped().First(&entities.User{}, 2).Error, gorm.ErrRecordNotFound))
+	var loans []entities.Loan
+	assert.Nil(t, db.Order("id").Find(&loans).Error)
+	assert.Len(t, loans, 2)
+	assert.NotNil(t, loans[0].ReturnedAt)
+	assert.Nil(t, loans[0].ItemID)
+	assert.Nil(t, loans[1].ReturnedAt)
+	assert.NotNil(t, loans[1].ItemID)
+	var items []entities.Item
+	assert.Nil(t, db.Order("id").Find(&items).Error)
+	statuses := []string{}
+	for _, item := range items {
+		statuses = append(statuses, item.Status)
+		assert.True(t, strings.HasPrefix(item.Barcode, "9780441013593-"))
+	}
+	assert.Equal(t, []string{entities.ItemAvailable, entities.ItemAvailable, entities.ItemOnLoan}, statuses)
+}
+
+// A legacy loan which cannot be moved fails the baseline, nothing of it is applied and the
+// library keeps refusing to start
+func Test_Baseline_LegacyLoans_Failure(t *testing.T) {
+	db := newTestDatabase(t)
+	legacy := []string{
+		"CREATE TABLE `books` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`isbn` varchar(32) UNIQUE,`title` varchar(256),`author` varchar(100),`available_units` integer,PRIMARY KEY (`id`))",
+		"CREATE TABLE `users` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`email` varchar(100) UNIQUE,`password` text,`role` text NOT NULL,PRIMARY KEY (`id`))",
+		"CREATE TABLE `user_returned` (`user_id` integer,`book_id` integer,PRIMARY KEY (`user_id`,`book_id`))",
+		//a join table without its book column cannot be read
+		"CREATE TABLE `user_taken` (`user_id` integer,PRIMARY KEY (`user_id`))",
+		"INSERT INTO `user_returned` (`user_id`, `book_id`) VALUES (1, 1)",
+		"INSERT INTO `user_taken` (`user_id`) VALUES (1)",
+	}
+	for _, statement := range legacy {
+		assert.Nil(t, db.Exec(statement).Error)
+	}
+	migrator := NewMigrator(db, All())
+
+	applied, err := migrator.Up()
+
+	assert.Empty(t, applied)
+	assert.True(t, strings.HasPrefix(err.Error(), "unable to apply migration 20261018120000 baseline: unable to read user_taken"))
+	assert.True(t, db.Migrator().HasTable("user_returned"))
+	assert.True(t, db.Migrator().HasTable("user_taken"))
+	assert.False(t, db.Migrator().HasTable("loans"))
+	assert.True(t, errors.Is(migrator.Check(), ErrSchemaBehind))
+}
+
+// The catalogue gets an FTS5 index with the books it has when SQLite is built with FTS5
+// (go test -tags sqlite_fts5), and no index otherwise
+func Test_BooksFTS(t *testing.T) {
+	db := newTestDatabase(t)
+	assert.Nil(t, db.Exec("CREATE TABLE `books` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`isbn` varchar(32) UNIQUE,`title` varchar(256),`author` varchar(100),`available_units` integer,PRIMARY KEY (`id`))").Error)
+	assert.Nil(t, db.Exec("INSERT INTO `books` (`id`, `isbn`, `title`, `author`, `available_units`) VALUES (1, '9780441013593', 'Dune', 'Frank Herbert', 0)").Error)
+	migrator := NewMigrator(db, before(20261018140001))
+
+	_, err := migrator.Up()
+
+	assert.Nil(t, err)
+	available := FullTextSearchAvailable(db)
+	assert.Equal(t, available, db.Migrator().HasTable("books_fts"))
+	if available {
+		var indexed int64
+		assert.Nil(t, db.Raw("SELECT COUNT(*) FROM books_fts WHERE books_fts MATCH 'dune'").Scan(&indexed).Error)
+		assert.Equal(t, int64(1), indexed)
+	} else {
+		t.Log("sqlite is built without FTS5, the catalogue has no index")
+	}
+
+	_, err = migrator.Down(1)
+	assert.Nil(t, err)
+	assert.False(t, db.Migrator().HasTable("books_fts"))
+}
+
+// before returns the migrations before the version
+func before(version uint64) []Migration {
+	var migrations []Migration
+	for _, migration := range All() {
+		if migration.Version < version {
+			migrations = append(migrations, migration)
+		}
+	}
+	return migrations
+}
+
+// upTo applies the migrations before the version
+func upTo(t *testing.T, db *gorm.DB, version uint64) {
+	_, err := NewMigrator(db, before(version)).Up()
+	assert.Nil(t, err)
+}
+
+// Books stored under other forms of the same ISBN are merged into the first of them
+func Test_NormalizeIsbns(t *testing.T) {
+	db := newTestDatabase(t)
+	upTo(t, db, 20261018150000)
+	now := time.Now()
+	seed := []interface{}{
+		&baselineUser{Email: "first@example.com", Role: "User"},
+		&baselineUser{Email: "second@example.com", Role: "User"},
+		&baselineBook{Isbn: "0-441-01359-7", Title: "Dune", Author: "Frank Herbert", AvailableUnits: 1},
+		&baselineBook{Isbn: "9780441013593", Title: "Dune", Author: "Frank Herbert", AvailableUnits: 1},
+		&baselineBook{Isbn: "978-0-306-40615-7", Title: "Physics", Author: "Unknown"},
+		&baselineItem{Barcode: "1", BookID: 1, Condition: baselineConditionGood, Status: baselineItemAvailable},
+		&baselineItem{Barcode: "2", BookID: 2, Condition: baselineConditionGood, Status: baselineItemAvailable},
+		&baselineLoan{UserID: 1, BookID: 2, BorrowedAt: now, DueAt: now},
+		&baselineHold{UserID: 1, BookID: 1, Position: 1, Status: normalizeHoldWaiting},
+		&baselineHold{UserID: 2, BookID: 2, Position: 1, Status: normalizeHoldWaiting},
+		&baselineHold{UserID: 1, BookID: 2, Position: 2, Status: normalizeHoldWaiting},
+	}
+	for _, row := range seed {
+		assert.Nil(t, db.Create(row).Error)
+	}
+	indexed := db.Migrator().HasTable("books_fts")
+	if indexed {
+		assert.Nil(t, db.Exec("INSERT INTO books_fts (rowid, title, author) SELECT id, title, author FROM books").Error)
+	}
+
+	_, err := NewMigrator(db, All()).Up()
+
+	assert.Nil(t, err)
+	var books []baselineBook
+	assert.Nil(t, db.Unscoped().Order("id").Find(&books).Error)
+	assert.Len(t, books, 2)
+	assert.Equal(t, "9780441013593", books[0].Isbn)
+	assert.Equal(t, uint(2), books[0].AvailableUnits)
+	assert.Equal(t, "9780306406157", books[1].Isbn)
+	var items int64
+	assert.Nil(t, db.Model(&baselineItem{}).Where("book_id = ?", 1).Count(&items).Error)
+	assert.Equal(t, int64(2), items)
+	var loan baselineLoan
+	assert.Nil(t, db.First(&loan).Error)
+	assert.Equal(t, uint(1), loan.BookID)
+	var holds []baselineHold
+	assert.Nil(t, db.Order("id").Find(&holds).Error)
+	assert.Len(t, holds, 3)
+	for _, hold := range holds {
+		assert.Equal(t, uint(1), hold.BookID)
+	}
+	assert.Equal(t, []uint{1, 2, 3}, []uint{holds[0].Position, holds[1].Position, holds[2].Position})
+	assert.Equal(t, []string{normalizeHoldWaiting, normalizeHoldWaiting, normalizeHoldCancelled}, []string{holds[0].Status, holds[1].Status, holds[2].Status})
+	if indexed {
+		var rows []uint
+		assert.Nil(t, db.Raw("SELECT rowid FROM books_fts ORDER BY rowid").Scan(&rows).Error)
+		assert.Equal(t, []uint{1, 3}, rows)
+	}
+}
+
+// Books whose ISBN cannot be normalized are listed and nothing is changed
+func Test_NormalizeIsbns_Invalid(t *testing.T) {
+	db := newTestDatabase(t)
+	upTo(t, db, 20261018150000)
+	assert.Nil(t, db.Create(&baselineBook{Isbn: "0-441-01359-7", Title: "Dune"}).Error)
+	assert.Nil(t, db.Create(&baselineBook{Isbn: "12435", Title: "Legacy"}).Error)
+	migrator := NewMigrator(db, All())
+
+	_, err := migrator.Up()
+
+	assert.Equal(t, `unable to apply migration 20261018150000 normalize_isbns: the ISBNs of these books cannot be normalized, correct them and migrate again: 2 "12435"`, err.Error())
+	assert.True(t, errors.Is(migrator.Check(), ErrSchemaBehind))
+	var book baselineBook
+	assert.Nil(t, db.First(&book, 1).Error)
+	assert.Equal(t, "0-441-01359-7", book.Isbn)
+}
+
+func Test_Create(t *testing.T) {
+	dir := tempDir(t)
+	now := time.Date(2026, 10, 18, 14, 30, 5, 0, time.UTC)
+
+	path, err := Create(dir, "add_book_language", now)
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "20261018143005_add_book_language.go"), path)
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), "Version: 20261018143005,")
+	assert.Contains(t, string(content), `Name:    "add_book_language",`)
+
+	_, err = Create(dir, "add_book_language", now)
+	assert.NotNil(t, err)
+
+	for _, name := range []string{"", "AddLanguage", "add-language", "1_language", "../language"} {
+		_, err := Create(dir, name, now)
+		assert.Equal(t, ErrInvalidName, err, name)
+	}
+}
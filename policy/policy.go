@@ -0,0 +1,62 @@
+package policy
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/mishozz/Library/auth"
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+)
+
+var (
+	// ErrUnauthenticated is returned when the caller cannot be resolved from the request
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrForbidden is returned when the caller is not allowed to act on behalf of the user
+	ErrForbidden = errors.New("forbidden")
+)
+
+// OwnershipPolicy decides whether the caller of a request may act on behalf of a user
+type OwnershipPolicy interface {
+	AuthorizeLoan(r *http.Request, owner entities.User) error
+	AuthorizeProfile(r *http.Request, owner entities.User) error
+}
+
+type ownershipPolicy struct {
+	signer         *auth.Signer
+	roleRepository repositories.RoleRepository
+}
+
+// NewOwnershipPolicy creates a policy which allows patrons to manage only their own loans
+// and see only their own record. The roles with the loans:manage permission manage the
+// loans of any patron and the roles with users:read see any record.
+func NewOwnershipPolicy(signer *auth.Signer, roleRepository repositories.RoleRepository) *ownershipPolicy {
+	return &ownershipPolicy{
+		signer:         signer,
+		roleRepository: roleRepository,
+	}
+}
+
+func (p *ownershipPolicy) AuthorizeLoan(r *http.Request, owner entities.User) error {
+	return p.authorize(r, owner, entities.PermissionLoansManage)
+}
+
+func (p *ownershipPolicy) AuthorizeProfile(r *http.Request, owner entities.User) error {
+	return p.authorize(r, owner, entities.PermissionUsersRead)
+}
+
+// authorize lets through the owner and the callers whose role grants the permission
+func (p *ownershipPolicy) authorize(r *http.Request, owner entities.User, permission string) error {
+	caller, err := p.signer.ExtractTokenAuth(r)
+	if err != nil || caller == nil {
+		return ErrUnauthenticated
+	}
+	if caller.UserId == uint64(owner.ID) {
+		return nil
+	}
+	role, err := p.roleRepository.Find(r.Context(), caller.Role)
+	if err != nil || !role.Can(permission) {
+		return ErrForbidden
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mishozz/Library/auth"
+	"github.com/mishozz/Library/entities"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+// testRoles are the roles the seed migration creates
+var testRoles = []entities.Role{
+	{Name: entities.RoleAdmin, Permissions: entities.AllPermissions},
+	{Name: entities.RoleLibrarian, Permissions: entities.Permissions{
+		entities.PermissionBooksRead,
+		entities.PermissionBooksWrite,
+		entities.PermissionItemsManage,
+		entities.PermissionHoldsManage,
+		entities.PermissionLoansManage,
+		entities.PermissionFinesManage,
+		entities.PermissionUsersRead,
+	}},
+	{Name: entities.RoleUser, Permissions: entities.Permissions{entities.PermissionBooksRead}},
+}
+
+var testSigner = auth.NewSigner("secret", time.Minute)
+
+type mockRoleRepository struct {
+	mock.Mock
+}
+
+func (m *mockRoleRepository) Find(ctx context.Context, name string) (entities.Role, error) {
+	args := m.Called(ctx, name)
+	return args.Get(0).(entities.Role), args.Error(1)
+}
+
+func (m *mockRoleRepository) FindAll(ctx context.Context) ([]entities.Role, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]entities.Role), args.Error(1)
+}
+
+func (m *mockRoleRepository) Save(ctx context.Context, role entities.Role) error {
+	args := m.Called(ctx, role)
+	return args.Error(0)
+}
+
+func (m *mockRoleRepository) Delete(ctx context.Context, name string) error {
+	args := m.Called(ctx, name)
+	return args.Error(0)
+}
+
+func Test_OwnershipPolicy_AuthorizeLoan(t *testing.T) {
+	owner := entities.User{Model: gorm.Model{ID: 1}, Email: "owner"}
+
+	tests := []struct {
+		name   string
+		caller *auth.AuthDetails
+		err    error
+	}{{
+		name:   "owner",
+		caller: &auth.AuthDetails{AuthUuid: "uuid", UserId: 1, Role: "User"},
+		err:    nil,
+	}, {
+		name:   "another patron",
+		caller: &auth.AuthDetails{AuthUuid: "uuid", UserId: 2, Role: "User"},
+		err:    ErrForbidden,
+	}, {
+		name:   "admin on behalf of a patron",
+		caller: &auth.AuthDetails{AuthUuid: "uuid", UserId: 2, Role: entities.RoleAdmin},
+		err:    nil,
+	}, {
+		name:   "librarian on behalf of a patron",
+		caller: &auth.AuthDetails{AuthUuid: "uuid", UserId: 2, Role: entities.RoleLibrarian},
+		err:    nil,
+	}, {
+		name:   "anonymous",
+		caller: nil,
+		err:    ErrUnauthenticated,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			req := requestAs(t, http.MethodPost, "/users/owner/isbn", tt.caller)
+
+			err := NewOwnershipPolicy(testSigner, defaultRoles()).AuthorizeLoan(req, owner)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
+
+func Test_OwnershipPolicy_AuthorizeProfile(t *testing.T) {
+	owner := entities.User{Model: gorm.Model{ID: 1}, Email: "owner"}
+
+	tests := []struct {
+		name   string
+		caller *auth.AuthDetails
+		err    error
+	}{{
+		name:   "owner",
+		caller: &auth.AuthDetails{AuthUuid: "uuid", UserId: 1, Role: entities.RoleUser},
+		err:    nil,
+	}, {
+		name:   "another patron",
+		caller: &auth.AuthDetails{AuthUuid: "uuid", UserId: 2, Role: entities.RoleUser},
+		err:    ErrForbidden,
+	}, {
+		name:   "librarian",
+		caller: &auth.AuthDetails{AuthUuid: "uuid", UserId: 2, Role: entities.RoleLibrarian},
+		err:    nil,
+	}, {
+		name:   "anonymous",
+		caller: nil,
+		err:    ErrUnauthenticated,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			req := requestAs(t, http.MethodGet, "/users/owner", tt.caller)
+
+			err := NewOwnershipPolicy(testSigner, defaultRoles()).AuthorizeProfile(req, owner)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
+
+// requestAs creates a request with the token of the caller, an anonymous one without a caller
+func requestAs(t *testing.T, method string, url string, caller *auth.AuthDetails) *http.Request {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.FailNow()
+	}
+	if caller != nil {
+		token, err := testSigner.CreateToken(*caller)
+		if err != nil {
+			t.FailNow()
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
+	}
+	return req
+}
+
+func defaultRoles() *mockRoleRepository {
+	roles := &mockRoleRepository{}
+	for _, role := range testRoles {
+		roles.On("Find", mock.Anything, role.Name).Return(role, nil)
+	}
+	return roles
+}
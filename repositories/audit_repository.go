@@ -0,0 +1,43 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/mishozz/Library/config"
+	"github.com/mishozz/Library/entities"
+	"gorm.io/gorm"
+)
+
+type AuditRepository interface {
+	Record(ctx context.Context, event entities.AuditEvent) error
+	FindAll(ctx context.Context, page Page) ([]entities.AuditEvent, int64, error)
+}
+
+type auditRepository struct {
+	connection *gorm.DB
+}
+
+func NewAuditRepository(db config.Database) *auditRepository {
+	return &auditRepository{
+		connection: db.Connection,
+	}
+}
+
+func (r *auditRepository) Record(ctx context.Context, event entities.AuditEvent) error {
+	return r.connection.WithContext(ctx).Create(&event).Error
+}
+
+// FindAll returns a page of the audit log, the latest events first, and the number of events
+func (r *auditRepository) FindAll(ctx context.Context, page Page) ([]entities.AuditEvent, int64, error) {
+	var total int64
+	if err := r.connection.WithContext(ctx).Model(&entities.AuditEvent{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	db := r.connection.WithContext(ctx).Order("id DESC")
+	if page.Size > 0 {
+		db = db.Offset((page.Number - 1) * page.Size).Limit(page.Size)
+	}
+	var events []entities.AuditEvent
+	err := db.Find(&events).Error
+	return events, total, err
+}
@@ -1,6 +1,8 @@
 package repositories
 
 import (
+	"context"
+
 	"github.com/mishozz/Library/auth"
 	"github.com/mishozz/Library/config"
 	"github.com/mishozz/Library/entities"
@@ -9,9 +11,10 @@ import (
 )
 
 type AuthRepository interface {
-	FetchAuth(*auth.AuthDetails) (*entities.Auth, error)
-	DeleteAuth(*auth.AuthDetails) error
-	CreateAuth(uint64, string) (*entities.Auth, error)
+	FetchAuth(ctx context.Context, authD *auth.AuthDetails) (*entities.Auth, error)
+	DeleteAuth(ctx context.Context, authD *auth.AuthDetails) error
+	CreateAuth(ctx context.Context, userId uint64, userRole string) (*entities.Auth, error)
+	DeleteByUser(ctx context.Context, userId uint64) error
 }
 
 type authRepository struct {
@@ -24,9 +27,9 @@ func NewAuthRepository(db config.Database) *authRepository {
 	}
 }
 
-func (s *authRepository) FetchAuth(authD *auth.AuthDetails) (*entities.Auth, error) {
+func (s *authRepository) FetchAuth(ctx context.Context, authD *auth.AuthDetails) (*entities.Auth, error) {
 	au := &entities.Auth{}
-	err := s.connection.Debug().Where("user_id = ? AND auth_uuid = ?", authD.UserId, authD.AuthUuid).Take(&au).Error
+	err := s.connection.WithContext(ctx).Where("user_id = ? AND auth_uuid = ?", authD.UserId, authD.AuthUuid).Take(&au).Error
 	if err != nil {
 		return nil, err
 	}
@@ -34,9 +37,9 @@ func (s *authRepository) FetchAuth(authD *auth.AuthDetails) (*entities.Auth, err
 }
 
 //Once a user row in the auth table
-func (s *authRepository) DeleteAuth(authD *auth.AuthDetails) error {
+func (s *authRepository) DeleteAuth(ctx context.Context, authD *auth.AuthDetails) error {
 	au := &entities.Auth{}
-	db := s.connection.Debug().Where("user_id = ? AND auth_uuid = ?", authD.UserId, authD.AuthUuid).Take(&au).Delete(&au)
+	db := s.connection.WithContext(ctx).Where("user_id = ? AND auth_uuid = ?", authD.UserId, authD.AuthUuid).Take(&au).Delete(&au)
 	if db.Error != nil {
 		return db.Error
 	}
@@ -44,14 +47,19 @@ func (s *authRepository) DeleteAuth(authD *auth.AuthDetails) error {
 }
 
 //Once the user signup/login, create a row in the auth table, with a new uuid
-func (s *authRepository) CreateAuth(userId uint64, userRole string) (*entities.Auth, error) {
+func (s *authRepository) CreateAuth(ctx context.Context, userId uint64, userRole string) (*entities.Auth, error) {
 	au := &entities.Auth{}
 	au.AuthUUID = uuid.NewV4().String() //generate a new UUID each time
 	au.Role = userRole
 	au.UserID = userId
-	err := s.connection.Debug().Create(&au).Error
+	err := s.connection.WithContext(ctx).Create(&au).Error
 	if err != nil {
 		return nil, err
 	}
 	return au, nil
 }
+
+// DeleteByUser ends every session of the user
+func (s *authRepository) DeleteByUser(ctx context.Context, userId uint64) error {
+	return s.connection.WithContext(ctx).Where("user_id = ?", userId).Delete(&entities.Auth{}).Error
+}
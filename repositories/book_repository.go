@@ -1,85 +1,183 @@
 package repositories
 
 import (
+	"context"
+	"errors"
+
 	"github.com/mishozz/Library/config"
 	"github.com/mishozz/Library/entities"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrNoAvailableUnits is returned when no copy of a book is left on the shelf
+	ErrNoAvailableUnits = errors.New("no available units")
+	// ErrBookHasLoans is returned when a book which was lent is deleted
+	ErrBookHasLoans = errors.New("book has loans")
 )
 
 type BookRepository interface {
-	Save(book entities.Book) error
-	Delete(isbn string) error
-	FindAll() ([]entities.Book, error)
-	Find(isbn string) (entities.Book, error)
-	UpdateUnits(book entities.Book) error
-	IsBookTaken(isbn string) bool
+	Save(ctx context.Context, book entities.Book) error
+	Delete(ctx context.Context, isbn string) error
+	FindAll(ctx context.Context, query BookQuery) ([]entities.Book, int64, error)
+	Find(ctx context.Context, isbn string) (entities.Book, error)
+	Update(ctx context.Context, book entities.Book) error
+	IsBookTaken(ctx context.Context, isbn string) bool
+	Search(ctx context.Context, text string, page Page) ([]BookMatch, int64, error)
+	Lock(ctx context.Context, bookId uint) error
 }
 
 type BookRepositoryImpl struct {
 	connection *gorm.DB
+	index      bookIndex
 }
 
 func NewBookRepository(db config.Database) *BookRepositoryImpl {
 	return &BookRepositoryImpl{
 		connection: db.Connection,
+		index:      newBookIndex(db.FullTextSearch),
 	}
 }
 
-func (b *BookRepositoryImpl) Save(book entities.Book) error {
-	err := b.connection.Create(&book).Error
-	if err != nil {
-		return err
-	}
-	return nil
+// Save adds the book to the catalogue without copies, they are added as items
+func (b *BookRepositoryImpl) Save(ctx context.Context, book entities.Book) error {
+	book.AvailableUnits = 0
+	return b.connection.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&book).Error; err != nil {
+			return err
+		}
+		return b.index.add(tx, book)
+	})
 }
 
-func (b *BookRepositoryImpl) Delete(isbn string) error {
-	var book entities.Book
-	b.connection.Where("Isbn = ?", isbn).First(&book)
-
-	if err := b.connection.Model(&book).Association("UserReturned").Delete(&book); err != nil {
-		return err
-	}
-	if err := b.connection.Unscoped().Delete(&book).Error; err != nil {
-		return err
-	}
-	return nil
+// Delete removes a book which was never lent, with its copies and holds. A book with loans
+// is kept, the loans are the history of the patrons who borrowed it and the renewals and
+// fines of the loans refer to them.
+func (b *BookRepositoryImpl) Delete(ctx context.Context, isbn string) error {
+	return b.connection.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var book entities.Book
+		if err := tx.Where("isbn = ?", isbn).First(&book).Error; err != nil {
+			return err
+		}
+		//checkouts lock the book too when they recount its shelf, so no loan is added meanwhile
+		if err := (&BookRepositoryImpl{connection: tx}).Lock(ctx, book.ID); err != nil {
+			return err
+		}
+		var loans int64
+		if err := tx.Unscoped().Model(&entities.Loan{}).Where("book_id = ?", book.ID).Count(&loans).Error; err != nil {
+			return err
+		}
+		if loans > 0 {
+			return ErrBookHasLoans
+		}
+		if err := tx.Where("book_id = ?", book.ID).Delete(&entities.Hold{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("book_id = ?", book.ID).Delete(&entities.Item{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Delete(&book).Error; err != nil {
+			return err
+		}
+		return b.index.remove(tx, book.ID)
+	})
 }
 
-func (b *BookRepositoryImpl) IsBookTaken(isbn string) bool {
+func (b *BookRepositoryImpl) IsBookTaken(ctx context.Context, isbn string) bool {
 	var book entities.Book
-	b.connection.Where("Isbn = ?", isbn).First(&book)
+	b.connection.WithContext(ctx).Where("isbn = ?", isbn).First(&book)
 
-	var users []entities.User
-	b.connection.Model(&book).Association("UserTaken").Find(&users)
-	if len(users) != 0 {
-		return true
-	}
+	var loans int64
+	b.connection.WithContext(ctx).Model(&entities.Loan{}).Where("book_id = ? AND returned_at IS NULL", book.ID).Count(&loans)
+	return loans != 0
+}
 
-	return false
+var bookSortColumns = map[string]string{
+	"isbn":      "isbn",
+	"title":     "title",
+	"author":    "author",
+	"available": "available_units",
 }
 
-func (b *BookRepositoryImpl) FindAll() ([]entities.Book, error) {
+// FindAll returns the page of the books matching the query and the number of all matching books
+func (b *BookRepositoryImpl) FindAll(ctx context.Context, query BookQuery) ([]entities.Book, int64, error) {
+	if err := validateSort(query.Sort, bookSortColumns); err != nil {
+		return nil, 0, err
+	}
+	filter := func(db *gorm.DB) *gorm.DB {
+		if query.Author != "" {
+			db = db.Where(containsText("author"), likeContains(query.Author))
+		}
+		if query.Title != "" {
+			db = db.Where(containsText("title"), likeContains(query.Title))
+		}
+		if query.Available != nil && *query.Available {
+			db = db.Where("available_units > 0")
+		}
+		if query.Available != nil && !*query.Available {
+			db = db.Where("available_units = 0")
+		}
+		return db
+	}
+
+	var total int64
+	err := b.connection.WithContext(ctx).Model(&entities.Book{}).Scopes(filter).Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
 	var books []entities.Book
-	err := b.connection.Set("gorm:auto_preload", true).Find(&books).Error
+	err = b.connection.WithContext(ctx).Scopes(filter, paginate(query.Sort, bookSortColumns, query.Page)).Find(&books).Error
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	return books, nil
+	return books, total, nil
 }
 
-func (b *BookRepositoryImpl) Find(isbn string) (entities.Book, error) {
+func (b *BookRepositoryImpl) Find(ctx context.Context, isbn string) (entities.Book, error) {
 	var book entities.Book
-	err := b.connection.Where("Isbn = ?", isbn).First(&book).Error
+	err := b.connection.WithContext(ctx).Where("isbn = ?", isbn).First(&book).Error
 	if err != nil {
 		return book, err
 	}
 	return book, nil
 }
-func (b *BookRepositoryImpl) UpdateUnits(book entities.Book) error {
-	err := b.connection.Model(&book).Update("available_units", book.AvailableUnits).Error
+
+// Update saves the details of the book. The available units follow the statuses of its items.
+func (b *BookRepositoryImpl) Update(ctx context.Context, book entities.Book) error {
+	err := b.connection.WithContext(ctx).Model(&entities.Book{}).
+		Where("id = ?", book.ID).
+		Updates(map[string]interface{}{"isbn": book.Isbn, "title": book.Title, "author": book.Author}).Error
 	if err != nil {
 		return err
 	}
-	return nil
+	return b.index.update(b.connection.WithContext(ctx), book)
+}
+
+// Lock locks the book until the end of the transaction, so the transactions which read
+// and then change the hold queue of the book wait for each other. Recounting the shelf
+// locks the book as well.
+func (b *BookRepositoryImpl) Lock(ctx context.Context, bookId uint) error {
+	var book entities.Book
+	return b.connection.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id").
+		Take(&book, bookId).Error
+}
+
+// Search returns the page of the books whose title or author match every word of the text,
+// best matches first, and the number of all matching books
+func (b *BookRepositoryImpl) Search(ctx context.Context, text string, page Page) ([]BookMatch, int64, error) {
+	terms := searchTerms(text)
+	if len(terms) == 0 {
+		return nil, 0, ErrEmptySearch
+	}
+	matches, total, err := b.index.search(b.connection.WithContext(ctx), terms, page)
+	if err != nil {
+		return nil, 0, err
+	}
+	//raw queries do not run the hooks of the book
+	for i := range matches {
+		matches[i].AfterFind(b.connection)
+	}
+	return matches, total, nil
 }
@@ -0,0 +1,207 @@
+package repositories
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/mishozz/Library/entities"
+	"gorm.io/gorm"
+)
+
+// ErrEmptySearch is returned when the search text has no words to look for
+var ErrEmptySearch = errors.New("empty search")
+
+const (
+	highlightStart = "<mark>"
+	highlightEnd   = "</mark>"
+	//snippet() wraps the matches in control characters, which survive the escaping of the
+	//text and are then replaced by the tags
+	snippetStart = "\x02"
+	snippetEnd   = "\x03"
+)
+
+// BookMatch is a book found by a catalogue search. The snippets are the title and the
+// author, HTML escaped, with the matching words wrapped in <mark> tags.
+type BookMatch struct {
+	entities.Book `json:"Book"`
+	TitleSnippet  string `json:"TitleSnippet"`
+	AuthorSnippet string `json:"AuthorSnippet"`
+}
+
+// bookIndex keeps the catalogue search in sync with the books and runs the searches.
+// Every word of a search has to match the beginning of a word in the title or the author.
+type bookIndex interface {
+	add(db *gorm.DB, book entities.Book) error
+	update(db *gorm.DB, book entities.Book) error
+	remove(db *gorm.DB, bookId uint) error
+	search(db *gorm.DB, terms []string, page Page) ([]BookMatch, int64, error)
+}
+
+func newBookIndex(fullTextSearch bool) bookIndex {
+	if fullTextSearch {
+		return ftsBookIndex{}
+	}
+	return likeBookIndex{}
+}
+
+// searchTerms splits the search text into words, dropping punctuation
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func limitOffset(page Page) string {
+	if page.Size <= 0 {
+		return ""
+	}
+	return " LIMIT ? OFFSET ?"
+}
+
+func pageArgs(args []interface{}, page Page) []interface{} {
+	if page.Size <= 0 {
+		return args
+	}
+	return append(args, page.Size, (page.Number-1)*page.Size)
+}
+
+// ftsBookIndex searches the books_fts table created by the books_fts migration,
+// ranking the matches with bm25 and titles weighted twice as much as authors
+type ftsBookIndex struct{}
+
+func (ftsBookIndex) add(db *gorm.DB, book entities.Book) error {
+	return db.Exec("INSERT INTO books_fts (rowid, title, author) VALUES (?, ?, ?)", book.ID, book.Title, book.Author).Error
+}
+
+func (ftsBookIndex) update(db *gorm.DB, book entities.Book) error {
+	return db.Exec("UPDATE books_fts SET title = ?, author = ? WHERE rowid = ?", book.Title, book.Author, book.ID).Error
+}
+
+func (ftsBookIndex) remove(db *gorm.DB, bookId uint) error {
+	return db.Exec("DELETE FROM books_fts WHERE rowid = ?", bookId).Error
+}
+
+func (ftsBookIndex) search(db *gorm.DB, terms []string, page Page) ([]BookMatch, int64, error) {
+	//every term is quoted, so the text cannot use the FTS5 query syntax, and matched as a prefix
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"*`
+	}
+	match := strings.Join(quoted, " ")
+	from := " FROM books_fts JOIN books ON books.id = books_fts.rowid WHERE books_fts MATCH ? AND books.deleted_at IS NULL"
+
+	var total int64
+	err := db.Raw("SELECT COUNT(*)"+from, match).Scan(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	var matches []BookMatch
+	err = db.Raw("SELECT books.*,"+
+		" snippet(books_fts, 0, ?, ?, '…', 16) AS title_snippet,"+
+		" snippet(books_fts, 1, ?, ?, '…', 16) AS author_snippet"+
+		from+" ORDER BY bm25(books_fts, 2.0, 1.0), books.id"+limitOffset(page),
+		pageArgs([]interface{}{snippetStart, snippetEnd, snippetStart, snippetEnd, match}, page)...).
+		Scan(&matches).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range matches {
+		matches[i].TitleSnippet = highlightSnippet(matches[i].TitleSnippet)
+		matches[i].AuthorSnippet = highlightSnippet(matches[i].AuthorSnippet)
+	}
+	return matches, total, nil
+}
+
+// highlightSnippet escapes the snippet of FTS5 and marks the matches it wrapped
+func highlightSnippet(snippet string) string {
+	return strings.NewReplacer(snippetStart, highlightStart, snippetEnd, highlightEnd).Replace(html.EscapeString(snippet))
+}
+
+// likeBookIndex needs no index of its own, it searches the books table with LIKE.
+// Books matching in the title come first, the rest are ordered by title.
+type likeBookIndex struct{}
+
+func (likeBookIndex) add(db *gorm.DB, book entities.Book) error {
+	return nil
+}
+
+func (likeBookIndex) update(db *gorm.DB, book entities.Book) error {
+	return nil
+}
+
+func (likeBookIndex) remove(db *gorm.DB, bookId uint) error {
+	return nil
+}
+
+func (likeBookIndex) search(db *gorm.DB, terms []string, page Page) ([]BookMatch, int64, error) {
+	var inTitle, inEither []string
+	var titleArgs, eitherArgs []interface{}
+	for _, term := range terms {
+		prefix, wordPrefix := strings.ToLower(term)+"%", "% "+strings.ToLower(term)+"%"
+		inTitle = append(inTitle, "(LOWER(title) LIKE ? OR LOWER(title) LIKE ?)")
+		titleArgs = append(titleArgs, prefix, wordPrefix)
+		inEither = append(inEither, "(LOWER(title) LIKE ? OR LOWER(title) LIKE ? OR LOWER(author) LIKE ? OR LOWER(author) LIKE ?)")
+		eitherArgs = append(eitherArgs, prefix, wordPrefix, prefix, wordPrefix)
+	}
+	from := " FROM books WHERE deleted_at IS NULL AND " + strings.Join(inEither, " AND ")
+
+	var total int64
+	err := db.Raw("SELECT COUNT(*)"+from, eitherArgs...).Scan(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	args := append(append([]interface{}{}, eitherArgs...), titleArgs...)
+	var matches []BookMatch
+	err = db.Raw("SELECT *"+from+
+		" ORDER BY CASE WHEN "+strings.Join(inTitle, " AND ")+" THEN 0 ELSE 1 END, title, id"+limitOffset(page),
+		pageArgs(args, page)...).
+		Scan(&matches).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range matches {
+		matches[i].TitleSnippet = highlightTerms(matches[i].Title, terms)
+		matches[i].AuthorSnippet = highlightTerms(matches[i].Author, terms)
+	}
+	return matches, total, nil
+}
+
+// highlightTerms escapes the text and marks its words which start with one of the terms
+func highlightTerms(text string, terms []string) string {
+	runes := []rune(text)
+	var b strings.Builder
+	for i := 0; i < len(runes); {
+		length := 0
+		if i == 0 || runes[i-1] == ' ' {
+			for _, term := range terms {
+				if n := len([]rune(term)); n > length && hasPrefixFold(runes[i:], []rune(term)) {
+					length = n
+				}
+			}
+		}
+		if length == 0 {
+			b.WriteString(html.EscapeString(string(runes[i])))
+			i++
+			continue
+		}
+		b.WriteString(highlightStart)
+		b.WriteString(html.EscapeString(string(runes[i : i+length])))
+		b.WriteString(highlightEnd)
+		i += length
+	}
+	return b.String()
+}
+
+func hasPrefixFold(text []rune, prefix []rune) bool {
+	if len(prefix) > len(text) {
+		return false
+	}
+	for i, r := range prefix {
+		if unicode.ToLower(text[i]) != unicode.ToLower(r) {
+			return false
+		}
+	}
+	return true
+}
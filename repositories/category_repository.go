@@ -0,0 +1,81 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mishozz/Library/config"
+	"github.com/mishozz/Library/entities"
+	"gorm.io/gorm"
+)
+
+// ErrCategoryExists is returned when a category is created with the name of another one
+var ErrCategoryExists = errors.New("category already exists")
+
+type CategoryRepository interface {
+	Find(ctx context.Context, name string) (entities.PatronCategory, error)
+	FindAll(ctx context.Context) ([]entities.PatronCategory, error)
+	Create(ctx context.Context, category entities.PatronCategory) error
+	Update(ctx context.Context, category entities.PatronCategory) error
+}
+
+type categoryRepository struct {
+	connection *gorm.DB
+}
+
+func NewCategoryRepository(db config.Database) *categoryRepository {
+	return &categoryRepository{
+		connection: db.Connection,
+	}
+}
+
+func (r *categoryRepository) Find(ctx context.Context, name string) (entities.PatronCategory, error) {
+	var category entities.PatronCategory
+	err := r.connection.WithContext(ctx).Where("name = ?", name).First(&category).Error
+	return category, err
+}
+
+func (r *categoryRepository) FindAll(ctx context.Context) ([]entities.PatronCategory, error) {
+	var categories []entities.PatronCategory
+	err := r.connection.WithContext(ctx).Order("name").Find(&categories).Error
+	if err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+// Create adds the category. A name which is taken, even by a category created at the same
+// time, is refused with ErrCategoryExists.
+func (r *categoryRepository) Create(ctx context.Context, category entities.PatronCategory) error {
+	_, err := r.Find(ctx, category.Name)
+	if err == nil {
+		return ErrCategoryExists
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	category.ID = 0
+	if err := r.connection.WithContext(ctx).Create(&category).Error; err != nil {
+		//the unique name refused the insert when another create took the name meanwhile
+		if _, findErr := r.Find(ctx, category.Name); findErr == nil {
+			return ErrCategoryExists
+		}
+		return err
+	}
+	return nil
+}
+
+// Update replaces the policy of the category with the given name
+func (r *categoryRepository) Update(ctx context.Context, category entities.PatronCategory) error {
+	db := r.connection.WithContext(ctx).Model(&entities.PatronCategory{}).
+		Where("name = ?", category.Name).
+		Select("loan_days", "max_loans", "max_renewals", "fine_rate", "max_holds").
+		Updates(category)
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
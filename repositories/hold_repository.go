@@ -0,0 +1,213 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mishozz/Library/config"
+	"github.com/mishozz/Library/entities"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrHoldNotFound is returned when there is no active hold to act on
+	ErrHoldNotFound = errors.New("hold not found")
+	// ErrHoldNotReady is returned when the user has no copy waiting for pickup
+	ErrHoldNotReady = errors.New("hold not ready")
+	// ErrNoWaitingHolds is returned when nobody is queued for the book
+	ErrNoWaitingHolds = errors.New("no waiting holds")
+)
+
+var activeHoldStatuses = []string{entities.HoldWaiting, entities.HoldReady}
+
+type HoldRepository interface {
+	Create(ctx context.Context, hold entities.Hold) (entities.Hold, error)
+	CountActive(ctx context.Context, userId uint, bookId uint) (int64, error)
+	CountByUser(ctx context.Context, userId uint) (int64, error)
+	FindByUser(ctx context.Context, userId uint) ([]entities.Hold, error)
+	FindQueue(ctx context.Context, bookId uint) ([]entities.Hold, error)
+	FindWaiting(ctx context.Context, bookId uint) ([]entities.Hold, error)
+	FindExpired(ctx context.Context, now time.Time) ([]entities.Hold, error)
+	Fulfil(ctx context.Context, userId uint, bookId uint, now time.Time) (entities.Hold, error)
+	Cancel(ctx context.Context, userId uint, bookId uint) (entities.Hold, error)
+	Expire(ctx context.Context, hold entities.Hold, now time.Time) error
+	AllocateNext(ctx context.Context, bookId uint, itemId uint, expiresAt time.Time) error
+	SetPosition(ctx context.Context, holdId uint, bookId uint, position uint) error
+}
+
+type holdRepository struct {
+	connection *gorm.DB
+}
+
+func NewHoldRepository(db config.Database) *holdRepository {
+	return &holdRepository{
+		connection: db.Connection,
+	}
+}
+
+// Create stores the hold at the end of the queue of its book. The caller must lock the
+// book first, otherwise concurrent holds could take the same position.
+func (r *holdRepository) Create(ctx context.Context, hold entities.Hold) (entities.Hold, error) {
+	var last uint
+	err := r.connection.WithContext(ctx).Model(&entities.Hold{}).
+		Select("COALESCE(MAX(position), 0)").
+		Where("book_id = ?", hold.BookID).
+		Scan(&last).Error
+	if err != nil {
+		return hold, err
+	}
+	hold.Status = entities.HoldWaiting
+	hold.Position = last + 1
+	if err := r.connection.WithContext(ctx).Create(&hold).Error; err != nil {
+		return hold, err
+	}
+	err = r.connection.WithContext(ctx).Preload("Book").First(&hold, hold.ID).Error
+	return hold, err
+}
+
+func (r *holdRepository) CountActive(ctx context.Context, userId uint, bookId uint) (int64, error) {
+	var count int64
+	err := r.connection.WithContext(ctx).Model(&entities.Hold{}).
+		Where("user_id = ? AND book_id = ? AND status IN ?", userId, bookId, activeHoldStatuses).
+		Count(&count).Error
+	return count, err
+}
+
+// CountByUser counts the active holds of the user on any book
+func (r *holdRepository) CountByUser(ctx context.Context, userId uint) (int64, error) {
+	var count int64
+	err := r.connection.WithContext(ctx).Model(&entities.Hold{}).
+		Where("user_id = ? AND status IN ?", userId, activeHoldStatuses).
+		Count(&count).Error
+	return count, err
+}
+
+func (r *holdRepository) FindByUser(ctx context.Context, userId uint) ([]entities.Hold, error) {
+	return r.find(r.connection.WithContext(ctx).Where("user_id = ? AND status IN ?", userId, activeHoldStatuses))
+}
+
+// FindQueue returns the active holds of the book, ready ones first and then in queue order
+func (r *holdRepository) FindQueue(ctx context.Context, bookId uint) ([]entities.Hold, error) {
+	return r.find(r.connection.WithContext(ctx).
+		Where("book_id = ? AND status IN ?", bookId, activeHoldStatuses).
+		Order("status = '" + entities.HoldWaiting + "'"))
+}
+
+func (r *holdRepository) FindWaiting(ctx context.Context, bookId uint) ([]entities.Hold, error) {
+	return r.find(r.connection.WithContext(ctx).Where("book_id = ? AND status = ?", bookId, entities.HoldWaiting))
+}
+
+func (r *holdRepository) FindExpired(ctx context.Context, now time.Time) ([]entities.Hold, error) {
+	return r.find(r.connection.WithContext(ctx).Where("status = ? AND expires_at <= ?", entities.HoldReady, now))
+}
+
+func (r *holdRepository) find(query *gorm.DB) ([]entities.Hold, error) {
+	var holds []entities.Hold
+	err := query.Preload("Book").Preload("User").Preload("Item").
+		Order("position, id").
+		Find(&holds).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range holds {
+		holds[i].Patron = holds[i].User.Email
+	}
+	return holds, nil
+}
+
+// Fulfil consumes the ready hold of the user when the patron picks up the copy in time.
+// It returns the hold with the item which was set aside.
+func (r *holdRepository) Fulfil(ctx context.Context, userId uint, bookId uint, now time.Time) (entities.Hold, error) {
+	db := r.connection.WithContext(ctx).Model(&entities.Hold{}).
+		Where("user_id = ? AND book_id = ? AND status = ? AND expires_at > ?", userId, bookId, entities.HoldReady, now).
+		Update("status", entities.HoldFulfilled)
+	if db.Error != nil {
+		return entities.Hold{}, db.Error
+	}
+	if db.RowsAffected == 0 {
+		return entities.Hold{}, ErrHoldNotReady
+	}
+	return r.lastChanged(ctx, userId, bookId, entities.HoldFulfilled)
+}
+
+// Cancel cancels the active hold of the user. The returned hold has an item when a copy was set aside for it.
+func (r *holdRepository) Cancel(ctx context.Context, userId uint, bookId uint) (entities.Hold, error) {
+	for _, status := range []string{entities.HoldReady, entities.HoldWaiting} {
+		db := r.connection.WithContext(ctx).Model(&entities.Hold{}).
+			Where("user_id = ? AND book_id = ? AND status = ?", userId, bookId, status).
+			Update("status", entities.HoldCancelled)
+		if db.Error != nil {
+			return entities.Hold{}, db.Error
+		}
+		if db.RowsAffected != 0 {
+			return r.lastChanged(ctx, userId, bookId, entities.HoldCancelled)
+		}
+	}
+	return entities.Hold{}, ErrHoldNotFound
+}
+
+// lastChanged reads back the hold which a conditional update has just moved to the status.
+// A user has one active hold per book, so it is the last one changed to that status.
+func (r *holdRepository) lastChanged(ctx context.Context, userId uint, bookId uint, status string) (entities.Hold, error) {
+	var hold entities.Hold
+	err := r.connection.WithContext(ctx).
+		Where("user_id = ? AND book_id = ? AND status = ?", userId, bookId, status).
+		Order("updated_at DESC, id DESC").
+		First(&hold).Error
+	return hold, err
+}
+
+// Expire closes a ready hold whose pickup window has passed. It fails with
+// ErrHoldNotFound when the hold was picked up or cancelled in the meantime.
+func (r *holdRepository) Expire(ctx context.Context, hold entities.Hold, now time.Time) error {
+	db := r.connection.WithContext(ctx).Model(&entities.Hold{}).
+		Where("id = ? AND status = ? AND expires_at <= ?", hold.ID, entities.HoldReady, now).
+		Update("status", entities.HoldExpired)
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrHoldNotFound
+	}
+	return nil
+}
+
+// AllocateNext sets the item aside for the first waiting hold of the book
+func (r *holdRepository) AllocateNext(ctx context.Context, bookId uint, itemId uint, expiresAt time.Time) error {
+	var next entities.Hold
+	err := r.connection.WithContext(ctx).Select("id").
+		Where("book_id = ? AND status = ?", bookId, entities.HoldWaiting).
+		Order("position, id").
+		Take(&next).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNoWaitingHolds
+	}
+	if err != nil {
+		return err
+	}
+	//a concurrent return may have allocated the hold in the meantime, so the update is conditional
+	db := r.connection.WithContext(ctx).Model(&entities.Hold{}).
+		Where("id = ? AND status = ?", next.ID, entities.HoldWaiting).
+		Updates(map[string]interface{}{"status": entities.HoldReady, "item_id": itemId, "expires_at": expiresAt})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrNoWaitingHolds
+	}
+	return nil
+}
+
+func (r *holdRepository) SetPosition(ctx context.Context, holdId uint, bookId uint, position uint) error {
+	db := r.connection.WithContext(ctx).Model(&entities.Hold{}).
+		Where("id = ? AND book_id = ? AND status = ?", holdId, bookId, entities.HoldWaiting).
+		Update("position", position)
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrHoldNotFound
+	}
+	return nil
+}
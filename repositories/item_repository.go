@@ -0,0 +1,128 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mishozz/Library/config"
+	"github.com/mishozz/Library/entities"
+	"gorm.io/gorm"
+)
+
+// ErrItemNotAvailable is returned when an item is not in the status an action expects,
+// e.g. a copy on loan is checked out or withdrawn
+var ErrItemNotAvailable = errors.New("item not available")
+
+// lendable matches the items which can be lent right away
+const lendable = "items.status = '" + entities.ItemAvailable + "' AND items.copy_condition <> '" + entities.ConditionDamaged + "'"
+
+type ItemRepository interface {
+	Create(ctx context.Context, item entities.Item) (entities.Item, error)
+	Find(ctx context.Context, barcode string) (entities.Item, error)
+	FindByID(ctx context.Context, id uint) (entities.Item, error)
+	FindByBook(ctx context.Context, bookId uint) ([]entities.Item, error)
+	Update(ctx context.Context, item entities.Item) error
+	Claim(ctx context.Context, bookId uint, status string) (entities.Item, error)
+	Transition(ctx context.Context, item entities.Item, from string, to string) error
+}
+
+type itemRepository struct {
+	connection *gorm.DB
+}
+
+func NewItemRepository(db config.Database) *itemRepository {
+	return &itemRepository{
+		connection: db.Connection,
+	}
+}
+
+// Create adds a copy to the shelf
+func (r *itemRepository) Create(ctx context.Context, item entities.Item) (entities.Item, error) {
+	item.Status = entities.ItemAvailable
+	if item.Condition == "" {
+		item.Condition = entities.ConditionGood
+	}
+	if err := r.connection.WithContext(ctx).Create(&item).Error; err != nil {
+		return item, err
+	}
+	return item, r.countAvailable(ctx, item.BookID)
+}
+
+func (r *itemRepository) Find(ctx context.Context, barcode string) (entities.Item, error) {
+	var item entities.Item
+	err := r.connection.WithContext(ctx).Preload("Book").Where("barcode = ?", barcode).First(&item).Error
+	return item, err
+}
+
+func (r *itemRepository) FindByID(ctx context.Context, id uint) (entities.Item, error) {
+	var item entities.Item
+	err := r.connection.WithContext(ctx).Preload("Book").First(&item, id).Error
+	return item, err
+}
+
+func (r *itemRepository) FindByBook(ctx context.Context, bookId uint) ([]entities.Item, error) {
+	var items []entities.Item
+	err := r.connection.WithContext(ctx).Where("book_id = ?", bookId).Order("barcode").Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Update saves the condition and the location of the item
+func (r *itemRepository) Update(ctx context.Context, item entities.Item) error {
+	err := r.connection.WithContext(ctx).Model(&entities.Item{}).
+		Where("id = ?", item.ID).
+		Updates(map[string]interface{}{"copy_condition": item.Condition, "location": item.Location}).Error
+	if err != nil {
+		return err
+	}
+	return r.countAvailable(ctx, item.BookID)
+}
+
+// Claim takes a lendable copy of the book off the shelf and gives it the status.
+// The update is conditional, so two concurrent requests never claim the same copy.
+func (r *itemRepository) Claim(ctx context.Context, bookId uint, status string) (entities.Item, error) {
+	//recounting the shelf locks the book before the shelf is read
+	if err := r.countAvailable(ctx, bookId); err != nil {
+		return entities.Item{}, err
+	}
+	var item entities.Item
+	err := r.connection.WithContext(ctx).Where("book_id = ? AND "+lendable, bookId).Order("id").First(&item).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return item, ErrNoAvailableUnits
+	}
+	if err != nil {
+		return item, err
+	}
+	err = r.Transition(ctx, item, entities.ItemAvailable, status)
+	if errors.Is(err, ErrItemNotAvailable) {
+		return item, ErrNoAvailableUnits
+	}
+	if err != nil {
+		return item, err
+	}
+	item.Status = status
+	return item, nil
+}
+
+// Transition moves the item from one status to another. It fails with ErrItemNotAvailable
+// when the item is not in the expected status anymore.
+func (r *itemRepository) Transition(ctx context.Context, item entities.Item, from string, to string) error {
+	db := r.connection.WithContext(ctx).Model(&entities.Item{}).
+		Where("id = ? AND status = ?", item.ID, from).
+		Update("status", to)
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrItemNotAvailable
+	}
+	return r.countAvailable(ctx, item.BookID)
+}
+
+// countAvailable recounts the lendable copies of the book on the shelf, so the
+// availability of the book always follows the statuses of its items
+func (r *itemRepository) countAvailable(ctx context.Context, bookId uint) error {
+	return r.connection.WithContext(ctx).Exec("UPDATE books SET available_units = (SELECT COUNT(*) FROM items WHERE items.book_id = books.id AND "+lendable+") WHERE id = ?", bookId).Error
+}
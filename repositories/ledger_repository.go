@@ -0,0 +1,56 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/mishozz/Library/config"
+	"github.com/mishozz/Library/entities"
+	"gorm.io/gorm"
+)
+
+// balance sums the ledger of a patron, charges count up and payments and waivers count down
+const balance = "COALESCE(SUM(CASE WHEN kind = '" + entities.LedgerCharge + "' THEN amount ELSE -amount END), 0)"
+
+type LedgerRepository interface {
+	Create(ctx context.Context, entry entities.LedgerEntry) (entities.LedgerEntry, error)
+	FindByUser(ctx context.Context, userId uint) ([]entities.LedgerEntry, error)
+	Balance(ctx context.Context, userId uint) (int64, error)
+}
+
+type ledgerRepository struct {
+	connection *gorm.DB
+}
+
+func NewLedgerRepository(db config.Database) *ledgerRepository {
+	return &ledgerRepository{
+		connection: db.Connection,
+	}
+}
+
+func (r *ledgerRepository) Create(ctx context.Context, entry entities.LedgerEntry) (entities.LedgerEntry, error) {
+	err := r.connection.WithContext(ctx).Create(&entry).Error
+	return entry, err
+}
+
+// FindByUser returns the ledger of the patron in the order it was written
+func (r *ledgerRepository) FindByUser(ctx context.Context, userId uint) ([]entities.LedgerEntry, error) {
+	var entries []entities.LedgerEntry
+	err := r.connection.WithContext(ctx).Preload("Loan").Preload("Loan.Book").
+		Where("user_id = ?", userId).
+		Order("created_at, id").
+		Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Balance is what the patron owes in cents
+func (r *ledgerRepository) Balance(ctx context.Context, userId uint) (int64, error) {
+	var total int64
+	err := r.connection.WithContext(ctx).Model(&entities.LedgerEntry{}).
+		Select(balance).
+		Where("user_id = ?", userId).
+		Scan(&total).Error
+	return total, err
+}
@@ -0,0 +1,156 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mishozz/Library/config"
+	"github.com/mishozz/Library/entities"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrBookAlreadyTaken is returned when the user already holds a copy of the book
+	ErrBookAlreadyTaken = errors.New("book already taken")
+	// ErrBookNotTaken is returned when the user does not hold a copy of the book
+	ErrBookNotTaken = errors.New("book not taken")
+	// ErrLoanOverdue is returned when an overdue loan is renewed
+	ErrLoanOverdue = errors.New("loan overdue")
+	// ErrRenewalLimit is returned when a loan was already renewed as many times as allowed
+	ErrRenewalLimit = errors.New("renewal limit reached")
+)
+
+type LoanRepository interface {
+	Create(ctx context.Context, loan entities.Loan) error
+	FindActive(ctx context.Context, userId uint, bookId uint) (entities.Loan, error)
+	CountActive(ctx context.Context, userId uint) (int64, error)
+	FindByUser(ctx context.Context, userId uint) ([]entities.Loan, error)
+	FindByUsers(ctx context.Context, userIds []uint) ([]entities.Loan, error)
+	Return(ctx context.Context, userId uint, bookId uint, returnedAt time.Time) (entities.Loan, error)
+	ReturnItem(ctx context.Context, userId uint, itemId uint, returnedAt time.Time) (entities.Loan, error)
+	Renew(ctx context.Context, userId uint, bookId uint, now time.Time, period time.Duration, maxRenewals uint) (entities.Loan, error)
+}
+
+type loanRepository struct {
+	connection *gorm.DB
+}
+
+func NewLoanRepository(db config.Database) *loanRepository {
+	return &loanRepository{
+		connection: db.Connection,
+	}
+}
+
+func (r *loanRepository) Create(ctx context.Context, loan entities.Loan) error {
+	return r.connection.WithContext(ctx).Create(&loan).Error
+}
+
+func (r *loanRepository) FindActive(ctx context.Context, userId uint, bookId uint) (entities.Loan, error) {
+	var loan entities.Loan
+	err := r.connection.WithContext(ctx).Preload("Book").Preload("Item").Preload("Renewals", orderRenewals).
+		Where("user_id = ? AND book_id = ? AND returned_at IS NULL", userId, bookId).
+		First(&loan).Error
+	return loan, err
+}
+
+// CountActive counts the books the user has at the moment
+func (r *loanRepository) CountActive(ctx context.Context, userId uint) (int64, error) {
+	var count int64
+	err := r.connection.WithContext(ctx).Model(&entities.Loan{}).
+		Where("user_id = ? AND returned_at IS NULL", userId).
+		Count(&count).Error
+	return count, err
+}
+
+func (r *loanRepository) FindByUser(ctx context.Context, userId uint) ([]entities.Loan, error) {
+	return r.FindByUsers(ctx, []uint{userId})
+}
+
+func (r *loanRepository) FindByUsers(ctx context.Context, userIds []uint) ([]entities.Loan, error) {
+	var loans []entities.Loan
+	err := r.connection.WithContext(ctx).Preload("Book").Preload("Item").Preload("Renewals", orderRenewals).
+		Where("user_id IN ?", userIds).
+		Order("borrowed_at, id").
+		Find(&loans).Error
+	if err != nil {
+		return nil, err
+	}
+	return loans, nil
+}
+
+// Return closes the active loan of the book with a conditional update, so a book cannot be
+// returned twice. It returns the closed loan with the item which was lent.
+func (r *loanRepository) Return(ctx context.Context, userId uint, bookId uint, returnedAt time.Time) (entities.Loan, error) {
+	return r.close(ctx, "user_id = ? AND book_id = ?", []interface{}{userId, bookId}, returnedAt)
+}
+
+// ReturnItem closes the active loan of the user on the item
+func (r *loanRepository) ReturnItem(ctx context.Context, userId uint, itemId uint, returnedAt time.Time) (entities.Loan, error) {
+	return r.close(ctx, "user_id = ? AND item_id = ?", []interface{}{userId, itemId}, returnedAt)
+}
+
+func (r *loanRepository) close(ctx context.Context, condition string, args []interface{}, returnedAt time.Time) (entities.Loan, error) {
+	//the loan is found again by its return time, which MySQL keeps to the millisecond
+	returnedAt = returnedAt.Truncate(time.Millisecond)
+	db := r.connection.WithContext(ctx).Model(&entities.Loan{}).
+		Where(condition, args...).
+		Where("returned_at IS NULL").
+		Update("returned_at", returnedAt)
+	if db.Error != nil {
+		return entities.Loan{}, db.Error
+	}
+	if db.RowsAffected == 0 {
+		return entities.Loan{}, ErrBookNotTaken
+	}
+	var loan entities.Loan
+	err := r.connection.WithContext(ctx).Preload("Book").Preload("Item").
+		Where(condition, args...).
+		Where("returned_at = ?", returnedAt).
+		First(&loan).Error
+	return loan, err
+}
+
+// Renew pushes the due date of the active loan of the book by the period and records the renewal.
+// The renewal is counted by a conditional update, which locks the loan and fails for overdue
+// loans and loans at the limit, so concurrent renewals cannot go over the limit.
+func (r *loanRepository) Renew(ctx context.Context, userId uint, bookId uint, now time.Time, period time.Duration, maxRenewals uint) (entities.Loan, error) {
+	db := r.connection.WithContext(ctx).Model(&entities.Loan{}).
+		Where("user_id = ? AND book_id = ? AND returned_at IS NULL", userId, bookId).
+		Where("due_at > ? AND renewal_count < ?", now, maxRenewals).
+		Update("renewal_count", gorm.Expr("renewal_count + 1"))
+	if db.Error != nil {
+		return entities.Loan{}, db.Error
+	}
+	loan, err := r.FindActive(ctx, userId, bookId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return loan, ErrBookNotTaken
+	}
+	if err != nil {
+		return loan, err
+	}
+	if db.RowsAffected == 0 {
+		if !loan.DueAt.After(now) {
+			return loan, ErrLoanOverdue
+		}
+		return loan, ErrRenewalLimit
+	}
+	renewal := entities.Renewal{
+		LoanID:        loan.ID,
+		RenewedAt:     now,
+		PreviousDueAt: loan.DueAt,
+		DueAt:         loan.DueAt.Add(period),
+	}
+	err = r.connection.WithContext(ctx).Model(&entities.Loan{}).Where("id = ?", loan.ID).Update("due_at", renewal.DueAt).Error
+	if err != nil {
+		return loan, err
+	}
+	if err := r.connection.WithContext(ctx).Create(&renewal).Error; err != nil {
+		return loan, err
+	}
+	return r.FindActive(ctx, userId, bookId)
+}
+
+func orderRenewals(db *gorm.DB) *gorm.DB {
+	return db.Order("renewed_at, id")
+}
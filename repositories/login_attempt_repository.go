@@ -0,0 +1,102 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/mishozz/Library/config"
+	"github.com/mishozz/Library/entities"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type LoginAttemptRepository interface {
+	Find(ctx context.Context, scope string, subject string) (entities.LoginAttempt, error)
+	Lock(ctx context.Context, scope string, subject string) (entities.LoginAttempt, error)
+	Increment(ctx context.Context, scope string, subject string) (entities.LoginAttempt, error)
+	Save(ctx context.Context, attempt entities.LoginAttempt) error
+	Delete(ctx context.Context, scope string, subject string) error
+	FindLocked(ctx context.Context, now time.Time) ([]entities.LoginAttempt, error)
+	DeleteStale(ctx context.Context, scope string, before time.Time, now time.Time) (int64, error)
+}
+
+type loginAttemptRepository struct {
+	connection *gorm.DB
+}
+
+func NewLoginAttemptRepository(db config.Database) *loginAttemptRepository {
+	return &loginAttemptRepository{
+		connection: db.Connection,
+	}
+}
+
+func (r *loginAttemptRepository) Find(ctx context.Context, scope string, subject string) (entities.LoginAttempt, error) {
+	var attempt entities.LoginAttempt
+	err := r.connection.WithContext(ctx).Where("scope = ? AND subject = ?", scope, subject).Take(&attempt).Error
+	return attempt, err
+}
+
+// Lock returns the counter of the subject, creating it without failures when there is none,
+// and locks it until the end of the transaction. The logins of the subject check and count
+// their failures one after another, so concurrent guesses cannot pass the check together.
+func (r *loginAttemptRepository) Lock(ctx context.Context, scope string, subject string) (entities.LoginAttempt, error) {
+	err := r.connection.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "scope"}, {Name: "subject"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"failures": gorm.Expr("login_attempts.failures")}),
+	}).Create(&entities.LoginAttempt{Scope: scope, Subject: subject}).Error
+	if err != nil {
+		return entities.LoginAttempt{}, err
+	}
+	var attempt entities.LoginAttempt
+	err = r.connection.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("scope = ? AND subject = ?", scope, subject).
+		Take(&attempt).Error
+	return attempt, err
+}
+
+// Increment adds a failure to the counter of the subject, creating the counter with the
+// first failure, and returns the counter. The increment is a single statement, so
+// concurrent failures are all counted. The column is qualified, as PostgreSQL finds it
+// ambiguous next to the excluded row.
+func (r *loginAttemptRepository) Increment(ctx context.Context, scope string, subject string) (entities.LoginAttempt, error) {
+	err := r.connection.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "scope"}, {Name: "subject"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"failures": gorm.Expr("login_attempts.failures + 1")}),
+	}).Create(&entities.LoginAttempt{Scope: scope, Subject: subject, Failures: 1}).Error
+	if err != nil {
+		return entities.LoginAttempt{}, err
+	}
+	return r.Find(ctx, scope, subject)
+}
+
+func (r *loginAttemptRepository) Save(ctx context.Context, attempt entities.LoginAttempt) error {
+	return r.connection.WithContext(ctx).Save(&attempt).Error
+}
+
+// Delete forgets the failures of the subject, it returns gorm.ErrRecordNotFound when there are none
+func (r *loginAttemptRepository) Delete(ctx context.Context, scope string, subject string) error {
+	db := r.connection.WithContext(ctx).Where("scope = ? AND subject = ?", scope, subject).Delete(&entities.LoginAttempt{})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// FindLocked returns the accounts and addresses which are locked out at the given time
+func (r *loginAttemptRepository) FindLocked(ctx context.Context, now time.Time) ([]entities.LoginAttempt, error) {
+	var attempts []entities.LoginAttempt
+	err := r.connection.WithContext(ctx).Where("locked = ? AND blocked_until > ?", true, now).Order("scope").Order("subject").Find(&attempts).Error
+	return attempts, err
+}
+
+// DeleteStale forgets the counters of the scope whose last failure was before the given time
+// and which do not block the logins now, and returns how many it deleted
+func (r *loginAttemptRepository) DeleteStale(ctx context.Context, scope string, before time.Time, now time.Time) (int64, error) {
+	db := r.connection.WithContext(ctx).
+		Where("scope = ? AND last_failure < ? AND (blocked_until IS NULL OR blocked_until <= ?)", scope, before, now).
+		Delete(&entities.LoginAttempt{})
+	return db.RowsAffected, db.Error
+}
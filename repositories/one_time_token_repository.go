@@ -0,0 +1,61 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mishozz/Library/config"
+	"github.com/mishozz/Library/entities"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidToken is returned when a one-time token is unknown, expired, already used or issued for another purpose
+var ErrInvalidToken = errors.New("invalid token")
+
+type OneTimeTokenRepository interface {
+	Create(ctx context.Context, userId uint, purpose string, tokenHash string, expiresAt time.Time) error
+	Consume(ctx context.Context, purpose string, tokenHash string, now time.Time) (entities.OneTimeToken, error)
+	DeleteByUser(ctx context.Context, userId uint, purpose string) error
+}
+
+type oneTimeTokenRepository struct {
+	connection *gorm.DB
+}
+
+func NewOneTimeTokenRepository(db config.Database) *oneTimeTokenRepository {
+	return &oneTimeTokenRepository{
+		connection: db.Connection,
+	}
+}
+
+func (r *oneTimeTokenRepository) Create(ctx context.Context, userId uint, purpose string, tokenHash string, expiresAt time.Time) error {
+	return r.connection.WithContext(ctx).Create(&entities.OneTimeToken{
+		UserID:    userId,
+		Purpose:   purpose,
+		TokenHash: tokenHash,
+		ExpiresAt: expiresAt,
+	}).Error
+}
+
+// Consume marks the token used. The update is conditional, so a token cannot be used twice
+// even by concurrent requests.
+func (r *oneTimeTokenRepository) Consume(ctx context.Context, purpose string, tokenHash string, now time.Time) (entities.OneTimeToken, error) {
+	db := r.connection.WithContext(ctx).Model(&entities.OneTimeToken{}).
+		Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", tokenHash, purpose, now).
+		Update("used_at", now)
+	if db.Error != nil {
+		return entities.OneTimeToken{}, db.Error
+	}
+	if db.RowsAffected == 0 {
+		return entities.OneTimeToken{}, ErrInvalidToken
+	}
+	var token entities.OneTimeToken
+	err := r.connection.WithContext(ctx).Where("token_hash = ?", tokenHash).Take(&token).Error
+	return token, err
+}
+
+// DeleteByUser removes the tokens of the user issued for the purpose, used or not
+func (r *oneTimeTokenRepository) DeleteByUser(ctx context.Context, userId uint, purpose string) error {
+	return r.connection.WithContext(ctx).Where("user_id = ? AND purpose = ?", userId, purpose).Delete(&entities.OneTimeToken{}).Error
+}
@@ -0,0 +1,80 @@
+package repositories
+
+import (
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidSort is returned when a list is sorted by an unknown key
+var ErrInvalidSort = errors.New("invalid sort key")
+
+// Page selects a window of an ordered list. Number starts from 1,
+// a zero Size returns the whole list.
+type Page struct {
+	Number int
+	Size   int
+}
+
+// Sort orders a list by one of the sort keys of the listed entity
+type Sort struct {
+	Key  string
+	Desc bool
+}
+
+// BookQuery filters the books by author and title substrings and by availability
+type BookQuery struct {
+	Author    string
+	Title     string
+	Available *bool
+	Sort      Sort
+	Page      Page
+}
+
+// UserQuery filters the users by an email substring and by overdue loans
+type UserQuery struct {
+	Email      string
+	HasOverdue *bool
+	Sort       Sort
+	Page       Page
+}
+
+// paginate orders the query by the sort key and selects the page. The primary key
+// breaks ties, so the pages are stable.
+func paginate(sort Sort, columns map[string]string, page Page) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if column, ok := columns[sort.Key]; ok {
+			if sort.Desc {
+				column += " DESC"
+			}
+			db = db.Order(column)
+		}
+		db = db.Order("id")
+		if page.Size > 0 {
+			db = db.Offset((page.Number - 1) * page.Size).Limit(page.Size)
+		}
+		return db
+	}
+}
+
+func validateSort(sort Sort, columns map[string]string) error {
+	if _, ok := columns[sort.Key]; sort.Key != "" && !ok {
+		return ErrInvalidSort
+	}
+	return nil
+}
+
+// containsText matches the rows whose column contains the likeContains pattern, ignoring
+// the case on every database
+func containsText(column string) string {
+	return "LOWER(" + column + ") LIKE LOWER(?) ESCAPE '!'"
+}
+
+// likeContains builds a LIKE pattern which matches the text anywhere, with the wildcards
+// of the text escaped by an exclamation mark. MySQL reads a backslash in a string literal
+// as an escape itself, so it cannot be the escape of LIKE.
+func likeContains(text string) string {
+	text = strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`).Replace(text)
+	return "%" + text + "%"
+}
@@ -0,0 +1,78 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mishozz/Library/config"
+	"github.com/mishozz/Library/entities"
+	"gorm.io/gorm"
+)
+
+// ErrRefreshTokenRotated is returned when a refresh token was already exchanged
+var ErrRefreshTokenRotated = errors.New("refresh token already rotated")
+
+type RefreshTokenRepository interface {
+	Create(ctx context.Context, userId uint64, authUuid string, tokenHash string, expiresAt time.Time) (*entities.RefreshToken, error)
+	FindByHash(ctx context.Context, tokenHash string) (*entities.RefreshToken, error)
+	MarkRotated(ctx context.Context, token *entities.RefreshToken) error
+	DeleteFamily(ctx context.Context, authUuid string) error
+	DeleteByUser(ctx context.Context, userId uint64) error
+}
+
+type refreshTokenRepository struct {
+	connection *gorm.DB
+}
+
+func NewRefreshTokenRepository(db config.Database) *refreshTokenRepository {
+	return &refreshTokenRepository{
+		connection: db.Connection,
+	}
+}
+
+func (r *refreshTokenRepository) Create(ctx context.Context, userId uint64, authUuid string, tokenHash string, expiresAt time.Time) (*entities.RefreshToken, error) {
+	token := &entities.RefreshToken{
+		UserID:    userId,
+		AuthUUID:  authUuid,
+		TokenHash: tokenHash,
+		ExpiresAt: expiresAt,
+	}
+	err := r.connection.WithContext(ctx).Create(token).Error
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (r *refreshTokenRepository) FindByHash(ctx context.Context, tokenHash string) (*entities.RefreshToken, error) {
+	token := &entities.RefreshToken{}
+	err := r.connection.WithContext(ctx).Where("token_hash = ?", tokenHash).Take(token).Error
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// MarkRotated is conditional so two concurrent exchanges of the same token cannot both succeed
+func (r *refreshTokenRepository) MarkRotated(ctx context.Context, token *entities.RefreshToken) error {
+	db := r.connection.WithContext(ctx).Model(&entities.RefreshToken{}).
+		Where("id = ? AND rotated = ?", token.ID, false).
+		Update("rotated", true)
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrRefreshTokenRotated
+	}
+	token.Rotated = true
+	return nil
+}
+
+func (r *refreshTokenRepository) DeleteFamily(ctx context.Context, authUuid string) error {
+	return r.connection.WithContext(ctx).Where("auth_uuid = ?", authUuid).Delete(&entities.RefreshToken{}).Error
+}
+
+func (r *refreshTokenRepository) DeleteByUser(ctx context.Context, userId uint64) error {
+	return r.connection.WithContext(ctx).Where("user_id = ?", userId).Delete(&entities.RefreshToken{}).Error
+}
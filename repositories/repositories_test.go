@@ -1,38 +1,98 @@
 package repositories
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/mishozz/Library/config"
 	"github.com/mishozz/Library/entities"
-	"github.com/pkg/errors"
+	"github.com/mishozz/Library/migrations"
 	"github.com/stretchr/testify/assert"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
+// testCategories are the patron categories the seed migration creates
+var testCategories = []entities.PatronCategory{
+	{Name: entities.CategoryPublic, LoanDays: 14, MaxLoans: 5, MaxRenewals: 2, FineRate: 25, MaxHolds: 3},
+	{Name: entities.CategoryStudent, LoanDays: 21, MaxLoans: 10, MaxRenewals: 3, FineRate: 10, MaxHolds: 5},
+	{Name: entities.CategoryStaff, LoanDays: 28, MaxLoans: 20, MaxRenewals: 5, FineRate: 0, MaxHolds: 10},
+}
+
+// testRoles are the roles the seed migration creates
+var testRoles = []entities.Role{
+	{Name: entities.RoleAdmin, Permissions: entities.AllPermissions},
+	{Name: entities.RoleLibrarian, Permissions: entities.Permissions{
+		entities.PermissionBooksRead,
+		entities.PermissionBooksWrite,
+		entities.PermissionItemsManage,
+		entities.PermissionHoldsManage,
+		entities.PermissionLoansManage,
+		entities.PermissionFinesManage,
+		entities.PermissionUsersRead,
+	}},
+	{Name: entities.RoleUser, Permissions: entities.Permissions{entities.PermissionBooksRead}},
+}
+
 var db config.Database
 
-func clearDatabase() {
-	deleteFromTables(db, "users", "books")
+// testConnection is the database all the repository tests run on. It is SQLite in test.db with
+// the foreign keys enforced, as the other databases enforce them, unless LIBRARY_TEST_DRIVER
+// and LIBRARY_TEST_DSN select another one, so the same tests check that the repositories
+// behave alike on every supported database, e.g.
+//
+//	LIBRARY_TEST_DRIVER=postgres LIBRARY_TEST_DSN="host=localhost user=library password=library dbname=library_test" go test ./repositories/
+//	LIBRARY_TEST_DRIVER=mysql LIBRARY_TEST_DSN="library:library@tcp(localhost:3306)/library_test" go test ./repositories/
+//
+// The tests drop every table of the database, never point them to a database with data.
+var testConnection *gorm.DB
+
+// testTables are the tables of the tests, the dependent ones first
+var testTables = []interface{}{&entities.AuditEvent{}, &entities.LoginAttempt{}, &entities.OneTimeToken{}, &entities.RefreshToken{}, &entities.LedgerEntry{}, &entities.Renewal{}, &entities.Hold{}, &entities.Loan{}, &entities.Item{}, &entities.User{}, &entities.Book{}, &entities.PatronCategory{}, &entities.Role{}}
+
+func TestMain(m *testing.M) {
+	cfg := config.DatabaseConfig{Driver: config.DriverSQLite, DSN: "test.db?_foreign_keys=1"}
+	if driver, ok := os.LookupEnv("LIBRARY_TEST_DRIVER"); ok {
+		cfg = config.DatabaseConfig{Driver: driver, DSN: os.Getenv("LIBRARY_TEST_DSN")}
+	}
+	connection, err := config.Open(cfg, config.LogConfig{Level: "warn"})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to open the %s test database: %v\n", cfg.Driver, err)
+		os.Exit(1)
+	}
+	testConnection = connection
+	clearDatabase()
+	code := m.Run()
+	clearDatabase()
+	os.Exit(code)
 }
 
-func deleteFromTables(db config.Database, tables ...string) {
-	for _, table := range tables {
-		db.Connection.Exec(fmt.Sprintf("DELETE FROM %s", table))
+// clearDatabase drops the tables rather than deleting their rows, so the ids start from 1
+// again on every database
+func clearDatabase() {
+	migrator := testConnection.Migrator()
+	if migrator.HasTable("books_fts") {
+		migrator.DropTable("books_fts")
 	}
+	migrator.DropTable(testTables...)
 }
 
+// newTestDatabaseConnection creates the tables from the entities, and the FTS5 index like
+// its migration does when SQLite has FTS5
 func newTestDatabaseConnection() config.Database {
-	db, err := gorm.Open(sqlite.Open("test.db"), &gorm.Config{})
-	if err != nil {
-		errors.Wrap(err, "unable to open db connection")
-	}
-	db.AutoMigrate(&entities.Book{}, &entities.User{})
+	testConnection.AutoMigrate(testTables...)
 
+	fullTextSearch := migrations.FullTextSearchAvailable(testConnection)
+	if fullTextSearch {
+		testConnection.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS books_fts USING fts5(title, author, tokenize = 'unicode61 remove_diacritics 2')")
+	}
 	return config.Database{
-		Connection: db,
+		Connection:     testConnection,
+		FullTextSearch: fullTextSearch,
 	}
 }
 
@@ -49,6 +109,7 @@ func Test_NewUserRepository(t *testing.T) {
 }
 
 func Test_BookRepository_Save_Find(t *testing.T) {
+	ctx := context.Background()
 	db = newTestDatabaseConnection()
 	defer clearDatabase()
 
@@ -60,14 +121,17 @@ func Test_BookRepository_Save_Find(t *testing.T) {
 		AvailableUnits: 3,
 	}
 
-	bookRepo.Save(book)
+	bookRepo.Save(ctx, book)
 
-	found, _ := bookRepo.Find(book.Isbn)
+	found, _ := bookRepo.Find(ctx, book.Isbn)
 
+	//copies are added as items
+	book.AvailableUnits = 0
 	assertEqualBooks(t, book, found)
 }
 
 func Test_BookRepository_FindAll(t *testing.T) {
+	ctx := context.Background()
 	db = newTestDatabaseConnection()
 	defer clearDatabase()
 
@@ -85,163 +149,1299 @@ func Test_BookRepository_FindAll(t *testing.T) {
 		AvailableUnits: 2,
 	}
 
-	bookRepo.Save(book1)
-	bookRepo.Save(book2)
+	saveTestBooks(bookRepo, book1, book2)
 
-	books, _ := bookRepo.FindAll()
+	books, total, _ := bookRepo.FindAll(ctx, BookQuery{})
 
+	assert.Equal(t, int64(2), total)
 	assertEqualBooks(t, book1, books[0])
 	assertEqualBooks(t, book2, books[1])
 }
 
+func Test_BookRepository_FindAll_Cancelled(t *testing.T) {
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
+
+	bookRepo := NewBookRepository(db)
+	saveTestBooks(bookRepo, entities.Book{Isbn: "test", Title: "test", Author: "test", AvailableUnits: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, _, err := bookRepo.FindAll(ctx, BookQuery{})
+
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func Test_BookRepository_FindAll_Query(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
+
+	bookRepo := NewBookRepository(db)
+	books := saveTestBooks(bookRepo, entities.Book{
+		Isbn:           "1",
+		Title:          "The Go Programming Language",
+		Author:         "Donovan",
+		AvailableUnits: 2,
+	}, entities.Book{
+		Isbn:           "2",
+		Title:          "Go in Action",
+		Author:         "Kennedy",
+		AvailableUnits: 0,
+	}, entities.Book{
+		Isbn:           "3",
+		Title:          "100% Go",
+		Author:         "Kennedy",
+		AvailableUnits: 1,
+	})
+	available := true
+
+	tests := []struct {
+		name     string
+		query    BookQuery
+		expected []entities.Book
+		total    int64
+		err      error
+	}{{
+		name:     "author substring",
+		query:    BookQuery{Author: "enned"},
+		expected: []entities.Book{books[1], books[2]},
+		total:    2,
+	}, {
+		name:     "title substring with a wildcard",
+		query:    BookQuery{Title: "100%"},
+		expected: []entities.Book{books[2]},
+		total:    1,
+	}, {
+		name:     "available and sorted by title",
+		query:    BookQuery{Available: &available, Sort: Sort{Key: "title"}},
+		expected: []entities.Book{books[2], books[0]},
+		total:    2,
+	}, {
+		name:     "second page in descending order",
+		query:    BookQuery{Sort: Sort{Key: "available", Desc: true}, Page: Page{Number: 2, Size: 2}},
+		expected: []entities.Book{books[1]},
+		total:    3,
+	}, {
+		name:  "unknown sort key",
+		query: BookQuery{Sort: Sort{Key: "deleted_at"}},
+		err:   ErrInvalidSort,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			actual, total, err := bookRepo.FindAll(ctx, tt.query)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.total, total)
+			assert.Equal(t, len(tt.expected), len(actual))
+			for i := range actual {
+				assertEqualBooks(t, tt.expected[i], actual[i])
+			}
+		})
+	}
+}
+
+// saveLoan stores a loan of the book for the user, returned ones are closed right away
+func saveLoan(t *testing.T, user entities.User, book entities.Book, returned bool) {
+	ctx := context.Background()
+	loanRepo := NewLoanRepository(db)
+	now := time.Now()
+	err := loanRepo.Create(ctx, entities.Loan{
+		UserID:     user.ID,
+		BookID:     book.ID,
+		BorrowedAt: now,
+		DueAt:      now.Add(entities.DefaultLoanPeriod),
+	})
+	assert.Nil(t, err)
+	if returned {
+		_, err = loanRepo.Return(ctx, user.ID, book.ID, now)
+		assert.Nil(t, err)
+	}
+}
+
+// saveTestBooks stores the books with as many copies on the shelf as they have available units
+func saveTestBooks(bookRepo BookRepository, books ...entities.Book) []entities.Book {
+	ctx := context.Background()
+	itemRepo := NewItemRepository(db)
+	saved := make([]entities.Book, len(books))
+	for i, book := range books {
+		bookRepo.Save(ctx, book)
+		found, _ := bookRepo.Find(ctx, book.Isbn)
+		for n := uint(1); n <= book.AvailableUnits; n++ {
+			itemRepo.Create(ctx, entities.Item{Barcode: fmt.Sprintf("%s-%d", book.Isbn, n), BookID: found.ID})
+		}
+		saved[i], _ = bookRepo.Find(ctx, book.Isbn)
+	}
+	return saved
+}
+
+func Test_BookRepository_Search(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
+
+	indexes := map[string]bookIndex{"like": likeBookIndex{}}
+	if db.FullTextSearch {
+		indexes["fts"] = ftsBookIndex{}
+	} else {
+		t.Log("sqlite is built without FTS5, only the LIKE search is tested")
+	}
+	for name, index := range indexes {
+		t.Run(name, func(t *testing.T) {
+			db = newTestDatabaseConnection()
+			defer clearDatabase()
+			bookRepo := &BookRepositoryImpl{connection: db.Connection, index: index}
+			for _, book := range []entities.Book{
+				{Isbn: "1", Title: "Dune", Author: "Frank Herbert"},
+				{Isbn: "2", Title: "Children of Dune", Author: "Frank Herbert"},
+				{Isbn: "3", Title: "Duneland", Author: "Ann Smith"},
+				{Isbn: "4", Title: "Mountains", Author: "Ann Dunedin"},
+				{Isbn: "5", Title: "Redundant", Author: "Bob"},
+				{Isbn: "6", Title: "Tom & Jerry <b>bold</b>", Author: "O'Neil"},
+			} {
+				assert.NoError(t, bookRepo.Save(ctx, book))
+			}
+
+			matches, total, err := bookRepo.Search(ctx, "dune", Page{Number: 1, Size: 20})
+			assert.NoError(t, err)
+			assert.Equal(t, int64(4), total)
+			isbns := []string{}
+			for _, match := range matches {
+				isbns = append(isbns, match.Isbn)
+			}
+			assert.ElementsMatch(t, []string{"1", "2", "3", "4"}, isbns)
+			assert.Equal(t, "4", isbns[3], "title matches come first")
+
+			matches, total, err = bookRepo.Search(ctx, "frank, DUNE children", Page{Number: 1, Size: 20})
+			assert.NoError(t, err)
+			assert.Equal(t, int64(1), total)
+			assert.Equal(t, "2", matches[0].Isbn)
+			assert.Equal(t, "<mark>Children</mark> of <mark>Dune</mark>", matches[0].TitleSnippet)
+			assert.Equal(t, "<mark>Frank</mark> Herbert", matches[0].AuthorSnippet)
+
+			matches, _, err = bookRepo.Search(ctx, "jerry", Page{Number: 1, Size: 20})
+			assert.NoError(t, err)
+			assert.Len(t, matches, 1)
+			assert.Equal(t, "Tom &amp; <mark>Jerry</mark> &lt;b&gt;bold&lt;/b&gt;", matches[0].TitleSnippet)
+			assert.Equal(t, "O&#39;Neil", matches[0].AuthorSnippet)
+
+			matches, total, err = bookRepo.Search(ctx, "dune", Page{Number: 2, Size: 3})
+			assert.NoError(t, err)
+			assert.Equal(t, int64(4), total)
+			assert.Len(t, matches, 1)
+
+			book, _ := bookRepo.Find(ctx, "5")
+			book.Title = "Dunes"
+			assert.NoError(t, bookRepo.Update(ctx, book))
+			_, total, _ = bookRepo.Search(ctx, "dune", Page{})
+			assert.Equal(t, int64(5), total)
+
+			assert.NoError(t, bookRepo.Delete(ctx, "1"))
+			_, total, _ = bookRepo.Search(ctx, "dune", Page{})
+			assert.Equal(t, int64(4), total)
+
+			_, _, err = bookRepo.Search(ctx, " ?! ", Page{})
+			assert.Equal(t, ErrEmptySearch, err)
+		})
+	}
+}
+
 func Test_UserRepository_Save_Find(t *testing.T) {
+	ctx := context.Background()
 	db = newTestDatabaseConnection()
 	defer clearDatabase()
 
 	userRepo := NewUserRepository(db)
-	takenBook := entities.Book{
+	books := saveTestBooks(NewBookRepository(db), entities.Book{
 		Isbn:           "test1",
 		Title:          "test",
 		Author:         "test",
 		AvailableUnits: 2,
-	}
-	returnedBook := entities.Book{
+	}, entities.Book{
 		Isbn:           "test2",
 		Title:          "test",
 		Author:         "test",
 		AvailableUnits: 2,
-	}
-	user := entities.User{
-		Email: "email",
-		TakenBooks: []entities.Book{
-			takenBook,
-		},
-		ReturnedBooks: []entities.Book{
-			returnedBook,
-		},
-	}
-	userRepo.Save(user)
+	})
+	takenBook, returnedBook := books[0], books[1]
+
+	userRepo.Save(ctx, entities.User{Email: "email"})
+	user, _ := userRepo.FindByEmail(ctx, "email")
+	saveLoan(t, user, takenBook, false)
+	saveLoan(t, user, returnedBook, true)
 
-	found, _ := userRepo.FindByEmail("email")
-	assert.Equal(t, user.Email, found.Email)
-	assertEqualBooks(t, user.TakenBooks[0], found.TakenBooks[0])
-	assertEqualBooks(t, user.ReturnedBooks[0], found.ReturnedBooks[0])
+	found, _ := userRepo.FindByEmail(ctx, "email")
+	assert.Equal(t, "email", found.Email)
+	assert.Len(t, found.TakenBooks, 1)
+	assert.Len(t, found.ReturnedBooks, 1)
+	assertEqualBooks(t, takenBook, found.TakenBooks[0])
+	assertEqualBooks(t, returnedBook, found.ReturnedBooks[0])
+}
+
+func Test_UserRepository_Accounts(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
+
+	userRepo := NewUserRepository(db)
+	userRepo.Save(ctx, entities.User{Email: "admin", Role: entities.RoleAdmin})
+	userRepo.Save(ctx, entities.User{Email: "email", Role: entities.RoleUser})
+	admin, _ := userRepo.FindByEmail(ctx, "admin")
+	user, _ := userRepo.FindByEmail(ctx, "email")
+
+	admins, err := userRepo.CountAdmins(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), admins)
+
+	assert.Nil(t, userRepo.SetRole(ctx, user.ID, entities.RoleAdmin))
+	user, _ = userRepo.FindByEmail(ctx, "email")
+	assert.Equal(t, entities.RoleAdmin, user.Role)
+	admins, _ = userRepo.CountAdmins(ctx)
+	assert.Equal(t, int64(2), admins)
+
+	assert.Nil(t, userRepo.SetDisabled(ctx, admin.ID, true))
+	admin, _ = userRepo.FindByEmail(ctx, "admin")
+	assert.True(t, admin.Disabled)
+	admins, _ = userRepo.CountAdmins(ctx)
+	assert.Equal(t, int64(1), admins)
+
+	assert.Nil(t, userRepo.SetPassword(ctx, user.ID, "hashed"))
+	user, _ = userRepo.FindByEmail(ctx, "email")
+	assert.Equal(t, "hashed", user.Password)
+
+	assert.False(t, user.Verified)
+	assert.Nil(t, userRepo.SetVerified(ctx, user.ID))
+	user, _ = userRepo.FindByEmail(ctx, "email")
+	assert.True(t, user.Verified)
+
+	book := saveTestBooks(NewBookRepository(db), entities.Book{Isbn: "test", Title: "test", Author: "test"})[0]
+	assert.Nil(t, db.Connection.Create(&entities.Hold{UserID: user.ID, BookID: book.ID, Position: 1, Status: entities.HoldCancelled}).Error)
+	assert.Nil(t, userRepo.Delete(ctx, user.ID))
+	_, err = userRepo.FindByEmail(ctx, "email")
+	assert.NotNil(t, err)
+	admins, _ = userRepo.CountAdmins(ctx)
+	assert.Equal(t, int64(0), admins)
+	var holds int64
+	db.Connection.Model(&entities.Hold{}).Where("user_id = ?", user.ID).Count(&holds)
+	assert.Equal(t, int64(0), holds)
+
+	//the email of a deleted user can register again
+	assert.Nil(t, userRepo.Save(ctx, entities.User{Email: "email", Role: entities.RoleUser}))
+	registered, err := userRepo.FindByEmail(ctx, "email")
+	assert.Nil(t, err)
+	assert.Equal(t, entities.RoleUser, registered.Role)
 }
 
 func Test_UserRepository_FindAll(t *testing.T) {
+	ctx := context.Background()
 	db = newTestDatabaseConnection()
 	defer clearDatabase()
 
-	takenBook := entities.Book{
+	userRepo := NewUserRepository(db)
+	books := saveTestBooks(NewBookRepository(db), entities.Book{
 		Isbn:           "test1",
 		Title:          "test",
 		Author:         "test",
 		AvailableUnits: 2,
-	}
-	returnedBook := entities.Book{
+	}, entities.Book{
 		Isbn:           "test2",
 		Title:          "test",
 		Author:         "test",
 		AvailableUnits: 2,
+	})
+	takenBook, returnedBook := books[0], books[1]
+
+	userRepo.Save(ctx, entities.User{Email: "email1"})
+	userRepo.Save(ctx, entities.User{Email: "email2"})
+	user1, _ := userRepo.FindByEmail(ctx, "email1")
+	user2, _ := userRepo.FindByEmail(ctx, "email2")
+	for _, user := range []entities.User{user1, user2} {
+		saveLoan(t, user, takenBook, false)
+		saveLoan(t, user, returnedBook, true)
 	}
-	user1 := entities.User{
-		Email:      "email1",
-		TakenBooks: []entities.Book{takenBook},
-		ReturnedBooks: []entities.Book{
-			returnedBook,
-		},
+
+	users, _, _ := userRepo.FindAll(ctx, UserQuery{})
+
+	expected := func(email string) entities.User {
+		return entities.User{
+			Email:         email,
+			TakenBooks:    []entities.Book{takenBook},
+			ReturnedBooks: []entities.Book{returnedBook},
+		}
+	}
+	assertEqualUsers(t, expected("email1"), users[0])
+	assertEqualUsers(t, expected("email2"), users[1])
+}
+
+func Test_UserRepository_FindAll_Query(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
+
+	userRepo := NewUserRepository(db)
+	book := saveTestBooks(NewBookRepository(db), entities.Book{
+		Isbn:           "test",
+		Title:          "test",
+		Author:         "test",
+		AvailableUnits: 2,
+	})[0]
+	for _, email := range []string{"b@abv.bg", "a@gmail.com", "c@gmail.com"} {
+		userRepo.Save(ctx, entities.User{Email: email})
 	}
-	user2 := entities.User{
-		Email: "email2",
-		TakenBooks: []entities.Book{
-			takenBook,
-		},
-		ReturnedBooks: []entities.Book{
-			returnedBook,
-		},
+	late, _ := userRepo.FindByEmail(ctx, "b@abv.bg")
+	onTime, _ := userRepo.FindByEmail(ctx, "c@gmail.com")
+	borrowedAt := time.Now().Add(-entities.DefaultLoanPeriod * 2)
+	loanRepo := NewLoanRepository(db)
+	assert.Nil(t, loanRepo.Create(ctx, entities.Loan{UserID: late.ID, BookID: book.ID, BorrowedAt: borrowedAt, DueAt: borrowedAt.Add(entities.DefaultLoanPeriod)}))
+	saveLoan(t, onTime, book, false)
+	hasOverdue, noOverdue := true, false
+
+	tests := []struct {
+		name     string
+		query    UserQuery
+		expected []string
+		total    int64
+	}{{
+		name:     "email substring sorted by email",
+		query:    UserQuery{Email: "gmail", Sort: Sort{Key: "email"}},
+		expected: []string{"a@gmail.com", "c@gmail.com"},
+		total:    2,
+	}, {
+		name:     "with overdue loans",
+		query:    UserQuery{HasOverdue: &hasOverdue},
+		expected: []string{"b@abv.bg"},
+		total:    1,
+	}, {
+		name:     "without overdue loans",
+		query:    UserQuery{HasOverdue: &noOverdue, Sort: Sort{Key: "email", Desc: true}},
+		expected: []string{"c@gmail.com", "a@gmail.com"},
+		total:    2,
+	}, {
+		name:     "first page",
+		query:    UserQuery{Page: Page{Number: 1, Size: 1}},
+		expected: []string{"b@abv.bg"},
+		total:    3,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			users, total, err := userRepo.FindAll(ctx, tt.query)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.total, total)
+			emails := make([]string, len(users))
+			for i, user := range users {
+				emails[i] = user.Email
+			}
+			assert.Equal(t, tt.expected, emails)
+		})
 	}
+}
+
+func Test_UserRepository_ReturnedBooksFromLoans(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
 
 	userRepo := NewUserRepository(db)
+	book := saveTestBooks(NewBookRepository(db), entities.Book{
+		Isbn:           "test1",
+		Title:          "test",
+		Author:         "test",
+		AvailableUnits: 2,
+	})[0]
+	userRepo.Save(ctx, entities.User{Email: "email"})
+	user, _ := userRepo.FindByEmail(ctx, "email")
 
-	userRepo.Save(user1)
-	userRepo.Save(user2)
+	//borrowed twice and returned twice, the book is listed only once
+	saveLoan(t, user, book, true)
+	saveLoan(t, user, book, true)
+	user, _ = userRepo.FindByEmail(ctx, "email")
+	assert.Empty(t, user.TakenBooks)
+	assert.Len(t, user.ReturnedBooks, 1)
 
-	users, _ := userRepo.FindAll()
+	//borrowed again, the book is no longer returned
+	saveLoan(t, user, book, false)
+	user, _ = userRepo.FindByEmail(ctx, "email")
+	assert.Len(t, user.TakenBooks, 1)
+	assert.Empty(t, user.ReturnedBooks)
 
-	assertEqualUsers(t, user1, users[0])
-	assertEqualUsers(t, user2, users[1])
+	loans, err := NewLoanRepository(db).FindByUser(ctx, user.ID)
+	assert.Nil(t, err)
+	assert.Len(t, loans, 3)
+	assertEqualBooks(t, book, loans[0].Book)
 }
 
-func Test_UserRepository_UpdateTakenBooks(t *testing.T) {
+func Test_LoanRepository_FindActive_Return(t *testing.T) {
+	ctx := context.Background()
 	db = newTestDatabaseConnection()
 	defer clearDatabase()
 
-	oldBook := entities.Book{
+	userRepo := NewUserRepository(db)
+	loanRepo := NewLoanRepository(db)
+	book := saveTestBooks(NewBookRepository(db), entities.Book{
 		Isbn:           "test1",
 		Title:          "test",
 		Author:         "test",
 		AvailableUnits: 2,
+	})[0]
+	userRepo.Save(ctx, entities.User{Email: "email"})
+	user, _ := userRepo.FindByEmail(ctx, "email")
+
+	_, err := loanRepo.FindActive(ctx, user.ID, book.ID)
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+
+	saveLoan(t, user, book, false)
+	loan, err := loanRepo.FindActive(ctx, user.ID, book.ID)
+	assert.Nil(t, err)
+	assert.Nil(t, loan.ReturnedAt)
+	assertEqualBooks(t, book, loan.Book)
+	active, _ := loanRepo.CountActive(ctx, user.ID)
+	assert.Equal(t, int64(1), active)
+
+	returned, err := loanRepo.Return(ctx, user.ID, book.ID, time.Now())
+	assert.Nil(t, err)
+	assert.NotNil(t, returned.ReturnedAt)
+	active, _ = loanRepo.CountActive(ctx, user.ID)
+	assert.Equal(t, int64(0), active)
+	_, err = loanRepo.Return(ctx, user.ID, book.ID, time.Now())
+	assert.Equal(t, ErrBookNotTaken, err)
+	_, err = loanRepo.FindActive(ctx, user.ID, book.ID)
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+}
+
+func Test_LoanRepository_Renew(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
+
+	userRepo := NewUserRepository(db)
+	loanRepo := NewLoanRepository(db)
+	book := saveTestBooks(NewBookRepository(db), entities.Book{
+		Isbn:   "test",
+		Title:  "test",
+		Author: "test",
+	})[0]
+	userRepo.Save(ctx, entities.User{Email: "email"})
+	user, _ := userRepo.FindByEmail(ctx, "email")
+
+	now := time.Now()
+	_, err := loanRepo.Renew(ctx, user.ID, book.ID, now, entities.DefaultLoanPeriod, 2)
+	assert.Equal(t, ErrBookNotTaken, err)
+
+	saveLoan(t, user, book, false)
+	loan, _ := loanRepo.FindActive(ctx, user.ID, book.ID)
+	dueAt := loan.DueAt
+	for i := 1; i <= 2; i++ {
+		renewed, err := loanRepo.Renew(ctx, user.ID, book.ID, now, entities.DefaultLoanPeriod, 2)
+		assert.Nil(t, err)
+		assert.Equal(t, uint(i), renewed.RenewalCount)
+		assert.Len(t, renewed.Renewals, i)
+		assert.True(t, renewed.DueAt.Equal(dueAt.Add(entities.DefaultLoanPeriod)))
+		assert.True(t, renewed.Renewals[i-1].PreviousDueAt.Equal(dueAt))
+		dueAt = renewed.DueAt
 	}
+	_, err = loanRepo.Renew(ctx, user.ID, book.ID, now, entities.DefaultLoanPeriod, 2)
+	assert.Equal(t, ErrRenewalLimit, err)
+	_, err = loanRepo.Renew(ctx, user.ID, book.ID, dueAt, entities.DefaultLoanPeriod, 3)
+	assert.Equal(t, ErrLoanOverdue, err)
 
-	newBook := entities.Book{
+	loans, _ := loanRepo.FindByUser(ctx, user.ID)
+	assert.Len(t, loans[0].Renewals, 2)
+	assert.True(t, loans[0].DueAt.Equal(dueAt))
+}
+
+func Test_LoanRepository_ReturnItem(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
+
+	userRepo := NewUserRepository(db)
+	loanRepo := NewLoanRepository(db)
+	book := saveTestBooks(NewBookRepository(db), entities.Book{
 		Isbn:           "test1",
 		Title:          "test",
 		Author:         "test",
-		AvailableUnits: 2,
+		AvailableUnits: 1,
+	})[0]
+	item, _ := NewItemRepository(db).Find(ctx, "test1-1")
+	userRepo.Save(ctx, entities.User{Email: "email"})
+	user, _ := userRepo.FindByEmail(ctx, "email")
+
+	now := time.Now()
+	err := loanRepo.Create(ctx, entities.Loan{
+		UserID:     user.ID,
+		BookID:     book.ID,
+		ItemID:     &item.ID,
+		BorrowedAt: now,
+		DueAt:      now.Add(entities.DefaultLoanPeriod),
+	})
+	assert.Nil(t, err)
+
+	loan, err := loanRepo.ReturnItem(ctx, user.ID, item.ID, now)
+	assert.Nil(t, err)
+	assert.Equal(t, "test1-1", loan.Item.Barcode)
+	assertEqualBooks(t, book, loan.Book)
+	_, err = loanRepo.ReturnItem(ctx, user.ID, item.ID, now)
+	assert.Equal(t, ErrBookNotTaken, err)
+}
+
+func Test_LedgerRepository(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
+
+	userRepo := NewUserRepository(db)
+	ledgerRepo := NewLedgerRepository(db)
+	book := saveTestBooks(NewBookRepository(db), entities.Book{
+		Isbn:   "test",
+		Title:  "test",
+		Author: "test",
+	})[0]
+	userRepo.Save(ctx, entities.User{Email: "email"})
+	user, _ := userRepo.FindByEmail(ctx, "email")
+	saveLoan(t, user, book, true)
+	loans, _ := NewLoanRepository(db).FindByUser(ctx, user.ID)
+
+	balance, err := ledgerRepo.Balance(ctx, user.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), balance)
+
+	for _, entry := range []entities.LedgerEntry{
+		{UserID: user.ID, LoanID: &loans[0].ID, Kind: entities.LedgerCharge, Amount: 300},
+		{UserID: user.ID, Kind: entities.LedgerPayment, Amount: 100},
+		{UserID: user.ID, Kind: entities.LedgerWaiver, Amount: 50},
+	} {
+		_, err := ledgerRepo.Create(ctx, entry)
+		assert.Nil(t, err)
 	}
 
-	user := entities.User{
-		Email: "email",
-		TakenBooks: []entities.Book{
-			oldBook,
-		},
+	balance, err = ledgerRepo.Balance(ctx, user.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(150), balance)
+	entries, err := ledgerRepo.FindByUser(ctx, user.ID)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, entities.LedgerCharge, entries[0].Kind)
+	assertEqualBooks(t, book, entries[0].Loan.Book)
+	assert.Nil(t, entries[1].Loan)
+}
+
+func Test_CategoryRepository(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
+
+	categoryRepo := NewCategoryRepository(db)
+	for _, category := range testCategories {
+		assert.Nil(t, db.Connection.Create(&category).Error)
 	}
 
+	categories, err := categoryRepo.FindAll(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, categories, 3)
+	assert.Equal(t, entities.CategoryPublic, categories[0].Name)
+
+	err = categoryRepo.Update(ctx, entities.PatronCategory{Name: entities.CategoryStaff, LoanDays: 60, MaxLoans: 50})
+	assert.Nil(t, err)
+	staff, err := categoryRepo.Find(ctx, entities.CategoryStaff)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(60), staff.LoanDays)
+	assert.Equal(t, uint(50), staff.MaxLoans)
+	assert.Equal(t, uint(0), staff.MaxRenewals)
+
+	err = categoryRepo.Update(ctx, entities.PatronCategory{Name: "alumni", LoanDays: 7, MaxLoans: 1})
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+
+	assert.Nil(t, categoryRepo.Create(ctx, entities.PatronCategory{Name: "alumni", LoanDays: 7, MaxLoans: 1}))
+	alumni, err := categoryRepo.Find(ctx, "alumni")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(7), alumni.LoanDays)
+	assert.Equal(t, ErrCategoryExists, categoryRepo.Create(ctx, entities.PatronCategory{Name: "alumni", LoanDays: 14, MaxLoans: 2}))
+	created := concurrently(t, 5, ErrCategoryExists, func(int) error {
+		return categoryRepo.Create(ctx, entities.PatronCategory{Name: "retirees", LoanDays: 14, MaxLoans: 2})
+	})
+	assert.Equal(t, 1, created)
+
 	userRepo := NewUserRepository(db)
+	userRepo.Save(ctx, entities.User{Email: "email"})
+	user, _ := userRepo.FindByEmail(ctx, "email")
+	assert.Equal(t, entities.CategoryPublic, user.Category)
+	assert.Nil(t, userRepo.SetCategory(ctx, user.ID, entities.CategoryStaff))
+	user, _ = userRepo.FindByEmail(ctx, "email")
+	assert.Equal(t, entities.CategoryStaff, user.Category)
 
-	userRepo.Save(user)
-	userRepo.UpdateTakenBooks(user, []entities.Book{newBook})
+	limit := uint(8)
+	assert.Nil(t, userRepo.SetLoanLimit(ctx, user.ID, &limit))
+	user, _ = userRepo.FindByEmail(ctx, "email")
+	assert.Equal(t, limit, *user.LoanLimit)
+	assert.Nil(t, userRepo.SetLoanLimit(ctx, user.ID, nil))
+	user, _ = userRepo.FindByEmail(ctx, "email")
+	assert.Nil(t, user.LoanLimit)
+}
 
-	user, _ = userRepo.FindByEmail("email")
-	assertEqualBooks(t, newBook, user.TakenBooks[0])
+func Test_RoleRepository(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
+
+	roleRepo := NewRoleRepository(db)
+	for _, role := range testRoles {
+		assert.Nil(t, roleRepo.Save(ctx, role))
+	}
+
+	roles, err := roleRepo.FindAll(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, roles, 3)
+	assert.Equal(t, entities.RoleAdmin, roles[0].Name)
+	assert.True(t, roles[0].Can(entities.PermissionRolesManage))
+
+	err = roleRepo.Save(ctx, entities.Role{Name: entities.RoleLibrarian, Permissions: entities.Permissions{entities.PermissionBooksWrite, entities.PermissionBooksRead}})
+	assert.Nil(t, err)
+	librarian, err := roleRepo.Find(ctx, entities.RoleLibrarian)
+	assert.Nil(t, err)
+	assert.Equal(t, entities.Permissions{entities.PermissionBooksRead, entities.PermissionBooksWrite}, librarian.Permissions)
+	assert.False(t, librarian.Can(entities.PermissionItemsManage))
+
+	userRepo := NewUserRepository(db)
+	userRepo.Save(ctx, entities.User{Email: "email", Role: entities.RoleLibrarian})
+	assert.Equal(t, ErrRoleInUse, roleRepo.Delete(ctx, entities.RoleLibrarian))
+	_, err = roleRepo.Find(ctx, entities.RoleLibrarian)
+	assert.Nil(t, err)
+
+	user, _ := userRepo.FindByEmail(ctx, "email")
+	userRepo.SetRole(ctx, user.ID, entities.RoleUser)
+	assert.Nil(t, roleRepo.Delete(ctx, entities.RoleLibrarian))
+	_, err = roleRepo.Find(ctx, entities.RoleLibrarian)
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+	assert.True(t, errors.Is(roleRepo.Delete(ctx, entities.RoleLibrarian), gorm.ErrRecordNotFound))
 }
 
-func Test_UserRepository_UpdateReturnedBooks(t *testing.T) {
+func Test_ItemRepository(t *testing.T) {
+	ctx := context.Background()
 	db = newTestDatabaseConnection()
 	defer clearDatabase()
 
-	oldBook := entities.Book{
-		Isbn:           "test1",
+	bookRepo := NewBookRepository(db)
+	itemRepo := NewItemRepository(db)
+	book := saveTestBooks(bookRepo, entities.Book{
+		Isbn:   "test",
+		Title:  "test",
+		Author: "test",
+	})[0]
+
+	damaged, err := itemRepo.Create(ctx, entities.Item{Barcode: "0001", BookID: book.ID, Condition: entities.ConditionDamaged})
+	assert.Nil(t, err)
+	assert.Equal(t, entities.ItemAvailable, damaged.Status)
+	good, err := itemRepo.Create(ctx, entities.Item{Barcode: "0002", BookID: book.ID})
+	assert.Nil(t, err)
+	assert.Equal(t, entities.ConditionGood, good.Condition)
+
+	//damaged copies are not lent
+	found, _ := bookRepo.Find(ctx, "test")
+	assert.Equal(t, uint(1), found.AvailableUnits)
+	claimed, err := itemRepo.Claim(ctx, book.ID, entities.ItemOnLoan)
+	assert.Nil(t, err)
+	assert.Equal(t, good.ID, claimed.ID)
+	_, err = itemRepo.Claim(ctx, book.ID, entities.ItemOnLoan)
+	assert.Equal(t, ErrNoAvailableUnits, err)
+	found, _ = bookRepo.Find(ctx, "test")
+	assert.Equal(t, uint(0), found.AvailableUnits)
+
+	assert.Equal(t, ErrItemNotAvailable, itemRepo.Transition(ctx, claimed, entities.ItemAvailable, entities.ItemWithdrawn))
+	assert.Nil(t, itemRepo.Transition(ctx, claimed, entities.ItemOnLoan, entities.ItemAvailable))
+
+	damaged.Condition = entities.ConditionWorn
+	damaged.Location = "A1"
+	assert.Nil(t, itemRepo.Update(ctx, damaged))
+	found, _ = bookRepo.Find(ctx, "test")
+	assert.Equal(t, uint(2), found.AvailableUnits)
+
+	item, err := itemRepo.Find(ctx, "0001")
+	assert.Nil(t, err)
+	assert.Equal(t, "A1", item.Location)
+	assertEqualBooks(t, found, *item.Book)
+	items, _ := itemRepo.FindByBook(ctx, book.ID)
+	assert.Len(t, items, 2)
+	assert.Equal(t, "0001", items[0].Barcode)
+	assert.Equal(t, "0002", items[1].Barcode)
+}
+
+// A book which was lent is kept with its loans, their renewals and fines, on every database.
+// A book which was never lent is deleted with its copies and holds.
+func Test_BookRepository_Delete(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
+
+	bookRepo := NewBookRepository(db)
+	userRepo := NewUserRepository(db)
+	loanRepo := NewLoanRepository(db)
+	ledgerRepo := NewLedgerRepository(db)
+	books := saveTestBooks(bookRepo,
+		entities.Book{Isbn: "lent", Title: "test", Author: "test", AvailableUnits: 1},
+		entities.Book{Isbn: "unlent", Title: "test", Author: "test", AvailableUnits: 1},
+	)
+	userRepo.Save(ctx, entities.User{Email: "email"})
+	user, _ := userRepo.FindByEmail(ctx, "email")
+
+	now := time.Now()
+	saveLoan(t, user, books[0], false)
+	renewed, err := loanRepo.Renew(ctx, user.ID, books[0].ID, now, entities.DefaultLoanPeriod, 2)
+	assert.Nil(t, err)
+	returned, err := loanRepo.Return(ctx, user.ID, books[0].ID, now)
+	assert.Nil(t, err)
+	_, err = ledgerRepo.Create(ctx, entities.LedgerEntry{UserID: user.ID, LoanID: &returned.ID, Kind: entities.LedgerCharge, Amount: 100})
+	assert.Nil(t, err)
+	_, err = NewHoldRepository(db).Create(ctx, entities.Hold{UserID: user.ID, BookID: books[1].ID})
+	assert.Nil(t, err)
+
+	assert.Equal(t, ErrBookHasLoans, bookRepo.Delete(ctx, "lent"))
+	_, err = bookRepo.Find(ctx, "lent")
+	assert.Nil(t, err)
+	loans, _ := loanRepo.FindByUser(ctx, user.ID)
+	assert.Len(t, loans, 1)
+	assert.Len(t, loans[0].Renewals, len(renewed.Renewals))
+	entries, _ := ledgerRepo.FindByUser(ctx, user.ID)
+	assert.Len(t, entries, 1)
+
+	assert.Nil(t, bookRepo.Delete(ctx, "unlent"))
+	_, err = bookRepo.Find(ctx, "unlent")
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+	items, _ := NewItemRepository(db).FindByBook(ctx, books[1].ID)
+	assert.Empty(t, items)
+	holds, _ := NewHoldRepository(db).FindByUser(ctx, user.ID)
+	assert.Empty(t, holds)
+
+	assert.True(t, errors.Is(bookRepo.Delete(ctx, "unlent"), gorm.ErrRecordNotFound))
+}
+
+func Test_BookRepository_Update(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
+
+	bookRepo := NewBookRepository(db)
+	book := saveTestBooks(bookRepo, entities.Book{
+		Isbn:           "test",
 		Title:          "test",
 		Author:         "test",
 		AvailableUnits: 2,
-	}
+	})[0]
 
-	newBook := entities.Book{
-		Isbn:           "test1",
+	book.Isbn = "renamed"
+	book.Title = "title"
+	book.AvailableUnits = 5
+	assert.Nil(t, bookRepo.Update(ctx, book))
+	found, err := bookRepo.Find(ctx, "renamed")
+	assert.Nil(t, err)
+	assert.Equal(t, "title", found.Title)
+	assert.Equal(t, uint(2), found.AvailableUnits)
+}
+
+func Test_UnitOfWork_RollsBackOnError(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
+
+	bookRepo := NewBookRepository(db)
+	book := saveTestBooks(bookRepo, entities.Book{
+		Isbn:           "test",
 		Title:          "test",
 		Author:         "test",
-		AvailableUnits: 2,
+		AvailableUnits: 1,
+	})[0]
+
+	err := NewUnitOfWork(db).Transaction(ctx, func(store Store) error {
+		if _, err := store.Items().Claim(ctx, book.ID, entities.ItemOnLoan); err != nil {
+			return err
+		}
+		return errors.New("failure after the copy was taken")
+	})
+	assert.NotNil(t, err)
+
+	found, _ := bookRepo.Find(ctx, "test")
+	assert.Equal(t, uint(1), found.AvailableUnits)
+	item, _ := NewItemRepository(db).Find(ctx, "test-1")
+	assert.Equal(t, entities.ItemAvailable, item.Status)
+}
+
+// Many patrons try to check out the same book at once, only as many as there are copies may succeed
+func Test_UnitOfWork_ConcurrentCheckouts(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
+
+	const (
+		units   = 3
+		patrons = 20
+		isbn    = "concurrent"
+	)
+
+	bookRepo := NewBookRepository(db)
+	userRepo := NewUserRepository(db)
+	book := saveTestBooks(bookRepo, entities.Book{
+		Isbn:           isbn,
+		Title:          "test",
+		Author:         "test",
+		AvailableUnits: units,
+	})[0]
+
+	users := make([]entities.User, patrons)
+	for i := range users {
+		userRepo.Save(ctx, entities.User{Email: fmt.Sprintf("email%d", i)})
+		users[i], _ = userRepo.FindByEmail(ctx, fmt.Sprintf("email%d", i))
 	}
 
-	user := entities.User{
-		Email: "email",
-		ReturnedBooks: []entities.Book{
-			oldBook,
-		},
+	uow := NewUnitOfWork(db)
+	errs := make(chan error, patrons)
+	var wg sync.WaitGroup
+	for _, user := range users {
+		wg.Add(1)
+		go func(user entities.User) {
+			defer wg.Done()
+			errs <- uow.Transaction(ctx, func(store Store) error {
+				item, err := store.Items().Claim(ctx, book.ID, entities.ItemOnLoan)
+				if err != nil {
+					return err
+				}
+				now := time.Now()
+				return store.Loans().Create(ctx, entities.Loan{
+					UserID:     user.ID,
+					BookID:     book.ID,
+					ItemID:     &item.ID,
+					BorrowedAt: now,
+					DueAt:      now.Add(entities.DefaultLoanPeriod),
+				})
+			})
+		}(user)
 	}
+	wg.Wait()
+	close(errs)
+
+	taken := 0
+	for err := range errs {
+		if err == nil {
+			taken++
+			continue
+		}
+		assert.Equal(t, ErrNoAvailableUnits, err)
+	}
+	assert.Equal(t, units, taken)
+
+	found, _ := bookRepo.Find(ctx, isbn)
+	assert.Equal(t, uint(0), found.AvailableUnits)
+	assert.True(t, bookRepo.IsBookTaken(ctx, isbn))
+
+	var loans int64
+	db.Connection.Model(&entities.Loan{}).Where("book_id = ?", book.ID).Distinct("item_id").Count(&loans)
+	assert.Equal(t, int64(units), loans)
+}
+
+// concurrently runs the function n times at once and returns how many runs succeeded.
+// The failed runs must fail with the expected error.
+func concurrently(t *testing.T, n int, expected error, fn func(i int) error) int {
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- fn(i)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		assert.Equal(t, expected, err)
+	}
+	return succeeded
+}
+
+func Test_UserRepository_Lock_ConcurrentPayments(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
 
 	userRepo := NewUserRepository(db)
+	ledgerRepo := NewLedgerRepository(db)
+	userRepo.Save(ctx, entities.User{Email: "email"})
+	user, _ := userRepo.FindByEmail(ctx, "email")
+	ledgerRepo.Create(ctx, entities.LedgerEntry{UserID: user.ID, Kind: entities.LedgerCharge, Amount: 100})
+
+	errOverpaid := errors.New("overpaid")
+	uow := NewUnitOfWork(db)
+	paid := concurrently(t, 10, errOverpaid, func(int) error {
+		return uow.Transaction(ctx, func(store Store) error {
+			if err := store.Users().Lock(ctx, user.ID); err != nil {
+				return err
+			}
+			balance, err := store.Ledger().Balance(ctx, user.ID)
+			if err != nil {
+				return err
+			}
+			if balance < 30 {
+				return errOverpaid
+			}
+			_, err = store.Ledger().Create(ctx, entities.LedgerEntry{UserID: user.ID, Kind: entities.LedgerPayment, Amount: 30})
+			return err
+		})
+	})
+
+	assert.Equal(t, 3, paid)
+	balance, _ := ledgerRepo.Balance(ctx, user.ID)
+	assert.Equal(t, int64(10), balance)
+}
+
+func Test_LoginAttemptRepository_Lock_ConcurrentGuesses(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
+
+	//every guess checks the counter and counts its failure while it holds the lock
+	errBlocked := errors.New("blocked")
+	uow := NewUnitOfWork(db)
+	guessed := concurrently(t, 10, errBlocked, func(int) error {
+		return uow.Transaction(ctx, func(store Store) error {
+			attempt, err := store.LoginAttempts().Lock(ctx, entities.LoginScopeAccount, "email")
+			if err != nil {
+				return err
+			}
+			if attempt.Failures >= 3 {
+				return errBlocked
+			}
+			_, err = store.LoginAttempts().Increment(ctx, entities.LoginScopeAccount, "email")
+			return err
+		})
+	})
+
+	assert.Equal(t, 3, guessed)
+	attempt, err := NewLoginAttemptRepository(db).Find(ctx, entities.LoginScopeAccount, "email")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(3), attempt.Failures)
+}
+
+func Test_HoldRepository_Create_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
+
+	const patrons = 5
+
+	userRepo := NewUserRepository(db)
+	holdRepo := NewHoldRepository(db)
+	book := saveTestBooks(NewBookRepository(db), entities.Book{
+		Isbn:   "test",
+		Title:  "test",
+		Author: "test",
+	})[0]
+	users := make([]entities.User, patrons)
+	for i := range users {
+		userRepo.Save(ctx, entities.User{Email: fmt.Sprintf("email%d", i)})
+		users[i], _ = userRepo.FindByEmail(ctx, fmt.Sprintf("email%d", i))
+	}
+
+	//every patron asks twice, the second hold of a patron must be turned away
+	errOnHold := errors.New("already on hold")
+	uow := NewUnitOfWork(db)
+	placed := concurrently(t, patrons*2, errOnHold, func(i int) error {
+		user := users[i%patrons]
+		return uow.Transaction(ctx, func(store Store) error {
+			if err := store.Users().Lock(ctx, user.ID); err != nil {
+				return err
+			}
+			if err := store.Books().Lock(ctx, book.ID); err != nil {
+				return err
+			}
+			active, err := store.Holds().CountActive(ctx, user.ID, book.ID)
+			if err != nil {
+				return err
+			}
+			if active > 0 {
+				return errOnHold
+			}
+			_, err = store.Holds().Create(ctx, entities.Hold{UserID: user.ID, BookID: book.ID})
+			return err
+		})
+	})
+
+	assert.Equal(t, patrons, placed)
+	queue, _ := holdRepo.FindWaiting(ctx, book.ID)
+	assert.Equal(t, patrons, len(queue))
+	for i, hold := range queue {
+		assert.Equal(t, uint(i+1), hold.Position)
+	}
+}
+
+func Test_UserRepository_LockAdmins_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
+
+	userRepo := NewUserRepository(db)
+	admins := make([]entities.User, 2)
+	for i := range admins {
+		userRepo.Save(ctx, entities.User{Email: fmt.Sprintf("admin%d", i), Role: entities.RoleAdmin})
+		admins[i], _ = userRepo.FindByEmail(ctx, fmt.Sprintf("admin%d", i))
+	}
+
+	//each admin demotes the other one, the library must keep one of them
+	errLastAdmin := errors.New("last admin")
+	uow := NewUnitOfWork(db)
+	demoted := concurrently(t, len(admins), errLastAdmin, func(i int) error {
+		return uow.Transaction(ctx, func(store Store) error {
+			if err := store.Users().LockAdmins(ctx); err != nil {
+				return err
+			}
+			if err := store.Users().SetRole(ctx, admins[i].ID, entities.RoleUser); err != nil {
+				return err
+			}
+			count, err := store.Users().CountAdmins(ctx)
+			if err != nil {
+				return err
+			}
+			if count == 0 {
+				return errLastAdmin
+			}
+			return nil
+		})
+	})
+
+	assert.Equal(t, 1, demoted)
+	count, _ := userRepo.CountAdmins(ctx)
+	assert.Equal(t, int64(1), count)
+}
+
+func Test_HoldRepository_Queue(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
+
+	userRepo := NewUserRepository(db)
+	holdRepo := NewHoldRepository(db)
+	book := saveTestBooks(NewBookRepository(db), entities.Book{
+		Isbn:   "test",
+		Title:  "test",
+		Author: "test",
+	})[0]
+	itemRepo := NewItemRepository(db)
+	items := make([]entities.Item, 3)
+	for i := range items {
+		items[i], _ = itemRepo.Create(ctx, entities.Item{Barcode: fmt.Sprintf("000%d", i), BookID: book.ID})
+	}
+	users := make([]entities.User, 3)
+	holds := make([]entities.Hold, 3)
+	for i := range users {
+		userRepo.Save(ctx, entities.User{Email: fmt.Sprintf("email%d", i)})
+		users[i], _ = userRepo.FindByEmail(ctx, fmt.Sprintf("email%d", i))
+		hold, err := holdRepo.Create(ctx, entities.Hold{UserID: users[i].ID, BookID: book.ID})
+		assert.Nil(t, err)
+		assert.Equal(t, uint(i+1), hold.Position)
+		assert.Equal(t, entities.HoldWaiting, hold.Status)
+		holds[i] = hold
+	}
+	active, _ := holdRepo.CountActive(ctx, users[0].ID, book.ID)
+	assert.Equal(t, int64(1), active)
+	held, _ := holdRepo.CountByUser(ctx, users[0].ID)
+	assert.Equal(t, int64(1), held)
+
+	//the last hold jumps the queue
+	assert.Nil(t, holdRepo.SetPosition(ctx, holds[2].ID, book.ID, 0))
+	waiting, _ := holdRepo.FindWaiting(ctx, book.ID)
+	assert.Equal(t, holds[2].ID, waiting[0].ID)
+	assert.Equal(t, "email2", waiting[0].Patron)
+
+	now := time.Now()
+	assert.Nil(t, holdRepo.AllocateNext(ctx, book.ID, items[0].ID, now.Add(time.Hour)))
+	_, err := holdRepo.Fulfil(ctx, users[0].ID, book.ID, now)
+	assert.Equal(t, ErrHoldNotReady, err)
+	fulfilled, err := holdRepo.Fulfil(ctx, users[2].ID, book.ID, now)
+	assert.Nil(t, err)
+	assert.Equal(t, entities.HoldFulfilled, fulfilled.Status)
+	assert.Equal(t, items[0].ID, *fulfilled.ItemID)
+
+	assert.Nil(t, holdRepo.AllocateNext(ctx, book.ID, items[1].ID, now))
+	expired, _ := holdRepo.FindExpired(ctx, now)
+	assert.Len(t, expired, 1)
+	assert.Equal(t, users[0].ID, expired[0].UserID)
+	_, err = holdRepo.Fulfil(ctx, users[0].ID, book.ID, now)
+	assert.Equal(t, ErrHoldNotReady, err)
+	assert.Nil(t, holdRepo.Expire(ctx, expired[0], now))
+	assert.Equal(t, ErrHoldNotFound, holdRepo.Expire(ctx, expired[0], now))
+
+	assert.Nil(t, holdRepo.AllocateNext(ctx, book.ID, items[2].ID, now.Add(time.Hour)))
+	assert.Equal(t, ErrNoWaitingHolds, holdRepo.AllocateNext(ctx, book.ID, items[2].ID, now.Add(time.Hour)))
+	queue, _ := holdRepo.FindQueue(ctx, book.ID)
+	assert.Len(t, queue, 1)
+	assert.Equal(t, entities.HoldReady, queue[0].Status)
+
+	cancelled, err := holdRepo.Cancel(ctx, users[1].ID, book.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, items[2].ID, *cancelled.ItemID)
+	_, err = holdRepo.Cancel(ctx, users[1].ID, book.ID)
+	assert.Equal(t, ErrHoldNotFound, err)
+	userHolds, _ := holdRepo.FindByUser(ctx, users[1].ID)
+	assert.Empty(t, userHolds)
+}
+
+func Test_RefreshTokenRepository_Rotation(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
+
+	repo := NewRefreshTokenRepository(db)
+	created, err := repo.Create(ctx, 1, "family", "hash", time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+
+	found, err := repo.FindByHash(ctx, "hash")
+	assert.Nil(t, err)
+	assert.Equal(t, created.ID, found.ID)
+	assert.False(t, found.Rotated)
+
+	assert.Nil(t, repo.MarkRotated(ctx, found))
+	assert.Equal(t, ErrRefreshTokenRotated, repo.MarkRotated(ctx, found))
+
+	assert.Nil(t, repo.DeleteFamily(ctx, "family"))
+	_, err = repo.FindByHash(ctx, "hash")
+	assert.NotNil(t, err)
+
+	_, err = repo.Create(ctx, 1, "other", "other", time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Nil(t, repo.DeleteByUser(ctx, 1))
+	_, err = repo.FindByHash(ctx, "other")
+	assert.NotNil(t, err)
+}
+
+func Test_OneTimeTokenRepository_Consume(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
+
+	repo := NewOneTimeTokenRepository(db)
+	now := time.Now()
+	assert.Nil(t, repo.Create(ctx, 1, entities.TokenPasswordReset, "hash", now.Add(time.Hour)))
+	assert.Nil(t, repo.Create(ctx, 1, entities.TokenPasswordReset, "expired", now.Add(-time.Minute)))
+
+	_, err := repo.Consume(ctx, "other", "hash", now)
+	assert.Equal(t, ErrInvalidToken, err)
+	_, err = repo.Consume(ctx, entities.TokenPasswordReset, "expired", now)
+	assert.Equal(t, ErrInvalidToken, err)
+
+	token, err := repo.Consume(ctx, entities.TokenPasswordReset, "hash", now)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), token.UserID)
+	assert.NotNil(t, token.UsedAt)
+	_, err = repo.Consume(ctx, entities.TokenPasswordReset, "hash", now)
+	assert.Equal(t, ErrInvalidToken, err)
+
+	assert.Nil(t, repo.Create(ctx, 1, entities.TokenPasswordReset, "unused", now.Add(time.Hour)))
+	assert.Nil(t, repo.DeleteByUser(ctx, 1, entities.TokenPasswordReset))
+	_, err = repo.Consume(ctx, entities.TokenPasswordReset, "unused", now)
+	assert.Equal(t, ErrInvalidToken, err)
+}
+
+func Test_LoginAttemptRepository(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
+
+	repo := NewLoginAttemptRepository(db)
+	now := time.Now()
+
+	_, err := repo.Find(ctx, entities.LoginScopeAccount, "email")
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+
+	attempt, err := repo.Increment(ctx, entities.LoginScopeAccount, "email")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), attempt.Failures)
+	attempt, err = repo.Increment(ctx, entities.LoginScopeAccount, "email")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(2), attempt.Failures)
+	address, err := repo.Increment(ctx, entities.LoginScopeAddress, "email")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), address.Failures)
+
+	until := now.Add(time.Hour)
+	attempt.LastFailure = now
+	attempt.BlockedUntil = &until
+	attempt.Locked = true
+	assert.Nil(t, repo.Save(ctx, attempt))
+	attempt, err = repo.Find(ctx, entities.LoginScopeAccount, "email")
+	assert.Nil(t, err)
+	assert.True(t, attempt.Locked)
+	assert.True(t, attempt.BlockedAt(now))
+
+	locked, err := repo.FindLocked(ctx, now)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(locked))
+	assert.Equal(t, entities.LoginScopeAccount, locked[0].Scope)
+	locked, _ = repo.FindLocked(ctx, until.Add(time.Second))
+	assert.Equal(t, 0, len(locked))
+
+	assert.Nil(t, repo.Delete(ctx, entities.LoginScopeAccount, "email"))
+	assert.Equal(t, gorm.ErrRecordNotFound, repo.Delete(ctx, entities.LoginScopeAccount, "email"))
+	_, err = repo.Find(ctx, entities.LoginScopeAddress, "email")
+	assert.Nil(t, err)
+
+	attempt, err = repo.Lock(ctx, entities.LoginScopeAccount, "unknown")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(0), attempt.Failures)
+	stale := entities.LoginAttempt{Scope: entities.LoginScopeAccount, Subject: "stale", Failures: 2, LastFailure: now.Add(-2 * time.Hour)}
+	assert.Nil(t, repo.Save(ctx, stale))
+	lockedOut := entities.LoginAttempt{Scope: entities.LoginScopeAccount, Subject: "locked", Failures: 9, LastFailure: now.Add(-2 * time.Hour), BlockedUntil: &until, Locked: true}
+	assert.Nil(t, repo.Save(ctx, lockedOut))
+	recent := entities.LoginAttempt{Scope: entities.LoginScopeAccount, Subject: "recent", Failures: 1, LastFailure: now}
+	assert.Nil(t, repo.Save(ctx, recent))
+
+	deleted, err := repo.DeleteStale(ctx, entities.LoginScopeAccount, now.Add(-time.Hour), now)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), deleted)
+	for _, subject := range []string{"unknown", "stale"} {
+		_, err = repo.Find(ctx, entities.LoginScopeAccount, subject)
+		assert.Equal(t, gorm.ErrRecordNotFound, err, subject)
+	}
+	for _, subject := range []string{"locked", "recent"} {
+		_, err = repo.Find(ctx, entities.LoginScopeAccount, subject)
+		assert.Nil(t, err, subject)
+	}
+	_, err = repo.Find(ctx, entities.LoginScopeAddress, "email")
+	assert.Nil(t, err)
+}
+
+func Test_AuditRepository(t *testing.T) {
+	ctx := context.Background()
+	db = newTestDatabaseConnection()
+	defer clearDatabase()
+
+	repo := NewAuditRepository(db)
+	actor := uint(7)
+	assert.Nil(t, repo.Record(ctx, entities.AuditEvent{Action: entities.AuditAccountLocked, Subject: "email"}))
+	assert.Nil(t, repo.Record(ctx, entities.AuditEvent{Action: entities.AuditAccountUnlocked, Subject: "email", ActorID: &actor}))
+	assert.Nil(t, repo.Record(ctx, entities.AuditEvent{Action: entities.AuditAddressLocked, Subject: "127.0.0.1"}))
 
-	userRepo.Save(user)
-	userRepo.UpdateReturnedBooks(user, []entities.Book{newBook})
+	events, total, err := repo.FindAll(ctx, Page{Number: 1, Size: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), total)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, entities.AuditAddressLocked, events[0].Action)
+	assert.Equal(t, entities.AuditAccountUnlocked, events[1].Action)
+	assert.Equal(t, actor, *events[1].ActorID)
 
-	user, _ = userRepo.FindByEmail("email")
-	assertEqualBooks(t, newBook, user.ReturnedBooks[0])
+	events, _, _ = repo.FindAll(ctx, Page{Number: 2, Size: 2})
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, entities.AuditAccountLocked, events[0].Action)
+	assert.Nil(t, events[0].ActorID)
 }
 
 func assertEqualUsers(t *testing.T, expected entities.User, actual entities.User) {
 	assert.Equal(t, expected.Email, actual.Email)
+	assert.Equal(t, len(expected.TakenBooks), len(actual.TakenBooks))
+	assert.Equal(t, len(expected.ReturnedBooks), len(actual.ReturnedBooks))
 	for i, book := range actual.TakenBooks {
 		assertEqualBooks(t, expected.TakenBooks[i], book)
 	}
@@ -0,0 +1,75 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mishozz/Library/config"
+	"github.com/mishozz/Library/entities"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrRoleInUse is returned when a role which users still have is deleted
+var ErrRoleInUse = errors.New("role in use")
+
+type RoleRepository interface {
+	Find(ctx context.Context, name string) (entities.Role, error)
+	FindAll(ctx context.Context) ([]entities.Role, error)
+	Save(ctx context.Context, role entities.Role) error
+	Delete(ctx context.Context, name string) error
+}
+
+type roleRepository struct {
+	connection *gorm.DB
+}
+
+func NewRoleRepository(db config.Database) *roleRepository {
+	return &roleRepository{
+		connection: db.Connection,
+	}
+}
+
+func (r *roleRepository) Find(ctx context.Context, name string) (entities.Role, error) {
+	var role entities.Role
+	err := r.connection.WithContext(ctx).Where("name = ?", name).First(&role).Error
+	return role, err
+}
+
+func (r *roleRepository) FindAll(ctx context.Context) ([]entities.Role, error) {
+	var roles []entities.Role
+	err := r.connection.WithContext(ctx).Order("name").Find(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// Save creates the role or replaces the permissions of the role with the same name
+func (r *roleRepository) Save(ctx context.Context, role entities.Role) error {
+	return r.connection.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"permissions"}),
+	}).Create(&role).Error
+}
+
+// Delete removes a role which no user has
+func (r *roleRepository) Delete(ctx context.Context, name string) error {
+	return r.connection.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		db := tx.Where("name = ?", name).Delete(&entities.Role{})
+		if db.Error != nil {
+			return db.Error
+		}
+		if db.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		var users int64
+		if err := tx.Model(&entities.User{}).Where("role = ?", name).Count(&users).Error; err != nil {
+			return err
+		}
+		if users > 0 {
+			return ErrRoleInUse
+		}
+		return nil
+	})
+}
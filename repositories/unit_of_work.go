@@ -0,0 +1,92 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/mishozz/Library/config"
+	"gorm.io/gorm"
+)
+
+// Store gives access to repositories which share the same database transaction
+type Store interface {
+	Books() BookRepository
+	Users() UserRepository
+	Loans() LoanRepository
+	Holds() HoldRepository
+	Items() ItemRepository
+	Ledger() LedgerRepository
+	Categories() CategoryRepository
+	Tokens() OneTimeTokenRepository
+	LoginAttempts() LoginAttemptRepository
+	Audit() AuditRepository
+}
+
+// UnitOfWork runs a function in a single database transaction. The transaction is
+// committed when the function returns nil and rolled back otherwise, or when the context
+// is done before it commits.
+type UnitOfWork interface {
+	Transaction(ctx context.Context, fn func(store Store) error) error
+}
+
+type unitOfWork struct {
+	connection *gorm.DB
+	index      bookIndex
+}
+
+func NewUnitOfWork(db config.Database) *unitOfWork {
+	return &unitOfWork{
+		connection: db.Connection,
+		index:      newBookIndex(db.FullTextSearch),
+	}
+}
+
+func (u *unitOfWork) Transaction(ctx context.Context, fn func(store Store) error) error {
+	return u.connection.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&store{connection: tx, index: u.index})
+	})
+}
+
+type store struct {
+	connection *gorm.DB
+	index      bookIndex
+}
+
+func (s *store) Books() BookRepository {
+	return &BookRepositoryImpl{connection: s.connection, index: s.index}
+}
+
+func (s *store) Users() UserRepository {
+	return &userRepository{connection: s.connection}
+}
+
+func (s *store) Loans() LoanRepository {
+	return &loanRepository{connection: s.connection}
+}
+
+func (s *store) Holds() HoldRepository {
+	return &holdRepository{connection: s.connection}
+}
+
+func (s *store) Items() ItemRepository {
+	return &itemRepository{connection: s.connection}
+}
+
+func (s *store) Ledger() LedgerRepository {
+	return &ledgerRepository{connection: s.connection}
+}
+
+func (s *store) Categories() CategoryRepository {
+	return &categoryRepository{connection: s.connection}
+}
+
+func (s *store) Tokens() OneTimeTokenRepository {
+	return &oneTimeTokenRepository{connection: s.connection}
+}
+
+func (s *store) LoginAttempts() LoginAttemptRepository {
+	return &loginAttemptRepository{connection: s.connection}
+}
+
+func (s *store) Audit() AuditRepository {
+	return &auditRepository{connection: s.connection}
+}
@@ -1,17 +1,29 @@
 package repositories
 
 import (
+	"context"
+	"time"
+
 	"github.com/mishozz/Library/config"
 	"github.com/mishozz/Library/entities"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UserRepository interface {
-	Save(user entities.User) error
-	FindByEmail(email string) (entities.User, error)
-	FindAll() ([]entities.User, error)
-	UpdateTakenBooks(user entities.User, takenBooks []entities.Book) error
-	UpdateReturnedBooks(user entities.User, returnedBooks []entities.Book) error
+	Save(ctx context.Context, user entities.User) error
+	FindByEmail(ctx context.Context, email string) (entities.User, error)
+	FindAll(ctx context.Context, query UserQuery) ([]entities.User, int64, error)
+	SetCategory(ctx context.Context, userId uint, category string) error
+	SetLoanLimit(ctx context.Context, userId uint, limit *uint) error
+	SetRole(ctx context.Context, userId uint, role string) error
+	SetDisabled(ctx context.Context, userId uint, disabled bool) error
+	SetPassword(ctx context.Context, userId uint, password string) error
+	SetVerified(ctx context.Context, userId uint) error
+	Delete(ctx context.Context, userId uint) error
+	CountAdmins(ctx context.Context) (int64, error)
+	Lock(ctx context.Context, userId uint) error
+	LockAdmins(ctx context.Context) error
 }
 
 type userRepository struct {
@@ -24,56 +36,177 @@ func NewUserRepository(db config.Database) *userRepository {
 	}
 }
 
-func (r *userRepository) Save(user entities.User) error {
-	return r.connection.Debug().Create(&user).Error
+func (r *userRepository) Save(ctx context.Context, user entities.User) error {
+	return r.connection.WithContext(ctx).Create(&user).Error
 }
 
-func (r *userRepository) FindByEmail(email string) (entities.User, error) {
+func (r *userRepository) FindByEmail(ctx context.Context, email string) (entities.User, error) {
 	var user entities.User
-	err := r.connection.Where("Email = ?", email).First(&user).Error
-	if err != nil {
-		return user, err
-	}
-	err = r.connection.Model(&user).Association("TakenBooks").Find(&user.TakenBooks)
+	err := r.connection.WithContext(ctx).Where("email = ?", email).First(&user).Error
 	if err != nil {
 		return user, err
 	}
-	err = r.connection.Model(&user).Association("ReturnedBooks").Find(&user.ReturnedBooks)
+	users := []entities.User{user}
+	err = r.loadBooks(ctx, users)
 	if err != nil {
 		return user, err
 	}
-	return user, nil
+	return users[0], nil
 }
 
-func (r *userRepository) FindAll() ([]entities.User, error) {
-	var users []entities.User
-	err := r.connection.Preload("TakenBooks").Preload("ReturnedBooks").Find(&users).Error
-	if err != nil {
-		return nil, err
-	}
-	return users, nil
+var userSortColumns = map[string]string{
+	"email":   "email",
+	"created": "created_at",
 }
 
-func (r *userRepository) UpdateTakenBooks(user entities.User, takenBooks []entities.Book) error {
-	err := r.connection.Model(&user).Association("TakenBooks").Clear()
+// hasOverdue matches the users with a loan which is still out after its due date
+const hasOverdue = "EXISTS (SELECT 1 FROM loans WHERE loans.user_id = users.id AND loans.returned_at IS NULL AND loans.deleted_at IS NULL AND loans.due_at < ?)"
+
+// FindAll returns the page of the users matching the query and the number of all matching users
+func (r *userRepository) FindAll(ctx context.Context, query UserQuery) ([]entities.User, int64, error) {
+	if err := validateSort(query.Sort, userSortColumns); err != nil {
+		return nil, 0, err
+	}
+	now := time.Now()
+	filter := func(db *gorm.DB) *gorm.DB {
+		if query.Email != "" {
+			db = db.Where(containsText("email"), likeContains(query.Email))
+		}
+		if query.HasOverdue != nil && *query.HasOverdue {
+			db = db.Where(hasOverdue, now)
+		}
+		if query.HasOverdue != nil && !*query.HasOverdue {
+			db = db.Where("NOT "+hasOverdue, now)
+		}
+		return db
+	}
+
+	var total int64
+	err := r.connection.WithContext(ctx).Model(&entities.User{}).Scopes(filter).Count(&total).Error
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
-	err = r.connection.Model(&user).Association("TakenBooks").Append(takenBooks)
+	var users []entities.User
+	err = r.connection.WithContext(ctx).Scopes(filter, paginate(query.Sort, userSortColumns, query.Page)).Find(&users).Error
 	if err != nil {
-		return err
+		return nil, 0, err
+	}
+	err = r.loadBooks(ctx, users)
+	if err != nil {
+		return nil, 0, err
 	}
-	return err
+	return users, total, nil
 }
 
-func (r *userRepository) UpdateReturnedBooks(user entities.User, returnedBooks []entities.Book) error {
-	err := r.connection.Model(&user).Association("ReturnedBooks").Clear()
-	if err != nil {
-		return err
+// loadBooks derives the taken and returned books of the users from their loans.
+// A book is returned when the user has borrowed it before but does not hold it now.
+func (r *userRepository) loadBooks(ctx context.Context, users []entities.User) error {
+	if len(users) == 0 {
+		return nil
 	}
-	err = r.connection.Model(&user).Association("ReturnedBooks").Append(returnedBooks)
+	ids := make([]uint, len(users))
+	byId := make(map[uint]*entities.User, len(users))
+	for i := range users {
+		ids[i] = users[i].ID
+		byId[users[i].ID] = &users[i]
+	}
+
+	loans, err := (&loanRepository{connection: r.connection}).FindByUsers(ctx, ids)
 	if err != nil {
 		return err
 	}
+
+	taken := make(map[uint]map[uint]bool, len(users))
+	for _, loan := range loans {
+		if loan.ReturnedAt != nil {
+			continue
+		}
+		if taken[loan.UserID] == nil {
+			taken[loan.UserID] = map[uint]bool{}
+		}
+		taken[loan.UserID][loan.BookID] = true
+		user := byId[loan.UserID]
+		user.TakenBooks = append(user.TakenBooks, loan.Book)
+	}
+
+	returned := make(map[uint]map[uint]bool, len(users))
+	for _, loan := range loans {
+		if loan.ReturnedAt == nil || taken[loan.UserID][loan.BookID] || returned[loan.UserID][loan.BookID] {
+			continue
+		}
+		if returned[loan.UserID] == nil {
+			returned[loan.UserID] = map[uint]bool{}
+		}
+		returned[loan.UserID][loan.BookID] = true
+		user := byId[loan.UserID]
+		user.ReturnedBooks = append(user.ReturnedBooks, loan.Book)
+	}
 	return nil
 }
+
+func (r *userRepository) SetCategory(ctx context.Context, userId uint, category string) error {
+	return r.connection.WithContext(ctx).Model(&entities.User{}).Where("id = ?", userId).Update("category", category).Error
+}
+
+// SetLoanLimit overrides the loan limit of the category of the user, a nil limit restores it
+func (r *userRepository) SetLoanLimit(ctx context.Context, userId uint, limit *uint) error {
+	return r.connection.WithContext(ctx).Model(&entities.User{}).Where("id = ?", userId).Update("loan_limit", limit).Error
+}
+
+func (r *userRepository) SetRole(ctx context.Context, userId uint, role string) error {
+	return r.connection.WithContext(ctx).Model(&entities.User{}).Where("id = ?", userId).Update("role", role).Error
+}
+
+func (r *userRepository) SetDisabled(ctx context.Context, userId uint, disabled bool) error {
+	return r.connection.WithContext(ctx).Model(&entities.User{}).Where("id = ?", userId).Update("disabled", disabled).Error
+}
+
+// SetPassword replaces the password of the user, the password must already be hashed
+func (r *userRepository) SetPassword(ctx context.Context, userId uint, password string) error {
+	return r.connection.WithContext(ctx).Model(&entities.User{}).Where("id = ?", userId).Update("password", password).Error
+}
+
+// SetVerified records that the user confirmed their email
+func (r *userRepository) SetVerified(ctx context.Context, userId uint) error {
+	return r.connection.WithContext(ctx).Model(&entities.User{}).Where("id = ?", userId).Update("verified", true).Error
+}
+
+// Delete removes the user and their holds from the library, their loans and ledger are kept
+// for the history. The row is deleted rather than marked deleted, so the email is free again.
+func (r *userRepository) Delete(ctx context.Context, userId uint) error {
+	if err := r.connection.WithContext(ctx).Where("user_id = ?", userId).Delete(&entities.Hold{}).Error; err != nil {
+		return err
+	}
+	return r.connection.WithContext(ctx).Unscoped().Delete(&entities.User{}, userId).Error
+}
+
+// CountAdmins counts the admins whose accounts are not disabled
+func (r *userRepository) CountAdmins(ctx context.Context) (int64, error) {
+	var count int64
+	err := r.connection.WithContext(ctx).Model(&entities.User{}).
+		Where("role = ? AND disabled = ?", entities.RoleAdmin, false).
+		Count(&count).Error
+	return count, err
+}
+
+// Lock locks the user until the end of the transaction, so the transactions which check
+// and then change what the patron owes or holds wait for each other. It must come before
+// the reads it protects. SQLite has no row locks, there every transaction takes the write
+// lock of the database when it begins.
+func (r *userRepository) Lock(ctx context.Context, userId uint) error {
+	var user entities.User
+	return r.connection.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id").
+		Take(&user, userId).Error
+}
+
+// LockAdmins locks the active admins until the end of the transaction, so two transactions
+// cannot each demote, disable or delete one of the last two admins
+func (r *userRepository) LockAdmins(ctx context.Context) error {
+	var ids []uint
+	return r.connection.WithContext(ctx).Model(&entities.User{}).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("role = ? AND disabled = ?", entities.RoleAdmin, false).
+		Order("id").
+		Pluck("id", &ids).Error
+}
@@ -1,37 +1,85 @@
 package router
 
 import (
+	"net/http"
+
 	"github.com/gin-gonic/gin"
+	"github.com/mishozz/Library/auth"
 	"github.com/mishozz/Library/controller"
+	"github.com/mishozz/Library/entities"
 	"github.com/mishozz/Library/middleware"
+	"github.com/mishozz/Library/repositories"
 )
 
 const (
 	libraryApiV1 = "/library/api/v1/"
-	ADMIN        = "Admin"
-	USER         = "User"
 )
 
 // HandleRequests handles all incoming http requests
-func HandleRequests(server *gin.Engine, bookController controller.BookController, userController controller.UserController, loginController controller.LoginController) {
+func HandleRequests(server *gin.Engine, bookController controller.BookController, userController controller.UserController, loginController controller.LoginController, loanController controller.LoanController, holdController controller.HoldController, itemController controller.ItemController, fineController controller.FineController, categoryController controller.CategoryController, accountController controller.AccountController, roleController controller.RoleController, passwordController controller.PasswordController, verificationController controller.VerificationController, lockoutController controller.LockoutController, signer *auth.Signer, authRepository repositories.AuthRepository, roleRepository repositories.RoleRepository) {
+	//can lets through the callers whose role grants the permission
+	can := func(permission string) gin.HandlerFunc {
+		return middleware.TokenPermissionMiddleware(signer, authRepository, roleRepository, permission)
+	}
 	apiRoutes := server.Group(libraryApiV1)
 	{
-		apiRoutes.GET("/books", middleware.TokenAuthMiddleware(), func(ctx *gin.Context) {
+		apiRoutes.GET("/books", can(entities.PermissionBooksRead), func(ctx *gin.Context) {
 			bookController.GetAll(ctx)
 		})
 
-		apiRoutes.GET("/books/:isbn", middleware.TokenAuthMiddleware(), func(ctx *gin.Context) {
+		//gin cannot route /books/search next to /books/:isbn, so the search is dispatched here
+		apiRoutes.GET("/books/:isbn", can(entities.PermissionBooksRead), func(ctx *gin.Context) {
+			if ctx.Param("isbn") == "search" {
+				bookController.Search(ctx)
+				return
+			}
 			bookController.GetByIsbn(ctx)
 		})
 
-		apiRoutes.DELETE("/books/:isbn", middleware.TokenRoleMiddleware(ADMIN), func(ctx *gin.Context) {
+		apiRoutes.DELETE("/books/:isbn", can(entities.PermissionBooksWrite), func(ctx *gin.Context) {
 			bookController.Delete(ctx)
 		})
 
-		apiRoutes.POST("/books", middleware.TokenRoleMiddleware(ADMIN), func(ctx *gin.Context) {
+		apiRoutes.POST("/books", can(entities.PermissionBooksWrite), func(ctx *gin.Context) {
 			bookController.Save(ctx)
 		})
 
+		apiRoutes.PUT("/books/:isbn", can(entities.PermissionBooksWrite), func(ctx *gin.Context) {
+			bookController.Update(ctx)
+		})
+
+		apiRoutes.PATCH("/books/:isbn", can(entities.PermissionBooksWrite), func(ctx *gin.Context) {
+			bookController.Patch(ctx)
+		})
+
+		apiRoutes.GET("/books/:isbn/holds", can(entities.PermissionHoldsManage), func(ctx *gin.Context) {
+			holdController.GetQueue(ctx)
+		})
+
+		apiRoutes.PUT("/books/:isbn/holds/:id", can(entities.PermissionHoldsManage), func(ctx *gin.Context) {
+			holdController.Reorder(ctx)
+		})
+
+		apiRoutes.GET("/books/:isbn/items", can(entities.PermissionItemsManage), func(ctx *gin.Context) {
+			itemController.GetByBook(ctx)
+		})
+
+		apiRoutes.POST("/books/:isbn/items", can(entities.PermissionItemsManage), func(ctx *gin.Context) {
+			itemController.Add(ctx)
+		})
+
+		apiRoutes.GET("/items/:barcode", can(entities.PermissionItemsManage), func(ctx *gin.Context) {
+			itemController.GetByBarcode(ctx)
+		})
+
+		apiRoutes.PATCH("/items/:barcode", can(entities.PermissionItemsManage), func(ctx *gin.Context) {
+			itemController.Update(ctx)
+		})
+
+		apiRoutes.DELETE("/items/:barcode", can(entities.PermissionItemsManage), func(ctx *gin.Context) {
+			itemController.Withdraw(ctx)
+		})
+
 		apiRoutes.POST("register", func(c *gin.Context) {
 			loginController.Register(c)
 		})
@@ -40,21 +88,113 @@ func HandleRequests(server *gin.Engine, bookController controller.BookController
 			loginController.Login(c)
 		})
 
-		apiRoutes.POST("logout", func(c *gin.Context) {
+		apiRoutes.POST("logout", middleware.TokenAuthMiddleware(signer, authRepository), func(c *gin.Context) {
 			loginController.LogOut(c)
 		})
 
-		apiRoutes.GET("users", middleware.TokenAuthMiddleware(), func(ctx *gin.Context) {
+		apiRoutes.POST("token/refresh", func(c *gin.Context) {
+			loginController.Refresh(c)
+		})
+
+		apiRoutes.POST("password/forgot", func(c *gin.Context) {
+			passwordController.Forgot(c)
+		})
+
+		apiRoutes.POST("password/reset", func(c *gin.Context) {
+			passwordController.Reset(c)
+		})
+
+		apiRoutes.GET("verify", func(c *gin.Context) {
+			verificationController.Verify(c)
+		})
+
+		apiRoutes.POST("verify/resend", func(c *gin.Context) {
+			verificationController.Resend(c)
+		})
+
+		apiRoutes.GET("users", can(entities.PermissionUsersRead), func(ctx *gin.Context) {
 			userController.GetAll(ctx)
 		})
-		apiRoutes.GET("users/:email", middleware.TokenAuthMiddleware(), func(ctx *gin.Context) {
+		apiRoutes.GET("users/:email", middleware.TokenAuthMiddleware(signer, authRepository), func(ctx *gin.Context) {
 			userController.GetByEmail(ctx)
 		})
-		apiRoutes.POST("users/:email/:isbn", middleware.TokenRoleMiddleware(USER), func(ctx *gin.Context) {
+		apiRoutes.GET("users/:email/loans", middleware.TokenAuthMiddleware(signer, authRepository), func(ctx *gin.Context) {
+			loanController.GetByUser(ctx)
+		})
+		apiRoutes.GET("users/:email/holds", middleware.TokenAuthMiddleware(signer, authRepository), func(ctx *gin.Context) {
+			holdController.GetByUser(ctx)
+		})
+		apiRoutes.GET("users/:email/fines", middleware.TokenAuthMiddleware(signer, authRepository), func(ctx *gin.Context) {
+			fineController.GetByUser(ctx)
+		})
+		apiRoutes.POST("users/:email/:isbn", middleware.TokenAuthMiddleware(signer, authRepository), func(ctx *gin.Context) {
 			userController.TakeBook(ctx)
 		})
-		apiRoutes.DELETE("users/:email/:isbn", middleware.TokenRoleMiddleware(USER), func(ctx *gin.Context) {
+		//gin cannot route /users/:email/loans/:isbn/renew next to /users/:email/:isbn, so the renewal is dispatched here
+		apiRoutes.POST("users/:email/:isbn/:book/renew", middleware.TokenAuthMiddleware(signer, authRepository), func(ctx *gin.Context) {
+			if ctx.Param("isbn") != "loans" {
+				ctx.Status(http.StatusNotFound)
+				return
+			}
+			ctx.Params = gin.Params{{Key: "email", Value: ctx.Param("email")}, {Key: "isbn", Value: ctx.Param("book")}}
+			loanController.Renew(ctx)
+		})
+		apiRoutes.DELETE("users/:email/:isbn", middleware.TokenAuthMiddleware(signer, authRepository), func(ctx *gin.Context) {
 			userController.ReturnBook(ctx)
 		})
+		apiRoutes.POST("holds/:email/:isbn", middleware.TokenAuthMiddleware(signer, authRepository), func(ctx *gin.Context) {
+			holdController.Place(ctx)
+		})
+		apiRoutes.DELETE("holds/:email/:isbn", middleware.TokenAuthMiddleware(signer, authRepository), func(ctx *gin.Context) {
+			holdController.Cancel(ctx)
+		})
+		apiRoutes.POST("fines/:email/payments", can(entities.PermissionFinesManage), func(ctx *gin.Context) {
+			fineController.Pay(ctx)
+		})
+		apiRoutes.POST("fines/:email/waivers", can(entities.PermissionFinesManage), func(ctx *gin.Context) {
+			fineController.Waive(ctx)
+		})
+		apiRoutes.GET("categories", middleware.TokenAuthMiddleware(signer, authRepository), func(ctx *gin.Context) {
+			categoryController.GetAll(ctx)
+		})
+		apiRoutes.POST("categories", can(entities.PermissionCategoriesManage), func(ctx *gin.Context) {
+			categoryController.Create(ctx)
+		})
+		apiRoutes.PUT("categories/:name", can(entities.PermissionCategoriesManage), func(ctx *gin.Context) {
+			categoryController.Update(ctx)
+		})
+		apiRoutes.PUT("users/:email/category", can(entities.PermissionUsersManage), func(ctx *gin.Context) {
+			categoryController.Assign(ctx)
+		})
+		apiRoutes.PUT("users/:email/loan-limit", can(entities.PermissionUsersManage), func(ctx *gin.Context) {
+			userController.SetLoanLimit(ctx)
+		})
+		apiRoutes.PUT("users/:email/role", can(entities.PermissionRolesManage), func(ctx *gin.Context) {
+			accountController.SetRole(ctx)
+		})
+		apiRoutes.PUT("users/:email/status", can(entities.PermissionUsersManage), func(ctx *gin.Context) {
+			accountController.SetStatus(ctx)
+		})
+		apiRoutes.DELETE("users/:email", can(entities.PermissionUsersManage), func(ctx *gin.Context) {
+			accountController.Delete(ctx)
+		})
+		apiRoutes.GET("lockouts", can(entities.PermissionUsersManage), func(ctx *gin.Context) {
+			lockoutController.GetAll(ctx)
+		})
+		apiRoutes.DELETE("lockouts/:scope/:subject", can(entities.PermissionUsersManage), func(ctx *gin.Context) {
+			lockoutController.Unlock(ctx)
+		})
+		apiRoutes.GET("audit", can(entities.PermissionUsersManage), func(ctx *gin.Context) {
+			lockoutController.GetAudit(ctx)
+		})
+		apiRoutes.GET("roles", can(entities.PermissionRolesManage), func(ctx *gin.Context) {
+			roleController.GetAll(ctx)
+		})
+		apiRoutes.PUT("roles/:name", can(entities.PermissionRolesManage), func(ctx *gin.Context) {
+			roleController.Save(ctx)
+		})
+		apiRoutes.DELETE("roles/:name", can(entities.PermissionRolesManage), func(ctx *gin.Context) {
+			roleController.Delete(ctx)
+		})
 	}
 }
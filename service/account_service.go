@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrNoAdmin is returned by the bootstrap when the library has no admin and none was configured
+	ErrNoAdmin = errors.New("no admin configured")
+	// ErrAdminPasswordRequired is returned by the bootstrap when the configured admin is not registered and has no password
+	ErrAdminPasswordRequired = errors.New("a password is required to create the admin")
+	// ErrLastAdmin is returned when a change would leave the library without an active admin
+	ErrLastAdmin = errors.New("last active admin")
+	// ErrUserHasLoans is returned when a user who still has books is deleted
+	ErrUserHasLoans = errors.New("user has books on loan")
+	// ErrUserOwesFines is returned when a user who still owes fines is deleted
+	ErrUserOwesFines = errors.New("user owes fines")
+)
+
+// AccountService manages the roles and the accounts of the users
+type AccountService interface {
+	BootstrapAdmin(ctx context.Context, email string, password string) error
+	SetRole(ctx context.Context, user entities.User, role string) error
+	SetDisabled(ctx context.Context, user entities.User, disabled bool) error
+	Delete(ctx context.Context, user entities.User) error
+}
+
+type accountService struct {
+	userRepository repositories.UserRepository
+	roleRepository repositories.RoleRepository
+	unitOfWork     repositories.UnitOfWork
+	tokenService   TokenService
+	bcryptCost     int
+}
+
+func NewAccountService(userRepository repositories.UserRepository, roleRepository repositories.RoleRepository, unitOfWork repositories.UnitOfWork, tokenService TokenService, bcryptCost int) *accountService {
+	return &accountService{
+		userRepository: userRepository,
+		roleRepository: roleRepository,
+		unitOfWork:     unitOfWork,
+		tokenService:   tokenService,
+		bcryptCost:     bcryptCost,
+	}
+}
+
+// BootstrapAdmin makes sure the library has an admin on its first run. The user with the
+// given email is promoted, or registered with the given password when they do not exist.
+// The operator vouches for the email, so the admin is verified either way.
+// Nothing changes once the library has an active admin.
+func (s *accountService) BootstrapAdmin(ctx context.Context, email string, password string) error {
+	admins, err := s.userRepository.CountAdmins(ctx)
+	if err != nil || admins > 0 {
+		return err
+	}
+	if email == "" {
+		return ErrNoAdmin
+	}
+	user, err := s.userRepository.FindByEmail(ctx, email)
+	if err == nil {
+		return s.unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+			if err := store.Users().SetRole(ctx, user.ID, entities.RoleAdmin); err != nil {
+				return err
+			}
+			if err := store.Users().SetVerified(ctx, user.ID); err != nil {
+				return err
+			}
+			return store.Users().SetDisabled(ctx, user.ID, false)
+		})
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if password == "" {
+		return ErrAdminPasswordRequired
+	}
+	hashed, err := hashPassword(password, s.bcryptCost)
+	if err != nil {
+		return err
+	}
+	return s.userRepository.Save(ctx, entities.User{
+		Email:    email,
+		Password: hashed,
+		Role:     entities.RoleAdmin,
+		Category: entities.CategoryStaff,
+		Verified: true,
+	})
+}
+
+// SetRole gives the user another role. Their sessions are revoked, so the new role
+// is in every token they get from now on.
+func (s *accountService) SetRole(ctx context.Context, user entities.User, name string) error {
+	role, err := s.roleRepository.Find(ctx, name)
+	if err != nil {
+		return err
+	}
+	if user.Role == role.Name {
+		return nil
+	}
+	err = s.unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+		if err := store.Users().LockAdmins(ctx); err != nil {
+			return err
+		}
+		if err := store.Users().SetRole(ctx, user.ID, role.Name); err != nil {
+			return err
+		}
+		return ensureAdmin(ctx, store)
+	})
+	if err != nil {
+		return err
+	}
+	return s.tokenService.RevokeUser(ctx, uint64(user.ID))
+}
+
+// SetDisabled disables or enables the account of the user. A disabled user cannot log in
+// and their sessions are revoked.
+func (s *accountService) SetDisabled(ctx context.Context, user entities.User, disabled bool) error {
+	err := s.unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+		if err := store.Users().LockAdmins(ctx); err != nil {
+			return err
+		}
+		if err := store.Users().SetDisabled(ctx, user.ID, disabled); err != nil {
+			return err
+		}
+		return ensureAdmin(ctx, store)
+	})
+	if err != nil || !disabled {
+		return err
+	}
+	return s.tokenService.RevokeUser(ctx, uint64(user.ID))
+}
+
+// Delete removes a user who has no books and owes nothing. Their active holds are
+// cancelled and the copies set aside for them go to the next holds in the queue.
+func (s *accountService) Delete(ctx context.Context, user entities.User) error {
+	now := time.Now()
+	err := s.unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+		if err := store.Users().LockAdmins(ctx); err != nil {
+			return err
+		}
+		if err := store.Users().Lock(ctx, user.ID); err != nil {
+			return err
+		}
+		loans, err := store.Loans().CountActive(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		if loans > 0 {
+			return ErrUserHasLoans
+		}
+		balance, err := store.Ledger().Balance(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		if balance > 0 {
+			return ErrUserOwesFines
+		}
+		holds, err := store.Holds().FindByUser(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		for _, hold := range holds {
+			cancelled, err := store.Holds().Cancel(ctx, user.ID, hold.BookID)
+			if err != nil {
+				return err
+			}
+			if cancelled.ItemID == nil {
+				continue
+			}
+			if err := releaseCopy(ctx, store, *cancelled.ItemID, now); err != nil {
+				return err
+			}
+		}
+		if err := store.Users().Delete(ctx, user.ID); err != nil {
+			return err
+		}
+		return ensureAdmin(ctx, store)
+	})
+	if err != nil {
+		return err
+	}
+	return s.tokenService.RevokeUser(ctx, uint64(user.ID))
+}
+
+// ensureAdmin fails when the changes of the transaction left the library without an active
+// admin. The transaction must lock the admins before it changes any user, otherwise two of
+// them could each remove one of the last two admins.
+func ensureAdmin(ctx context.Context, store repositories.Store) error {
+	admins, err := store.Users().CountAdmins(ctx)
+	if err != nil {
+		return err
+	}
+	if admins == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
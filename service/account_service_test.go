@@ -0,0 +1,356 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mishozz/Library/entities"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+// revokedTokens expects the sessions of the user to be revoked when revoked is true
+func revokedTokens(userId uint64, revoked bool) (*mockAuthRepository, *mockRefreshTokenRepository) {
+	mockAuth := &mockAuthRepository{}
+	mockRefresh := &mockRefreshTokenRepository{}
+	if revoked {
+		mockAuth.On("DeleteByUser", mock.Anything, userId).Return(nil).Once()
+		mockRefresh.On("DeleteByUser", mock.Anything, userId).Return(nil).Once()
+	}
+	return mockAuth, mockRefresh
+}
+
+func Test_NewAccountService(t *testing.T) {
+	service := NewAccountService(&mockUserRepository{}, &mockRoleRepository{}, &mockUnitOfWork{}, NewTokenService(&mockAuthRepository{}, &mockRefreshTokenRepository{}, testSigner, testRefreshTokenTTL), testBcryptCost)
+	assert.NotNil(t, service.userRepository)
+	assert.NotNil(t, service.roleRepository)
+	assert.NotNil(t, service.unitOfWork)
+	assert.NotNil(t, service.tokenService)
+}
+
+func Test_AccountService_BootstrapAdmin(t *testing.T) {
+	ctx := context.Background()
+	user := entities.User{Model: gorm.Model{ID: 1}, Email: "admin", Role: entities.RoleUser, Disabled: true}
+
+	tests := []struct {
+		name       string
+		email      string
+		password   string
+		mockUsers  func(m *mockUserRepository) *mockUserRepository
+		mockTxUser func(m *mockUserRepository) *mockUserRepository
+		err        error
+	}{{
+		name:  "library has an admin",
+		email: "admin",
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("CountAdmins", mock.Anything).Return(int64(1), nil)
+			return m
+		},
+		mockTxUser: func(m *mockUserRepository) *mockUserRepository {
+			return m
+		},
+	}, {
+		name: "no admin configured",
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("CountAdmins", mock.Anything).Return(int64(0), nil)
+			return m
+		},
+		mockTxUser: func(m *mockUserRepository) *mockUserRepository {
+			return m
+		},
+		err: ErrNoAdmin,
+	}, {
+		name:  "existing user is promoted",
+		email: "admin",
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("CountAdmins", mock.Anything).Return(int64(0), nil)
+			m.On("FindByEmail", mock.Anything, "admin").Return(user, nil)
+			return m
+		},
+		mockTxUser: func(m *mockUserRepository) *mockUserRepository {
+			m.On("SetRole", mock.Anything, uint(1), entities.RoleAdmin).Return(nil)
+			m.On("SetVerified", mock.Anything, uint(1)).Return(nil)
+			m.On("SetDisabled", mock.Anything, uint(1), false).Return(nil)
+			return m
+		},
+	}, {
+		name:  "new admin without password",
+		email: "admin",
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("CountAdmins", mock.Anything).Return(int64(0), nil)
+			m.On("FindByEmail", mock.Anything, "admin").Return(entities.User{}, gorm.ErrRecordNotFound)
+			return m
+		},
+		mockTxUser: func(m *mockUserRepository) *mockUserRepository {
+			return m
+		},
+		err: ErrAdminPasswordRequired,
+	}, {
+		name:     "new admin is registered",
+		email:    "admin",
+		password: "secret",
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("CountAdmins", mock.Anything).Return(int64(0), nil)
+			m.On("FindByEmail", mock.Anything, "admin").Return(entities.User{}, gorm.ErrRecordNotFound)
+			m.On("Save", mock.Anything, mock.MatchedBy(func(u entities.User) bool {
+				return u.Email == "admin" && u.Role == entities.RoleAdmin && u.Category == entities.CategoryStaff && u.Verified && u.Password != "secret"
+			})).Return(nil)
+			return m
+		},
+		mockTxUser: func(m *mockUserRepository) *mockUserRepository {
+			return m
+		},
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockUsers := tt.mockUsers(&mockUserRepository{})
+			mockTxUsers := tt.mockTxUser(&mockUserRepository{})
+			service := NewAccountService(mockUsers, &mockRoleRepository{}, &mockUnitOfWork{users: mockTxUsers}, NewTokenService(&mockAuthRepository{}, &mockRefreshTokenRepository{}, testSigner, testRefreshTokenTTL), testBcryptCost)
+
+			err := service.BootstrapAdmin(ctx, tt.email, tt.password)
+
+			assert.Equal(t, tt.err, err)
+			mockUsers.AssertExpectations(t)
+			mockTxUsers.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_AccountService_SetRole(t *testing.T) {
+	ctx := context.Background()
+	admin := entities.User{Model: gorm.Model{ID: 1}, Role: entities.RoleAdmin}
+
+	tests := []struct {
+		name      string
+		role      string
+		mockUsers func(m *mockUserRepository) *mockUserRepository
+		revoked   bool
+		err       error
+	}{{
+		name: "role unchanged",
+		role: entities.RoleAdmin,
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			return m
+		},
+	}, {
+		name: "demoted",
+		role: entities.RoleUser,
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("LockAdmins", mock.Anything).Return(nil)
+			m.On("SetRole", mock.Anything, uint(1), entities.RoleUser).Return(nil)
+			m.On("CountAdmins", mock.Anything).Return(int64(1), nil)
+			return m
+		},
+		revoked: true,
+	}, {
+		name: "last admin",
+		role: entities.RoleUser,
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("LockAdmins", mock.Anything).Return(nil)
+			m.On("SetRole", mock.Anything, uint(1), entities.RoleUser).Return(nil)
+			m.On("CountAdmins", mock.Anything).Return(int64(0), nil)
+			return m
+		},
+		err: ErrLastAdmin,
+	}, {
+		name: "librarian",
+		role: entities.RoleLibrarian,
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("LockAdmins", mock.Anything).Return(nil)
+			m.On("SetRole", mock.Anything, uint(1), entities.RoleLibrarian).Return(nil)
+			m.On("CountAdmins", mock.Anything).Return(int64(1), nil)
+			return m
+		},
+		revoked: true,
+	}, {
+		name: "unknown role",
+		role: "Janitor",
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			return m
+		},
+		err: gorm.ErrRecordNotFound,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockUsers := tt.mockUsers(&mockUserRepository{})
+			mockAuth, mockRefresh := revokedTokens(1, tt.revoked)
+			service := NewAccountService(&mockUserRepository{}, defaultRoles(), &mockUnitOfWork{users: mockUsers}, NewTokenService(mockAuth, mockRefresh, testSigner, testRefreshTokenTTL), testBcryptCost)
+
+			err := service.SetRole(ctx, admin, tt.role)
+
+			assert.Equal(t, tt.err, err)
+			mockUsers.AssertExpectations(t)
+			mockAuth.AssertExpectations(t)
+			mockRefresh.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_AccountService_SetDisabled(t *testing.T) {
+	ctx := context.Background()
+	user := entities.User{Model: gorm.Model{ID: 2}, Role: entities.RoleUser}
+
+	tests := []struct {
+		name     string
+		disabled bool
+		admins   int64
+		revoked  bool
+		err      error
+	}{{
+		name:     "disabled",
+		disabled: true,
+		admins:   1,
+		revoked:  true,
+	}, {
+		name:     "enabled",
+		disabled: false,
+		admins:   1,
+	}, {
+		name:     "last admin",
+		disabled: true,
+		admins:   0,
+		err:      ErrLastAdmin,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockUsers := &mockUserRepository{}
+			mockUsers.On("LockAdmins", mock.Anything).Return(nil)
+			mockUsers.On("SetDisabled", mock.Anything, uint(2), tt.disabled).Return(nil)
+			mockUsers.On("CountAdmins", mock.Anything).Return(tt.admins, nil)
+			mockAuth, mockRefresh := revokedTokens(2, tt.revoked)
+			service := NewAccountService(&mockUserRepository{}, &mockRoleRepository{}, &mockUnitOfWork{users: mockUsers}, NewTokenService(mockAuth, mockRefresh, testSigner, testRefreshTokenTTL), testBcryptCost)
+
+			err := service.SetDisabled(ctx, user, tt.disabled)
+
+			assert.Equal(t, tt.err, err)
+			mockUsers.AssertExpectations(t)
+			mockAuth.AssertExpectations(t)
+			mockRefresh.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_AccountService_Delete(t *testing.T) {
+	ctx := context.Background()
+	user := entities.User{Model: gorm.Model{ID: 2}, Role: entities.RoleUser}
+
+	tests := []struct {
+		name        string
+		mockLoans   func(m *mockLoanRepository) *mockLoanRepository
+		mockLedger  func(m *mockLedgerRepository) *mockLedgerRepository
+		mockHolds   func(m *mockHoldRepository) *mockHoldRepository
+		deleted     bool
+		mockAdmins  int64
+		revoked     bool
+		expectedErr error
+	}{{
+		name: "success",
+		mockLoans: func(m *mockLoanRepository) *mockLoanRepository {
+			m.On("CountActive", mock.Anything, uint(2)).Return(int64(0), nil)
+			return m
+		},
+		mockLedger: func(m *mockLedgerRepository) *mockLedgerRepository {
+			m.On("Balance", mock.Anything, uint(2)).Return(int64(0), nil)
+			return m
+		},
+		mockHolds: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("FindByUser", mock.Anything, uint(2)).Return([]entities.Hold{{UserID: 2, BookID: 7}}, nil)
+			m.On("Cancel", mock.Anything, uint(2), uint(7)).Return(entities.Hold{UserID: 2, BookID: 7}, nil)
+			return m
+		},
+		deleted:    true,
+		mockAdmins: 1,
+		revoked:    true,
+	}, {
+		name: "last admin",
+		mockLoans: func(m *mockLoanRepository) *mockLoanRepository {
+			m.On("CountActive", mock.Anything, uint(2)).Return(int64(0), nil)
+			return m
+		},
+		mockLedger: func(m *mockLedgerRepository) *mockLedgerRepository {
+			m.On("Balance", mock.Anything, uint(2)).Return(int64(0), nil)
+			return m
+		},
+		mockHolds: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("FindByUser", mock.Anything, uint(2)).Return([]entities.Hold{}, nil)
+			return m
+		},
+		deleted:     true,
+		expectedErr: ErrLastAdmin,
+	}, {
+		name: "books on loan",
+		mockLoans: func(m *mockLoanRepository) *mockLoanRepository {
+			m.On("CountActive", mock.Anything, uint(2)).Return(int64(1), nil)
+			return m
+		},
+		mockLedger: func(m *mockLedgerRepository) *mockLedgerRepository {
+			return m
+		},
+		mockHolds: func(m *mockHoldRepository) *mockHoldRepository {
+			return m
+		},
+		expectedErr: ErrUserHasLoans,
+	}, {
+		name: "owes fines",
+		mockLoans: func(m *mockLoanRepository) *mockLoanRepository {
+			m.On("CountActive", mock.Anything, uint(2)).Return(int64(0), nil)
+			return m
+		},
+		mockLedger: func(m *mockLedgerRepository) *mockLedgerRepository {
+			m.On("Balance", mock.Anything, uint(2)).Return(int64(150), nil)
+			return m
+		},
+		mockHolds: func(m *mockHoldRepository) *mockHoldRepository {
+			return m
+		},
+		expectedErr: ErrUserOwesFines,
+	}, {
+		name: "holds lookup fails",
+		mockLoans: func(m *mockLoanRepository) *mockLoanRepository {
+			m.On("CountActive", mock.Anything, uint(2)).Return(int64(0), nil)
+			return m
+		},
+		mockLedger: func(m *mockLedgerRepository) *mockLedgerRepository {
+			m.On("Balance", mock.Anything, uint(2)).Return(int64(0), nil)
+			return m
+		},
+		mockHolds: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("FindByUser", mock.Anything, uint(2)).Return([]entities.Hold{}, errors.New("error"))
+			return m
+		},
+		expectedErr: errors.New("error"),
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockUsers := &mockUserRepository{}
+			mockUsers.On("LockAdmins", mock.Anything).Return(nil)
+			mockUsers.On("Lock", mock.Anything, uint(2)).Return(nil)
+			if tt.deleted {
+				mockUsers.On("Delete", mock.Anything, uint(2)).Return(nil)
+				mockUsers.On("CountAdmins", mock.Anything).Return(tt.mockAdmins, nil)
+			}
+			mockLoans := tt.mockLoans(&mockLoanRepository{})
+			mockLedger := tt.mockLedger(&mockLedgerRepository{})
+			mockHolds := tt.mockHolds(&mockHoldRepository{})
+			mockAuth, mockRefresh := revokedTokens(2, tt.revoked)
+			uow := &mockUnitOfWork{users: mockUsers, loans: mockLoans, ledger: mockLedger, holds: mockHolds}
+			service := NewAccountService(&mockUserRepository{}, &mockRoleRepository{}, uow, NewTokenService(mockAuth, mockRefresh, testSigner, testRefreshTokenTTL), testBcryptCost)
+
+			err := service.Delete(ctx, user)
+
+			assert.Equal(t, tt.expectedErr, err)
+			mockUsers.AssertExpectations(t)
+			mockLoans.AssertExpectations(t)
+			mockLedger.AssertExpectations(t)
+			mockHolds.AssertExpectations(t)
+			mockAuth.AssertExpectations(t)
+			mockRefresh.AssertExpectations(t)
+		})
+	}
+}
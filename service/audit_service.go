@@ -0,0 +1,27 @@
+package service
+
+import (
+	"context"
+
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+)
+
+// AuditService reads the audit log of the security relevant actions
+type AuditService interface {
+	FindAll(ctx context.Context, page repositories.Page) ([]entities.AuditEvent, int64, error)
+}
+
+type auditService struct {
+	repository repositories.AuditRepository
+}
+
+func NewAuditService(repo repositories.AuditRepository) *auditService {
+	return &auditService{
+		repository: repo,
+	}
+}
+
+func (s *auditService) FindAll(ctx context.Context, page repositories.Page) ([]entities.AuditEvent, int64, error) {
+	return s.repository.FindAll(ctx, page)
+}
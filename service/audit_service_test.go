@@ -0,0 +1,25 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_AuditService_FindAll(t *testing.T) {
+	events := []entities.AuditEvent{{ID: 1, Action: entities.AuditAccountLocked, Subject: "email"}}
+	page := repositories.Page{Number: 1, Size: 20}
+	mockAudit := &mockAuditRepository{}
+	mockAudit.On("FindAll", mock.Anything, page).Return(events, int64(1), nil)
+
+	found, total, err := NewAuditService(mockAudit).FindAll(context.Background(), page)
+
+	assert.Nil(t, err)
+	assert.Equal(t, events, found)
+	assert.Equal(t, int64(1), total)
+	mockAudit.AssertExpectations(t)
+}
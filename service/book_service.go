@@ -1,44 +1,96 @@
 package service
 
 import (
+	"context"
+	"errors"
+
 	"github.com/mishozz/Library/entities"
 	"github.com/mishozz/Library/repositories"
 )
 
+// ErrBookConflict is returned when a book is given the ISBN of another book
+var ErrBookConflict = errors.New("book with this isbn already exists")
+
+// BookUpdate holds the changes of a book, nil fields are left as they are.
+// The copies of a book are managed as items.
+type BookUpdate struct {
+	Isbn   *string `json:"Isbn" binding:"omitempty,min=1,max=32"`
+	Title  *string `json:"Title" binding:"omitempty,min=1,max=256"`
+	Author *string `json:"Author" binding:"omitempty,min=1,max=100"`
+}
+
 type BookService interface {
-	Save(entities.Book) error
-	FindAll() ([]entities.Book, error)
-	FindByIsbn(isbn string) (entities.Book, error)
-	Delete(isbn string) error
-	IsBookTaken(isbn string) bool
+	Save(ctx context.Context, book entities.Book) error
+	FindAll(ctx context.Context, query repositories.BookQuery) ([]entities.Book, int64, error)
+	Search(ctx context.Context, text string, page repositories.Page) ([]repositories.BookMatch, int64, error)
+	FindByIsbn(ctx context.Context, isbn string) (entities.Book, error)
+	Update(ctx context.Context, book entities.Book, update BookUpdate) (entities.Book, error)
+	Delete(ctx context.Context, isbn string) error
+	IsBookTaken(ctx context.Context, isbn string) bool
 }
 
 type bookService struct {
 	repository repositories.BookRepository
+	unitOfWork repositories.UnitOfWork
 }
 
-func NewBookService(repo repositories.BookRepository) *bookService {
+func NewBookService(repo repositories.BookRepository, unitOfWork repositories.UnitOfWork) *bookService {
 	return &bookService{
 		repository: repo,
+		unitOfWork: unitOfWork,
 	}
 }
 
-func (s *bookService) Save(book entities.Book) error {
-	return s.repository.Save(book)
+func (s *bookService) Save(ctx context.Context, book entities.Book) error {
+	return s.repository.Save(ctx, book)
+}
+
+func (s *bookService) FindAll(ctx context.Context, query repositories.BookQuery) ([]entities.Book, int64, error) {
+	return s.repository.FindAll(ctx, query)
 }
 
-func (s *bookService) FindAll() ([]entities.Book, error) {
-	return s.repository.FindAll()
+func (s *bookService) Search(ctx context.Context, text string, page repositories.Page) ([]repositories.BookMatch, int64, error) {
+	return s.repository.Search(ctx, text, page)
 }
 
-func (s *bookService) FindByIsbn(isbn string) (entities.Book, error) {
-	return s.repository.Find(isbn)
+func (s *bookService) FindByIsbn(ctx context.Context, isbn string) (entities.Book, error) {
+	return s.repository.Find(ctx, isbn)
+}
+
+// Update applies the changes to the book
+func (s *bookService) Update(ctx context.Context, book entities.Book, update BookUpdate) (entities.Book, error) {
+	if update.Isbn != nil && *update.Isbn != book.Isbn {
+		if _, err := s.repository.Find(ctx, *update.Isbn); err == nil {
+			return entities.Book{}, ErrBookConflict
+		}
+		book.Isbn = *update.Isbn
+	}
+	if update.Title != nil {
+		book.Title = *update.Title
+	}
+	if update.Author != nil {
+		book.Author = *update.Author
+	}
+	err := s.unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+		return store.Books().Update(ctx, book)
+	})
+	if err != nil {
+		//the unique ISBN refused the update when another book took the ISBN meanwhile
+		if other, findErr := s.repository.Find(ctx, book.Isbn); findErr == nil && other.ID != book.ID {
+			return entities.Book{}, ErrBookConflict
+		}
+		return entities.Book{}, err
+	}
+	return s.repository.Find(ctx, book.Isbn)
 }
 
-func (s *bookService) Delete(isbn string) error {
-	return s.repository.Delete(isbn)
+// Delete removes a book which was never lent, see BookRepository.Delete
+func (s *bookService) Delete(ctx context.Context, isbn string) error {
+	return s.unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+		return store.Books().Delete(ctx, isbn)
+	})
 }
 
-func (s *bookService) IsBookTaken(isbn string) bool {
-	return s.repository.IsBookTaken(isbn)
+func (s *bookService) IsBookTaken(ctx context.Context, isbn string) bool {
+	return s.repository.IsBookTaken(ctx, isbn)
 }
@@ -1,66 +1,83 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
 )
 
 type mockBookRepository struct {
 	mock.Mock
 }
 
-func (m *mockBookRepository) Save(book entities.Book) error {
-	args := m.Called(book)
+func (m *mockBookRepository) Save(ctx context.Context, book entities.Book) error {
+	args := m.Called(ctx, book)
 	return args.Error(0)
 }
 
-func (m *mockBookRepository) Delete(isbn string) error {
-	args := m.Called(isbn)
+func (m *mockBookRepository) Delete(ctx context.Context, isbn string) error {
+	args := m.Called(ctx, isbn)
 	return args.Error(0)
 }
 
-func (m *mockBookRepository) IsBookTaken(isbn string) bool {
-	args := m.Called(isbn)
+func (m *mockBookRepository) IsBookTaken(ctx context.Context, isbn string) bool {
+	args := m.Called(ctx, isbn)
 	return args.Get(0).(bool)
 }
 
-func (m *mockBookRepository) FindAll() ([]entities.Book, error) {
-	args := m.Called()
-	return args.Get(0).([]entities.Book), args.Error(1)
+func (m *mockBookRepository) FindAll(ctx context.Context, query repositories.BookQuery) ([]entities.Book, int64, error) {
+	args := m.Called(ctx, query)
+	return args.Get(0).([]entities.Book), args.Get(1).(int64), args.Error(2)
 }
 
-func (m *mockBookRepository) UpdateUnits(book entities.Book) error {
-	args := m.Called(book)
+func (m *mockBookRepository) Search(ctx context.Context, text string, page repositories.Page) ([]repositories.BookMatch, int64, error) {
+	args := m.Called(ctx, text, page)
+	return args.Get(0).([]repositories.BookMatch), args.Get(1).(int64), args.Error(2)
+}
+
+func (m *mockBookRepository) Lock(ctx context.Context, bookId uint) error {
+	args := m.Called(ctx, bookId)
+	return args.Error(0)
+}
+
+func (m *mockBookRepository) Update(ctx context.Context, book entities.Book) error {
+	args := m.Called(ctx, book)
 	return args.Error(0)
 }
 
-func (m *mockBookRepository) Find(isbn string) (entities.Book, error) {
-	args := m.Called(isbn)
+func (m *mockBookRepository) Find(ctx context.Context, isbn string) (entities.Book, error) {
+	args := m.Called(ctx, isbn)
 	return args.Get(0).(entities.Book), args.Error(1)
 }
 
 func Test_NewBookService(t *testing.T) {
 	repo := &mockBookRepository{}
-	service := NewBookService(repo)
+	service := NewBookService(repo, &mockUnitOfWork{})
 	assert.NotNil(t, service.repository)
+	assert.NotNil(t, service.unitOfWork)
 }
 
 func Test_BookService_Save(t *testing.T) {
+	ctx := context.Background()
 	mockRepo := func(m *mockBookRepository) *mockBookRepository {
-		m.On("Save", mock.Anything).Return(nil)
+		m.On("Save", mock.Anything, mock.Anything).Return(nil)
 		return m
 	}
 	service := bookService{
 		repository: mockRepo(&mockBookRepository{}),
 	}
 	book := entities.Book{}
-	service.Save(book)
+	service.Save(ctx, book)
 }
 
 func Test_BookService_FindAll(t *testing.T) {
+	ctx := context.Background()
 	tests := []struct {
 		name         string
 		mockBookRepo func(m *mockBookRepository) *mockBookRepository
@@ -69,7 +86,7 @@ func Test_BookService_FindAll(t *testing.T) {
 	}{{
 		name: "find empty slice",
 		mockBookRepo: func(m *mockBookRepository) *mockBookRepository {
-			m.On("FindAll").Return([]entities.Book{}, nil)
+			m.On("FindAll", mock.Anything, repositories.BookQuery{}).Return([]entities.Book{}, int64(0), nil)
 			return m
 		},
 		expected: []entities.Book{},
@@ -77,14 +94,14 @@ func Test_BookService_FindAll(t *testing.T) {
 	}, {
 		name: "find not empty slice",
 		mockBookRepo: func(m *mockBookRepository) *mockBookRepository {
-			m.On("FindAll").Return([]entities.Book{
+			m.On("FindAll", mock.Anything, repositories.BookQuery{}).Return([]entities.Book{
 				{
 					Isbn:           "test",
 					Author:         "test",
 					Title:          "test",
 					AvailableUnits: 1,
 				},
-			}, nil)
+			}, int64(1), nil)
 			return m
 		},
 		expected: []entities.Book{
@@ -104,7 +121,7 @@ func Test_BookService_FindAll(t *testing.T) {
 			service := bookService{
 				repository: tt.mockBookRepo(m),
 			}
-			books, err := service.FindAll()
+			books, _, err := service.FindAll(ctx, repositories.BookQuery{})
 			if err != nil {
 				assert.EqualError(t, err, tt.err.Error())
 			}
@@ -115,9 +132,57 @@ func Test_BookService_FindAll(t *testing.T) {
 	}
 }
 
+func Test_BookService_Search(t *testing.T) {
+	ctx := context.Background()
+	page := repositories.Page{Number: 1, Size: 20}
+	tests := []struct {
+		name         string
+		mockBookRepo func(m *mockBookRepository) *mockBookRepository
+		expected     []repositories.BookMatch
+		total        int64
+		err          error
+	}{{
+		name: "matches found",
+		mockBookRepo: func(m *mockBookRepository) *mockBookRepository {
+			m.On("Search", mock.Anything, "dune", page).Return([]repositories.BookMatch{{
+				Book:         entities.Book{Isbn: "test", Title: "Dune"},
+				TitleSnippet: "<mark>Dune</mark>",
+			}}, int64(1), nil)
+			return m
+		},
+		expected: []repositories.BookMatch{{
+			Book:         entities.Book{Isbn: "test", Title: "Dune"},
+			TitleSnippet: "<mark>Dune</mark>",
+		}},
+		total: 1,
+	}, {
+		name: "empty search",
+		mockBookRepo: func(m *mockBookRepository) *mockBookRepository {
+			m.On("Search", mock.Anything, "dune", page).Return([]repositories.BookMatch(nil), int64(0), repositories.ErrEmptySearch)
+			return m
+		},
+		err: repositories.ErrEmptySearch,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := tt.mockBookRepo(&mockBookRepository{})
+			service := NewBookService(m, nil)
+
+			matches, total, err := service.Search(ctx, "dune", page)
+
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.expected, matches)
+			assert.Equal(t, tt.total, total)
+			m.AssertExpectations(t)
+		})
+	}
+}
+
 func Test_BookService_FindByIsbn(t *testing.T) {
+	ctx := context.Background()
 	mockRepo := func(m *mockBookRepository) *mockBookRepository {
-		m.On("Find", "test").Return(entities.Book{
+		m.On("Find", mock.Anything, "test").Return(entities.Book{
 			Isbn:           "test",
 			Author:         "test",
 			Title:          "test",
@@ -135,29 +200,118 @@ func Test_BookService_FindByIsbn(t *testing.T) {
 	service := bookService{
 		repository: mockRepo(m),
 	}
-	book, _ := service.FindByIsbn("test")
+	book, _ := service.FindByIsbn(ctx, "test")
 	assert.Equal(t, expectedBook, book)
 	m.AssertExpectations(t)
 }
 
+func Test_BookService_Update(t *testing.T) {
+	ctx := context.Background()
+	book := entities.Book{
+		Model:          gorm.Model{ID: 1},
+		Isbn:           "test",
+		Author:         "test",
+		Title:          "test",
+		AvailableUnits: 1,
+	}
+	renamed := book
+	renamed.Isbn = "renamed"
+	renamed.Title = "title"
+	retitled := book
+	retitled.Title = "title"
+	isbn, title := "renamed", "title"
+
+	tests := []struct {
+		name         string
+		update       BookUpdate
+		mockBookRepo func(m *mockBookRepository) *mockBookRepository
+		mockTxBooks  func(m *mockBookRepository) *mockBookRepository
+		expected     entities.Book
+		err          error
+	}{{
+		name:   "rename",
+		update: BookUpdate{Isbn: &isbn, Title: &title},
+		mockBookRepo: func(m *mockBookRepository) *mockBookRepository {
+			m.On("Find", mock.Anything, "renamed").Return(entities.Book{}, gorm.ErrRecordNotFound).Once()
+			m.On("Find", mock.Anything, "renamed").Return(renamed, nil).Once()
+			return m
+		},
+		mockTxBooks: func(m *mockBookRepository) *mockBookRepository {
+			m.On("Update", mock.Anything, renamed).Return(nil).Once()
+			return m
+		},
+		expected: renamed,
+	}, {
+		name:   "isbn of another book",
+		update: BookUpdate{Isbn: &isbn},
+		mockBookRepo: func(m *mockBookRepository) *mockBookRepository {
+			m.On("Find", mock.Anything, "renamed").Return(renamed, nil).Once()
+			return m
+		},
+		mockTxBooks: func(m *mockBookRepository) *mockBookRepository {
+			return m
+		},
+		err: ErrBookConflict,
+	}, {
+		name:   "isbn taken meanwhile",
+		update: BookUpdate{Isbn: &isbn, Title: &title},
+		mockBookRepo: func(m *mockBookRepository) *mockBookRepository {
+			m.On("Find", mock.Anything, "renamed").Return(entities.Book{}, gorm.ErrRecordNotFound).Once()
+			m.On("Find", mock.Anything, "renamed").Return(entities.Book{Model: gorm.Model{ID: 2}, Isbn: "renamed"}, nil).Once()
+			return m
+		},
+		mockTxBooks: func(m *mockBookRepository) *mockBookRepository {
+			m.On("Update", mock.Anything, renamed).Return(errors.New("UNIQUE constraint failed: books.isbn")).Once()
+			return m
+		},
+		err: ErrBookConflict,
+	}, {
+		name:   "update fails",
+		update: BookUpdate{Title: &title},
+		mockBookRepo: func(m *mockBookRepository) *mockBookRepository {
+			m.On("Find", mock.Anything, "test").Return(book, nil).Once()
+			return m
+		},
+		mockTxBooks: func(m *mockBookRepository) *mockBookRepository {
+			m.On("Update", mock.Anything, retitled).Return(errors.New("locked")).Once()
+			return m
+		},
+		err: errors.New("locked"),
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockBooks := tt.mockBookRepo(&mockBookRepository{})
+			mockTxBooks := tt.mockTxBooks(&mockBookRepository{})
+			service := NewBookService(mockBooks, &mockUnitOfWork{books: mockTxBooks})
+
+			actual, err := service.Update(ctx, book, tt.update)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.expected, actual)
+			mockBooks.AssertExpectations(t)
+			mockTxBooks.AssertExpectations(t)
+		})
+	}
+}
+
 func Test_BookService_Delete(t *testing.T) {
+	ctx := context.Background()
 	mockRepo := func(m *mockBookRepository) *mockBookRepository {
-		m.On("Delete", "test").Return(nil)
+		m.On("Delete", mock.Anything, "test").Return(nil)
 		return m
 	}
 
 	m := &mockBookRepository{}
-	service := bookService{
-		repository: mockRepo(m),
-	}
-	err := service.Delete("test")
+	service := NewBookService(&mockBookRepository{}, &mockUnitOfWork{books: mockRepo(m)})
+	err := service.Delete(ctx, "test")
 	assert.Nil(t, err)
 	m.AssertExpectations(t)
 }
 
 func Test_BookService_IsBookTaken(t *testing.T) {
+	ctx := context.Background()
 	mockRepo := func(m *mockBookRepository) *mockBookRepository {
-		m.On("IsBookTaken", "test").Return(true)
+		m.On("IsBookTaken", mock.Anything, "test").Return(true)
 		return m
 	}
 
@@ -165,7 +319,7 @@ func Test_BookService_IsBookTaken(t *testing.T) {
 	service := bookService{
 		repository: mockRepo(m),
 	}
-	err := service.IsBookTaken("test")
+	err := service.IsBookTaken(ctx, "test")
 	assert.True(t, err)
 	m.AssertExpectations(t)
 }
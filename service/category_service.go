@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+)
+
+var (
+	// ErrLoanLimit is matched by the LoanLimitError of a patron who has as many books as they are allowed
+	ErrLoanLimit = errors.New("loan limit reached")
+	// ErrHoldLimit is returned when a patron who has as many holds as their category allows places another one
+	ErrHoldLimit = errors.New("hold limit reached")
+)
+
+type CategoryService interface {
+	FindAll(ctx context.Context) ([]entities.PatronCategory, error)
+	Find(ctx context.Context, name string) (entities.PatronCategory, error)
+	Create(ctx context.Context, category entities.PatronCategory) (entities.PatronCategory, error)
+	Update(ctx context.Context, name string, category entities.PatronCategory) (entities.PatronCategory, error)
+	Assign(ctx context.Context, user entities.User, name string) error
+}
+
+type categoryService struct {
+	repository     repositories.CategoryRepository
+	userRepository repositories.UserRepository
+}
+
+func NewCategoryService(repo repositories.CategoryRepository, userRepository repositories.UserRepository) *categoryService {
+	return &categoryService{
+		repository:     repo,
+		userRepository: userRepository,
+	}
+}
+
+func (s *categoryService) FindAll(ctx context.Context) ([]entities.PatronCategory, error) {
+	return s.repository.FindAll(ctx)
+}
+
+func (s *categoryService) Find(ctx context.Context, name string) (entities.PatronCategory, error) {
+	return s.repository.Find(ctx, name)
+}
+
+// Create adds a category with its policy, patrons are moved to it with Assign
+func (s *categoryService) Create(ctx context.Context, category entities.PatronCategory) (entities.PatronCategory, error) {
+	if err := s.repository.Create(ctx, category); err != nil {
+		return entities.PatronCategory{}, err
+	}
+	return s.repository.Find(ctx, category.Name)
+}
+
+// Update replaces the policy of the named category. Loans which are already out keep
+// their due dates, the new policy applies from the next checkout or renewal.
+func (s *categoryService) Update(ctx context.Context, name string, category entities.PatronCategory) (entities.PatronCategory, error) {
+	category.Name = name
+	if err := s.repository.Update(ctx, category); err != nil {
+		return entities.PatronCategory{}, err
+	}
+	return s.repository.Find(ctx, name)
+}
+
+// Assign moves the user to the named category
+func (s *categoryService) Assign(ctx context.Context, user entities.User, name string) error {
+	category, err := s.repository.Find(ctx, name)
+	if err != nil {
+		return err
+	}
+	return s.userRepository.SetCategory(ctx, user.ID, category.Name)
+}
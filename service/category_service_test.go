@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+// testCategories are the patron categories the seed migration creates
+var testCategories = []entities.PatronCategory{
+	{Name: entities.CategoryPublic, LoanDays: 14, MaxLoans: 5, MaxRenewals: 2, FineRate: 25, MaxHolds: 3},
+	{Name: entities.CategoryStudent, LoanDays: 21, MaxLoans: 10, MaxRenewals: 3, FineRate: 10, MaxHolds: 5},
+	{Name: entities.CategoryStaff, LoanDays: 28, MaxLoans: 20, MaxRenewals: 5, FineRate: 0, MaxHolds: 10},
+}
+
+type mockCategoryRepository struct {
+	mock.Mock
+}
+
+func (m *mockCategoryRepository) Find(ctx context.Context, name string) (entities.PatronCategory, error) {
+	args := m.Called(ctx, name)
+	return args.Get(0).(entities.PatronCategory), args.Error(1)
+}
+
+func (m *mockCategoryRepository) FindAll(ctx context.Context) ([]entities.PatronCategory, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]entities.PatronCategory), args.Error(1)
+}
+
+func (m *mockCategoryRepository) Create(ctx context.Context, category entities.PatronCategory) error {
+	args := m.Called(ctx, category)
+	return args.Error(0)
+}
+
+func (m *mockCategoryRepository) Update(ctx context.Context, category entities.PatronCategory) error {
+	args := m.Called(ctx, category)
+	return args.Error(0)
+}
+
+// publicCategories returns the public category to every lookup
+func publicCategories() *mockCategoryRepository {
+	m := &mockCategoryRepository{}
+	m.On("Find", mock.Anything, entities.CategoryPublic).Return(testCategories[0], nil)
+	return m
+}
+
+func Test_NewCategoryService(t *testing.T) {
+	service := NewCategoryService(&mockCategoryRepository{}, &mockUserRepository{})
+	assert.NotNil(t, service.repository)
+	assert.NotNil(t, service.userRepository)
+}
+
+func Test_CategoryService_Create(t *testing.T) {
+	ctx := context.Background()
+	alumni := entities.PatronCategory{Name: "alumni", LoanDays: 14, MaxLoans: 3, FineRate: 25}
+	created := alumni
+	created.ID = 4
+
+	tests := []struct {
+		name         string
+		mockCategory func(m *mockCategoryRepository) *mockCategoryRepository
+		expected     entities.PatronCategory
+		err          error
+	}{{
+		name: "success",
+		mockCategory: func(m *mockCategoryRepository) *mockCategoryRepository {
+			m.On("Create", mock.Anything, alumni).Return(nil).Once()
+			m.On("Find", mock.Anything, "alumni").Return(created, nil).Once()
+			return m
+		},
+		expected: created,
+	}, {
+		name: "name taken",
+		mockCategory: func(m *mockCategoryRepository) *mockCategoryRepository {
+			m.On("Create", mock.Anything, alumni).Return(repositories.ErrCategoryExists).Once()
+			return m
+		},
+		err: repositories.ErrCategoryExists,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			m := tt.mockCategory(&mockCategoryRepository{})
+			service := NewCategoryService(m, &mockUserRepository{})
+
+			actual, err := service.Create(ctx, alumni)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.expected, actual)
+			m.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_CategoryService_Update(t *testing.T) {
+	ctx := context.Background()
+	policy := entities.PatronCategory{Name: "ignored", LoanDays: 7, MaxLoans: 2, FineRate: 50}
+	updated := entities.PatronCategory{ID: 1, Name: entities.CategoryPublic, LoanDays: 7, MaxLoans: 2, FineRate: 50}
+
+	tests := []struct {
+		name         string
+		mockCategory func(m *mockCategoryRepository) *mockCategoryRepository
+		expected     entities.PatronCategory
+		err          error
+	}{{
+		name: "success",
+		mockCategory: func(m *mockCategoryRepository) *mockCategoryRepository {
+			m.On("Update", mock.Anything, entities.PatronCategory{Name: entities.CategoryPublic, LoanDays: 7, MaxLoans: 2, FineRate: 50}).Return(nil).Once()
+			m.On("Find", mock.Anything, entities.CategoryPublic).Return(updated, nil).Once()
+			return m
+		},
+		expected: updated,
+	}, {
+		name: "category does not exist",
+		mockCategory: func(m *mockCategoryRepository) *mockCategoryRepository {
+			m.On("Update", mock.Anything, mock.AnythingOfType("entities.PatronCategory")).Return(gorm.ErrRecordNotFound).Once()
+			return m
+		},
+		err: gorm.ErrRecordNotFound,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			m := tt.mockCategory(&mockCategoryRepository{})
+			service := NewCategoryService(m, &mockUserRepository{})
+
+			actual, err := service.Update(ctx, entities.CategoryPublic, policy)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.expected, actual)
+			m.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_CategoryService_Assign(t *testing.T) {
+	ctx := context.Background()
+	user := entities.User{Model: gorm.Model{ID: 1}, Category: entities.CategoryPublic}
+
+	tests := []struct {
+		name         string
+		category     string
+		mockCategory func(m *mockCategoryRepository) *mockCategoryRepository
+		mockUserRepo func(m *mockUserRepository) *mockUserRepository
+		err          error
+	}{{
+		name:     "success",
+		category: entities.CategoryStaff,
+		mockCategory: func(m *mockCategoryRepository) *mockCategoryRepository {
+			m.On("Find", mock.Anything, entities.CategoryStaff).Return(testCategories[2], nil).Once()
+			return m
+		},
+		mockUserRepo: func(m *mockUserRepository) *mockUserRepository {
+			m.On("SetCategory", mock.Anything, uint(1), entities.CategoryStaff).Return(nil).Once()
+			return m
+		},
+	}, {
+		name:     "category does not exist",
+		category: "alumni",
+		mockCategory: func(m *mockCategoryRepository) *mockCategoryRepository {
+			m.On("Find", mock.Anything, "alumni").Return(entities.PatronCategory{}, gorm.ErrRecordNotFound).Once()
+			return m
+		},
+		mockUserRepo: func(m *mockUserRepository) *mockUserRepository {
+			return m
+		},
+		err: gorm.ErrRecordNotFound,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockCategories := tt.mockCategory(&mockCategoryRepository{})
+			mockUsers := tt.mockUserRepo(&mockUserRepository{})
+			service := NewCategoryService(mockCategories, mockUsers)
+
+			err := service.Assign(ctx, user, tt.category)
+			assert.Equal(t, tt.err, err)
+			mockCategories.AssertExpectations(t)
+			mockUsers.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+)
+
+var (
+	// ErrFinesOutstanding is returned when a patron who owes more than the rules allow takes a book
+	ErrFinesOutstanding = errors.New("fines outstanding")
+	// ErrAmountExceedsBalance is returned when a payment or a waiver is larger than what the patron owes
+	ErrAmountExceedsBalance = errors.New("amount exceeds balance")
+)
+
+// FineRules limit the fines of every patron category. Amounts are in cents, a zero Cap means
+// no cap. Patrons who owe more than BlockingBalance cannot take books.
+type FineRules struct {
+	Cap             int64
+	BlockingBalance int64
+}
+
+// Charge is the fine for the loan returned at the given time at the daily rate of the patron
+func (r FineRules) Charge(loan entities.Loan, dailyRate int64, returnedAt time.Time) int64 {
+	fine := loan.DaysOverdue(returnedAt) * dailyRate
+	if r.Cap > 0 && fine > r.Cap {
+		return r.Cap
+	}
+	return fine
+}
+
+// Account is the ledger of a patron with the balance it adds up to
+type Account struct {
+	Balance int64                  `json:"Balance"`
+	Entries []entities.LedgerEntry `json:"Entries"`
+}
+
+// Settlement is a payment or a waiver recorded by the library
+type Settlement struct {
+	Amount int64  `json:"Amount" binding:"required,min=1"`
+	Note   string `json:"Note" binding:"max=256"`
+}
+
+type FineService interface {
+	FindAccount(ctx context.Context, user entities.User) (Account, error)
+	Pay(ctx context.Context, user entities.User, payment Settlement) (entities.LedgerEntry, error)
+	Waive(ctx context.Context, user entities.User, waiver Settlement) (entities.LedgerEntry, error)
+}
+
+type fineService struct {
+	repository repositories.LedgerRepository
+	unitOfWork repositories.UnitOfWork
+}
+
+func NewFineService(repo repositories.LedgerRepository, unitOfWork repositories.UnitOfWork) *fineService {
+	return &fineService{
+		repository: repo,
+		unitOfWork: unitOfWork,
+	}
+}
+
+func (s *fineService) FindAccount(ctx context.Context, user entities.User) (Account, error) {
+	entries, err := s.repository.FindByUser(ctx, user.ID)
+	if err != nil {
+		return Account{}, err
+	}
+	balance, err := s.repository.Balance(ctx, user.ID)
+	if err != nil {
+		return Account{}, err
+	}
+	return Account{Balance: balance, Entries: entries}, nil
+}
+
+func (s *fineService) Pay(ctx context.Context, user entities.User, payment Settlement) (entities.LedgerEntry, error) {
+	return s.settle(ctx, user, entities.LedgerPayment, payment)
+}
+
+func (s *fineService) Waive(ctx context.Context, user entities.User, waiver Settlement) (entities.LedgerEntry, error) {
+	return s.settle(ctx, user, entities.LedgerWaiver, waiver)
+}
+
+// settle lowers the balance of the patron. The patron is locked before the balance is
+// read, so concurrent settlements wait for each other and none of them can take the
+// balance below zero.
+func (s *fineService) settle(ctx context.Context, user entities.User, kind string, settlement Settlement) (entities.LedgerEntry, error) {
+	var entry entities.LedgerEntry
+	err := s.unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+		if err := store.Users().Lock(ctx, user.ID); err != nil {
+			return err
+		}
+		balance, err := store.Ledger().Balance(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		if settlement.Amount > balance {
+			return ErrAmountExceedsBalance
+		}
+		entry, err = store.Ledger().Create(ctx, entities.LedgerEntry{
+			UserID: user.ID,
+			Kind:   kind,
+			Amount: settlement.Amount,
+			Note:   settlement.Note,
+		})
+		return err
+	})
+	if err != nil {
+		return entities.LedgerEntry{}, err
+	}
+	return entry, nil
+}
+
+// chargeOverdue records the fine of a loan which was returned late
+func chargeOverdue(ctx context.Context, store repositories.Store, rules FineRules, category entities.PatronCategory, loan entities.Loan, returnedAt time.Time) error {
+	fine := rules.Charge(loan, category.FineRate, returnedAt)
+	if fine <= 0 {
+		return nil
+	}
+	_, err := store.Ledger().Create(ctx, entities.LedgerEntry{
+		UserID: loan.UserID,
+		LoanID: &loan.ID,
+		Kind:   entities.LedgerCharge,
+		Amount: fine,
+	})
+	return err
+}
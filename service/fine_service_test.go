@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mishozz/Library/entities"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+type mockLedgerRepository struct {
+	mock.Mock
+}
+
+func (m *mockLedgerRepository) Create(ctx context.Context, entry entities.LedgerEntry) (entities.LedgerEntry, error) {
+	args := m.Called(ctx, entry)
+	return args.Get(0).(entities.LedgerEntry), args.Error(1)
+}
+
+func (m *mockLedgerRepository) FindByUser(ctx context.Context, userId uint) ([]entities.LedgerEntry, error) {
+	args := m.Called(ctx, userId)
+	return args.Get(0).([]entities.LedgerEntry), args.Error(1)
+}
+
+func (m *mockLedgerRepository) Balance(ctx context.Context, userId uint) (int64, error) {
+	args := m.Called(ctx, userId)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func Test_NewFineService(t *testing.T) {
+	service := NewFineService(&mockLedgerRepository{}, &mockUnitOfWork{})
+	assert.NotNil(t, service.repository)
+	assert.NotNil(t, service.unitOfWork)
+}
+
+func Test_FineRules_Charge(t *testing.T) {
+	due := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	rules := FineRules{Cap: 100}
+
+	tests := []struct {
+		name       string
+		rules      FineRules
+		dailyRate  int64
+		returnedAt time.Time
+		expected   int64
+	}{{
+		name:       "returned on time",
+		rules:      rules,
+		dailyRate:  25,
+		returnedAt: due,
+	}, {
+		name:       "every started day is charged",
+		rules:      rules,
+		dailyRate:  25,
+		returnedAt: due.Add(time.Hour*24 + time.Minute),
+		expected:   50,
+	}, {
+		name:       "capped",
+		rules:      rules,
+		dailyRate:  25,
+		returnedAt: due.Add(time.Hour * 24 * 30),
+		expected:   100,
+	}, {
+		name:       "no cap",
+		rules:      FineRules{},
+		dailyRate:  25,
+		returnedAt: due.Add(time.Hour * 24 * 30),
+		expected:   750,
+	}, {
+		name:       "category without fines",
+		rules:      rules,
+		returnedAt: due.Add(time.Hour * 24 * 30),
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			loan := entities.Loan{DueAt: due, ReturnedAt: &tt.returnedAt}
+			assert.Equal(t, tt.expected, tt.rules.Charge(loan, tt.dailyRate, tt.returnedAt))
+		})
+	}
+}
+
+func Test_FineService_FindAccount(t *testing.T) {
+	ctx := context.Background()
+	user := entities.User{Model: gorm.Model{ID: 1}}
+	entries := []entities.LedgerEntry{
+		{ID: 1, Kind: entities.LedgerCharge, Amount: 300},
+		{ID: 2, Kind: entities.LedgerPayment, Amount: 100},
+	}
+	m := &mockLedgerRepository{}
+	m.On("FindByUser", mock.Anything, uint(1)).Return(entries, nil)
+	m.On("Balance", mock.Anything, uint(1)).Return(int64(200), nil)
+
+	service := NewFineService(m, &mockUnitOfWork{})
+	account, err := service.FindAccount(ctx, user)
+
+	assert.Nil(t, err)
+	assert.Equal(t, Account{Balance: 200, Entries: entries}, account)
+	m.AssertExpectations(t)
+}
+
+func Test_FineService_Pay_Waive(t *testing.T) {
+	ctx := context.Background()
+	user := entities.User{Model: gorm.Model{ID: 1}}
+	settlement := Settlement{Amount: 100, Note: "cash"}
+
+	tests := []struct {
+		name     string
+		settle   func(s *fineService) (entities.LedgerEntry, error)
+		kind     string
+		balance  int64
+		expected entities.LedgerEntry
+		err      error
+	}{{
+		name: "payment",
+		settle: func(s *fineService) (entities.LedgerEntry, error) {
+			return s.Pay(ctx, user, settlement)
+		},
+		kind:     entities.LedgerPayment,
+		balance:  150,
+		expected: entities.LedgerEntry{ID: 3, UserID: 1, Kind: entities.LedgerPayment, Amount: 100, Note: "cash"},
+	}, {
+		name: "waiver",
+		settle: func(s *fineService) (entities.LedgerEntry, error) {
+			return s.Waive(ctx, user, settlement)
+		},
+		kind:     entities.LedgerWaiver,
+		balance:  100,
+		expected: entities.LedgerEntry{ID: 3, UserID: 1, Kind: entities.LedgerWaiver, Amount: 100, Note: "cash"},
+	}, {
+		name: "more than the balance",
+		settle: func(s *fineService) (entities.LedgerEntry, error) {
+			return s.Pay(ctx, user, settlement)
+		},
+		kind:    entities.LedgerPayment,
+		balance: 50,
+		err:     ErrAmountExceedsBalance,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			entry := entities.LedgerEntry{UserID: 1, Kind: tt.kind, Amount: 100, Note: "cash"}
+			created := entry
+			created.ID = 3
+			users := &mockUserRepository{}
+			users.On("Lock", mock.Anything, uint(1)).Return(nil).Once()
+			m := &mockLedgerRepository{}
+			m.On("Balance", mock.Anything, uint(1)).Return(tt.balance, nil).Once()
+			if tt.err == nil {
+				m.On("Create", mock.Anything, entry).Return(created, nil).Once()
+			}
+			service := NewFineService(&mockLedgerRepository{}, &mockUnitOfWork{users: users, ledger: m})
+
+			actual, err := tt.settle(service)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.expected, actual)
+			m.AssertExpectations(t)
+			users.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_FineService_Pay_Error(t *testing.T) {
+	ctx := context.Background()
+	users := &mockUserRepository{}
+	users.On("Lock", mock.Anything, uint(1)).Return(errors.New("locked")).Once()
+	m := &mockLedgerRepository{}
+	service := NewFineService(&mockLedgerRepository{}, &mockUnitOfWork{users: users, ledger: m})
+
+	_, err := service.Pay(ctx, entities.User{Model: gorm.Model{ID: 1}}, Settlement{Amount: 100})
+	assert.Equal(t, errors.New("locked"), err)
+	m.AssertExpectations(t)
+	users.AssertExpectations(t)
+}
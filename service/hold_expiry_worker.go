@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// HoldExpiryWorker periodically expires ready holds which were not picked up in time
+type HoldExpiryWorker struct {
+	holdService HoldService
+	interval    time.Duration
+	ctx         context.Context
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+func NewHoldExpiryWorker(holdService HoldService, interval time.Duration) *HoldExpiryWorker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &HoldExpiryWorker{
+		holdService: holdService,
+		interval:    interval,
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+}
+
+// Start runs the worker in a new goroutine until Stop is called
+func (w *HoldExpiryWorker) Start() {
+	go w.run()
+}
+
+// Stop signals the worker to exit and waits for the current run to finish. The run is
+// cancelled, its holds are expired again on the next start.
+func (w *HoldExpiryWorker) Stop() {
+	w.cancel()
+	<-w.done
+}
+
+func (w *HoldExpiryWorker) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case now := <-ticker.C:
+			expired, err := w.holdService.ExpireReady(w.ctx, now)
+			if err != nil && w.ctx.Err() == nil {
+				zap.L().Error("unable to expire holds", zap.Error(err))
+			}
+			if expired > 0 {
+				zap.L().Debug("expired holds", zap.Int("count", expired))
+			}
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrCopiesAvailable is returned when a hold is placed on a book which can be taken right away
+	ErrCopiesAvailable = errors.New("book has available copies")
+	// ErrAlreadyOnHold is returned when the user already has an active hold on the book
+	ErrAlreadyOnHold = errors.New("book already on hold")
+	// ErrInvalidPosition is returned when a hold is moved outside of the queue
+	ErrInvalidPosition = errors.New("invalid hold position")
+)
+
+type HoldService interface {
+	Place(ctx context.Context, user entities.User, book entities.Book) (entities.Hold, error)
+	Cancel(ctx context.Context, user entities.User, book entities.Book) error
+	FindByUser(ctx context.Context, user entities.User) ([]entities.Hold, error)
+	FindQueue(ctx context.Context, book entities.Book) ([]entities.Hold, error)
+	Reorder(ctx context.Context, book entities.Book, holdId uint, position int) error
+	ExpireReady(ctx context.Context, now time.Time) (int, error)
+}
+
+type holdService struct {
+	repository repositories.HoldRepository
+	unitOfWork repositories.UnitOfWork
+}
+
+func NewHoldService(repo repositories.HoldRepository, unitOfWork repositories.UnitOfWork) *holdService {
+	return &holdService{
+		repository: repo,
+		unitOfWork: unitOfWork,
+	}
+}
+
+// Place puts the user at the end of the queue of the book. Holds are accepted only
+// while no copy is on the shelf, the user does not have the book already and the
+// category of the user allows one more hold. The user and the book are locked before
+// the checks, so concurrent holds of the user or on the book wait for each other.
+func (s *holdService) Place(ctx context.Context, user entities.User, book entities.Book) (entities.Hold, error) {
+	var hold entities.Hold
+	err := s.unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+		if err := store.Users().Lock(ctx, user.ID); err != nil {
+			return err
+		}
+		if err := store.Books().Lock(ctx, book.ID); err != nil {
+			return err
+		}
+		current, err := store.Books().Find(ctx, book.Isbn)
+		if err != nil {
+			return err
+		}
+		if current.AvailableUnits > 0 {
+			return ErrCopiesAvailable
+		}
+		_, err = store.Loans().FindActive(ctx, user.ID, book.ID)
+		if err == nil {
+			return repositories.ErrBookAlreadyTaken
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		active, err := store.Holds().CountActive(ctx, user.ID, book.ID)
+		if err != nil {
+			return err
+		}
+		if active > 0 {
+			return ErrAlreadyOnHold
+		}
+		category, err := store.Categories().Find(ctx, user.Category)
+		if err != nil {
+			return err
+		}
+		held, err := store.Holds().CountByUser(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		if held >= int64(category.MaxHolds) {
+			return ErrHoldLimit
+		}
+		hold, err = store.Holds().Create(ctx, entities.Hold{UserID: user.ID, BookID: book.ID})
+		return err
+	})
+	if err != nil {
+		return entities.Hold{}, err
+	}
+	return hold, nil
+}
+
+// Cancel drops the active hold of the user. A copy which was set aside for it
+// goes to the next hold in the queue.
+func (s *holdService) Cancel(ctx context.Context, user entities.User, book entities.Book) error {
+	now := time.Now()
+	return s.unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+		hold, err := store.Holds().Cancel(ctx, user.ID, book.ID)
+		if err != nil || hold.ItemID == nil {
+			return err
+		}
+		return releaseCopy(ctx, store, *hold.ItemID, now)
+	})
+}
+
+func (s *holdService) FindByUser(ctx context.Context, user entities.User) ([]entities.Hold, error) {
+	return s.repository.FindByUser(ctx, user.ID)
+}
+
+func (s *holdService) FindQueue(ctx context.Context, book entities.Book) ([]entities.Hold, error) {
+	return s.repository.FindQueue(ctx, book.ID)
+}
+
+// Reorder moves a waiting hold to the given 1-based position of the queue
+func (s *holdService) Reorder(ctx context.Context, book entities.Book, holdId uint, position int) error {
+	if position < 1 {
+		return ErrInvalidPosition
+	}
+	return s.unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+		//new holds lock the book too, so the queue does not grow while it is renumbered
+		if err := store.Books().Lock(ctx, book.ID); err != nil {
+			return err
+		}
+		queue, err := store.Holds().FindWaiting(ctx, book.ID)
+		if err != nil {
+			return err
+		}
+		ordered := make([]entities.Hold, 0, len(queue))
+		for _, hold := range queue {
+			if hold.ID != holdId {
+				ordered = append(ordered, hold)
+			}
+		}
+		if len(ordered) == len(queue) {
+			return repositories.ErrHoldNotFound
+		}
+		if position > len(queue) {
+			return ErrInvalidPosition
+		}
+		ordered = append(ordered[:position-1], append([]entities.Hold{{ID: holdId}}, ordered[position-1:]...)...)
+		for i, hold := range ordered {
+			if err := store.Holds().SetPosition(ctx, hold.ID, book.ID, uint(i+1)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ExpireReady closes the ready holds whose pickup window has passed and passes their
+// copies on. It returns how many holds were expired.
+func (s *holdService) ExpireReady(ctx context.Context, now time.Time) (int, error) {
+	holds, err := s.repository.FindExpired(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	expired := 0
+	for _, hold := range holds {
+		err := s.unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+			if err := store.Holds().Expire(ctx, hold, now); err != nil {
+				return err
+			}
+			if hold.ItemID == nil {
+				return nil
+			}
+			return releaseCopy(ctx, store, *hold.ItemID, now)
+		})
+		if errors.Is(err, repositories.ErrHoldNotFound) {
+			continue
+		}
+		if err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// releaseCopy sets a freed item aside for the next waiting hold of its book, or puts it
+// back on the shelf when the queue is empty or the item is damaged
+func releaseCopy(ctx context.Context, store repositories.Store, itemId uint, now time.Time) error {
+	item, err := store.Items().FindByID(ctx, itemId)
+	if err != nil {
+		return err
+	}
+	status := entities.ItemAvailable
+	if item.Condition != entities.ConditionDamaged {
+		err = store.Holds().AllocateNext(ctx, item.BookID, item.ID, now.Add(entities.DefaultPickupWindow))
+		if err == nil {
+			status = entities.ItemOnHold
+		} else if !errors.Is(err, repositories.ErrNoWaitingHolds) {
+			return err
+		}
+	}
+	if item.Status == status {
+		return nil
+	}
+	return store.Items().Transition(ctx, item, item.Status, status)
+}
+
+// allocateShelfCopies sets items from the shelf aside for waiting holds until
+// either the shelf or the queue is empty
+func allocateShelfCopies(ctx context.Context, store repositories.Store, bookId uint, now time.Time) error {
+	for {
+		item, err := store.Items().Claim(ctx, bookId, entities.ItemOnHold)
+		if errors.Is(err, repositories.ErrNoAvailableUnits) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = store.Holds().AllocateNext(ctx, bookId, item.ID, now.Add(entities.DefaultPickupWindow))
+		if errors.Is(err, repositories.ErrNoWaitingHolds) {
+			return store.Items().Transition(ctx, item, entities.ItemOnHold, entities.ItemAvailable)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+type mockHoldRepository struct {
+	mock.Mock
+}
+
+func (m *mockHoldRepository) Create(ctx context.Context, hold entities.Hold) (entities.Hold, error) {
+	args := m.Called(ctx, hold)
+	return args.Get(0).(entities.Hold), args.Error(1)
+}
+
+func (m *mockHoldRepository) CountActive(ctx context.Context, userId uint, bookId uint) (int64, error) {
+	args := m.Called(ctx, userId, bookId)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *mockHoldRepository) CountByUser(ctx context.Context, userId uint) (int64, error) {
+	args := m.Called(ctx, userId)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *mockHoldRepository) FindByUser(ctx context.Context, userId uint) ([]entities.Hold, error) {
+	args := m.Called(ctx, userId)
+	return args.Get(0).([]entities.Hold), args.Error(1)
+}
+
+func (m *mockHoldRepository) FindQueue(ctx context.Context, bookId uint) ([]entities.Hold, error) {
+	args := m.Called(ctx, bookId)
+	return args.Get(0).([]entities.Hold), args.Error(1)
+}
+
+func (m *mockHoldRepository) FindWaiting(ctx context.Context, bookId uint) ([]entities.Hold, error) {
+	args := m.Called(ctx, bookId)
+	return args.Get(0).([]entities.Hold), args.Error(1)
+}
+
+func (m *mockHoldRepository) FindExpired(ctx context.Context, now time.Time) ([]entities.Hold, error) {
+	args := m.Called(ctx, now)
+	return args.Get(0).([]entities.Hold), args.Error(1)
+}
+
+func (m *mockHoldRepository) Fulfil(ctx context.Context, userId uint, bookId uint, now time.Time) (entities.Hold, error) {
+	args := m.Called(ctx, userId, bookId, now)
+	return args.Get(0).(entities.Hold), args.Error(1)
+}
+
+func (m *mockHoldRepository) Cancel(ctx context.Context, userId uint, bookId uint) (entities.Hold, error) {
+	args := m.Called(ctx, userId, bookId)
+	return args.Get(0).(entities.Hold), args.Error(1)
+}
+
+func (m *mockHoldRepository) Expire(ctx context.Context, hold entities.Hold, now time.Time) error {
+	args := m.Called(ctx, hold, now)
+	return args.Error(0)
+}
+
+func (m *mockHoldRepository) AllocateNext(ctx context.Context, bookId uint, itemId uint, expiresAt time.Time) error {
+	args := m.Called(ctx, bookId, itemId, expiresAt)
+	return args.Error(0)
+}
+
+func (m *mockHoldRepository) SetPosition(ctx context.Context, holdId uint, bookId uint, position uint) error {
+	args := m.Called(ctx, holdId, bookId, position)
+	return args.Error(0)
+}
+
+func Test_NewHoldService(t *testing.T) {
+	service := NewHoldService(&mockHoldRepository{}, &mockUnitOfWork{})
+	assert.NotNil(t, service.repository)
+	assert.NotNil(t, service.unitOfWork)
+}
+
+func Test_HoldService_Place(t *testing.T) {
+	ctx := context.Background()
+	user := entities.User{Model: gorm.Model{ID: 1}, Email: "email", Category: entities.CategoryPublic}
+	book := entities.Book{Model: gorm.Model{ID: 2}, Isbn: "test"}
+	hold := entities.Hold{ID: 3, UserID: 1, BookID: 2, Position: 1, Status: entities.HoldWaiting}
+
+	tests := []struct {
+		name         string
+		mockHoldRepo func(m *mockHoldRepository) *mockHoldRepository
+		mockBookRepo func(m *mockBookRepository) *mockBookRepository
+		mockLoanRepo func(m *mockLoanRepository) *mockLoanRepository
+		expected     entities.Hold
+		err          error
+	}{{
+		name: "success",
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("CountActive", mock.Anything, uint(1), uint(2)).Return(int64(0), nil).Once()
+			m.On("CountByUser", mock.Anything, uint(1)).Return(int64(0), nil).Once()
+			m.On("Create", mock.Anything, entities.Hold{UserID: 1, BookID: 2}).Return(hold, nil).Once()
+			return m
+		},
+		mockBookRepo: func(m *mockBookRepository) *mockBookRepository {
+			m.On("Lock", mock.Anything, uint(2)).Return(nil).Once()
+			m.On("Find", mock.Anything, "test").Return(book, nil).Once()
+			return m
+		},
+		mockLoanRepo: func(m *mockLoanRepository) *mockLoanRepository {
+			m.On("FindActive", mock.Anything, uint(1), uint(2)).Return(entities.Loan{}, gorm.ErrRecordNotFound).Once()
+			return m
+		},
+		expected: hold,
+	}, {
+		name: "hold limit of the category",
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("CountActive", mock.Anything, uint(1), uint(2)).Return(int64(0), nil).Once()
+			m.On("CountByUser", mock.Anything, uint(1)).Return(int64(testCategories[0].MaxHolds), nil).Once()
+			return m
+		},
+		mockBookRepo: func(m *mockBookRepository) *mockBookRepository {
+			m.On("Lock", mock.Anything, uint(2)).Return(nil).Once()
+			m.On("Find", mock.Anything, "test").Return(book, nil).Once()
+			return m
+		},
+		mockLoanRepo: func(m *mockLoanRepository) *mockLoanRepository {
+			m.On("FindActive", mock.Anything, uint(1), uint(2)).Return(entities.Loan{}, gorm.ErrRecordNotFound).Once()
+			return m
+		},
+		err: ErrHoldLimit,
+	}, {
+		name: "copies on the shelf",
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			return m
+		},
+		mockBookRepo: func(m *mockBookRepository) *mockBookRepository {
+			m.On("Lock", mock.Anything, uint(2)).Return(nil).Once()
+			m.On("Find", mock.Anything, "test").Return(entities.Book{Isbn: "test", AvailableUnits: 1}, nil).Once()
+			return m
+		},
+		mockLoanRepo: func(m *mockLoanRepository) *mockLoanRepository {
+			return m
+		},
+		err: ErrCopiesAvailable,
+	}, {
+		name: "book taken by the user",
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			return m
+		},
+		mockBookRepo: func(m *mockBookRepository) *mockBookRepository {
+			m.On("Lock", mock.Anything, uint(2)).Return(nil).Once()
+			m.On("Find", mock.Anything, "test").Return(book, nil).Once()
+			return m
+		},
+		mockLoanRepo: func(m *mockLoanRepository) *mockLoanRepository {
+			m.On("FindActive", mock.Anything, uint(1), uint(2)).Return(entities.Loan{UserID: 1, BookID: 2}, nil).Once()
+			return m
+		},
+		err: repositories.ErrBookAlreadyTaken,
+	}, {
+		name: "already on hold",
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("CountActive", mock.Anything, uint(1), uint(2)).Return(int64(1), nil).Once()
+			return m
+		},
+		mockBookRepo: func(m *mockBookRepository) *mockBookRepository {
+			m.On("Lock", mock.Anything, uint(2)).Return(nil).Once()
+			m.On("Find", mock.Anything, "test").Return(book, nil).Once()
+			return m
+		},
+		mockLoanRepo: func(m *mockLoanRepository) *mockLoanRepository {
+			m.On("FindActive", mock.Anything, uint(1), uint(2)).Return(entities.Loan{}, gorm.ErrRecordNotFound).Once()
+			return m
+		},
+		err: ErrAlreadyOnHold,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockHolds := tt.mockHoldRepo(&mockHoldRepository{})
+			mockBooks := tt.mockBookRepo(&mockBookRepository{})
+			mockLoans := tt.mockLoanRepo(&mockLoanRepository{})
+			mockUsers := &mockUserRepository{}
+			mockUsers.On("Lock", mock.Anything, uint(1)).Return(nil).Once()
+			uow := &mockUnitOfWork{books: mockBooks, users: mockUsers, loans: mockLoans, holds: mockHolds, categories: publicCategories()}
+			service := NewHoldService(&mockHoldRepository{}, uow)
+
+			actual, err := service.Place(ctx, user, book)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.expected, actual)
+			mockUsers.AssertExpectations(t)
+			mockHolds.AssertExpectations(t)
+			mockBooks.AssertExpectations(t)
+			mockLoans.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_HoldService_Cancel(t *testing.T) {
+	ctx := context.Background()
+	user := entities.User{Model: gorm.Model{ID: 1}, Email: "email"}
+	book := entities.Book{Model: gorm.Model{ID: 2}, Isbn: "test"}
+	itemID := uint(5)
+	held := entities.Item{ID: 5, BookID: 2, Condition: entities.ConditionGood, Status: entities.ItemOnHold}
+
+	tests := []struct {
+		name         string
+		mockHoldRepo func(m *mockHoldRepository) *mockHoldRepository
+		mockItemRepo func(m *mockItemRepository) *mockItemRepository
+		err          error
+	}{{
+		name: "waiting hold",
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("Cancel", mock.Anything, uint(1), uint(2)).Return(entities.Hold{Status: entities.HoldCancelled}, nil).Once()
+			return m
+		},
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			return m
+		},
+	}, {
+		name: "ready hold passes the copy on",
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("Cancel", mock.Anything, uint(1), uint(2)).Return(entities.Hold{ItemID: &itemID}, nil).Once()
+			m.On("AllocateNext", mock.Anything, uint(2), uint(5), mock.Anything).Return(nil).Once()
+			return m
+		},
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			m.On("FindByID", mock.Anything, uint(5)).Return(held, nil).Once()
+			return m
+		},
+	}, {
+		name: "ready hold with an empty queue returns the copy to the shelf",
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("Cancel", mock.Anything, uint(1), uint(2)).Return(entities.Hold{ItemID: &itemID}, nil).Once()
+			m.On("AllocateNext", mock.Anything, uint(2), uint(5), mock.Anything).Return(repositories.ErrNoWaitingHolds).Once()
+			return m
+		},
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			m.On("FindByID", mock.Anything, uint(5)).Return(held, nil).Once()
+			m.On("Transition", mock.Anything, held, entities.ItemOnHold, entities.ItemAvailable).Return(nil).Once()
+			return m
+		},
+	}, {
+		name: "no active hold",
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("Cancel", mock.Anything, uint(1), uint(2)).Return(entities.Hold{}, repositories.ErrHoldNotFound).Once()
+			return m
+		},
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			return m
+		},
+		err: repositories.ErrHoldNotFound,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockHolds := tt.mockHoldRepo(&mockHoldRepository{})
+			mockItems := tt.mockItemRepo(&mockItemRepository{})
+			uow := &mockUnitOfWork{items: mockItems, holds: mockHolds}
+			service := NewHoldService(&mockHoldRepository{}, uow)
+
+			err := service.Cancel(ctx, user, book)
+			assert.Equal(t, tt.err, err)
+			mockHolds.AssertExpectations(t)
+			mockItems.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_HoldService_Reorder(t *testing.T) {
+	ctx := context.Background()
+	book := entities.Book{Model: gorm.Model{ID: 2}, Isbn: "test"}
+	queue := []entities.Hold{{ID: 1, Position: 1}, {ID: 3, Position: 2}, {ID: 2, Position: 3}}
+
+	tests := []struct {
+		name         string
+		position     int
+		mockHoldRepo func(m *mockHoldRepository) *mockHoldRepository
+		err          error
+	}{{
+		name:     "move to the end",
+		position: 3,
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("FindWaiting", mock.Anything, uint(2)).Return(queue, nil).Once()
+			m.On("SetPosition", mock.Anything, uint(1), uint(2), uint(1)).Return(nil).Once()
+			m.On("SetPosition", mock.Anything, uint(2), uint(2), uint(2)).Return(nil).Once()
+			m.On("SetPosition", mock.Anything, uint(3), uint(2), uint(3)).Return(nil).Once()
+			return m
+		},
+	}, {
+		name:     "move to the middle",
+		position: 2,
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("FindWaiting", mock.Anything, uint(2)).Return(queue, nil).Once()
+			m.On("SetPosition", mock.Anything, uint(1), uint(2), uint(1)).Return(nil).Once()
+			m.On("SetPosition", mock.Anything, uint(3), uint(2), uint(2)).Return(nil).Once()
+			m.On("SetPosition", mock.Anything, uint(2), uint(2), uint(3)).Return(nil).Once()
+			return m
+		},
+	}, {
+		name:     "position outside of the queue",
+		position: 4,
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("FindWaiting", mock.Anything, uint(2)).Return(queue, nil).Once()
+			return m
+		},
+		err: ErrInvalidPosition,
+	}, {
+		name:     "non positive position",
+		position: 0,
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			return m
+		},
+		err: ErrInvalidPosition,
+	}, {
+		name:     "hold not waiting",
+		position: 1,
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("FindWaiting", mock.Anything, uint(2)).Return(queue[:1], nil).Once()
+			return m
+		},
+		err: repositories.ErrHoldNotFound,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockHolds := tt.mockHoldRepo(&mockHoldRepository{})
+			mockBooks := &mockBookRepository{}
+			if tt.position > 0 {
+				mockBooks.On("Lock", mock.Anything, uint(2)).Return(nil).Once()
+			}
+			service := NewHoldService(&mockHoldRepository{}, &mockUnitOfWork{books: mockBooks, holds: mockHolds})
+
+			err := service.Reorder(ctx, book, 3, tt.position)
+			assert.Equal(t, tt.err, err)
+			mockHolds.AssertExpectations(t)
+			mockBooks.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_HoldService_ExpireReady(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	book := entities.Book{Model: gorm.Model{ID: 2}, Isbn: "test"}
+	itemID := uint(5)
+	held := entities.Item{ID: 5, BookID: 2, Condition: entities.ConditionGood, Status: entities.ItemOnHold}
+	expired := entities.Hold{ID: 1, BookID: 2, Book: book, ItemID: &itemID, Status: entities.HoldReady}
+	pickedUp := entities.Hold{ID: 2, BookID: 2, Book: book, ItemID: &itemID, Status: entities.HoldReady}
+
+	repo := &mockHoldRepository{}
+	repo.On("FindExpired", mock.Anything, now).Return([]entities.Hold{expired, pickedUp}, nil)
+	txHolds := &mockHoldRepository{}
+	txHolds.On("Expire", mock.Anything, expired, now).Return(nil).Once()
+	txHolds.On("Expire", mock.Anything, pickedUp, now).Return(repositories.ErrHoldNotFound).Once()
+	txHolds.On("AllocateNext", mock.Anything, uint(2), uint(5), now.Add(entities.DefaultPickupWindow)).Return(nil).Once()
+	txItems := &mockItemRepository{}
+	txItems.On("FindByID", mock.Anything, uint(5)).Return(held, nil).Once()
+
+	service := NewHoldService(repo, &mockUnitOfWork{holds: txHolds, items: txItems})
+	count, err := service.ExpireReady(ctx, now)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	repo.AssertExpectations(t)
+	txHolds.AssertExpectations(t)
+	txItems.AssertExpectations(t)
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+)
+
+// ErrBarcodeConflict is returned when an item is given the barcode of another item
+var ErrBarcodeConflict = errors.New("item with this barcode already exists")
+
+// ItemUpdate holds the changes of an item, nil fields are left as they are
+type ItemUpdate struct {
+	Condition *string `json:"Condition" binding:"omitempty,oneof=good worn damaged"`
+	Location  *string `json:"Location" binding:"omitempty,max=100"`
+}
+
+type ItemService interface {
+	Add(ctx context.Context, book entities.Book, item entities.Item) (entities.Item, error)
+	Find(ctx context.Context, barcode string) (entities.Item, error)
+	FindByBook(ctx context.Context, book entities.Book) ([]entities.Item, error)
+	Update(ctx context.Context, item entities.Item, update ItemUpdate) (entities.Item, error)
+	Withdraw(ctx context.Context, item entities.Item) error
+}
+
+type itemService struct {
+	repository repositories.ItemRepository
+	unitOfWork repositories.UnitOfWork
+}
+
+func NewItemService(repo repositories.ItemRepository, unitOfWork repositories.UnitOfWork) *itemService {
+	return &itemService{
+		repository: repo,
+		unitOfWork: unitOfWork,
+	}
+}
+
+// Add puts a new copy of the book on the shelf. It goes to the waiting holds first.
+func (s *itemService) Add(ctx context.Context, book entities.Book, item entities.Item) (entities.Item, error) {
+	if _, err := s.repository.Find(ctx, item.Barcode); err == nil {
+		return entities.Item{}, ErrBarcodeConflict
+	}
+	now := time.Now()
+	item.BookID = book.ID
+	if item.AcquiredAt.IsZero() {
+		item.AcquiredAt = now
+	}
+	err := s.unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+		if _, err := store.Items().Create(ctx, item); err != nil {
+			return err
+		}
+		return allocateShelfCopies(ctx, store, book.ID, now)
+	})
+	if err != nil {
+		return entities.Item{}, err
+	}
+	return s.repository.Find(ctx, item.Barcode)
+}
+
+func (s *itemService) Find(ctx context.Context, barcode string) (entities.Item, error) {
+	return s.repository.Find(ctx, barcode)
+}
+
+func (s *itemService) FindByBook(ctx context.Context, book entities.Book) ([]entities.Item, error) {
+	return s.repository.FindByBook(ctx, book.ID)
+}
+
+// Update changes the condition and the location of the item. A repaired copy
+// on the shelf goes to the waiting holds first.
+func (s *itemService) Update(ctx context.Context, item entities.Item, update ItemUpdate) (entities.Item, error) {
+	if update.Condition != nil {
+		item.Condition = *update.Condition
+	}
+	if update.Location != nil {
+		item.Location = *update.Location
+	}
+	err := s.unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+		if err := store.Items().Update(ctx, item); err != nil {
+			return err
+		}
+		return allocateShelfCopies(ctx, store, item.BookID, time.Now())
+	})
+	if err != nil {
+		return entities.Item{}, err
+	}
+	return s.repository.Find(ctx, item.Barcode)
+}
+
+// Withdraw takes a copy on the shelf out of the collection. The item is kept for the loan history.
+func (s *itemService) Withdraw(ctx context.Context, item entities.Item) error {
+	return s.repository.Transition(ctx, item, entities.ItemAvailable, entities.ItemWithdrawn)
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+type mockItemRepository struct {
+	mock.Mock
+}
+
+func (m *mockItemRepository) Create(ctx context.Context, item entities.Item) (entities.Item, error) {
+	args := m.Called(ctx, item)
+	return args.Get(0).(entities.Item), args.Error(1)
+}
+
+func (m *mockItemRepository) Find(ctx context.Context, barcode string) (entities.Item, error) {
+	args := m.Called(ctx, barcode)
+	return args.Get(0).(entities.Item), args.Error(1)
+}
+
+func (m *mockItemRepository) FindByID(ctx context.Context, id uint) (entities.Item, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(entities.Item), args.Error(1)
+}
+
+func (m *mockItemRepository) FindByBook(ctx context.Context, bookId uint) ([]entities.Item, error) {
+	args := m.Called(ctx, bookId)
+	return args.Get(0).([]entities.Item), args.Error(1)
+}
+
+func (m *mockItemRepository) Update(ctx context.Context, item entities.Item) error {
+	args := m.Called(ctx, item)
+	return args.Error(0)
+}
+
+func (m *mockItemRepository) Claim(ctx context.Context, bookId uint, status string) (entities.Item, error) {
+	args := m.Called(ctx, bookId, status)
+	return args.Get(0).(entities.Item), args.Error(1)
+}
+
+func (m *mockItemRepository) Transition(ctx context.Context, item entities.Item, from string, to string) error {
+	args := m.Called(ctx, item, from, to)
+	return args.Error(0)
+}
+
+func Test_NewItemService(t *testing.T) {
+	service := NewItemService(&mockItemRepository{}, &mockUnitOfWork{})
+	assert.NotNil(t, service.repository)
+	assert.NotNil(t, service.unitOfWork)
+}
+
+func Test_ItemService_Add(t *testing.T) {
+	ctx := context.Background()
+	book := entities.Book{Model: gorm.Model{ID: 2}, Isbn: "test"}
+	acquired := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	item := entities.Item{Barcode: "0001", AcquiredAt: acquired}
+	created := entities.Item{ID: 5, Barcode: "0001", BookID: 2, AcquiredAt: acquired, Status: entities.ItemAvailable}
+
+	tests := []struct {
+		name         string
+		mockItemRepo func(m *mockItemRepository) *mockItemRepository
+		mockTxItems  func(m *mockItemRepository) *mockItemRepository
+		mockTxHolds  func(m *mockHoldRepository) *mockHoldRepository
+		expected     entities.Item
+		err          error
+	}{{
+		name: "copy goes to the shelf",
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			m.On("Find", mock.Anything, "0001").Return(entities.Item{}, gorm.ErrRecordNotFound).Once()
+			m.On("Find", mock.Anything, "0001").Return(created, nil).Once()
+			return m
+		},
+		mockTxItems: func(m *mockItemRepository) *mockItemRepository {
+			m.On("Create", mock.Anything, entities.Item{Barcode: "0001", BookID: 2, AcquiredAt: acquired}).Return(created, nil).Once()
+			m.On("Claim", mock.Anything, uint(2), entities.ItemOnHold).Return(created, nil).Once()
+			m.On("Transition", mock.Anything, created, entities.ItemOnHold, entities.ItemAvailable).Return(nil).Once()
+			return m
+		},
+		mockTxHolds: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("AllocateNext", mock.Anything, uint(2), uint(5), mock.Anything).Return(repositories.ErrNoWaitingHolds).Once()
+			return m
+		},
+		expected: created,
+	}, {
+		name: "copy goes to the waiting hold",
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			m.On("Find", mock.Anything, "0001").Return(entities.Item{}, gorm.ErrRecordNotFound).Once()
+			m.On("Find", mock.Anything, "0001").Return(created, nil).Once()
+			return m
+		},
+		mockTxItems: func(m *mockItemRepository) *mockItemRepository {
+			m.On("Create", mock.Anything, entities.Item{Barcode: "0001", BookID: 2, AcquiredAt: acquired}).Return(created, nil).Once()
+			m.On("Claim", mock.Anything, uint(2), entities.ItemOnHold).Return(created, nil).Once()
+			m.On("Claim", mock.Anything, uint(2), entities.ItemOnHold).Return(entities.Item{}, repositories.ErrNoAvailableUnits).Once()
+			return m
+		},
+		mockTxHolds: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("AllocateNext", mock.Anything, uint(2), uint(5), mock.Anything).Return(nil).Once()
+			return m
+		},
+		expected: created,
+	}, {
+		name: "barcode of another item",
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			m.On("Find", mock.Anything, "0001").Return(created, nil).Once()
+			return m
+		},
+		mockTxItems: func(m *mockItemRepository) *mockItemRepository {
+			return m
+		},
+		mockTxHolds: func(m *mockHoldRepository) *mockHoldRepository {
+			return m
+		},
+		err: ErrBarcodeConflict,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockItems := tt.mockItemRepo(&mockItemRepository{})
+			mockTxItems := tt.mockTxItems(&mockItemRepository{})
+			mockTxHolds := tt.mockTxHolds(&mockHoldRepository{})
+			service := NewItemService(mockItems, &mockUnitOfWork{items: mockTxItems, holds: mockTxHolds})
+
+			actual, err := service.Add(ctx, book, item)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.expected, actual)
+			mockItems.AssertExpectations(t)
+			mockTxItems.AssertExpectations(t)
+			mockTxHolds.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_ItemService_Update(t *testing.T) {
+	ctx := context.Background()
+	item := entities.Item{ID: 5, Barcode: "0001", BookID: 2, Condition: entities.ConditionDamaged, Status: entities.ItemAvailable}
+	repaired := item
+	repaired.Condition = entities.ConditionGood
+	good := entities.ConditionGood
+
+	mockItems := &mockItemRepository{}
+	mockItems.On("Find", mock.Anything, "0001").Return(repaired, nil).Once()
+	mockTxItems := &mockItemRepository{}
+	mockTxItems.On("Update", mock.Anything, repaired).Return(nil).Once()
+	mockTxItems.On("Claim", mock.Anything, uint(2), entities.ItemOnHold).Return(entities.Item{}, repositories.ErrNoAvailableUnits).Once()
+
+	service := NewItemService(mockItems, &mockUnitOfWork{items: mockTxItems})
+	actual, err := service.Update(ctx, item, ItemUpdate{Condition: &good})
+
+	assert.Nil(t, err)
+	assert.Equal(t, repaired, actual)
+	mockItems.AssertExpectations(t)
+	mockTxItems.AssertExpectations(t)
+}
+
+func Test_ItemService_Withdraw(t *testing.T) {
+	ctx := context.Background()
+	item := entities.Item{ID: 5, Barcode: "0001", BookID: 2, Status: entities.ItemOnLoan}
+	m := &mockItemRepository{}
+	m.On("Transition", mock.Anything, item, entities.ItemAvailable, entities.ItemWithdrawn).Return(repositories.ErrItemNotAvailable)
+
+	service := NewItemService(m, &mockUnitOfWork{})
+	err := service.Withdraw(ctx, item)
+
+	assert.Equal(t, repositories.ErrItemNotAvailable, err)
+	m.AssertExpectations(t)
+}
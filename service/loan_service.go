@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+)
+
+type LoanService interface {
+	FindByUser(ctx context.Context, user entities.User) ([]entities.Loan, error)
+	Renew(ctx context.Context, user entities.User, book entities.Book) (entities.Loan, error)
+}
+
+type loanService struct {
+	repository repositories.LoanRepository
+	categories repositories.CategoryRepository
+	unitOfWork repositories.UnitOfWork
+}
+
+func NewLoanService(repo repositories.LoanRepository, categories repositories.CategoryRepository, unitOfWork repositories.UnitOfWork) *loanService {
+	return &loanService{
+		repository: repo,
+		categories: categories,
+		unitOfWork: unitOfWork,
+	}
+}
+
+func (s *loanService) FindByUser(ctx context.Context, user entities.User) ([]entities.Loan, error) {
+	return s.repository.FindByUser(ctx, user.ID)
+}
+
+// Renew pushes the due date of the loan of the book by another loan period of the category
+// of the user. Overdue loans and loans which were renewed as many times as the category
+// allows are not renewed.
+func (s *loanService) Renew(ctx context.Context, user entities.User, book entities.Book) (entities.Loan, error) {
+	category, err := s.categories.Find(ctx, user.Category)
+	if err != nil {
+		return entities.Loan{}, err
+	}
+	var loan entities.Loan
+	err = s.unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+		var err error
+		loan, err = store.Loans().Renew(ctx, user.ID, book.ID, time.Now(), category.LoanPeriod(), category.MaxRenewals)
+		return err
+	})
+	if err != nil {
+		return entities.Loan{}, err
+	}
+	return loan, nil
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+type mockLoanRepository struct {
+	mock.Mock
+}
+
+func (m *mockLoanRepository) Create(ctx context.Context, loan entities.Loan) error {
+	args := m.Called(ctx, loan)
+	return args.Error(0)
+}
+
+func (m *mockLoanRepository) FindActive(ctx context.Context, userId uint, bookId uint) (entities.Loan, error) {
+	args := m.Called(ctx, userId, bookId)
+	return args.Get(0).(entities.Loan), args.Error(1)
+}
+
+func (m *mockLoanRepository) CountActive(ctx context.Context, userId uint) (int64, error) {
+	args := m.Called(ctx, userId)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *mockLoanRepository) FindByUser(ctx context.Context, userId uint) ([]entities.Loan, error) {
+	args := m.Called(ctx, userId)
+	return args.Get(0).([]entities.Loan), args.Error(1)
+}
+
+func (m *mockLoanRepository) FindByUsers(ctx context.Context, userIds []uint) ([]entities.Loan, error) {
+	args := m.Called(ctx, userIds)
+	return args.Get(0).([]entities.Loan), args.Error(1)
+}
+
+func (m *mockLoanRepository) Return(ctx context.Context, userId uint, bookId uint, returnedAt time.Time) (entities.Loan, error) {
+	args := m.Called(ctx, userId, bookId, returnedAt)
+	return args.Get(0).(entities.Loan), args.Error(1)
+}
+
+func (m *mockLoanRepository) Renew(ctx context.Context, userId uint, bookId uint, now time.Time, period time.Duration, maxRenewals uint) (entities.Loan, error) {
+	args := m.Called(ctx, userId, bookId, now, period, maxRenewals)
+	return args.Get(0).(entities.Loan), args.Error(1)
+}
+
+func (m *mockLoanRepository) ReturnItem(ctx context.Context, userId uint, itemId uint, returnedAt time.Time) (entities.Loan, error) {
+	args := m.Called(ctx, userId, itemId, returnedAt)
+	return args.Get(0).(entities.Loan), args.Error(1)
+}
+
+func Test_NewLoanService(t *testing.T) {
+	service := NewLoanService(&mockLoanRepository{}, &mockCategoryRepository{}, &mockUnitOfWork{})
+	assert.NotNil(t, service.repository)
+	assert.NotNil(t, service.categories)
+	assert.NotNil(t, service.unitOfWork)
+}
+
+func Test_LoanService_FindByUser(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	expectedLoans := []entities.Loan{{
+		UserID:     1,
+		BookID:     2,
+		BorrowedAt: now,
+		DueAt:      now.Add(entities.DefaultLoanPeriod),
+	}}
+	m := &mockLoanRepository{}
+	m.On("FindByUser", mock.Anything, uint(1)).Return(expectedLoans, nil)
+
+	service := NewLoanService(m, &mockCategoryRepository{}, &mockUnitOfWork{})
+	loans, err := service.FindByUser(ctx, entities.User{Model: gorm.Model{ID: 1}})
+
+	assert.Nil(t, err)
+	assert.Equal(t, expectedLoans, loans)
+	m.AssertExpectations(t)
+}
+
+func Test_LoanService_Renew(t *testing.T) {
+	ctx := context.Background()
+	user := entities.User{Model: gorm.Model{ID: 1}, Category: entities.CategoryStudent}
+	book := entities.Book{Model: gorm.Model{ID: 2}}
+	student := testCategories[1]
+	renewed := entities.Loan{UserID: 1, BookID: 2, RenewalCount: 1}
+
+	tests := []struct {
+		name     string
+		loan     entities.Loan
+		err      error
+		expected entities.Loan
+	}{{
+		name:     "success",
+		loan:     renewed,
+		expected: renewed,
+	}, {
+		name: "renewal limit",
+		loan: renewed,
+		err:  repositories.ErrRenewalLimit,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockLoanRepository{}
+			m.On("Renew", mock.Anything, uint(1), uint(2), mock.Anything, student.LoanPeriod(), student.MaxRenewals).Return(tt.loan, tt.err).Once()
+			categories := &mockCategoryRepository{}
+			categories.On("Find", mock.Anything, entities.CategoryStudent).Return(student, nil).Once()
+			service := NewLoanService(&mockLoanRepository{}, categories, &mockUnitOfWork{loans: m})
+
+			loan, err := service.Renew(ctx, user, book)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.expected, loan)
+			m.AssertExpectations(t)
+			categories.AssertExpectations(t)
+		})
+	}
+}
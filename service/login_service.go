@@ -0,0 +1,278 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidCredentials is returned for a wrong password and for an unknown email alike
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrUnknownLoginScope is returned when a lockout is not of an account or an address
+	ErrUnknownLoginScope = errors.New("unknown login scope")
+)
+
+// LoginBlockedError is returned while the logins to an account or from an address are
+// refused after too many failures
+type LoginBlockedError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *LoginBlockedError) Error() string {
+	return fmt.Sprintf("logins blocked for %s", e.RetryAfter)
+}
+
+// Throttle slows down the guessing of passwords. After FreeFailures failed logins every
+// failure blocks the logins for Backoff, doubled with every further failure up to
+// MaxBackoff, and LockoutFailures failures lock the logins out for Lockout. Failures are
+// forgotten Window after the last one and when a lockout ends.
+type Throttle struct {
+	FreeFailures    uint
+	Backoff         time.Duration
+	MaxBackoff      time.Duration
+	LockoutFailures uint
+	Lockout         time.Duration
+	Window          time.Duration
+}
+
+// LoginRules throttle the failed logins to every account and from every address. The limit
+// of an address is higher, as many users may share it.
+type LoginRules struct {
+	Account Throttle
+	Address Throttle
+}
+
+// DefaultLoginRules lock an account out for 15 minutes after 10 failures and an address for
+// an hour after 50
+var DefaultLoginRules = LoginRules{
+	Account: Throttle{
+		FreeFailures:    3,
+		Backoff:         time.Second,
+		MaxBackoff:      time.Minute,
+		LockoutFailures: 10,
+		Lockout:         15 * time.Minute,
+		Window:          time.Hour,
+	},
+	Address: Throttle{
+		FreeFailures:    10,
+		Backoff:         time.Second,
+		MaxBackoff:      time.Minute,
+		LockoutFailures: 50,
+		Lockout:         time.Hour,
+		Window:          time.Hour,
+	},
+}
+
+// block is how long the logins are refused after the given number of failures and whether
+// they are locked out
+func (t Throttle) block(failures uint) (time.Duration, bool) {
+	if t.LockoutFailures > 0 && failures >= t.LockoutFailures {
+		return t.Lockout, true
+	}
+	if failures <= t.FreeFailures {
+		return 0, false
+	}
+	backoff := t.Backoff
+	for i := t.FreeFailures + 1; i < failures && backoff < t.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > t.MaxBackoff {
+		backoff = t.MaxBackoff
+	}
+	return backoff, false
+}
+
+// LoginService checks the credentials of the users and throttles the failed logins
+type LoginService interface {
+	Authenticate(ctx context.Context, email string, password string, address string) (entities.User, error)
+	Lockouts(ctx context.Context) ([]entities.LoginAttempt, error)
+	Unlock(ctx context.Context, scope string, subject string, actorId uint) error
+}
+
+type loginService struct {
+	loginAttemptRepository repositories.LoginAttemptRepository
+	unitOfWork             repositories.UnitOfWork
+	rules                  LoginRules
+	bcryptCost             int
+
+	unknownUserOnce sync.Once
+	unknownUserHash []byte
+}
+
+func NewLoginService(loginAttemptRepository repositories.LoginAttemptRepository, unitOfWork repositories.UnitOfWork, rules LoginRules, bcryptCost int) *loginService {
+	return &loginService{
+		loginAttemptRepository: loginAttemptRepository,
+		unitOfWork:             unitOfWork,
+		rules:                  rules,
+		bcryptCost:             bcryptCost,
+	}
+}
+
+// unknownUser is the hash compared with the password of logins to unknown accounts, so they
+// take as long as the logins to the existing ones
+func (s *loginService) unknownUser() []byte {
+	s.unknownUserOnce.Do(func() {
+		s.unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte("no user has this password"), s.bcryptCost)
+	})
+	return s.unknownUserHash
+}
+
+// Authenticate returns the user with the email when the password is theirs. While the
+// account or the address is blocked the password is not even checked. Unknown emails are
+// counted and blocked like the existing ones, so neither the response nor the lockout
+// tells whether an account exists. The counters of the account and the address stay locked
+// from the check until the failure is counted, so concurrent guesses wait for each other
+// and every one of them is throttled.
+func (s *loginService) Authenticate(ctx context.Context, email string, password string, address string) (entities.User, error) {
+	now := time.Now()
+	var user entities.User
+	failed := false
+	err := s.unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+		var addressAttempt entities.LoginAttempt
+		for _, scope := range []struct{ name, subject string }{
+			{entities.LoginScopeAddress, address},
+			{entities.LoginScopeAccount, email},
+		} {
+			attempt, err := store.LoginAttempts().Lock(ctx, scope.name, scope.subject)
+			if err != nil {
+				return err
+			}
+			if attempt.BlockedAt(now) {
+				return &LoginBlockedError{RetryAfter: attempt.BlockedUntil.Sub(now), Locked: attempt.Locked}
+			}
+			if scope.name == entities.LoginScopeAddress {
+				addressAttempt = attempt
+			}
+		}
+
+		var err error
+		user, err = store.Users().FindByEmail(ctx, email)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		hash := s.unknownUser()
+		if err == nil {
+			hash = []byte(user.Password)
+		}
+		if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || err != nil {
+			failed = true
+			return s.fail(ctx, store, email, address, now)
+		}
+
+		//the address keeps its failures, a valid login does not excuse guessing other accounts
+		if err := store.LoginAttempts().Delete(ctx, entities.LoginScopeAccount, email); err != nil {
+			return err
+		}
+		if addressAttempt.Failures > 0 {
+			return nil
+		}
+		return store.LoginAttempts().Delete(ctx, entities.LoginScopeAddress, address)
+	})
+	if err != nil {
+		return entities.User{}, err
+	}
+	if failed {
+		s.forgetStale(ctx, now)
+		return entities.User{}, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// fail counts a failed login to the account from the address and blocks them when they
+// failed too often. Every lockout is recorded in the audit log.
+func (s *loginService) fail(ctx context.Context, store repositories.Store, email string, address string, now time.Time) error {
+	if err := countFailure(ctx, store, entities.LoginScopeAccount, email, s.rules.Account, now); err != nil {
+		return err
+	}
+	return countFailure(ctx, store, entities.LoginScopeAddress, address, s.rules.Address, now)
+}
+
+// forgetStale deletes the counters whose failures are forgotten already. Every unknown email
+// gets a counter, so without it the guesses would fill the table.
+func (s *loginService) forgetStale(ctx context.Context, now time.Time) {
+	for _, scope := range []struct {
+		name     string
+		throttle Throttle
+	}{
+		{entities.LoginScopeAccount, s.rules.Account},
+		{entities.LoginScopeAddress, s.rules.Address},
+	} {
+		_, err := s.loginAttemptRepository.DeleteStale(ctx, scope.name, now.Add(-scope.throttle.Window), now)
+		if err != nil {
+			zap.L().Error("unable to delete the stale login counters", zap.String("scope", scope.name), zap.Error(err))
+		}
+	}
+}
+
+func countFailure(ctx context.Context, store repositories.Store, scope string, subject string, throttle Throttle, now time.Time) error {
+	attempt, err := store.LoginAttempts().Increment(ctx, scope, subject)
+	if err != nil {
+		return err
+	}
+	expired := attempt.Locked && !attempt.BlockedAt(now)
+	if expired || (attempt.Failures > 1 && now.Sub(attempt.LastFailure) > throttle.Window) {
+		attempt.Failures = 1
+	}
+	attempt.LastFailure = now
+	attempt.Locked = false
+	attempt.BlockedUntil = nil
+	block, locked := throttle.block(attempt.Failures)
+	if block > 0 {
+		until := now.Add(block)
+		attempt.BlockedUntil = &until
+		attempt.Locked = locked
+	}
+	if err := store.LoginAttempts().Save(ctx, attempt); err != nil {
+		return err
+	}
+	if !locked {
+		return nil
+	}
+	action := entities.AuditAccountLocked
+	if scope == entities.LoginScopeAddress {
+		action = entities.AuditAddressLocked
+	}
+	return store.Audit().Record(ctx, entities.AuditEvent{
+		Action:  action,
+		Subject: subject,
+		Detail:  fmt.Sprintf("locked until %s after %d failed logins", attempt.BlockedUntil.Format(time.RFC3339), attempt.Failures),
+	})
+}
+
+// Lockouts returns the accounts and addresses which are locked out now
+func (s *loginService) Lockouts(ctx context.Context) ([]entities.LoginAttempt, error) {
+	return s.loginAttemptRepository.FindLocked(ctx, time.Now())
+}
+
+// Unlock forgets the failed logins of an account or an address, which lifts its lockout or
+// backoff, and records who unlocked it in the audit log
+func (s *loginService) Unlock(ctx context.Context, scope string, subject string, actorId uint) error {
+	action := entities.AuditAccountUnlocked
+	switch scope {
+	case entities.LoginScopeAccount:
+	case entities.LoginScopeAddress:
+		action = entities.AuditAddressUnlocked
+	default:
+		return ErrUnknownLoginScope
+	}
+	return s.unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+		if err := store.LoginAttempts().Delete(ctx, scope, subject); err != nil {
+			return err
+		}
+		return store.Audit().Record(ctx, entities.AuditEvent{
+			Action:  action,
+			Subject: subject,
+			ActorID: &actorId,
+		})
+	})
+}
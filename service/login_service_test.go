@@ -0,0 +1,388 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+type mockLoginAttemptRepository struct {
+	mock.Mock
+}
+
+func (m *mockLoginAttemptRepository) Find(ctx context.Context, scope string, subject string) (entities.LoginAttempt, error) {
+	args := m.Called(ctx, scope, subject)
+	return args.Get(0).(entities.LoginAttempt), args.Error(1)
+}
+
+func (m *mockLoginAttemptRepository) Lock(ctx context.Context, scope string, subject string) (entities.LoginAttempt, error) {
+	args := m.Called(ctx, scope, subject)
+	return args.Get(0).(entities.LoginAttempt), args.Error(1)
+}
+
+func (m *mockLoginAttemptRepository) Increment(ctx context.Context, scope string, subject string) (entities.LoginAttempt, error) {
+	args := m.Called(ctx, scope, subject)
+	return args.Get(0).(entities.LoginAttempt), args.Error(1)
+}
+
+func (m *mockLoginAttemptRepository) Save(ctx context.Context, attempt entities.LoginAttempt) error {
+	args := m.Called(ctx, attempt)
+	return args.Error(0)
+}
+
+func (m *mockLoginAttemptRepository) Delete(ctx context.Context, scope string, subject string) error {
+	args := m.Called(ctx, scope, subject)
+	return args.Error(0)
+}
+
+func (m *mockLoginAttemptRepository) FindLocked(ctx context.Context, now time.Time) ([]entities.LoginAttempt, error) {
+	args := m.Called(ctx, now)
+	return args.Get(0).([]entities.LoginAttempt), args.Error(1)
+}
+
+func (m *mockLoginAttemptRepository) DeleteStale(ctx context.Context, scope string, before time.Time, now time.Time) (int64, error) {
+	args := m.Called(ctx, scope, before, now)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+type mockAuditRepository struct {
+	mock.Mock
+}
+
+func (m *mockAuditRepository) Record(ctx context.Context, event entities.AuditEvent) error {
+	args := m.Called(ctx, event)
+	return args.Error(0)
+}
+
+func (m *mockAuditRepository) FindAll(ctx context.Context, page repositories.Page) ([]entities.AuditEvent, int64, error) {
+	args := m.Called(ctx, page)
+	return args.Get(0).([]entities.AuditEvent), args.Get(1).(int64), args.Error(2)
+}
+
+var testThrottle = Throttle{
+	FreeFailures:    2,
+	Backoff:         time.Second,
+	MaxBackoff:      10 * time.Second,
+	LockoutFailures: 8,
+	Lockout:         time.Hour,
+	Window:          time.Hour,
+}
+
+func Test_Throttle_Block(t *testing.T) {
+	tests := []struct {
+		failures uint
+		block    time.Duration
+		locked   bool
+	}{
+		{failures: 1, block: 0},
+		{failures: 2, block: 0},
+		{failures: 3, block: time.Second},
+		{failures: 4, block: 2 * time.Second},
+		{failures: 5, block: 4 * time.Second},
+		{failures: 6, block: 8 * time.Second},
+		{failures: 7, block: 10 * time.Second},
+		{failures: 8, block: time.Hour, locked: true},
+		{failures: 20, block: time.Hour, locked: true},
+	}
+	for _, tt := range tests {
+		block, locked := testThrottle.block(tt.failures)
+		assert.Equal(t, tt.block, block, "failures: %d", tt.failures)
+		assert.Equal(t, tt.locked, locked, "failures: %d", tt.failures)
+	}
+}
+
+func Test_LoginService_Authenticate(t *testing.T) {
+	ctx := context.Background()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	user := entities.User{Model: gorm.Model{ID: 1}, Email: "email", Password: string(hash)}
+	blockedUntil := time.Now().Add(time.Minute)
+	rules := LoginRules{Account: testThrottle, Address: testThrottle}
+
+	// noFailures locks the counters of the account and the address, which have no failures
+	noFailures := func(m *mockLoginAttemptRepository) *mockLoginAttemptRepository {
+		m.On("Lock", mock.Anything, entities.LoginScopeAddress, "127.0.0.1").Return(entities.LoginAttempt{Scope: entities.LoginScopeAddress, Subject: "127.0.0.1"}, nil).Once()
+		m.On("Lock", mock.Anything, entities.LoginScopeAccount, "email").Return(entities.LoginAttempt{Scope: entities.LoginScopeAccount, Subject: "email"}, nil).Once()
+		return m
+	}
+	// forgetStale deletes the counters forgotten an hour ago
+	forgetStale := func(m *mockLoginAttemptRepository) *mockLoginAttemptRepository {
+		for _, scope := range []string{entities.LoginScopeAccount, entities.LoginScopeAddress} {
+			m.On("DeleteStale", mock.Anything, scope, mock.MatchedBy(func(before time.Time) bool {
+				return time.Since(before) >= time.Hour && time.Since(before) < time.Hour+time.Minute
+			}), mock.Anything).Return(int64(0), nil).Once()
+		}
+		return m
+	}
+
+	tests := []struct {
+		name         string
+		password     string
+		mockUsers    func(m *mockUserRepository) *mockUserRepository
+		mockAttempts func(m *mockLoginAttemptRepository) *mockLoginAttemptRepository
+		err          error
+		blocked      *LoginBlockedError
+	}{{
+		name:     "success",
+		password: "password",
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		mockAttempts: func(m *mockLoginAttemptRepository) *mockLoginAttemptRepository {
+			noFailures(m)
+			m.On("Delete", mock.Anything, entities.LoginScopeAccount, "email").Return(nil).Once()
+			m.On("Delete", mock.Anything, entities.LoginScopeAddress, "127.0.0.1").Return(nil).Once()
+			return m
+		},
+	}, {
+		name:     "success from an address with failures",
+		password: "password",
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		mockAttempts: func(m *mockLoginAttemptRepository) *mockLoginAttemptRepository {
+			m.On("Lock", mock.Anything, entities.LoginScopeAddress, "127.0.0.1").Return(entities.LoginAttempt{Failures: 2}, nil).Once()
+			m.On("Lock", mock.Anything, entities.LoginScopeAccount, "email").Return(entities.LoginAttempt{Failures: 1}, nil).Once()
+			m.On("Delete", mock.Anything, entities.LoginScopeAccount, "email").Return(nil).Once()
+			return m
+		},
+	}, {
+		name:     "wrong password",
+		password: "wrong",
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		mockAttempts: func(m *mockLoginAttemptRepository) *mockLoginAttemptRepository {
+			noFailures(m)
+			m.On("Increment", mock.Anything, entities.LoginScopeAccount, "email").Return(entities.LoginAttempt{Scope: entities.LoginScopeAccount, Subject: "email", Failures: 1}, nil)
+			m.On("Increment", mock.Anything, entities.LoginScopeAddress, "127.0.0.1").Return(entities.LoginAttempt{Scope: entities.LoginScopeAddress, Subject: "127.0.0.1", Failures: 1}, nil)
+			m.On("Save", mock.Anything, mock.MatchedBy(func(a entities.LoginAttempt) bool {
+				return a.Failures == 1 && a.BlockedUntil == nil && !a.Locked
+			})).Return(nil).Twice()
+			return forgetStale(m)
+		},
+		err: ErrInvalidCredentials,
+	}, {
+		name:     "unknown user",
+		password: "password",
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{}, gorm.ErrRecordNotFound)
+			return m
+		},
+		mockAttempts: func(m *mockLoginAttemptRepository) *mockLoginAttemptRepository {
+			noFailures(m)
+			m.On("Increment", mock.Anything, entities.LoginScopeAccount, "email").Return(entities.LoginAttempt{Scope: entities.LoginScopeAccount, Subject: "email", Failures: 1}, nil)
+			m.On("Increment", mock.Anything, entities.LoginScopeAddress, "127.0.0.1").Return(entities.LoginAttempt{Scope: entities.LoginScopeAddress, Subject: "127.0.0.1", Failures: 1}, nil)
+			m.On("Save", mock.Anything, mock.Anything).Return(nil).Twice()
+			return forgetStale(m)
+		},
+		err: ErrInvalidCredentials,
+	}, {
+		name:     "account locked",
+		password: "password",
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			return m
+		},
+		mockAttempts: func(m *mockLoginAttemptRepository) *mockLoginAttemptRepository {
+			m.On("Lock", mock.Anything, entities.LoginScopeAddress, "127.0.0.1").Return(entities.LoginAttempt{}, nil)
+			m.On("Lock", mock.Anything, entities.LoginScopeAccount, "email").Return(entities.LoginAttempt{Failures: 8, BlockedUntil: &blockedUntil, Locked: true}, nil)
+			return m
+		},
+		blocked: &LoginBlockedError{Locked: true},
+	}, {
+		name:     "address backing off",
+		password: "password",
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			return m
+		},
+		mockAttempts: func(m *mockLoginAttemptRepository) *mockLoginAttemptRepository {
+			m.On("Lock", mock.Anything, entities.LoginScopeAddress, "127.0.0.1").Return(entities.LoginAttempt{Failures: 3, BlockedUntil: &blockedUntil}, nil)
+			return m
+		},
+		blocked: &LoginBlockedError{Locked: false},
+	}, {
+		name:     "internal error",
+		password: "password",
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{}, errors.New("db down"))
+			return m
+		},
+		mockAttempts: noFailures,
+		err:          errors.New("db down"),
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockUsers := tt.mockUsers(&mockUserRepository{})
+			mockAttempts := tt.mockAttempts(&mockLoginAttemptRepository{})
+			unitOfWork := &mockUnitOfWork{users: mockUsers, attempts: mockAttempts, audit: &mockAuditRepository{}}
+			service := NewLoginService(mockAttempts, unitOfWork, rules, testBcryptCost)
+
+			authenticated, err := service.Authenticate(ctx, "email", tt.password, "127.0.0.1")
+
+			switch {
+			case tt.blocked != nil:
+				var blocked *LoginBlockedError
+				assert.True(t, errors.As(err, &blocked))
+				assert.Equal(t, tt.blocked.Locked, blocked.Locked)
+				assert.True(t, blocked.RetryAfter > 0 && blocked.RetryAfter <= time.Minute)
+			case tt.err != nil:
+				assert.Equal(t, tt.err, err)
+			default:
+				assert.Nil(t, err)
+				assert.Equal(t, user, authenticated)
+			}
+			mockUsers.AssertExpectations(t)
+			mockAttempts.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_LoginService_CountFailure(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	recent := now.Add(-time.Minute)
+	expiredLock := now.Add(-time.Second)
+
+	tests := []struct {
+		name     string
+		counter  entities.LoginAttempt
+		failures uint
+		block    time.Duration
+		locked   bool
+	}{{
+		name:     "backoff",
+		counter:  entities.LoginAttempt{Failures: 4, LastFailure: recent},
+		failures: 4,
+		block:    2 * time.Second,
+	}, {
+		name:     "lockout",
+		counter:  entities.LoginAttempt{Failures: 8, LastFailure: recent},
+		failures: 8,
+		block:    time.Hour,
+		locked:   true,
+	}, {
+		name:     "failures forgotten after the window",
+		counter:  entities.LoginAttempt{Failures: 7, LastFailure: now.Add(-2 * time.Hour)},
+		failures: 1,
+	}, {
+		name:     "failures forgotten after the lockout",
+		counter:  entities.LoginAttempt{Failures: 9, LastFailure: recent, BlockedUntil: &expiredLock, Locked: true},
+		failures: 1,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			counter := tt.counter
+			counter.Scope = entities.LoginScopeAccount
+			counter.Subject = "email"
+			mockAttempts := &mockLoginAttemptRepository{}
+			mockAttempts.On("Increment", mock.Anything, entities.LoginScopeAccount, "email").Return(counter, nil)
+			mockAttempts.On("Save", mock.Anything, mock.Anything).Return(nil)
+			mockAudit := &mockAuditRepository{}
+			if tt.locked {
+				mockAudit.On("Record", mock.Anything, mock.MatchedBy(func(e entities.AuditEvent) bool {
+					return e.Action == entities.AuditAccountLocked && e.Subject == "email" && e.ActorID == nil
+				})).Return(nil)
+			}
+
+			err := countFailure(ctx, &mockUnitOfWork{attempts: mockAttempts, audit: mockAudit}, entities.LoginScopeAccount, "email", testThrottle, now)
+
+			assert.Nil(t, err)
+			saved := mockAttempts.Calls[1].Arguments.Get(1).(entities.LoginAttempt)
+			assert.Equal(t, tt.failures, saved.Failures)
+			assert.Equal(t, now, saved.LastFailure)
+			assert.Equal(t, tt.locked, saved.Locked)
+			if tt.block > 0 {
+				assert.Equal(t, now.Add(tt.block), *saved.BlockedUntil)
+			} else {
+				assert.Nil(t, saved.BlockedUntil)
+			}
+			mockAttempts.AssertExpectations(t)
+			mockAudit.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_LoginService_Unlock(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name         string
+		scope        string
+		mockAttempts func(m *mockLoginAttemptRepository) *mockLoginAttemptRepository
+		mockAudit    func(m *mockAuditRepository) *mockAuditRepository
+		err          error
+	}{{
+		name:  "account",
+		scope: entities.LoginScopeAccount,
+		mockAttempts: func(m *mockLoginAttemptRepository) *mockLoginAttemptRepository {
+			m.On("Delete", mock.Anything, entities.LoginScopeAccount, "subject").Return(nil)
+			return m
+		},
+		mockAudit: func(m *mockAuditRepository) *mockAuditRepository {
+			m.On("Record", mock.Anything, mock.MatchedBy(func(e entities.AuditEvent) bool {
+				return e.Action == entities.AuditAccountUnlocked && e.Subject == "subject" && *e.ActorID == 7
+			})).Return(nil)
+			return m
+		},
+	}, {
+		name:  "address",
+		scope: entities.LoginScopeAddress,
+		mockAttempts: func(m *mockLoginAttemptRepository) *mockLoginAttemptRepository {
+			m.On("Delete", mock.Anything, entities.LoginScopeAddress, "subject").Return(nil)
+			return m
+		},
+		mockAudit: func(m *mockAuditRepository) *mockAuditRepository {
+			m.On("Record", mock.Anything, mock.MatchedBy(func(e entities.AuditEvent) bool {
+				return e.Action == entities.AuditAddressUnlocked
+			})).Return(nil)
+			return m
+		},
+	}, {
+		name:  "not locked",
+		scope: entities.LoginScopeAccount,
+		mockAttempts: func(m *mockLoginAttemptRepository) *mockLoginAttemptRepository {
+			m.On("Delete", mock.Anything, entities.LoginScopeAccount, "subject").Return(gorm.ErrRecordNotFound)
+			return m
+		},
+		mockAudit: func(m *mockAuditRepository) *mockAuditRepository {
+			return m
+		},
+		err: gorm.ErrRecordNotFound,
+	}, {
+		name:  "unknown scope",
+		scope: "device",
+		mockAttempts: func(m *mockLoginAttemptRepository) *mockLoginAttemptRepository {
+			return m
+		},
+		mockAudit: func(m *mockAuditRepository) *mockAuditRepository {
+			return m
+		},
+		err: ErrUnknownLoginScope,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockAttempts := tt.mockAttempts(&mockLoginAttemptRepository{})
+			mockAudit := tt.mockAudit(&mockAuditRepository{})
+			unitOfWork := &mockUnitOfWork{attempts: mockAttempts, audit: mockAudit}
+			service := NewLoginService(&mockLoginAttemptRepository{}, unitOfWork, DefaultLoginRules, testBcryptCost)
+
+			err := service.Unlock(ctx, tt.scope, "subject", 7)
+
+			assert.Equal(t, tt.err, err)
+			mockAttempts.AssertExpectations(t)
+			mockAudit.AssertExpectations(t)
+		})
+	}
+}
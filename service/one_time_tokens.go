@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/mishozz/Library/auth"
+	"github.com/mishozz/Library/repositories"
+)
+
+// issueOneTimeToken replaces the tokens of the user issued for the purpose with a new one
+// and returns it. The tokens are random and opaque like the refresh tokens, only their
+// hash is stored.
+func issueOneTimeToken(ctx context.Context, unitOfWork repositories.UnitOfWork, userId uint, purpose string, ttl time.Duration) (string, error) {
+	token, err := auth.CreateRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	err = unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+		if err := store.Tokens().DeleteByUser(ctx, userId, purpose); err != nil {
+			return err
+		}
+		return store.Tokens().Create(ctx, userId, purpose, auth.HashToken(token), time.Now().Add(ttl))
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// spendOneTimeToken uses up the token issued for the purpose, together with the other
+// tokens of its user, and returns the user. Consuming the token is a conditional update,
+// so concurrent requests cannot both spend it.
+func spendOneTimeToken(ctx context.Context, store repositories.Store, purpose string, token string) (uint, error) {
+	spent, err := store.Tokens().Consume(ctx, purpose, auth.HashToken(token), time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return spent.UserID, store.Tokens().DeleteByUser(ctx, spent.UserID, purpose)
+}
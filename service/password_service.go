@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/mail"
+	"github.com/mishozz/Library/repositories"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const passwordResetSubject = "Reset your library password"
+
+const passwordResetBody = `Someone asked to reset the password of your library account.
+
+Use this token to choose a new password:
+
+%s
+
+The token expires in an hour and can be used once. If you did not ask for a new
+password you can ignore this email.`
+
+// PasswordService lets users who forgot their password choose a new one
+type PasswordService interface {
+	Forgot(ctx context.Context, email string) error
+	Reset(ctx context.Context, token string, password string) error
+	Wait()
+}
+
+type passwordService struct {
+	userRepository repositories.UserRepository
+	unitOfWork     repositories.UnitOfWork
+	tokenService   TokenService
+	mailer         mail.Mailer
+	bcryptCost     int
+	mailing        sync.WaitGroup
+}
+
+func NewPasswordService(userRepository repositories.UserRepository, unitOfWork repositories.UnitOfWork, tokenService TokenService, mailer mail.Mailer, bcryptCost int) *passwordService {
+	return &passwordService{
+		userRepository: userRepository,
+		unitOfWork:     unitOfWork,
+		tokenService:   tokenService,
+		mailer:         mailer,
+		bcryptCost:     bcryptCost,
+	}
+}
+
+// Forgot mails a reset token to the user and invalidates the tokens mailed before. Unknown
+// and disabled users get no email, but the caller cannot tell them apart from the others:
+// the token is issued and mailed in the background, so the answer takes as long for them.
+func (s *passwordService) Forgot(ctx context.Context, email string) error {
+	user, err := s.userRepository.FindByEmail(ctx, email)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && user.Disabled) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.mailing.Add(1)
+	go func() {
+		defer s.mailing.Done()
+		//the request is answered before the email is sent, its context is done by then
+		if err := s.mailResetToken(context.Background(), user); err != nil {
+			zap.L().Error("unable to mail the password reset token", zap.Uint("user", user.ID), zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+func (s *passwordService) mailResetToken(ctx context.Context, user entities.User) error {
+	token, err := issueOneTimeToken(ctx, s.unitOfWork, user.ID, entities.TokenPasswordReset, entities.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: passwordResetSubject,
+		Body:    fmt.Sprintf(passwordResetBody, token),
+	})
+}
+
+// Wait blocks until the reset tokens being mailed are sent
+func (s *passwordService) Wait() {
+	s.mailing.Wait()
+}
+
+// Reset spends the token and sets the new password of its user. Every session of the user
+// is revoked, so whoever knew the old password is logged out.
+func (s *passwordService) Reset(ctx context.Context, token string, password string) error {
+	hashed, err := hashPassword(password, s.bcryptCost)
+	if err != nil {
+		return err
+	}
+	var userId uint
+	err = s.unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+		userId, err = spendOneTimeToken(ctx, store, entities.TokenPasswordReset, token)
+		if err != nil {
+			return err
+		}
+		return store.Users().SetPassword(ctx, userId, hashed)
+	})
+	if err != nil {
+		return err
+	}
+	return s.tokenService.RevokeUser(ctx, uint64(userId))
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/mishozz/Library/auth"
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/mail"
+	"github.com/mishozz/Library/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+type mockOneTimeTokenRepository struct {
+	mock.Mock
+}
+
+func (m *mockOneTimeTokenRepository) Create(ctx context.Context, userId uint, purpose string, tokenHash string, expiresAt time.Time) error {
+	args := m.Called(ctx, userId, purpose, tokenHash, expiresAt)
+	return args.Error(0)
+}
+
+func (m *mockOneTimeTokenRepository) Consume(ctx context.Context, purpose string, tokenHash string, now time.Time) (entities.OneTimeToken, error) {
+	args := m.Called(ctx, purpose, tokenHash, now)
+	return args.Get(0).(entities.OneTimeToken), args.Error(1)
+}
+
+func (m *mockOneTimeTokenRepository) DeleteByUser(ctx context.Context, userId uint, purpose string) error {
+	args := m.Called(ctx, userId, purpose)
+	return args.Error(0)
+}
+
+func Test_NewPasswordService(t *testing.T) {
+	service := NewPasswordService(&mockUserRepository{}, &mockUnitOfWork{}, NewTokenService(&mockAuthRepository{}, &mockRefreshTokenRepository{}, testSigner, testRefreshTokenTTL), mail.NewLogMailer(&bytes.Buffer{}), testBcryptCost)
+	assert.NotNil(t, service.userRepository)
+	assert.NotNil(t, service.unitOfWork)
+	assert.NotNil(t, service.tokenService)
+	assert.NotNil(t, service.mailer)
+}
+
+func Test_PasswordService_Forgot(t *testing.T) {
+	ctx := context.Background()
+	user := entities.User{Model: gorm.Model{ID: 1}, Email: "email"}
+	disabled := entities.User{Model: gorm.Model{ID: 1}, Email: "email", Disabled: true}
+
+	tests := []struct {
+		name       string
+		mockUsers  func(m *mockUserRepository) *mockUserRepository
+		mockTokens func(m *mockOneTimeTokenRepository) *mockOneTimeTokenRepository
+		mailed     bool
+		err        error
+	}{{
+		name: "success",
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		mockTokens: func(m *mockOneTimeTokenRepository) *mockOneTimeTokenRepository {
+			m.On("DeleteByUser", mock.Anything, uint(1), entities.TokenPasswordReset).Return(nil)
+			m.On("Create", mock.Anything, uint(1), entities.TokenPasswordReset, mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).Return(nil)
+			return m
+		},
+		mailed: true,
+	}, {
+		name: "unknown user",
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{}, gorm.ErrRecordNotFound)
+			return m
+		},
+		mockTokens: func(m *mockOneTimeTokenRepository) *mockOneTimeTokenRepository {
+			return m
+		},
+	}, {
+		name: "disabled user",
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("FindByEmail", mock.Anything, "email").Return(disabled, nil)
+			return m
+		},
+		mockTokens: func(m *mockOneTimeTokenRepository) *mockOneTimeTokenRepository {
+			return m
+		},
+	}, {
+		name: "token not stored",
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		mockTokens: func(m *mockOneTimeTokenRepository) *mockOneTimeTokenRepository {
+			m.On("DeleteByUser", mock.Anything, uint(1), entities.TokenPasswordReset).Return(nil)
+			m.On("Create", mock.Anything, uint(1), entities.TokenPasswordReset, mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).Return(errors.New("error"))
+			return m
+		},
+	}, {
+		name: "user not found",
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{}, errors.New("error"))
+			return m
+		},
+		mockTokens: func(m *mockOneTimeTokenRepository) *mockOneTimeTokenRepository {
+			return m
+		},
+		err: errors.New("error"),
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockUsers := tt.mockUsers(&mockUserRepository{})
+			mockTokens := tt.mockTokens(&mockOneTimeTokenRepository{})
+			var outbox bytes.Buffer
+			service := NewPasswordService(mockUsers, &mockUnitOfWork{tokens: mockTokens}, NewTokenService(&mockAuthRepository{}, &mockRefreshTokenRepository{}, testSigner, testRefreshTokenTTL), mail.NewLogMailer(&outbox), testBcryptCost)
+
+			err := service.Forgot(ctx, "email")
+			service.Wait()
+
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.mailed, outbox.Len() > 0)
+			if tt.mailed {
+				//the mailed token is the one whose hash was stored
+				token := regexp.MustCompile(`\r\n\r\n([A-Za-z0-9_-]{43})\r\n`).FindStringSubmatch(outbox.String())
+				if assert.Len(t, token, 2) {
+					mockTokens.AssertCalled(t, "Create", mock.Anything, uint(1), entities.TokenPasswordReset, auth.HashToken(token[1]), mock.AnythingOfType("time.Time"))
+				}
+			}
+			mockUsers.AssertExpectations(t)
+			mockTokens.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_PasswordService_Reset(t *testing.T) {
+	ctx := context.Background()
+	newPassword := mock.MatchedBy(func(hashed string) bool {
+		return bcrypt.CompareHashAndPassword([]byte(hashed), []byte("new password")) == nil
+	})
+
+	tests := []struct {
+		name       string
+		mockTokens func(m *mockOneTimeTokenRepository) *mockOneTimeTokenRepository
+		mockUsers  func(m *mockUserRepository) *mockUserRepository
+		revoked    bool
+		err        error
+	}{{
+		name: "success",
+		mockTokens: func(m *mockOneTimeTokenRepository) *mockOneTimeTokenRepository {
+			m.On("Consume", mock.Anything, entities.TokenPasswordReset, auth.HashToken("token"), mock.AnythingOfType("time.Time")).Return(entities.OneTimeToken{UserID: 1}, nil)
+			m.On("DeleteByUser", mock.Anything, uint(1), entities.TokenPasswordReset).Return(nil)
+			return m
+		},
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("SetPassword", mock.Anything, uint(1), newPassword).Return(nil)
+			return m
+		},
+		revoked: true,
+	}, {
+		name: "invalid token",
+		mockTokens: func(m *mockOneTimeTokenRepository) *mockOneTimeTokenRepository {
+			m.On("Consume", mock.Anything, entities.TokenPasswordReset, auth.HashToken("token"), mock.AnythingOfType("time.Time")).Return(entities.OneTimeToken{}, repositories.ErrInvalidToken)
+			return m
+		},
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			return m
+		},
+		err: repositories.ErrInvalidToken,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockTokens := tt.mockTokens(&mockOneTimeTokenRepository{})
+			mockUsers := tt.mockUsers(&mockUserRepository{})
+			mockAuth, mockRefresh := revokedTokens(1, tt.revoked)
+			uow := &mockUnitOfWork{users: mockUsers, tokens: mockTokens}
+			service := NewPasswordService(&mockUserRepository{}, uow, NewTokenService(mockAuth, mockRefresh, testSigner, testRefreshTokenTTL), mail.NewLogMailer(&bytes.Buffer{}), testBcryptCost)
+
+			err := service.Reset(ctx, "token", "new password")
+
+			assert.Equal(t, tt.err, err)
+			mockTokens.AssertExpectations(t)
+			mockUsers.AssertExpectations(t)
+			mockAuth.AssertExpectations(t)
+			mockRefresh.AssertExpectations(t)
+		})
+	}
+}
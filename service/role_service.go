@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+)
+
+var (
+	// ErrUnknownPermission is returned when a role is given a permission the library does not check
+	ErrUnknownPermission = errors.New("unknown permission")
+	// ErrBuiltInRole is returned when the Admin role is changed or a built-in role is deleted
+	ErrBuiltInRole = errors.New("built-in role")
+)
+
+type RoleService interface {
+	FindAll(ctx context.Context) ([]entities.Role, error)
+	Save(ctx context.Context, name string, permissions entities.Permissions) (entities.Role, error)
+	Delete(ctx context.Context, name string) error
+}
+
+type roleService struct {
+	repository repositories.RoleRepository
+}
+
+func NewRoleService(repo repositories.RoleRepository) *roleService {
+	return &roleService{
+		repository: repo,
+	}
+}
+
+func (s *roleService) FindAll(ctx context.Context) ([]entities.Role, error) {
+	return s.repository.FindAll(ctx)
+}
+
+// Save creates the named role or replaces its permissions. The Admin role always has
+// every permission, so it cannot be changed. The users of the role get the new
+// permissions with their next request.
+func (s *roleService) Save(ctx context.Context, name string, permissions entities.Permissions) (entities.Role, error) {
+	if name == entities.RoleAdmin {
+		return entities.Role{}, ErrBuiltInRole
+	}
+	for _, permission := range permissions {
+		if !entities.AllPermissions.Has(permission) {
+			return entities.Role{}, ErrUnknownPermission
+		}
+	}
+	if err := s.repository.Save(ctx, entities.Role{Name: name, Permissions: permissions}); err != nil {
+		return entities.Role{}, err
+	}
+	return s.repository.Find(ctx, name)
+}
+
+// Delete removes a role which no user has. Admin and User are built in and cannot be deleted.
+func (s *roleService) Delete(ctx context.Context, name string) error {
+	if (entities.Role{Name: name}).BuiltIn() {
+		return ErrBuiltInRole
+	}
+	return s.repository.Delete(ctx, name)
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mishozz/Library/entities"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+// testRoles are the roles the seed migration creates
+var testRoles = []entities.Role{
+	{Name: entities.RoleAdmin, Permissions: entities.AllPermissions},
+	{Name: entities.RoleLibrarian, Permissions: entities.Permissions{
+		entities.PermissionBooksRead,
+		entities.PermissionBooksWrite,
+		entities.PermissionItemsManage,
+		entities.PermissionHoldsManage,
+		entities.PermissionLoansManage,
+		entities.PermissionFinesManage,
+		entities.PermissionUsersRead,
+	}},
+	{Name: entities.RoleUser, Permissions: entities.Permissions{entities.PermissionBooksRead}},
+}
+
+type mockRoleRepository struct {
+	mock.Mock
+}
+
+func (m *mockRoleRepository) Find(ctx context.Context, name string) (entities.Role, error) {
+	args := m.Called(ctx, name)
+	return args.Get(0).(entities.Role), args.Error(1)
+}
+
+func (m *mockRoleRepository) FindAll(ctx context.Context) ([]entities.Role, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]entities.Role), args.Error(1)
+}
+
+func (m *mockRoleRepository) Save(ctx context.Context, role entities.Role) error {
+	args := m.Called(ctx, role)
+	return args.Error(0)
+}
+
+func (m *mockRoleRepository) Delete(ctx context.Context, name string) error {
+	args := m.Called(ctx, name)
+	return args.Error(0)
+}
+
+// defaultRoles finds the roles the library starts with and no other role
+func defaultRoles() *mockRoleRepository {
+	m := &mockRoleRepository{}
+	for _, role := range testRoles {
+		m.On("Find", mock.Anything, role.Name).Return(role, nil)
+	}
+	m.On("Find", mock.Anything, mock.Anything).Return(entities.Role{}, gorm.ErrRecordNotFound)
+	return m
+}
+
+func Test_NewRoleService(t *testing.T) {
+	service := NewRoleService(&mockRoleRepository{})
+	assert.NotNil(t, service.repository)
+}
+
+func Test_RoleService_Save(t *testing.T) {
+	ctx := context.Background()
+	permissions := entities.Permissions{entities.PermissionBooksRead, entities.PermissionItemsManage}
+	saved := entities.Role{ID: 4, Name: "Archivist", Permissions: permissions}
+
+	tests := []struct {
+		name        string
+		role        string
+		permissions entities.Permissions
+		mockRoles   func(m *mockRoleRepository) *mockRoleRepository
+		expected    entities.Role
+		err         error
+	}{{
+		name:        "success",
+		role:        "Archivist",
+		permissions: permissions,
+		mockRoles: func(m *mockRoleRepository) *mockRoleRepository {
+			m.On("Save", mock.Anything, entities.Role{Name: "Archivist", Permissions: permissions}).Return(nil)
+			m.On("Find", mock.Anything, "Archivist").Return(saved, nil)
+			return m
+		},
+		expected: saved,
+	}, {
+		name:        "unknown permission",
+		role:        "Archivist",
+		permissions: entities.Permissions{"books:burn"},
+		mockRoles: func(m *mockRoleRepository) *mockRoleRepository {
+			return m
+		},
+		err: ErrUnknownPermission,
+	}, {
+		name:        "admin",
+		role:        entities.RoleAdmin,
+		permissions: permissions,
+		mockRoles: func(m *mockRoleRepository) *mockRoleRepository {
+			return m
+		},
+		err: ErrBuiltInRole,
+	}, {
+		name:        "save fails",
+		role:        "Archivist",
+		permissions: permissions,
+		mockRoles: func(m *mockRoleRepository) *mockRoleRepository {
+			m.On("Save", mock.Anything, entities.Role{Name: "Archivist", Permissions: permissions}).Return(errors.New("error"))
+			return m
+		},
+		err: errors.New("error"),
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockRoles := tt.mockRoles(&mockRoleRepository{})
+			service := NewRoleService(mockRoles)
+
+			role, err := service.Save(ctx, tt.role, tt.permissions)
+
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.expected, role)
+			mockRoles.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_RoleService_Delete(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name      string
+		role      string
+		mockRoles func(m *mockRoleRepository) *mockRoleRepository
+		err       error
+	}{{
+		name: "success",
+		role: entities.RoleLibrarian,
+		mockRoles: func(m *mockRoleRepository) *mockRoleRepository {
+			m.On("Delete", mock.Anything, entities.RoleLibrarian).Return(nil)
+			return m
+		},
+	}, {
+		name: "admin",
+		role: entities.RoleAdmin,
+		mockRoles: func(m *mockRoleRepository) *mockRoleRepository {
+			return m
+		},
+		err: ErrBuiltInRole,
+	}, {
+		name: "user",
+		role: entities.RoleUser,
+		mockRoles: func(m *mockRoleRepository) *mockRoleRepository {
+			return m
+		},
+		err: ErrBuiltInRole,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockRoles := tt.mockRoles(&mockRoleRepository{})
+			service := NewRoleService(mockRoles)
+
+			err := service.Delete(ctx, tt.role)
+
+			assert.Equal(t, tt.err, err)
+			mockRoles.AssertExpectations(t)
+		})
+	}
+}
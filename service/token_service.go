@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mishozz/Library/auth"
+	"github.com/mishozz/Library/repositories"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned for unknown, expired or logged out refresh tokens
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is presented again
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// TokenPair is returned to the client on login and on refresh
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type TokenService interface {
+	CreateTokenPair(ctx context.Context, authD auth.AuthDetails) (TokenPair, error)
+	Refresh(ctx context.Context, refreshToken string) (TokenPair, error)
+	Revoke(ctx context.Context, authD *auth.AuthDetails) error
+	RevokeUser(ctx context.Context, userId uint64) error
+}
+
+type tokenService struct {
+	authRepository         repositories.AuthRepository
+	refreshTokenRepository repositories.RefreshTokenRepository
+	signer                 *auth.Signer
+	refreshTokenTTL        time.Duration
+}
+
+// NewTokenService creates a service which signs the access tokens with the signer and issues
+// refresh tokens which can be exchanged for refreshTokenTTL
+func NewTokenService(authRepository repositories.AuthRepository, refreshTokenRepository repositories.RefreshTokenRepository, signer *auth.Signer, refreshTokenTTL time.Duration) *tokenService {
+	return &tokenService{
+		authRepository:         authRepository,
+		refreshTokenRepository: refreshTokenRepository,
+		signer:                 signer,
+		refreshTokenTTL:        refreshTokenTTL,
+	}
+}
+
+func (s *tokenService) CreateTokenPair(ctx context.Context, authD auth.AuthDetails) (TokenPair, error) {
+	accessToken, err := s.signer.CreateToken(authD)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refreshToken, err := auth.CreateRefreshToken()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	_, err = s.refreshTokenRepository.Create(ctx, authD.UserId, authD.AuthUuid, auth.HashToken(refreshToken), time.Now().Add(s.refreshTokenTTL))
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+func (s *tokenService) Refresh(ctx context.Context, refreshToken string) (TokenPair, error) {
+	stored, err := s.refreshTokenRepository.FindByHash(ctx, auth.HashToken(refreshToken))
+	if err != nil {
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+	authD := &auth.AuthDetails{
+		AuthUuid: stored.AuthUUID,
+		UserId:   stored.UserID,
+	}
+	//a rotated token should never be seen again, so it has most likely leaked
+	if stored.Rotated {
+		return TokenPair{}, s.revokeReused(ctx, authD)
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+	session, err := s.authRepository.FetchAuth(ctx, authD)
+	if err != nil {
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+	authD.Role = session.Role
+
+	err = s.refreshTokenRepository.MarkRotated(ctx, stored)
+	if errors.Is(err, repositories.ErrRefreshTokenRotated) {
+		return TokenPair{}, s.revokeReused(ctx, authD)
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return s.CreateTokenPair(ctx, *authD)
+}
+
+// Revoke deletes the session together with its whole refresh token family
+func (s *tokenService) Revoke(ctx context.Context, authD *auth.AuthDetails) error {
+	err := s.refreshTokenRepository.DeleteFamily(ctx, authD.AuthUuid)
+	if err != nil {
+		return err
+	}
+	return s.authRepository.DeleteAuth(ctx, authD)
+}
+
+// RevokeUser deletes every session of the user together with their refresh tokens
+func (s *tokenService) RevokeUser(ctx context.Context, userId uint64) error {
+	err := s.refreshTokenRepository.DeleteByUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	return s.authRepository.DeleteByUser(ctx, userId)
+}
+
+func (s *tokenService) revokeReused(ctx context.Context, authD *auth.AuthDetails) error {
+	err := s.Revoke(ctx, authD)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mishozz/Library/auth"
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	testRefreshTokenTTL = time.Hour
+	//the lowest cost keeps the tests of the hashed passwords fast
+	testBcryptCost = bcrypt.MinCost
+)
+
+var testSigner = auth.NewSigner("secret", time.Minute)
+
+type mockAuthRepository struct {
+	mock.Mock
+}
+
+func (m *mockAuthRepository) FetchAuth(ctx context.Context, authD *auth.AuthDetails) (*entities.Auth, error) {
+	args := m.Called(ctx, authD)
+	return args.Get(0).(*entities.Auth), args.Error(1)
+}
+
+func (m *mockAuthRepository) DeleteAuth(ctx context.Context, authD *auth.AuthDetails) error {
+	args := m.Called(ctx, authD)
+	return args.Error(0)
+}
+
+func (m *mockAuthRepository) CreateAuth(ctx context.Context, userId uint64, role string) (*entities.Auth, error) {
+	args := m.Called(ctx, userId, role)
+	return args.Get(0).(*entities.Auth), args.Error(1)
+}
+
+func (m *mockAuthRepository) DeleteByUser(ctx context.Context, userId uint64) error {
+	args := m.Called(ctx, userId)
+	return args.Error(0)
+}
+
+type mockRefreshTokenRepository struct {
+	mock.Mock
+}
+
+func (m *mockRefreshTokenRepository) Create(ctx context.Context, userId uint64, authUuid string, tokenHash string, expiresAt time.Time) (*entities.RefreshToken, error) {
+	args := m.Called(ctx, userId, authUuid, tokenHash, expiresAt)
+	return args.Get(0).(*entities.RefreshToken), args.Error(1)
+}
+
+func (m *mockRefreshTokenRepository) FindByHash(ctx context.Context, tokenHash string) (*entities.RefreshToken, error) {
+	args := m.Called(ctx, tokenHash)
+	return args.Get(0).(*entities.RefreshToken), args.Error(1)
+}
+
+func (m *mockRefreshTokenRepository) MarkRotated(ctx context.Context, token *entities.RefreshToken) error {
+	args := m.Called(ctx, token)
+	return args.Error(0)
+}
+
+func (m *mockRefreshTokenRepository) DeleteFamily(ctx context.Context, authUuid string) error {
+	args := m.Called(ctx, authUuid)
+	return args.Error(0)
+}
+
+func (m *mockRefreshTokenRepository) DeleteByUser(ctx context.Context, userId uint64) error {
+	args := m.Called(ctx, userId)
+	return args.Error(0)
+}
+
+func Test_NewTokenService(t *testing.T) {
+	service := NewTokenService(&mockAuthRepository{}, &mockRefreshTokenRepository{}, testSigner, testRefreshTokenTTL)
+	assert.NotNil(t, service.authRepository)
+	assert.NotNil(t, service.refreshTokenRepository)
+	assert.Equal(t, testRefreshTokenTTL, service.refreshTokenTTL)
+}
+
+func Test_TokenService_CreateTokenPair(t *testing.T) {
+	ctx := context.Background()
+	authD := auth.AuthDetails{AuthUuid: "uuid", UserId: 1, Role: "User"}
+	mockRefreshRepo := &mockRefreshTokenRepository{}
+	mockRefreshRepo.On("Create", mock.Anything, uint64(1), "uuid", mock.Anything, mock.Anything).Return(&entities.RefreshToken{}, nil)
+
+	service := NewTokenService(&mockAuthRepository{}, mockRefreshRepo, testSigner, testRefreshTokenTTL)
+	tokens, err := service.CreateTokenPair(ctx, authD)
+
+	assert.Nil(t, err)
+	assert.NotEmpty(t, tokens.AccessToken)
+	assert.NotEmpty(t, tokens.RefreshToken)
+	mockRefreshRepo.AssertCalled(t, "Create", mock.Anything, uint64(1), "uuid", auth.HashToken(tokens.RefreshToken), mock.Anything)
+}
+
+func Test_TokenService_Refresh(t *testing.T) {
+	ctx := context.Background()
+	hash := auth.HashToken("refresh")
+	authD := &auth.AuthDetails{AuthUuid: "uuid", UserId: 1}
+	valid := &entities.RefreshToken{ID: 1, UserID: 1, AuthUUID: "uuid", TokenHash: hash, ExpiresAt: time.Now().Add(time.Hour)}
+
+	tests := []struct {
+		name            string
+		mockAuthRepo    func(m *mockAuthRepository) *mockAuthRepository
+		mockRefreshRepo func(m *mockRefreshTokenRepository) *mockRefreshTokenRepository
+		err             error
+		expectNewTokens bool
+	}{{
+		name: "success",
+		mockAuthRepo: func(m *mockAuthRepository) *mockAuthRepository {
+			m.On("FetchAuth", mock.Anything, authD).Return(&entities.Auth{UserID: 1, AuthUUID: "uuid", Role: "User"}, nil)
+			return m
+		},
+		mockRefreshRepo: func(m *mockRefreshTokenRepository) *mockRefreshTokenRepository {
+			m.On("FindByHash", mock.Anything, hash).Return(valid, nil)
+			m.On("MarkRotated", mock.Anything, valid).Return(nil)
+			m.On("Create", mock.Anything, uint64(1), "uuid", mock.Anything, mock.Anything).Return(&entities.RefreshToken{}, nil)
+			return m
+		},
+		expectNewTokens: true,
+	}, {
+		name: "unknown token",
+		mockAuthRepo: func(m *mockAuthRepository) *mockAuthRepository {
+			return m
+		},
+		mockRefreshRepo: func(m *mockRefreshTokenRepository) *mockRefreshTokenRepository {
+			m.On("FindByHash", mock.Anything, hash).Return((*entities.RefreshToken)(nil), errors.New("record not found"))
+			return m
+		},
+		err: ErrInvalidRefreshToken,
+	}, {
+		name: "expired token",
+		mockAuthRepo: func(m *mockAuthRepository) *mockAuthRepository {
+			return m
+		},
+		mockRefreshRepo: func(m *mockRefreshTokenRepository) *mockRefreshTokenRepository {
+			m.On("FindByHash", mock.Anything, hash).Return(&entities.RefreshToken{UserID: 1, AuthUUID: "uuid", ExpiresAt: time.Now().Add(-time.Hour)}, nil)
+			return m
+		},
+		err: ErrInvalidRefreshToken,
+	}, {
+		name: "logged out session",
+		mockAuthRepo: func(m *mockAuthRepository) *mockAuthRepository {
+			m.On("FetchAuth", mock.Anything, authD).Return((*entities.Auth)(nil), errors.New("record not found"))
+			return m
+		},
+		mockRefreshRepo: func(m *mockRefreshTokenRepository) *mockRefreshTokenRepository {
+			m.On("FindByHash", mock.Anything, hash).Return(valid, nil)
+			return m
+		},
+		err: ErrInvalidRefreshToken,
+	}, {
+		name: "reused token revokes the family",
+		mockAuthRepo: func(m *mockAuthRepository) *mockAuthRepository {
+			m.On("DeleteAuth", mock.Anything, authD).Return(nil).Once()
+			return m
+		},
+		mockRefreshRepo: func(m *mockRefreshTokenRepository) *mockRefreshTokenRepository {
+			m.On("FindByHash", mock.Anything, hash).Return(&entities.RefreshToken{UserID: 1, AuthUUID: "uuid", ExpiresAt: time.Now().Add(time.Hour), Rotated: true}, nil)
+			m.On("DeleteFamily", mock.Anything, "uuid").Return(nil).Once()
+			return m
+		},
+		err: ErrRefreshTokenReused,
+	}, {
+		name: "concurrent rotation revokes the family",
+		mockAuthRepo: func(m *mockAuthRepository) *mockAuthRepository {
+			m.On("FetchAuth", mock.Anything, authD).Return(&entities.Auth{UserID: 1, AuthUUID: "uuid", Role: "User"}, nil)
+			m.On("DeleteAuth", mock.Anything, mock.Anything).Return(nil).Once()
+			return m
+		},
+		mockRefreshRepo: func(m *mockRefreshTokenRepository) *mockRefreshTokenRepository {
+			m.On("FindByHash", mock.Anything, hash).Return(valid, nil)
+			m.On("MarkRotated", mock.Anything, valid).Return(repositories.ErrRefreshTokenRotated)
+			m.On("DeleteFamily", mock.Anything, "uuid").Return(nil).Once()
+			return m
+		},
+		err: ErrRefreshTokenReused,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockAuth := tt.mockAuthRepo(&mockAuthRepository{})
+			mockRefresh := tt.mockRefreshRepo(&mockRefreshTokenRepository{})
+			service := NewTokenService(mockAuth, mockRefresh, testSigner, testRefreshTokenTTL)
+
+			tokens, err := service.Refresh(ctx, "refresh")
+			if tt.err != nil {
+				assert.Equal(t, tt.err, err)
+			} else {
+				assert.Nil(t, err)
+			}
+			if tt.expectNewTokens {
+				assert.NotEmpty(t, tokens.AccessToken)
+				assert.NotEqual(t, "refresh", tokens.RefreshToken)
+			}
+			mockAuth.AssertExpectations(t)
+			mockRefresh.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_TokenService_Revoke(t *testing.T) {
+	ctx := context.Background()
+	authD := &auth.AuthDetails{AuthUuid: "uuid", UserId: 1}
+	mockAuth := &mockAuthRepository{}
+	mockAuth.On("DeleteAuth", mock.Anything, authD).Return(nil)
+	mockRefresh := &mockRefreshTokenRepository{}
+	mockRefresh.On("DeleteFamily", mock.Anything, "uuid").Return(nil)
+
+	service := NewTokenService(mockAuth, mockRefresh, testSigner, testRefreshTokenTTL)
+	err := service.Revoke(ctx, authD)
+
+	assert.Nil(t, err)
+	mockAuth.AssertExpectations(t)
+	mockRefresh.AssertExpectations(t)
+}
+
+func Test_TokenService_RevokeUser(t *testing.T) {
+	ctx := context.Background()
+	mockAuthRepo := &mockAuthRepository{}
+	mockAuthRepo.On("DeleteByUser", mock.Anything, uint64(1)).Return(nil).Once()
+	mockRefreshRepo := &mockRefreshTokenRepository{}
+	mockRefreshRepo.On("DeleteByUser", mock.Anything, uint64(1)).Return(nil).Once()
+
+	service := NewTokenService(mockAuthRepo, mockRefreshRepo, testSigner, testRefreshTokenTTL)
+	err := service.RevokeUser(ctx, 1)
+
+	assert.Nil(t, err)
+	mockAuthRepo.AssertExpectations(t)
+	mockRefreshRepo.AssertExpectations(t)
+}
@@ -1,91 +1,216 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/mishozz/Library/entities"
 	"github.com/mishozz/Library/repositories"
 	"github.com/mishozz/Library/utils"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
+// LoanLimitError is returned when a patron who has as many books as they are allowed takes another one
+type LoanLimitError struct {
+	Loans int64
+	Limit uint
+}
+
+func (e *LoanLimitError) Error() string {
+	return fmt.Sprintf("loan limit reached: %d of %d books taken", e.Loans, e.Limit)
+}
+
+// Is makes the error match ErrLoanLimit
+func (e *LoanLimitError) Is(target error) bool {
+	return target == ErrLoanLimit
+}
+
 type UserService interface {
-	FindByEmail(email string) (entities.User, error)
-	FindAll() ([]entities.User, error)
-	TakeBook(user entities.User, book entities.Book) error
-	ReturnBook(user entities.User, book entities.Book) error
-	IsBookTakenByUser(email string, isbn string) bool
-	Register(user entities.User) error
+	FindByEmail(ctx context.Context, email string) (entities.User, error)
+	FindAll(ctx context.Context, query repositories.UserQuery) ([]entities.User, int64, error)
+	TakeBook(ctx context.Context, user entities.User, book entities.Book) error
+	TakeItem(ctx context.Context, user entities.User, item entities.Item) error
+	ReturnBook(ctx context.Context, user entities.User, book entities.Book) error
+	ReturnItem(ctx context.Context, user entities.User, item entities.Item) error
+	IsBookTakenByUser(ctx context.Context, email string, isbn string) bool
+	Register(ctx context.Context, user entities.User) error
+	LoanAllowance(ctx context.Context, user entities.User) (entities.LoanAllowance, error)
+	SetLoanLimit(ctx context.Context, user entities.User, limit *uint) error
 }
 
 type userService struct {
 	userRepository repositories.UserRepository
 	bookRepository repositories.BookRepository
+	unitOfWork     repositories.UnitOfWork
+	fineRules      FineRules
+	bcryptCost     int
 }
 
-func NewUserService(userRepository repositories.UserRepository, bookRepository repositories.BookRepository) *userService {
+func NewUserService(userRepository repositories.UserRepository, bookRepository repositories.BookRepository, unitOfWork repositories.UnitOfWork, fineRules FineRules, bcryptCost int) *userService {
 	return &userService{
 		userRepository: userRepository,
 		bookRepository: bookRepository,
+		unitOfWork:     unitOfWork,
+		fineRules:      fineRules,
+		bcryptCost:     bcryptCost,
 	}
 }
 
-func (s *userService) FindByEmail(email string) (entities.User, error) {
-	return s.userRepository.FindByEmail(email)
+func (s *userService) FindByEmail(ctx context.Context, email string) (entities.User, error) {
+	return s.userRepository.FindByEmail(ctx, email)
 }
 
-func (s *userService) FindAll() ([]entities.User, error) {
-	return s.userRepository.FindAll()
+func (s *userService) FindAll(ctx context.Context, query repositories.UserQuery) ([]entities.User, int64, error) {
+	return s.userRepository.FindAll(ctx, query)
 }
 
-func (s *userService) TakeBook(user entities.User, book entities.Book) error {
-	book.AvailableUnits = book.AvailableUnits - 1
-	user.TakenBooks = append(user.TakenBooks, book)
+// TakeBook checks out any copy of the book. A copy set aside for a ready hold of the
+// user is lent before the copies on the shelf.
+func (s *userService) TakeBook(ctx context.Context, user entities.User, book entities.Book) error {
+	return s.checkout(ctx, user, book.ID, nil)
+}
 
-	err := s.bookRepository.UpdateUnits(book)
-	if err != nil {
-		return err
+// TakeItem checks out the given copy. When another copy was set aside for a ready hold
+// of the user, that copy goes to the next hold in the queue.
+func (s *userService) TakeItem(ctx context.Context, user entities.User, item entities.Item) error {
+	if item.Condition == entities.ConditionDamaged {
+		return repositories.ErrItemNotAvailable
 	}
-	err = s.userRepository.UpdateTakenBooks(user, user.TakenBooks)
-	if err != nil {
-		return err
+	return s.checkout(ctx, user, item.BookID, &item)
+}
+
+// checkout lends a copy of the book and records the loan of the user in one transaction.
+// The user is locked first, so concurrent checkouts of the user cannot both pass the
+// loan limit or take the same book twice. The category of the
+// patron decides when the loan is due and, unless an admin set a limit for the patron,
+// how many books they may have at once. Patrons who owe more than the fine rules allow
+// are turned away, and so are the ones who have not verified their email yet.
+func (s *userService) checkout(ctx context.Context, user entities.User, bookId uint, item *entities.Item) error {
+	if !user.Verified {
+		return ErrEmailNotVerified
 	}
+	now := time.Now()
+	return s.unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+		if err := store.Users().Lock(ctx, user.ID); err != nil {
+			return err
+		}
+		hold, err := store.Holds().Fulfil(ctx, user.ID, bookId, now)
+		if err != nil && !errors.Is(err, repositories.ErrHoldNotReady) {
+			return err
+		}
+		balance, err := store.Ledger().Balance(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		if balance > s.fineRules.BlockingBalance {
+			return ErrFinesOutstanding
+		}
+		category, err := store.Categories().Find(ctx, user.Category)
+		if err != nil {
+			return err
+		}
+		active, err := store.Loans().CountActive(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		if limit := user.MaxLoans(category); active >= int64(limit) {
+			return &LoanLimitError{Loans: active, Limit: limit}
+		}
+		held := hold.ItemID
 
-	if utils.Contains(user.ReturnedBooks, book) {
-		user.ReturnedBooks = utils.Remove(user.ReturnedBooks, book)
-		err = s.userRepository.UpdateReturnedBooks(user, user.ReturnedBooks)
+		var lent entities.Item
+		switch {
+		case item == nil && held != nil:
+			lent, err = store.Items().FindByID(ctx, *held)
+			if err == nil && lent.Condition == entities.ConditionDamaged {
+				err = repositories.ErrItemNotAvailable
+			}
+			if err == nil {
+				err = store.Items().Transition(ctx, lent, entities.ItemOnHold, entities.ItemOnLoan)
+			}
+		case item == nil:
+			lent, err = store.Items().Claim(ctx, bookId, entities.ItemOnLoan)
+		case held != nil && *held == item.ID:
+			lent = *item
+			err = store.Items().Transition(ctx, lent, entities.ItemOnHold, entities.ItemOnLoan)
+		default:
+			lent = *item
+			err = store.Items().Transition(ctx, lent, entities.ItemAvailable, entities.ItemOnLoan)
+			if err == nil && held != nil {
+				err = releaseCopy(ctx, store, *held, now)
+			}
+		}
 		if err != nil {
 			return err
 		}
-	}
-	return nil
+
+		_, err = store.Loans().FindActive(ctx, user.ID, bookId)
+		if err == nil {
+			return repositories.ErrBookAlreadyTaken
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		return store.Loans().Create(ctx, entities.Loan{
+			UserID:     user.ID,
+			BookID:     bookId,
+			ItemID:     &lent.ID,
+			BorrowedAt: now,
+			DueAt:      now.Add(category.LoanPeriod()),
+		})
+	})
 }
 
-func (s *userService) ReturnBook(user entities.User, book entities.Book) error {
-	book.AvailableUnits = book.AvailableUnits + 1
-	user.ReturnedBooks = append(user.ReturnedBooks, book)
-	user.TakenBooks = utils.Remove(user.TakenBooks, book)
+// ReturnBook closes the loan of the book and hands the copy to the next hold in the queue,
+// or puts it back on the shelf when nobody is waiting for it. A late return is charged.
+func (s *userService) ReturnBook(ctx context.Context, user entities.User, book entities.Book) error {
+	now := time.Now()
+	return s.unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+		loan, err := store.Loans().Return(ctx, user.ID, book.ID, now)
+		if err != nil {
+			return err
+		}
+		return s.checkin(ctx, store, user, loan, now)
+	})
+}
 
-	err := s.bookRepository.UpdateUnits(book)
+// ReturnItem closes the loan of the user on the given copy
+func (s *userService) ReturnItem(ctx context.Context, user entities.User, item entities.Item) error {
+	now := time.Now()
+	return s.unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+		loan, err := store.Loans().ReturnItem(ctx, user.ID, item.ID, now)
+		if err != nil {
+			return err
+		}
+		return s.checkin(ctx, store, user, loan, now)
+	})
+}
+
+// checkin charges the closed loan at the fine rate of the patron when it was late and passes its copy on
+func (s *userService) checkin(ctx context.Context, store repositories.Store, user entities.User, loan entities.Loan, now time.Time) error {
+	category, err := store.Categories().Find(ctx, user.Category)
 	if err != nil {
 		return err
 	}
-	err = s.userRepository.UpdateReturnedBooks(user, user.ReturnedBooks)
-	if err != nil {
+	if err := chargeOverdue(ctx, store, s.fineRules, category, loan, now); err != nil {
 		return err
 	}
-	err = s.userRepository.UpdateTakenBooks(user, user.TakenBooks)
-	if err != nil {
-		return err
+	if loan.ItemID == nil {
+		return nil
 	}
-	return nil
+	return releaseCopy(ctx, store, *loan.ItemID, now)
 }
 
-func (s *userService) IsBookTakenByUser(email string, isbn string) bool {
-	book, err := s.bookRepository.Find(isbn)
+func (s *userService) IsBookTakenByUser(ctx context.Context, email string, isbn string) bool {
+	book, err := s.bookRepository.Find(ctx, isbn)
 	if err != nil {
 		return false
 	}
 
-	user, err := s.userRepository.FindByEmail(email)
+	user, err := s.userRepository.FindByEmail(ctx, email)
 	if err != nil {
 		return false
 	}
@@ -93,12 +218,48 @@ func (s *userService) IsBookTakenByUser(email string, isbn string) bool {
 	return utils.Contains(user.TakenBooks, book)
 }
 
-func (s *userService) Register(user entities.User) error {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(user.Password), 14)
+func (s *userService) Register(ctx context.Context, user entities.User) error {
+	hashed, err := hashPassword(user.Password, s.bcryptCost)
 	if err != nil {
 		return err
 	}
-	user.Password = string(bytes)
-	user.Role = "User"
-	return s.userRepository.Save(user)
+	user.Password = hashed
+	user.Role = entities.RoleUser
+	user.Disabled = false
+	user.Verified = false
+	user.Category = entities.CategoryPublic
+	user.LoanLimit = nil
+	return s.userRepository.Save(ctx, user)
+}
+
+func hashPassword(password string, cost int) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
+
+// LoanAllowance counts the books of the user against their loan limit. The category
+// and the loans are read in one transaction, so they agree with each other.
+func (s *userService) LoanAllowance(ctx context.Context, user entities.User) (entities.LoanAllowance, error) {
+	var allowance entities.LoanAllowance
+	err := s.unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+		category, err := store.Categories().Find(ctx, user.Category)
+		if err != nil {
+			return err
+		}
+		loans, err := store.Loans().CountActive(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		allowance = entities.NewLoanAllowance(loans, user.MaxLoans(category))
+		return nil
+	})
+	return allowance, err
+}
+
+// SetLoanLimit overrides the loan limit of the category of the user, a nil limit restores it
+func (s *userService) SetLoanLimit(ctx context.Context, user entities.User, limit *uint) error {
+	return s.userRepository.SetLoanLimit(ctx, user.ID, limit)
 }
@@ -1,196 +1,643 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/repositories"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
 )
 
+var testFineRules = FineRules{Cap: 1000, BlockingBalance: 500}
+
 type mockUserRepository struct {
 	mock.Mock
 }
 
-func (m *mockUserRepository) Save(user entities.User) error {
-	args := m.Called(user)
+func (m *mockUserRepository) Save(ctx context.Context, user entities.User) error {
+	args := m.Called(ctx, user)
 	return args.Error(0)
 }
 
-func (m *mockUserRepository) FindByEmail(email string) (entities.User, error) {
-	args := m.Called(email)
+func (m *mockUserRepository) FindByEmail(ctx context.Context, email string) (entities.User, error) {
+	args := m.Called(ctx, email)
 	return args.Get(0).(entities.User), args.Error(1)
 }
-func (m *mockUserRepository) FindAll() ([]entities.User, error) {
-	args := m.Called()
-	return args.Get(0).([]entities.User), args.Error(1)
+func (m *mockUserRepository) FindAll(ctx context.Context, query repositories.UserQuery) ([]entities.User, int64, error) {
+	args := m.Called(ctx, query)
+	return args.Get(0).([]entities.User), args.Get(1).(int64), args.Error(2)
+}
+
+func (m *mockUserRepository) SetCategory(ctx context.Context, userId uint, category string) error {
+	args := m.Called(ctx, userId, category)
+	return args.Error(0)
+}
+
+func (m *mockUserRepository) SetLoanLimit(ctx context.Context, userId uint, limit *uint) error {
+	args := m.Called(ctx, userId, limit)
+	return args.Error(0)
+}
+
+func (m *mockUserRepository) SetRole(ctx context.Context, userId uint, role string) error {
+	args := m.Called(ctx, userId, role)
+	return args.Error(0)
+}
+
+func (m *mockUserRepository) SetDisabled(ctx context.Context, userId uint, disabled bool) error {
+	args := m.Called(ctx, userId, disabled)
+	return args.Error(0)
+}
+
+func (m *mockUserRepository) SetPassword(ctx context.Context, userId uint, password string) error {
+	args := m.Called(ctx, userId, password)
+	return args.Error(0)
 }
 
-func (m *mockUserRepository) UpdateTakenBooks(user entities.User, takenBooks []entities.Book) error {
-	args := m.Called(user, takenBooks)
+func (m *mockUserRepository) SetVerified(ctx context.Context, userId uint) error {
+	args := m.Called(ctx, userId)
 	return args.Error(0)
 }
 
-func (m *mockUserRepository) UpdateReturnedBooks(user entities.User, returnedBooks []entities.Book) error {
-	args := m.Called(user, returnedBooks)
+func (m *mockUserRepository) Delete(ctx context.Context, userId uint) error {
+	args := m.Called(ctx, userId)
 	return args.Error(0)
 }
 
+func (m *mockUserRepository) CountAdmins(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *mockUserRepository) Lock(ctx context.Context, userId uint) error {
+	args := m.Called(ctx, userId)
+	return args.Error(0)
+}
+
+func (m *mockUserRepository) LockAdmins(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
+// mockUnitOfWork runs the transaction function against the mocked repositories
+type mockUnitOfWork struct {
+	books      *mockBookRepository
+	users      *mockUserRepository
+	loans      *mockLoanRepository
+	holds      *mockHoldRepository
+	items      *mockItemRepository
+	ledger     *mockLedgerRepository
+	categories *mockCategoryRepository
+	tokens     *mockOneTimeTokenRepository
+	attempts   *mockLoginAttemptRepository
+	audit      *mockAuditRepository
+}
+
+func (m *mockUnitOfWork) Transaction(ctx context.Context, fn func(store repositories.Store) error) error {
+	return fn(m)
+}
+
+func (m *mockUnitOfWork) Books() repositories.BookRepository {
+	return m.books
+}
+
+func (m *mockUnitOfWork) Users() repositories.UserRepository {
+	return m.users
+}
+
+func (m *mockUnitOfWork) Loans() repositories.LoanRepository {
+	return m.loans
+}
+
+func (m *mockUnitOfWork) Holds() repositories.HoldRepository {
+	return m.holds
+}
+
+func (m *mockUnitOfWork) Items() repositories.ItemRepository {
+	return m.items
+}
+
+func (m *mockUnitOfWork) Ledger() repositories.LedgerRepository {
+	return m.ledger
+}
+
+func (m *mockUnitOfWork) Categories() repositories.CategoryRepository {
+	return m.categories
+}
+
+func (m *mockUnitOfWork) Tokens() repositories.OneTimeTokenRepository {
+	return m.tokens
+}
+
+func (m *mockUnitOfWork) LoginAttempts() repositories.LoginAttemptRepository {
+	return m.attempts
+}
+
+func (m *mockUnitOfWork) Audit() repositories.AuditRepository {
+	return m.audit
+}
+
 func Test_NewUserService(t *testing.T) {
 	userRepo := &mockUserRepository{}
 	bookRepo := &mockBookRepository{}
-	service := NewUserService(userRepo, bookRepo)
+	service := NewUserService(userRepo, bookRepo, &mockUnitOfWork{}, testFineRules, testBcryptCost)
 	assert.NotNil(t, service.bookRepository)
 	assert.NotNil(t, service.userRepository)
+	assert.NotNil(t, service.unitOfWork)
 }
 
 func Test_UserService_FindByEmail(t *testing.T) {
+	ctx := context.Background()
 	expectedUser := entities.User{
 		Email: "test",
 	}
 	mockUserRepo := func(m *mockUserRepository) *mockUserRepository {
-		m.On("FindByEmail", "test").Return(entities.User{
+		m.On("FindByEmail", mock.Anything, "test").Return(entities.User{
 			Email: "test",
 		}, nil)
 		return m
 	}
 	mockUserRepository := &mockUserRepository{}
 	mockBookRepository := &mockBookRepository{}
-	service := NewUserService(mockUserRepo(mockUserRepository), mockBookRepository)
-	user, _ := service.FindByEmail("test")
+	service := NewUserService(mockUserRepo(mockUserRepository), mockBookRepository, &mockUnitOfWork{}, testFineRules, testBcryptCost)
+	user, _ := service.FindByEmail(ctx, "test")
 	assert.Equal(t, expectedUser, user)
 	mockUserRepository.AssertExpectations(t)
 }
 
 func Test_UserService_FindAll(t *testing.T) {
+	ctx := context.Background()
 	expectedUsers := []entities.User{entities.User{Email: "test1"}, entities.User{Email: "test2"}}
 	mockUserRepo := func(m *mockUserRepository) *mockUserRepository {
-		m.On("FindAll").Return([]entities.User{entities.User{Email: "test1"}, entities.User{Email: "test2"}}, nil)
+		m.On("FindAll", mock.Anything, repositories.UserQuery{}).Return([]entities.User{entities.User{Email: "test1"}, entities.User{Email: "test2"}}, int64(2), nil)
 		return m
 	}
 	mockUserRepository := &mockUserRepository{}
 	mockBookRepository := &mockBookRepository{}
-	service := NewUserService(mockUserRepo(mockUserRepository), mockBookRepository)
-	users, _ := service.userRepository.FindAll()
+	service := NewUserService(mockUserRepo(mockUserRepository), mockBookRepository, &mockUnitOfWork{}, testFineRules, testBcryptCost)
+	users, _, _ := service.FindAll(ctx, repositories.UserQuery{})
 	assert.Equal(t, expectedUsers, users)
 	mockUserRepository.AssertExpectations(t)
 }
 
 func Test_UserService_TakeBook(t *testing.T) {
-	book1 := entities.Book{
+	ctx := context.Background()
+	user := entities.User{Model: gorm.Model{ID: 1}, Email: "email1", Category: entities.CategoryPublic, Verified: true}
+	book := entities.Book{
+		Model:          gorm.Model{ID: 2},
 		Isbn:           "test",
 		Title:          "test",
 		Author:         "test",
-		AvailableUnits: 2,
+		AvailableUnits: 1,
+	}
+	shelved := entities.Item{ID: 5, BookID: 2, Barcode: "0005", Status: entities.ItemOnLoan}
+	heldID := uint(6)
+	held := entities.Item{ID: 6, BookID: 2, Barcode: "0006", Condition: entities.ConditionGood, Status: entities.ItemOnHold}
+	damaged := held
+	damaged.Condition = entities.ConditionDamaged
+	override := uint(8)
+	newLoan := func(itemId uint) interface{} {
+		return mock.MatchedBy(func(loan entities.Loan) bool {
+			return loan.UserID == 1 && loan.BookID == 2 && loan.ReturnedAt == nil &&
+				loan.ItemID != nil && *loan.ItemID == itemId &&
+				loan.DueAt.Sub(loan.BorrowedAt) == testCategories[0].LoanPeriod()
+		})
 	}
 
-	book2 := entities.Book{
-		Isbn:           "test",
-		Title:          "test",
-		Author:         "test",
-		AvailableUnits: 1,
+	tests := []struct {
+		name         string
+		mockLoanRepo func(m *mockLoanRepository) *mockLoanRepository
+		mockItemRepo func(m *mockItemRepository) *mockItemRepository
+		mockHoldRepo func(m *mockHoldRepository) *mockHoldRepository
+		loanLimit    *uint
+		balance      int64
+		active       int64
+		err          error
+	}{{
+		name: "success",
+		mockLoanRepo: func(m *mockLoanRepository) *mockLoanRepository {
+			m.On("FindActive", mock.Anything, uint(1), uint(2)).Return(entities.Loan{}, gorm.ErrRecordNotFound).Once()
+			m.On("Create", mock.Anything, newLoan(5)).Return(nil).Once()
+			return m
+		},
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			m.On("Claim", mock.Anything, uint(2), entities.ItemOnLoan).Return(shelved, nil).Once()
+			return m
+		},
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("Fulfil", mock.Anything, uint(1), uint(2), mock.Anything).Return(entities.Hold{}, repositories.ErrHoldNotReady).Once()
+			return m
+		},
+	}, {
+		name: "no available units",
+		mockLoanRepo: func(m *mockLoanRepository) *mockLoanRepository {
+			return m
+		},
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			m.On("Claim", mock.Anything, uint(2), entities.ItemOnLoan).Return(entities.Item{}, repositories.ErrNoAvailableUnits).Once()
+			return m
+		},
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("Fulfil", mock.Anything, uint(1), uint(2), mock.Anything).Return(entities.Hold{}, repositories.ErrHoldNotReady).Once()
+			return m
+		},
+		err: repositories.ErrNoAvailableUnits,
+	}, {
+		name: "book is already taken",
+		mockLoanRepo: func(m *mockLoanRepository) *mockLoanRepository {
+			m.On("FindActive", mock.Anything, uint(1), uint(2)).Return(entities.Loan{UserID: 1, BookID: 2}, nil).Once()
+			return m
+		},
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			m.On("Claim", mock.Anything, uint(2), entities.ItemOnLoan).Return(shelved, nil).Once()
+			return m
+		},
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("Fulfil", mock.Anything, uint(1), uint(2), mock.Anything).Return(entities.Hold{}, repositories.ErrHoldNotReady).Once()
+			return m
+		},
+		err: repositories.ErrBookAlreadyTaken,
+	}, {
+		name: "copy set aside for a ready hold",
+		mockLoanRepo: func(m *mockLoanRepository) *mockLoanRepository {
+			m.On("FindActive", mock.Anything, uint(1), uint(2)).Return(entities.Loan{}, gorm.ErrRecordNotFound).Once()
+			m.On("Create", mock.Anything, newLoan(6)).Return(nil).Once()
+			return m
+		},
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			m.On("FindByID", mock.Anything, uint(6)).Return(held, nil).Once()
+			m.On("Transition", mock.Anything, held, entities.ItemOnHold, entities.ItemOnLoan).Return(nil).Once()
+			return m
+		},
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("Fulfil", mock.Anything, uint(1), uint(2), mock.Anything).Return(entities.Hold{ItemID: &heldID}, nil).Once()
+			return m
+		},
+	}, {
+		name: "damaged copy set aside for a ready hold",
+		mockLoanRepo: func(m *mockLoanRepository) *mockLoanRepository {
+			return m
+		},
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			m.On("FindByID", mock.Anything, uint(6)).Return(damaged, nil).Once()
+			return m
+		},
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("Fulfil", mock.Anything, uint(1), uint(2), mock.Anything).Return(entities.Hold{ItemID: &heldID}, nil).Once()
+			return m
+		},
+		err: repositories.ErrItemNotAvailable,
+	}, {
+		name: "fines outstanding",
+		mockLoanRepo: func(m *mockLoanRepository) *mockLoanRepository {
+			return m
+		},
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			return m
+		},
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("Fulfil", mock.Anything, uint(1), uint(2), mock.Anything).Return(entities.Hold{}, repositories.ErrHoldNotReady).Once()
+			return m
+		},
+		balance: testFineRules.BlockingBalance + 1,
+		err:     ErrFinesOutstanding,
+	}, {
+		name: "loan limit of the category",
+		mockLoanRepo: func(m *mockLoanRepository) *mockLoanRepository {
+			return m
+		},
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			return m
+		},
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("Fulfil", mock.Anything, uint(1), uint(2), mock.Anything).Return(entities.Hold{}, repositories.ErrHoldNotReady).Once()
+			return m
+		},
+		active: int64(testCategories[0].MaxLoans),
+		err:    &LoanLimitError{Loans: 5, Limit: 5},
+	}, {
+		name: "loan limit set for the user",
+		mockLoanRepo: func(m *mockLoanRepository) *mockLoanRepository {
+			return m
+		},
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			return m
+		},
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("Fulfil", mock.Anything, uint(1), uint(2), mock.Anything).Return(entities.Hold{}, repositories.ErrHoldNotReady).Once()
+			return m
+		},
+		loanLimit: &override,
+		active:    8,
+		err:       &LoanLimitError{Loans: 8, Limit: 8},
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockLoans := tt.mockLoanRepo(&mockLoanRepository{})
+			mockItems := tt.mockItemRepo(&mockItemRepository{})
+			mockHolds := tt.mockHoldRepo(&mockHoldRepository{})
+			mockLedger := &mockLedgerRepository{}
+			mockLedger.On("Balance", mock.Anything, uint(1)).Return(tt.balance, nil)
+			mockLoans.On("CountActive", mock.Anything, uint(1)).Return(tt.active, nil).Maybe()
+			mockUsers := &mockUserRepository{}
+			mockUsers.On("Lock", mock.Anything, uint(1)).Return(nil).Once()
+			uow := &mockUnitOfWork{users: mockUsers, items: mockItems, loans: mockLoans, holds: mockHolds, ledger: mockLedger, categories: publicCategories()}
+			service := NewUserService(&mockUserRepository{}, &mockBookRepository{}, uow, testFineRules, testBcryptCost)
+			user := user
+			user.LoanLimit = tt.loanLimit
+			err := service.TakeBook(ctx, user, book)
+			assert.Equal(t, tt.err, err)
+			mockUsers.AssertExpectations(t)
+			mockItems.AssertExpectations(t)
+			mockLoans.AssertExpectations(t)
+			mockHolds.AssertExpectations(t)
+			mockLedger.AssertExpectations(t)
+		})
 	}
+}
+
+func Test_UserService_TakeBook_Unverified(t *testing.T) {
+	ctx := context.Background()
+	user := entities.User{Model: gorm.Model{ID: 1}, Email: "email1", Category: entities.CategoryPublic}
+	uow := &mockUnitOfWork{}
+	service := NewUserService(&mockUserRepository{}, &mockBookRepository{}, uow, testFineRules, testBcryptCost)
+
+	err := service.TakeBook(ctx, user, entities.Book{Model: gorm.Model{ID: 2}})
+
+	assert.Equal(t, ErrEmailNotVerified, err)
+}
+
+func Test_UserService_TakeItem(t *testing.T) {
+	ctx := context.Background()
+	user := entities.User{Model: gorm.Model{ID: 1}, Email: "email1", Category: entities.CategoryPublic, Verified: true}
+	item := entities.Item{ID: 5, BookID: 2, Barcode: "0005", Condition: entities.ConditionGood, Status: entities.ItemAvailable}
+	damaged := item
+	damaged.Condition = entities.ConditionDamaged
+	heldID := uint(6)
+	held := entities.Item{ID: 6, BookID: 2, Barcode: "0006", Condition: entities.ConditionGood, Status: entities.ItemOnHold}
+
 	tests := []struct {
 		name         string
-		user         entities.User
-		mockUserRepo func(m *mockUserRepository) *mockUserRepository
-		mockBookRepo func(m *mockBookRepository) *mockBookRepository
+		item         entities.Item
+		mockLoanRepo func(m *mockLoanRepository) *mockLoanRepository
+		mockItemRepo func(m *mockItemRepository) *mockItemRepository
+		mockHoldRepo func(m *mockHoldRepository) *mockHoldRepository
+		err          error
 	}{{
-		name: "book is not in returned books",
-		mockUserRepo: func(m *mockUserRepository) *mockUserRepository {
-			m.On("UpdateTakenBooks", entities.User{
-				Email:      "email1",
-				TakenBooks: []entities.Book{book2},
-			}, []entities.Book{book2}).Return(nil).Once()
+		name: "copy from the shelf",
+		item: item,
+		mockLoanRepo: func(m *mockLoanRepository) *mockLoanRepository {
+			m.On("FindActive", mock.Anything, uint(1), uint(2)).Return(entities.Loan{}, gorm.ErrRecordNotFound).Once()
+			m.On("Create", mock.Anything, mock.AnythingOfType("entities.Loan")).Return(nil).Once()
 			return m
 		},
-		mockBookRepo: func(m *mockBookRepository) *mockBookRepository {
-			m.On("UpdateUnits", book2).Return(nil).Once()
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			m.On("Transition", mock.Anything, item, entities.ItemAvailable, entities.ItemOnLoan).Return(nil).Once()
+			return m
+		},
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("Fulfil", mock.Anything, uint(1), uint(2), mock.Anything).Return(entities.Hold{}, repositories.ErrHoldNotReady).Once()
 			return m
 		},
-		user: entities.User{Email: "email1"},
 	}, {
-		name: "book is in returned books",
-		mockUserRepo: func(m *mockUserRepository) *mockUserRepository {
-			m.On("UpdateTakenBooks", entities.User{
-				Email:         "email1",
-				TakenBooks:    []entities.Book{book2},
-				ReturnedBooks: []entities.Book{book1},
-			}, []entities.Book{book2}).Return(nil).Once()
-			m.On("UpdateReturnedBooks", entities.User{
-				Email:         "email1",
-				TakenBooks:    []entities.Book{book2},
-				ReturnedBooks: []entities.Book{},
-			}, []entities.Book{}).Return(nil).Once()
+		name: "the copy set aside goes to the next hold",
+		item: item,
+		mockLoanRepo: func(m *mockLoanRepository) *mockLoanRepository {
+			m.On("FindActive", mock.Anything, uint(1), uint(2)).Return(entities.Loan{}, gorm.ErrRecordNotFound).Once()
+			m.On("Create", mock.Anything, mock.AnythingOfType("entities.Loan")).Return(nil).Once()
 			return m
 		},
-		mockBookRepo: func(m *mockBookRepository) *mockBookRepository {
-			m.On("UpdateUnits", book2).Return(nil).Once()
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			m.On("Transition", mock.Anything, item, entities.ItemAvailable, entities.ItemOnLoan).Return(nil).Once()
+			m.On("FindByID", mock.Anything, uint(6)).Return(held, nil).Once()
 			return m
 		},
-		user: entities.User{
-			Email:         "email1",
-			ReturnedBooks: []entities.Book{book1},
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("Fulfil", mock.Anything, uint(1), uint(2), mock.Anything).Return(entities.Hold{ItemID: &heldID}, nil).Once()
+			m.On("AllocateNext", mock.Anything, uint(2), uint(6), mock.Anything).Return(nil).Once()
+			return m
 		},
+	}, {
+		name: "copy is not on the shelf",
+		item: item,
+		mockLoanRepo: func(m *mockLoanRepository) *mockLoanRepository {
+			return m
+		},
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			m.On("Transition", mock.Anything, item, entities.ItemAvailable, entities.ItemOnLoan).Return(repositories.ErrItemNotAvailable).Once()
+			return m
+		},
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("Fulfil", mock.Anything, uint(1), uint(2), mock.Anything).Return(entities.Hold{}, repositories.ErrHoldNotReady).Once()
+			return m
+		},
+		err: repositories.ErrItemNotAvailable,
+	}, {
+		name: "damaged copy",
+		item: damaged,
+		mockLoanRepo: func(m *mockLoanRepository) *mockLoanRepository {
+			return m
+		},
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			return m
+		},
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			return m
+		},
+		err: repositories.ErrItemNotAvailable,
 	}}
 	for _, tt := range tests {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
-			mockUserRepository := &mockUserRepository{}
-			mockBookRepository := &mockBookRepository{}
-			service := NewUserService(tt.mockUserRepo(mockUserRepository), tt.mockBookRepo(mockBookRepository))
-			service.TakeBook(tt.user, book1)
-			mockBookRepository.AssertExpectations(t)
-			mockUserRepository.AssertExpectations(t)
+			mockLoans := tt.mockLoanRepo(&mockLoanRepository{})
+			mockItems := tt.mockItemRepo(&mockItemRepository{})
+			mockHolds := tt.mockHoldRepo(&mockHoldRepository{})
+			mockLedger := &mockLedgerRepository{}
+			mockLedger.On("Balance", mock.Anything, uint(1)).Return(int64(0), nil).Maybe()
+			mockLoans.On("CountActive", mock.Anything, uint(1)).Return(int64(0), nil).Maybe()
+			mockUsers := &mockUserRepository{}
+			mockUsers.On("Lock", mock.Anything, uint(1)).Return(nil).Maybe()
+			uow := &mockUnitOfWork{users: mockUsers, items: mockItems, loans: mockLoans, holds: mockHolds, ledger: mockLedger, categories: publicCategories()}
+			service := NewUserService(&mockUserRepository{}, &mockBookRepository{}, uow, testFineRules, testBcryptCost)
+			err := service.TakeItem(ctx, user, tt.item)
+			assert.Equal(t, tt.err, err)
+			mockItems.AssertExpectations(t)
+			mockLoans.AssertExpectations(t)
+			mockHolds.AssertExpectations(t)
 		})
 	}
 }
 
 func Test_UserService_ReturnBook(t *testing.T) {
-	book1 := entities.Book{
+	ctx := context.Background()
+	user := entities.User{Model: gorm.Model{ID: 1}, Email: "email1", Category: entities.CategoryPublic}
+	book := entities.Book{
+		Model:          gorm.Model{ID: 2},
 		Isbn:           "test",
 		Title:          "test",
 		Author:         "test",
 		AvailableUnits: 1,
 	}
+	itemID := uint(5)
+	lent := entities.Item{ID: 5, BookID: 2, Condition: entities.ConditionGood, Status: entities.ItemOnLoan}
+	damaged := lent
+	damaged.Condition = entities.ConditionDamaged
+	now := time.Now()
+	loan := entities.Loan{Model: gorm.Model{ID: 7}, UserID: 1, BookID: 2, ItemID: &itemID, DueAt: now.Add(time.Hour), ReturnedAt: &now}
+	late := loan
+	late.DueAt = now.Add(-time.Hour * 24 * 3)
+	lateCharge := mock.MatchedBy(func(entry entities.LedgerEntry) bool {
+		return entry.UserID == 1 && *entry.LoanID == 7 && entry.Kind == entities.LedgerCharge &&
+			entry.Amount == 3*testCategories[0].FineRate
+	})
 
-	book2 := entities.Book{
-		Isbn:           "test",
-		Title:          "test",
-		Author:         "test",
-		AvailableUnits: 2,
-	}
-	mockUserRepo := func(m *mockUserRepository) *mockUserRepository {
-		m.On("UpdateTakenBooks",
-			entities.User{
-				Email:         "email1",
-				TakenBooks:    []entities.Book{},
-				ReturnedBooks: []entities.Book{book2},
-			},
-			[]entities.Book{}).Return(nil).Once()
-		m.On("UpdateReturnedBooks",
-			entities.User{
-				Email:         "email1",
-				TakenBooks:    []entities.Book{},
-				ReturnedBooks: []entities.Book{book2},
-			},
-			[]entities.Book{book2}).Return(nil).Once()
-		return m
-	}
-	mockBookRepo := func(m *mockBookRepository) *mockBookRepository {
-		m.On("UpdateUnits", book2).Return(nil).Once()
-		return m
+	tests := []struct {
+		name         string
+		mockLoanRepo func(m *mockLoanRepository) *mockLoanRepository
+		mockItemRepo func(m *mockItemRepository) *mockItemRepository
+		mockHoldRepo func(m *mockHoldRepository) *mockHoldRepository
+		mockLedger   func(m *mockLedgerRepository) *mockLedgerRepository
+		err          error
+	}{{
+		name: "success",
+		mockLoanRepo: func(m *mockLoanRepository) *mockLoanRepository {
+			m.On("Return", mock.Anything, uint(1), uint(2), mock.Anything).Return(loan, nil).Once()
+			return m
+		},
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			m.On("FindByID", mock.Anything, uint(5)).Return(lent, nil).Once()
+			m.On("Transition", mock.Anything, lent, entities.ItemOnLoan, entities.ItemAvailable).Return(nil).Once()
+			return m
+		},
+		mockLedger: func(m *mockLedgerRepository) *mockLedgerRepository {
+			return m
+		},
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("AllocateNext", mock.Anything, uint(2), uint(5), mock.Anything).Return(repositories.ErrNoWaitingHolds).Once()
+			return m
+		},
+	}, {
+		name: "book is not taken",
+		mockLoanRepo: func(m *mockLoanRepository) *mockLoanRepository {
+			m.On("Return", mock.Anything, uint(1), uint(2), mock.Anything).Return(entities.Loan{}, repositories.ErrBookNotTaken).Once()
+			return m
+		},
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			return m
+		},
+		mockLedger: func(m *mockLedgerRepository) *mockLedgerRepository {
+			return m
+		},
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			return m
+		},
+		err: repositories.ErrBookNotTaken,
+	}, {
+		name: "copy goes to the next hold",
+		mockLoanRepo: func(m *mockLoanRepository) *mockLoanRepository {
+			m.On("Return", mock.Anything, uint(1), uint(2), mock.Anything).Return(loan, nil).Once()
+			return m
+		},
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			m.On("FindByID", mock.Anything, uint(5)).Return(lent, nil).Once()
+			m.On("Transition", mock.Anything, lent, entities.ItemOnLoan, entities.ItemOnHold).Return(nil).Once()
+			return m
+		},
+		mockLedger: func(m *mockLedgerRepository) *mockLedgerRepository {
+			return m
+		},
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("AllocateNext", mock.Anything, uint(2), uint(5), mock.Anything).Return(nil).Once()
+			return m
+		},
+	}, {
+		name: "damaged copy is not passed on",
+		mockLoanRepo: func(m *mockLoanRepository) *mockLoanRepository {
+			m.On("Return", mock.Anything, uint(1), uint(2), mock.Anything).Return(loan, nil).Once()
+			return m
+		},
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			m.On("FindByID", mock.Anything, uint(5)).Return(damaged, nil).Once()
+			m.On("Transition", mock.Anything, damaged, entities.ItemOnLoan, entities.ItemAvailable).Return(nil).Once()
+			return m
+		},
+		mockLedger: func(m *mockLedgerRepository) *mockLedgerRepository {
+			return m
+		},
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			return m
+		},
+	}, {
+		name: "late return is charged",
+		mockLoanRepo: func(m *mockLoanRepository) *mockLoanRepository {
+			m.On("Return", mock.Anything, uint(1), uint(2), mock.Anything).Return(late, nil).Once()
+			return m
+		},
+		mockItemRepo: func(m *mockItemRepository) *mockItemRepository {
+			m.On("FindByID", mock.Anything, uint(5)).Return(lent, nil).Once()
+			m.On("Transition", mock.Anything, lent, entities.ItemOnLoan, entities.ItemAvailable).Return(nil).Once()
+			return m
+		},
+		mockLedger: func(m *mockLedgerRepository) *mockLedgerRepository {
+			m.On("Create", mock.Anything, lateCharge).Return(entities.LedgerEntry{}, nil).Once()
+			return m
+		},
+		mockHoldRepo: func(m *mockHoldRepository) *mockHoldRepository {
+			m.On("AllocateNext", mock.Anything, uint(2), uint(5), mock.Anything).Return(repositories.ErrNoWaitingHolds).Once()
+			return m
+		},
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockLoans := tt.mockLoanRepo(&mockLoanRepository{})
+			mockItems := tt.mockItemRepo(&mockItemRepository{})
+			mockHolds := tt.mockHoldRepo(&mockHoldRepository{})
+			mockLedger := tt.mockLedger(&mockLedgerRepository{})
+			uow := &mockUnitOfWork{items: mockItems, loans: mockLoans, holds: mockHolds, ledger: mockLedger, categories: publicCategories()}
+			service := NewUserService(&mockUserRepository{}, &mockBookRepository{}, uow, testFineRules, testBcryptCost)
+			err := service.ReturnBook(ctx, user, book)
+			assert.Equal(t, tt.err, err)
+			mockItems.AssertExpectations(t)
+			mockLoans.AssertExpectations(t)
+			mockHolds.AssertExpectations(t)
+			mockLedger.AssertExpectations(t)
+		})
 	}
-	mockUserRepository := &mockUserRepository{}
-	mockBookRepository := &mockBookRepository{}
-	service := NewUserService(mockUserRepo(mockUserRepository), mockBookRepo(mockBookRepository))
-	service.ReturnBook(entities.User{Email: "email1", TakenBooks: []entities.Book{book1}}, book1)
-	mockBookRepository.AssertExpectations(t)
-	mockUserRepository.AssertExpectations(t)
+}
+
+func Test_UserService_ReturnItem(t *testing.T) {
+	ctx := context.Background()
+	user := entities.User{Model: gorm.Model{ID: 1}, Email: "email1", Category: entities.CategoryPublic}
+	item := entities.Item{ID: 5, BookID: 2, Barcode: "0005", Condition: entities.ConditionGood, Status: entities.ItemOnLoan}
+	itemID := item.ID
+
+	mockLoans := &mockLoanRepository{}
+	due := time.Now().Add(time.Hour)
+	mockLoans.On("ReturnItem", mock.Anything, uint(1), uint(5), mock.Anything).Return(entities.Loan{UserID: 1, BookID: 2, ItemID: &itemID, DueAt: due}, nil).Once()
+	mockItems := &mockItemRepository{}
+	mockItems.On("FindByID", mock.Anything, uint(5)).Return(item, nil).Once()
+	mockItems.On("Transition", mock.Anything, item, entities.ItemOnLoan, entities.ItemAvailable).Return(nil).Once()
+	mockHolds := &mockHoldRepository{}
+	mockHolds.On("AllocateNext", mock.Anything, uint(2), uint(5), mock.Anything).Return(repositories.ErrNoWaitingHolds).Once()
+
+	uow := &mockUnitOfWork{items: mockItems, loans: mockLoans, holds: mockHolds, categories: publicCategories()}
+	service := NewUserService(&mockUserRepository{}, &mockBookRepository{}, uow, testFineRules, testBcryptCost)
+	err := service.ReturnItem(ctx, user, item)
+
+	assert.Nil(t, err)
+	mockItems.AssertExpectations(t)
+	mockLoans.AssertExpectations(t)
+	mockHolds.AssertExpectations(t)
 }
 
 func Test_UserService_IsBookTakenByUser(t *testing.T) {
+	ctx := context.Background()
 	book := entities.Book{
 		Isbn:           "test",
 		Title:          "test",
@@ -206,7 +653,7 @@ func Test_UserService_IsBookTakenByUser(t *testing.T) {
 	}{{
 		name: "book is taken",
 		mockUserRepo: func(m *mockUserRepository) *mockUserRepository {
-			m.On("FindByEmail", "email").Return(entities.User{
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{
 				Email: "email",
 				TakenBooks: []entities.Book{
 					book,
@@ -215,31 +662,31 @@ func Test_UserService_IsBookTakenByUser(t *testing.T) {
 			return m
 		},
 		mockBookRepo: func(m *mockBookRepository) *mockBookRepository {
-			m.On("Find", "test").Return(book, nil)
+			m.On("Find", mock.Anything, "test").Return(book, nil)
 			return m
 		},
 		expected: true,
 	}, {
 		name: "user does not exist",
 		mockUserRepo: func(m *mockUserRepository) *mockUserRepository {
-			m.On("FindByEmail", "email").Return(entities.User{}, nil)
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{}, nil)
 			return m
 		},
 		mockBookRepo: func(m *mockBookRepository) *mockBookRepository {
-			m.On("Find", "test").Return(book, nil)
+			m.On("Find", mock.Anything, "test").Return(book, nil)
 			return m
 		},
 		expected: false,
 	}, {
 		name: "user has not taken this book",
 		mockUserRepo: func(m *mockUserRepository) *mockUserRepository {
-			m.On("FindByEmail", "email").Return(entities.User{
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{
 				Email: "email",
 			}, nil)
 			return m
 		},
 		mockBookRepo: func(m *mockBookRepository) *mockBookRepository {
-			m.On("Find", "test").Return(book, nil)
+			m.On("Find", mock.Anything, "test").Return(book, nil)
 			return m
 		},
 		expected: false,
@@ -249,7 +696,7 @@ func Test_UserService_IsBookTakenByUser(t *testing.T) {
 			return m
 		},
 		mockBookRepo: func(m *mockBookRepository) *mockBookRepository {
-			m.On("Find", "test").Return(entities.Book{}, errors.New("not found"))
+			m.On("Find", mock.Anything, "test").Return(entities.Book{}, errors.New("not found"))
 			return m
 		},
 		expected: false,
@@ -259,8 +706,8 @@ func Test_UserService_IsBookTakenByUser(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			mockUserRepository := &mockUserRepository{}
 			mockBookRepository := &mockBookRepository{}
-			service := NewUserService(tt.mockUserRepo(mockUserRepository), tt.mockBookRepo(mockBookRepository))
-			flag := service.IsBookTakenByUser("email", "test")
+			service := NewUserService(tt.mockUserRepo(mockUserRepository), tt.mockBookRepo(mockBookRepository), &mockUnitOfWork{}, testFineRules, testBcryptCost)
+			flag := service.IsBookTakenByUser(ctx, "email", "test")
 			assert.Equal(t, tt.expected, flag)
 			mockBookRepository.AssertExpectations(t)
 			mockUserRepository.AssertExpectations(t)
@@ -269,6 +716,7 @@ func Test_UserService_IsBookTakenByUser(t *testing.T) {
 }
 
 func Test_UserService_Register(t *testing.T) {
+	ctx := context.Background()
 	tests := []struct {
 		name         string
 		mockUserRepo func(m *mockUserRepository) *mockUserRepository
@@ -276,7 +724,9 @@ func Test_UserService_Register(t *testing.T) {
 	}{{
 		name: "success",
 		mockUserRepo: func(m *mockUserRepository) *mockUserRepository {
-			m.On("Save", mock.Anything).Return(nil)
+			m.On("Save", mock.Anything, mock.MatchedBy(func(user entities.User) bool {
+				return user.Role == "User" && user.Category == entities.CategoryPublic && !user.Verified
+			})).Return(nil)
 			return m
 		},
 		mockBookRepo: func(m *mockBookRepository) *mockBookRepository {
@@ -288,9 +738,58 @@ func Test_UserService_Register(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			mockUserRepository := &mockUserRepository{}
 			mockBookRepository := &mockBookRepository{}
-			service := NewUserService(tt.mockUserRepo(mockUserRepository), tt.mockBookRepo(mockBookRepository))
-			err := service.Register(entities.User{})
+			service := NewUserService(tt.mockUserRepo(mockUserRepository), tt.mockBookRepo(mockBookRepository), &mockUnitOfWork{}, testFineRules, testBcryptCost)
+			err := service.Register(ctx, entities.User{Category: entities.CategoryStaff, Verified: true})
+			assert.Nil(t, err)
+			mockUserRepository.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_UserService_LoanAllowance(t *testing.T) {
+	ctx := context.Background()
+	limit := uint(1)
+
+	tests := []struct {
+		name     string
+		user     entities.User
+		active   int64
+		expected entities.LoanAllowance
+	}{{
+		name:     "limit of the category",
+		user:     entities.User{Model: gorm.Model{ID: 1}, Category: entities.CategoryPublic},
+		active:   2,
+		expected: entities.LoanAllowance{Loans: 2, Limit: 5, Remaining: 3},
+	}, {
+		name:     "limit set for the user",
+		user:     entities.User{Model: gorm.Model{ID: 1}, Category: entities.CategoryPublic, LoanLimit: &limit},
+		active:   2,
+		expected: entities.LoanAllowance{Loans: 2, Limit: 1, Remaining: 0},
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockLoans := &mockLoanRepository{}
+			mockLoans.On("CountActive", mock.Anything, uint(1)).Return(tt.active, nil).Once()
+			uow := &mockUnitOfWork{loans: mockLoans, categories: publicCategories()}
+			service := NewUserService(&mockUserRepository{}, &mockBookRepository{}, uow, testFineRules, testBcryptCost)
+
+			allowance, err := service.LoanAllowance(ctx, tt.user)
 			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, allowance)
+			mockLoans.AssertExpectations(t)
 		})
 	}
 }
+
+func Test_UserService_SetLoanLimit(t *testing.T) {
+	ctx := context.Background()
+	limit := uint(8)
+	m := &mockUserRepository{}
+	m.On("SetLoanLimit", mock.Anything, uint(1), &limit).Return(nil).Once()
+	service := NewUserService(m, &mockBookRepository{}, &mockUnitOfWork{}, testFineRules, testBcryptCost)
+
+	err := service.SetLoanLimit(ctx, entities.User{Model: gorm.Model{ID: 1}}, &limit)
+	assert.Nil(t, err)
+	m.AssertExpectations(t)
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/mail"
+	"github.com/mishozz/Library/repositories"
+	"gorm.io/gorm"
+)
+
+// ErrEmailNotVerified is returned when a user who has not verified their email takes a book
+var ErrEmailNotVerified = errors.New("email not verified")
+
+const verificationSubject = "Verify your library email"
+
+const verificationBody = `Welcome to the library!
+
+Open this link to verify your email, you can borrow books once it is verified:
+
+%s
+
+The link expires in 48 hours. If you did not register you can ignore this email.`
+
+// VerificationService confirms that new users own the email they registered with
+type VerificationService interface {
+	Send(ctx context.Context, email string) error
+	Verify(ctx context.Context, token string) error
+}
+
+type verificationService struct {
+	userRepository repositories.UserRepository
+	unitOfWork     repositories.UnitOfWork
+	mailer         mail.Mailer
+	verifyURL      string
+}
+
+// NewVerificationService creates a service which mails links to verifyURL, the token is
+// added to the link as the token query parameter
+func NewVerificationService(userRepository repositories.UserRepository, unitOfWork repositories.UnitOfWork, mailer mail.Mailer, verifyURL string) *verificationService {
+	return &verificationService{
+		userRepository: userRepository,
+		unitOfWork:     unitOfWork,
+		mailer:         mailer,
+		verifyURL:      verifyURL,
+	}
+}
+
+// Send mails a verification link to the user and invalidates the links mailed before.
+// Unknown and already verified users get no email, but the caller cannot tell them
+// apart from the others.
+func (s *verificationService) Send(ctx context.Context, email string) error {
+	user, err := s.userRepository.FindByEmail(ctx, email)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && user.Verified) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	token, err := issueOneTimeToken(ctx, s.unitOfWork, user.ID, entities.TokenEmailVerification, entities.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	link := fmt.Sprintf("%s?token=%s", s.verifyURL, url.QueryEscape(token))
+	return s.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: verificationSubject,
+		Body:    fmt.Sprintf(verificationBody, link),
+	})
+}
+
+// Verify spends the token and marks the email of its user verified
+func (s *verificationService) Verify(ctx context.Context, token string) error {
+	return s.unitOfWork.Transaction(ctx, func(store repositories.Store) error {
+		userId, err := spendOneTimeToken(ctx, store, entities.TokenEmailVerification, token)
+		if err != nil {
+			return err
+		}
+		return store.Users().SetVerified(ctx, userId)
+	})
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/mishozz/Library/auth"
+	"github.com/mishozz/Library/entities"
+	"github.com/mishozz/Library/mail"
+	"github.com/mishozz/Library/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+const testVerifyURL = "http://localhost:8080/library/api/v1/verify"
+
+func Test_NewVerificationService(t *testing.T) {
+	service := NewVerificationService(&mockUserRepository{}, &mockUnitOfWork{}, mail.NewLogMailer(&bytes.Buffer{}), testVerifyURL)
+	assert.NotNil(t, service.userRepository)
+	assert.NotNil(t, service.unitOfWork)
+	assert.NotNil(t, service.mailer)
+	assert.Equal(t, testVerifyURL, service.verifyURL)
+}
+
+func Test_VerificationService_Send(t *testing.T) {
+	ctx := context.Background()
+	user := entities.User{Model: gorm.Model{ID: 1}, Email: "email"}
+	verified := entities.User{Model: gorm.Model{ID: 1}, Email: "email", Verified: true}
+
+	tests := []struct {
+		name       string
+		mockUsers  func(m *mockUserRepository) *mockUserRepository
+		mockTokens func(m *mockOneTimeTokenRepository) *mockOneTimeTokenRepository
+		mailed     bool
+		err        error
+	}{{
+		name: "success",
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("FindByEmail", mock.Anything, "email").Return(user, nil)
+			return m
+		},
+		mockTokens: func(m *mockOneTimeTokenRepository) *mockOneTimeTokenRepository {
+			m.On("DeleteByUser", mock.Anything, uint(1), entities.TokenEmailVerification).Return(nil)
+			m.On("Create", mock.Anything, uint(1), entities.TokenEmailVerification, mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).Return(nil)
+			return m
+		},
+		mailed: true,
+	}, {
+		name: "unknown user",
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{}, gorm.ErrRecordNotFound)
+			return m
+		},
+		mockTokens: func(m *mockOneTimeTokenRepository) *mockOneTimeTokenRepository {
+			return m
+		},
+	}, {
+		name: "already verified",
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("FindByEmail", mock.Anything, "email").Return(verified, nil)
+			return m
+		},
+		mockTokens: func(m *mockOneTimeTokenRepository) *mockOneTimeTokenRepository {
+			return m
+		},
+	}, {
+		name: "lookup fails",
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("FindByEmail", mock.Anything, "email").Return(entities.User{}, errors.New("error"))
+			return m
+		},
+		mockTokens: func(m *mockOneTimeTokenRepository) *mockOneTimeTokenRepository {
+			return m
+		},
+		err: errors.New("error"),
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockUsers := tt.mockUsers(&mockUserRepository{})
+			mockTokens := tt.mockTokens(&mockOneTimeTokenRepository{})
+			var outbox bytes.Buffer
+			service := NewVerificationService(mockUsers, &mockUnitOfWork{tokens: mockTokens}, mail.NewLogMailer(&outbox), testVerifyURL)
+
+			err := service.Send(ctx, "email")
+
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.mailed, outbox.Len() > 0)
+			if tt.mailed {
+				//the link carries the token whose hash was stored
+				link := regexp.MustCompile(regexp.QuoteMeta(testVerifyURL) + `\?token=(\S+)`).FindStringSubmatch(outbox.String())
+				if assert.Len(t, link, 2) {
+					token, err := url.QueryUnescape(link[1])
+					assert.Nil(t, err)
+					mockTokens.AssertCalled(t, "Create", mock.Anything, uint(1), entities.TokenEmailVerification, auth.HashToken(token), mock.AnythingOfType("time.Time"))
+				}
+			}
+			mockUsers.AssertExpectations(t)
+			mockTokens.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_VerificationService_Verify(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name       string
+		mockTokens func(m *mockOneTimeTokenRepository) *mockOneTimeTokenRepository
+		mockUsers  func(m *mockUserRepository) *mockUserRepository
+		err        error
+	}{{
+		name: "success",
+		mockTokens: func(m *mockOneTimeTokenRepository) *mockOneTimeTokenRepository {
+			m.On("Consume", mock.Anything, entities.TokenEmailVerification, auth.HashToken("token"), mock.AnythingOfType("time.Time")).Return(entities.OneTimeToken{UserID: 1}, nil)
+			m.On("DeleteByUser", mock.Anything, uint(1), entities.TokenEmailVerification).Return(nil)
+			return m
+		},
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			m.On("SetVerified", mock.Anything, uint(1)).Return(nil)
+			return m
+		},
+	}, {
+		name: "invalid token",
+		mockTokens: func(m *mockOneTimeTokenRepository) *mockOneTimeTokenRepository {
+			m.On("Consume", mock.Anything, entities.TokenEmailVerification, auth.HashToken("token"), mock.AnythingOfType("time.Time")).Return(entities.OneTimeToken{}, repositories.ErrInvalidToken)
+			return m
+		},
+		mockUsers: func(m *mockUserRepository) *mockUserRepository {
+			return m
+		},
+		err: repositories.ErrInvalidToken,
+	}}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mockTokens := tt.mockTokens(&mockOneTimeTokenRepository{})
+			mockUsers := tt.mockUsers(&mockUserRepository{})
+			uow := &mockUnitOfWork{users: mockUsers, tokens: mockTokens}
+			service := NewVerificationService(&mockUserRepository{}, uow, mail.NewLogMailer(&bytes.Buffer{}), testVerifyURL)
+
+			err := service.Verify(ctx, "token")
+
+			assert.Equal(t, tt.err, err)
+			mockTokens.AssertExpectations(t)
+			mockUsers.AssertExpectations(t)
+		})
+	}
+}